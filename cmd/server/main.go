@@ -41,27 +41,35 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"net/http"
-	"net/http/pprof"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/diagnostics"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/metrics"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/network"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/policy"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/profiling"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/server"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/service"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/utils"
 	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -69,7 +77,12 @@ import (
 const successCode = 0
 const errorCode = 1
 const HealthPath = "/health"
-const ProfilingPath = "/debug/pprof"
+const ReadinessPath = "/ready"
+const AggregatedReadinessPath = "/readyz"
+const VersionPath = "/version"
+
+// readinessCacheTTL is the time the result of OCI Vault access check is served to readiness probes
+const readinessCacheTTL = 30 * time.Second
 
 var (
 	endpoint            = flag.String("endpoint", "unix:///opt/provider/sockets/oci.sock", "CSI gRPC endpoint")
@@ -77,13 +90,142 @@ var (
 	healthzPort         = flag.Int("healthz-port", 8098, "configure http listener for reporting health")
 	metricsBackend      = flag.String("metrics-backend", "prometheus", "Backend used for metrics")
 	metricsPort         = flag.Int("metrics-port", 8198, "Metrics port for metrics backend")
-	enableProfile       = flag.Bool("enable-pprof", true, "enable pprof profiling")
-	pprofPort           = flag.Int("pprof-port", 6060, "port for pprof profiling")
+	otlpEndpoint        = flag.String("otlp-endpoint", "",
+		"host:port of OpenTelemetry Collector receiving metrics over OTLP gRPC, required by otlp metrics backend")
+	enableProfile           = flag.Bool("enable-pprof", false, "enable pprof profiling, the endpoints are unauthenticated")
+	pprofPort               = flag.Int("pprof-port", 6060, "port for pprof profiling")
+	readinessFile           = flag.String("readiness-file", "", "file created once the provider serves requests")
+	maxSecretStages         = flag.Int("max-secret-stages", 5, "maximum number of stages accepted in a secret bundle")
+	instancePrincipalRegion = flag.String("instance-principal-region", "",
+		"region of instance principal, skips region discovery via instance metadata service if set")
+	detectDoubleEncoding = flag.Bool("detect-double-encoding", false,
+		"warn about secrets which content looks base64-encoded twice")
+	maxFileNameLength = flag.Int("max-filename-length", 255, "maximum length in bytes of mounted secret file names")
+	maxMountJitter    = flag.Duration("max-mount-jitter", 0,
+		"maximum random delay of the first OCI call of each mount to spread the load, disabled if zero")
+	secretChunkSize = flag.Int("secret-chunk-size", 0,
+		"split secrets larger than the size in bytes into numbered parts with a manifest, disabled if zero")
+	diagnosticsTokenFile = flag.String("diagnostics-token-file", "",
+		"path to file with bearer token protecting diagnostic endpoint of health server, endpoint is disabled if empty")
+	vaultEndpoint = flag.String("vault-endpoint", "",
+		"OCI Vault secrets endpoint overriding the regional one, e.g. private endpoint reachable via service gateway")
+	vaultCABundle = flag.String("vault-ca-bundle", "",
+		"path to PEM file with CA certificates trusted for OCI Vault endpoint instead of system ones")
+	costCenterTag = flag.String("cost-center-tag", "",
+		"cost-center tag sent in X-Cost-Center header of OCI Vault calls for cost attribution, not sent if empty")
+	cacheTTL = flag.Duration("cache-ttl", 0,
+		"default time retrieved secrets are served from in-memory cache, unless cacheTTL of the secret is set, 0 disables")
+	secretFetchTimeout = flag.Duration("secret-fetch-timeout", 10*time.Second,
+		"timeout of a single secret retrieval from OCI Vault, independent of other secrets of the mount")
+	maxConcurrentFetches = flag.Int("max-concurrent-fetches", 5,
+		"maximum number of secrets of a single mount retrieved from OCI Vault at once")
+	defaultStages = flag.String("default-stages", "CURRENT",
+		"comma-separated stages tried in order for secrets specifying neither stage nor version, e.g. LATEST,CURRENT")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 20*time.Second,
+		"time in-flight requests are finished within on shutdown before the servers are stopped forcibly")
+	readinessMountFailurePeriod = flag.Duration("readiness-mount-failure-period", 0,
+		"period of failing mounts without a successful one making /readyz report the mounts unhealthy, 0 disables")
+	saTokenTTL = flag.Duration("sa-token-ttl", 15*time.Minute,
+		"requested expiration of service account token used by workload identity, between 10m and 2^32s")
+	metricsSummaryInterval = flag.Duration("metrics-summary-interval", 0,
+		"interval of metrics summary logged at info level for environments without metrics scraping, 0 disables")
+	metricsRequired = flag.Bool("metrics-required", true,
+		"fail startup if metrics can't be initialized, otherwise the failure is logged and secrets are served")
+	pprofBind = flag.String("pprof-bind", "",
+		"address pprof server listens on, e.g. 127.0.0.1 to reach it only via port forwarding, all interfaces if empty")
+	fetchOutcomesFile = flag.String("fetch-outcomes-file", "",
+		"path of JSON file added to each mount describing retrieval of each secret without contents, not added if empty")
+	endpointUID = flag.Int("endpoint-uid", network.UnchangedOwner,
+		"owner user ID of the unix socket, requires root or CAP_CHOWN, the owner is not changed if -1")
+	endpointGID = flag.Int("endpoint-gid", network.UnchangedOwner,
+		"owner group ID of the unix socket, e.g. the group the driver runs as, the group is not changed if -1")
+	readinessVaultID = flag.String("readiness-vault-id", "",
+		"OCID of the vault accessed with instance principal by /ready endpoint, the access is not checked if empty")
+	allowedPrincipals = flag.String("allowed-principals", "",
+		"comma-separated principal types SecretProviderClass could authenticate with, e.g. instance,workload, "+
+			"any type is allowed if empty")
+	authSecretNamespaces = flag.String("auth-secret-namespaces", "",
+		"comma-separated namespaces SecretProviderClass could read user principal auth secret from via "+
+			"authSecretNamespace parameter instead of the pod namespace, the override is refused if empty")
+	configFileDir = flag.String("config-file-dir", server.DefaultConfigFileDir,
+		"path of the directory OCI config files of file principal are read from, configFilePath of "+
+			"SecretProviderClass should be within it, file principal is refused if empty")
+	privateKeyDir = flag.String("private-key-dir", "",
+		"absolute path of the directory user principal private key files referenced by privateKeyPath are read from, "+
+			"each namespace reads only the subdirectory named after it, privateKeyPath is refused if empty")
+	reportSecretExpiry = flag.Bool("report-secret-expiry", false,
+		"log expiry time of mounted secrets and export it as secret_seconds_until_expiry metric")
+	mountFailureEventThreshold = flag.Int("mount-failure-event-threshold", 0,
+		"number of consecutive mount failures of a pod emitting SecretMountFailed event on it, 0 disables events")
+	pendingDeletionPolicy = flag.String("pending-deletion-policy", string(server.PendingDeletionWarn),
+		"handling of secrets scheduled for deletion: \"warn\" mounts the secret with warning, \"refuse\" fails the mount")
+	refuseEmptyStages = flag.Bool("refuse-empty-stages", false,
+		"fail the mount of secrets returned by OCI Vault without stages, only log a warning otherwise")
+	contentHashObjectVersion = flag.Bool("content-hash-object-version", false,
+		"report digest of secret content as object version, so unchanged content is not rewritten on version change")
+	stagesObjectVersion = flag.Bool("stages-object-version", false,
+		"append stages of the mounted secret version to object version, e.g. \"2;CURRENT,LATEST\"")
+	maxFileMode = flag.Int("max-file-mode", 0,
+		"loosest mode of mounted secret files, e.g. 0600, any mode requested by the driver is allowed if not set")
+	fileModePolicy = flag.String("file-mode-policy", string(server.FileModeReject),
+		"handling of file modes looser than --max-file-mode: \"reject\" fails the mount, \"tighten\" clears extra bits")
+	strictSecretFields = flag.Bool("strict-secret-fields", true,
+		"fail the mount on unknown fields of SecretProviderClass secrets, only log them otherwise")
+	allowedVaultsFile = flag.String("allowed-vaults-file", "",
+		"file listing vault OCIDs the secrets could be mounted from, one per line, any vault is allowed if not set")
+	allowedVaultsReloadInterval = flag.Duration("allowed-vaults-reload-interval", 30*time.Second,
+		"how often the allowed vaults file is checked for changes")
+	allowedSecretNames = flag.String("allowed-secret-names", "",
+		"comma-separated glob patterns of secret names could be mounted, e.g. \"app-*\", any name is allowed if not set")
+	deniedSecretNames = flag.String("denied-secret-names", "",
+		"comma-separated glob patterns of secret names never mounted, e.g. \"*-root-*\", takes precedence over allowed")
+	namespaceSecretNamesFile = flag.String("namespace-secret-names-file", "",
+		"YAML file mapping pod namespaces to allowed and denied glob patterns of secret names, not restricted if not set")
+	namespaceSecretNamesReloadInterval = flag.Duration("namespace-secret-names-reload-interval", 30*time.Second,
+		"how often the namespace secret names file is checked for changes")
+	validateAttributesFile = flag.String("validate-attributes", "",
+		"path to JSON file with mount request attributes, e.g. SecretProviderClass parameters, validated without "+
+			"starting the server, exits with non-zero code if they are invalid")
+	maxVaultResponseSize = flag.Int64("max-vault-response-size", 0,
+		"maximum size in bytes of OCI Vault response with a secret bundle, larger secrets fail without being "+
+			"transferred, 0 disables")
+	maxSecretSize = flag.Int("max-secret-size", 1<<20,
+		"maximum size in bytes of decoded secret content, larger secrets fail the mount before they are decoded")
+	ociHTTPTimeout = flag.Duration("oci-http-timeout", 20*time.Second,
+		"timeout of a single HTTP call to OCI Vault for all principal types, and of instance principal authentication")
+	ociDialTimeout = flag.Duration("oci-dial-timeout", 0,
+		"timeout of establishing a connection to OCI within --oci-http-timeout, 0 keeps the default of 30s")
+	fileNameMetadataKey = flag.String("file-name-metadata-key", "",
+		"key of secret metadata specifying the file path of secrets without fileName, e.g. mountPath, not read if empty")
+	circuitFailureThreshold = flag.Int("circuit-failure-threshold", 0,
+		"consecutive failures of OCI Vault calls of a principal to a vault after which the calls fail fast, "+
+			"0 disables circuit breaker")
+	circuitCooldown = flag.Duration("circuit-cooldown", 30*time.Second,
+		"time calls to a failing vault fail fast before a probe call checks whether it has recovered")
+	printVersion = flag.Bool("version", false, "print build information and exit")
+	logFormat    = flag.String("log-format", logging.ConsoleFormat,
+		"log output format: \"console\" for human-readable or \"json\" for newline-delimited JSON")
+	logLevel = flag.String("log-level", logging.InfoLevel,
+		"minimal level of logged messages: \"debug\", \"info\", \"warn\" or \"error\"")
+	otlpLogEndpoint = flag.String("otlp-log-endpoint", "",
+		"host:port of OpenTelemetry Collector receiving logs over OTLP gRPC in addition to stderr, disabled if empty")
+	endpointTLSCert = flag.String("endpoint-tls-cert", "", "PEM certificate used to serve TCP endpoint with mutual TLS")
+	endpointTLSKey  = flag.String("endpoint-tls-key", "", "PEM private key used to serve TCP endpoint with mutual TLS")
+	endpointTLSCA   = flag.String("endpoint-tls-ca", "", "PEM CA certificates used to verify clients of TCP endpoint")
+
+	grpcMaxConcurrentStreams = flag.Uint("grpc-max-concurrent-streams", 0,
+		"maximum number of requests in progress over a single driver connection, 0 doesn't limit them")
+	grpcKeepaliveMinTime = flag.Duration("grpc-keepalive-min-time", 5*time.Minute,
+		"minimal interval of client keepalive pings, the driver connection pinging more often is closed")
+	grpcKeepalivePermitWithoutStream = flag.Bool("grpc-keepalive-permit-without-stream", false,
+		"allow client keepalive pings while no request is in progress over the driver connection")
+
+	// started is the start time of the provider, reported as uptime by diagnostic endpoint
+	started = time.Now()
 )
 
 func init() {
 	common.EnableInstanceMetadataServiceLookup()
-	logging.ConfigureGlobalLogger()
 	flag.Parse()
 }
 
@@ -92,54 +234,171 @@ func main() {
 	exitCode := successCode
 	defer func() { os.Exit(exitCode) }()
 
+	if *printVersion {
+		fmt.Print(server.BuildInfo())
+		return
+	}
+
+	if err := logging.ConfigureGlobalLogger(*logFormat, *logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log format: %v\n", err)
+		exitCode = errorCode
+		return
+	}
+
+	if *validateAttributesFile != "" {
+		if err := validateAttributes(*validateAttributesFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid attributes: %v\n", status.Convert(err).Message())
+			exitCode = errorCode
+		}
+		return
+	}
+
+	if *otlpLogEndpoint != "" {
+		logSink, err := logging.ConfigureOTLPSink(*otlpLogEndpoint)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to configure OTLP log sink")
+			exitCode = errorCode
+			return
+		}
+		// the sink is shut down last, so the lines logged while shutting down are exported too
+		defer shutdownLogSink(logSink)
+		log.Info().Str("endpoint", *otlpLogEndpoint).Msg("Logs are exported over OTLP")
+	}
+
 	// Intercepting signals to shut down gracefully
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
 
-	listener, err := network.ListenUDS(*endpoint)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to listen on socket")
+	if err := network.ValidatePorts(configuredPorts()); err != nil {
+		log.Error().Err(err).Msg("Invalid port configuration")
 		exitCode = errorCode
 		return
 	}
 
-	// Change socket permissions
-	_, path, _ := network.ParseSocketEndpoint(*endpoint)
-	if err := changeSocketPermissions(path, *endpointPermissions); err != nil {
-		log.Error().Err(err).Msg("failed to change socket file permissions")
+	listener, proto, err := network.Listen(*endpoint)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to listen on socket")
 		exitCode = errorCode
 		return
 	}
 	defer gracefulClose(listener)
 
+	// Change socket permissions, TCP endpoint has no socket file
+	if proto == network.UnixProto {
+		_, path, _ := network.ParseSocketEndpoint(*endpoint)
+		err := network.SetSocketAccess(path, os.FileMode(*endpointPermissions), *endpointUID, *endpointGID)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to change socket file permissions")
+			exitCode = errorCode
+			return
+		}
+	}
+
 	// initialize metrics exporter before creating measurements
-	if err := metrics.InitMetricsExporter(*metricsBackend, *metricsPort); err != nil {
+	metricsExporter, err := metrics.InitMetricsExporter(metrics.ExporterConfig{
+		Backend:      *metricsBackend,
+		Port:         *metricsPort,
+		OTLPEndpoint: *otlpEndpoint,
+	}, *metricsRequired)
+	if err != nil {
 		log.Error().Err(err).Msg("failed to initialize metrics exporter")
 		exitCode = errorCode
 		return
 	}
-	log.Info().Str("address", strconv.Itoa(*metricsPort)+metrics.MetricsPath).
-		Msg("Metrics server listening")
+	// HTTP servers and the metrics exporter are shut down after in-flight mounts are finished
+	var httpServers []utils.HTTPServer
+	if metricsExporter != nil {
+		httpServers = append(httpServers, metricsExporter)
+		if *metricsBackend == "otlp" {
+			log.Info().Str("endpoint", *otlpEndpoint).Msg("Metrics are pushed over OTLP")
+		} else {
+			log.Info().Str("address", strconv.Itoa(*metricsPort)+metrics.MetricsPath).
+				Msg("Metrics server listening")
+		}
+	}
 
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(utils.LogInterceptor()),
+	shutdownTracker := &utils.ShutdownTracker{}
+	interceptorOptions := utils.InterceptorOptions{Recovery: true, Shutdown: shutdownTracker}
+	// subsystems register their checks aggregated by /readyz
+	healthRegistry := utils.NewHealthRegistry()
+	if *readinessMountFailurePeriod > 0 {
+		interceptorOptions.MountActivity = utils.NewMountActivity(*readinessMountFailurePeriod)
+		healthRegistry.Register("mounts", interceptorOptions.MountActivity.Check)
 	}
+	opts := []grpc.ServerOption{utils.UnaryInterceptorChain(interceptorOptions)}
+	connectionOpts, err := utils.ConnectionServerOptions(utils.ConnectionLimits{
+		MaxConcurrentStreams:         *grpcMaxConcurrentStreams,
+		KeepaliveMinTime:             *grpcKeepaliveMinTime,
+		KeepalivePermitWithoutStream: *grpcKeepalivePermitWithoutStream,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid gRPC connection limits")
+		exitCode = errorCode
+		return
+	}
+	opts = append(opts, connectionOpts...)
+	credentialsOpts, err := endpointCredentials(proto)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to configure endpoint TLS")
+		exitCode = errorCode
+		return
+	}
+	opts = append(opts, credentialsOpts...)
+	allowedVaults, err := initAllowedVaults()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load allowed vaults")
+		exitCode = errorCode
+		return
+	}
+	namespaceSecretNames, err := initNamespaceSecretNames()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load secret names of namespaces")
+		exitCode = errorCode
+		return
+	}
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	if allowedVaults != nil {
+		go allowedVaults.Watch(*allowedVaultsReloadInterval, stopWatching)
+	}
+	if namespaceSecretNames != nil {
+		go namespaceSecretNames.Watch(*namespaceSecretNamesReloadInterval, stopWatching)
+	}
+	if *metricsSummaryInterval > 0 {
+		go metrics.LogSummaries(*metricsSummaryInterval, stopWatching)
+	}
+
 	grpcServer := grpc.NewServer(opts...)
-	if err := initProviderService(grpcServer); err != nil {
+	if err := initProviderService(grpcServer, allowedVaults, namespaceSecretNames); err != nil {
 		exitCode = errorCode
 		return
 	}
 
 	done := make(chan struct{}, 1)
 	go serveRequests(grpcServer, listener, done)
-	defer grpcServer.GracefulStop()
+	healthRegistry.Register("grpc", servingCheck(done, shutdownTracker))
+	defer func() { utils.Shutdown(grpcServer, *shutdownTimeout, httpServers...) }()
+
+	readinessMarker := utils.NewReadinessMarker(*readinessFile)
+	if err := readinessMarker.MarkReady(); err != nil {
+		log.Error().Err(err).Msg("Failed to create readiness marker")
+		exitCode = errorCode
+		return
+	}
+	defer clearReadinessMarker(readinessMarker)
 
 	// intialize health server
-	initializeHealthServer(*healthzPort)
+	healthServer, err := initializeHealthServer(*healthzPort, healthRegistry)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to initialize health server")
+		exitCode = errorCode
+		return
+	}
+	httpServers = append(httpServers, healthServer)
 
 	// initialize profiling endpoint
-	if *enableProfile {
-		initializeProfileServer(*pprofPort)
+	if profileServer := profiling.InitProfileServer(*enableProfile, *pprofBind, *pprofPort); profileServer != nil {
+		httpServers = append(httpServers, profileServer)
 	}
 
 	select {
@@ -148,12 +407,112 @@ func main() {
 	case <-done:
 		log.Info().Msg("Server stopped serving requests")
 	}
+	// new mounts are rejected while in-flight ones are drained by the graceful stop
+	shutdownTracker.Begin()
+}
+
+// initAllowedVaults loads the vault allow list, it returns nil when any vault is allowed.
+func initAllowedVaults() (*policy.VaultAllowList, error) {
+	if *allowedVaultsFile == "" {
+		return nil, nil
+	}
+	if *allowedVaultsReloadInterval <= 0 {
+		return nil, fmt.Errorf("allowed vaults reload interval should be positive")
+	}
+	return policy.NewVaultAllowList(*allowedVaultsFile)
+}
+
+// initNamespaceSecretNames loads the secret names of namespaces, it returns nil when namespaces are not restricted.
+func initNamespaceSecretNames() (*policy.NamespaceSecretNamePolicy, error) {
+	if *namespaceSecretNamesFile == "" {
+		return nil, nil
+	}
+	if *namespaceSecretNamesReloadInterval <= 0 {
+		return nil, fmt.Errorf("namespace secret names reload interval should be positive")
+	}
+	return policy.NewNamespaceSecretNamePolicy(*namespaceSecretNamesFile)
 }
 
-func initProviderService(grpcServer *grpc.Server) error {
-	providerServer, err := server.NewOCIVaultProviderServer()
+func serviceConfig() (service.Config, error) {
+	stages, err := parseDefaultStages(*defaultStages)
+	if err != nil {
+		return service.Config{}, err
+	}
+	return service.Config{
+		MaxStages:               *maxSecretStages,
+		MaxJitter:               *maxMountJitter,
+		FetchTimeout:            *secretFetchTimeout,
+		CacheTTL:                *cacheTTL,
+		Endpoint:                *vaultEndpoint,
+		CABundleFile:            *vaultCABundle,
+		CostCenter:              *costCenterTag,
+		MaxConcurrentFetches:    *maxConcurrentFetches,
+		DefaultStages:           stages,
+		MaxResponseSize:         *maxVaultResponseSize,
+		MaxSecretSize:           *maxSecretSize,
+		HTTPTimeout:             *ociHTTPTimeout,
+		DialTimeout:             *ociDialTimeout,
+		FileNameMetadataKey:     *fileNameMetadataKey,
+		CircuitFailureThreshold: *circuitFailureThreshold,
+		CircuitCooldown:         *circuitCooldown,
+	}, nil
+}
+
+// parseDefaultStages returns nil if the default stages are not configured, so CURRENT is used
+func parseDefaultStages(value string) ([]types.Stage, error) {
+	var stages []types.Stage
+	for _, stageName := range strings.Split(value, ",") {
+		if stageName = strings.TrimSpace(stageName); stageName == "" {
+			continue
+		}
+		var stage types.Stage
+		if err := stage.FromString(stageName); err != nil {
+			return nil, fmt.Errorf("invalid default stage: %v", stageName)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// initSecretNamePolicy returns nil if secret names are not restricted
+func initSecretNamePolicy() (*policy.SecretNamePolicy, error) {
+	if *allowedSecretNames == "" && *deniedSecretNames == "" {
+		return nil, nil
+	}
+	return policy.NewSecretNamePolicy(policy.ParsePatterns(*allowedSecretNames), policy.ParsePatterns(*deniedSecretNames))
+}
+
+// parseNamespaces splits comma-separated list of namespaces
+func parseNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// parseAllowedPrincipals returns nil if principal types are not restricted
+func parseAllowedPrincipals(value string) ([]types.OCIPrincipalType, error) {
+	var principals []types.OCIPrincipalType
+	for _, authType := range strings.Split(value, ",") {
+		if authType = strings.TrimSpace(authType); authType == "" {
+			continue
+		}
+		principalType, err := types.MapToPrincipalType(authType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed principal type: %v", authType)
+		}
+		principals = append(principals, principalType)
+	}
+	return principals, nil
+}
+
+func initProviderService(grpcServer *grpc.Server, allowedVaults *policy.VaultAllowList,
+	namespaceSecretNames *policy.NamespaceSecretNamePolicy) error {
+	providerServer, err := newProviderServer(allowedVaults, namespaceSecretNames)
 	if err != nil {
-		log.Error().Err(err).Msg("Unable to create provider server")
 		return err
 	}
 	provider.RegisterCSIDriverProviderServer(grpcServer, providerServer)
@@ -161,38 +520,118 @@ func initProviderService(grpcServer *grpc.Server) error {
 	return nil
 }
 
-func changeSocketPermissions(path string, permissions int) error {
-	return os.Chmod(path, os.FileMode(permissions))
+// validateAttributes checks the attributes read from the file with the provider configured by the flags
+func validateAttributes(path string) error {
+	attributes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	allowedVaults, err := initAllowedVaults()
+	if err != nil {
+		return err
+	}
+	namespaceSecretNames, err := initNamespaceSecretNames()
+	if err != nil {
+		return err
+	}
+	providerServer, err := newProviderServer(allowedVaults, namespaceSecretNames)
+	if err != nil {
+		return err
+	}
+	secretBundleRequests, err := providerServer.ValidateAttributes(string(attributes))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Attributes are valid, %v secrets requested\n", len(secretBundleRequests))
+	return nil
 }
 
-func initializeProfileServer(port int) {
-	dmux := http.NewServeMux()
-	dmux.HandleFunc(ProfilingPath+"/", pprof.Index)
-	dmux.HandleFunc(ProfilingPath+"/cmdline", pprof.Cmdline)
-	dmux.HandleFunc(ProfilingPath+"/profile", pprof.Profile)
-	dmux.HandleFunc(ProfilingPath+"/symbol", pprof.Symbol)
-	dmux.HandleFunc(ProfilingPath+"/trace", pprof.Trace)
-	address := fmt.Sprintf(":%v", port)
-	ds := http.Server{
-		Addr:              address,
-		Handler:           dmux,
-		ReadHeaderTimeout: 2 * time.Minute,
+func newProviderServer(allowedVaults *policy.VaultAllowList,
+	namespaceSecretNames *policy.NamespaceSecretNamePolicy) (*server.ProviderServer, error) {
+	secretNames, err := initSecretNamePolicy()
+	if err != nil {
+		return nil, err
 	}
-	go func() {
-		err := ds.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			log.Error().Err(err).Msg("Profiling http server error")
+	principals, err := parseAllowedPrincipals(*allowedPrincipals)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to parse allowed principals")
+		return nil, err
+	}
+	secretServiceConfig, err := serviceConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to parse default stages")
+		return nil, err
+	}
+	providerServer, err := server.NewOCIVaultProviderServer(server.Config{
+		Service:                    secretServiceConfig,
+		InstancePrincipalRegion:    *instancePrincipalRegion,
+		DetectDoubleEncoding:       *detectDoubleEncoding,
+		MaxFileNameLength:          *maxFileNameLength,
+		AllowedVaults:              allowedVaults,
+		SecretNames:                secretNames,
+		NamespaceSecretNames:       namespaceSecretNames,
+		AllowedPrincipals:          principals,
+		AuthSecretNamespaces:       parseNamespaces(*authSecretNamespaces),
+		PrivateKeyDir:              *privateKeyDir,
+		ConfigFileDir:              *configFileDir,
+		ChunkSize:                  *secretChunkSize,
+		AllowUnknownSecretFields:   !*strictSecretFields,
+		ReportSecretExpiry:         *reportSecretExpiry,
+		MountFailureEventThreshold: *mountFailureEventThreshold,
+		PendingDeletion:            server.PendingDeletionPolicy(*pendingDeletionPolicy),
+		RefuseEmptyStages:          *refuseEmptyStages,
+		ContentHashObjectVersion:   *contentHashObjectVersion,
+		StagesObjectVersion:        *stagesObjectVersion,
+		MaxFileMode:                os.FileMode(*maxFileMode),
+		FileMode:                   server.FileModePolicy(*fileModePolicy),
+		SATokenTTL:                 *saTokenTTL,
+		FetchOutcomesFile:          *fetchOutcomesFile,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to create provider server")
+		return nil, err
+	}
+	return providerServer, nil
+}
+
+// endpointCredentials returns server options enforcing mutual TLS on TCP endpoint.
+// Unix domain socket is served without TLS.
+func endpointCredentials(proto string) ([]grpc.ServerOption, error) {
+	tlsConfig := network.TLSConfig{CertFile: *endpointTLSCert, KeyFile: *endpointTLSKey, CAFile: *endpointTLSCA}
+	if proto != network.TCPProto {
+		if tlsConfig.Enabled() {
+			log.Warn().Str("proto", proto).Msg("Endpoint TLS settings are ignored for non-TCP endpoint")
 		}
-	}()
-	log.Info().Str("address", strconv.Itoa(port)+ProfilingPath).Msg("Initializing Profiling server at")
+		return nil, nil
+	}
+	if !tlsConfig.Enabled() {
+		log.Warn().Msg("TCP endpoint is served without TLS")
+		return nil, nil
+	}
+	transportCredentials, err := network.NewServerCredentials(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{grpc.Creds(transportCredentials)}, nil
+}
 
+// configuredPorts lists TCP ports of the servers started by the provider
+func configuredPorts() []network.NamedPort {
+	ports := []network.NamedPort{
+		{Name: "healthz-port", Number: *healthzPort},
+		{Name: "metrics-port", Number: *metricsPort},
+	}
+	if *enableProfile {
+		ports = append(ports, network.NamedPort{Name: "pprof-port", Number: *pprofPort})
+	}
+	return ports
 }
 
-func initializeHealthServer(port int) {
+func initializeHealthServer(port int, healthRegistry *utils.HealthRegistry) (*http.Server, error) {
 	// initialize health http server
 	healthzAddr := ":" + strconv.Itoa(port)
 	mux := http.NewServeMux()
-	ms := http.Server{
+	ms := &http.Server{
 		Addr:              healthzAddr,
 		Handler:           mux,
 		ReadHeaderTimeout: 2 * time.Minute,
@@ -201,12 +640,74 @@ func initializeHealthServer(port int) {
 	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.HandleFunc(VersionPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(server.GetBuildDetails()); err != nil {
+			log.Error().Err(err).Msg("Failed to write version")
+		}
+	})
+	vaultAccessCheck, err := initVaultAccessCheck()
+	if err != nil {
+		return nil, err
+	}
+	readinessCheck := utils.NewReadinessCheck(vaultAccessCheck, readinessCacheTTL)
+	mux.Handle(ReadinessPath, readinessCheck)
+	if vaultAccessCheck != nil {
+		healthRegistry.Register("ociVault", readinessCheck.Check)
+	}
+	mux.Handle(AggregatedReadinessPath, healthRegistry)
+	if *diagnosticsTokenFile != "" {
+		token, err := os.ReadFile(*diagnosticsTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read diagnostics token: %w", err)
+		}
+		mux.Handle(diagnostics.Path, diagnostics.NewHandler(strings.TrimSpace(string(token)), effectiveConfig(), started))
+		log.Info().Str("address", strconv.Itoa(port)+diagnostics.Path).Msg("Diagnostic endpoint enabled")
+	}
 	go func() {
 		if err := ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msg("Error starting health server")
 		}
 	}()
 	log.Info().Str("address", strconv.Itoa(port)+HealthPath).Msg("Health server listening")
+	return ms, nil
+}
+
+// initVaultAccessCheck returns nil if OCI Vault access is not checked by readiness probes
+func initVaultAccessCheck() (func(ctx context.Context) error, error) {
+	if *readinessVaultID == "" {
+		return nil, nil
+	}
+	secretServiceConfig, err := serviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	secretService, err := service.NewOCISecretService(secretServiceConfig)
+	if err != nil {
+		return nil, err
+	}
+	auth := &types.Auth{Type: types.Instance, Region: *instancePrincipalRegion}
+	return func(ctx context.Context) error {
+		return secretService.CheckVaultAccess(ctx, auth, types.VaultID(*readinessVaultID))
+	}, nil
+}
+
+// effectiveConfig lists values of all the flags, including defaults
+func effectiveConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// shutdownLogSink exports the pending log records within the shutdown timeout
+func shutdownLogSink(sink *logging.OTLPSink) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := sink.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to export pending log records")
+	}
 }
 
 func gracefulClose(listener net.Listener) {
@@ -224,6 +725,27 @@ func gracefulClose(listener net.Listener) {
 	}
 }
 
+func clearReadinessMarker(marker *utils.ReadinessMarker) {
+	if err := marker.Clear(); err != nil {
+		log.Error().Err(err).Msg("Failed to remove readiness marker")
+	}
+}
+
+// servingCheck fails once the gRPC server stops serving requests or the provider starts shutting down
+func servingCheck(done <-chan struct{}, shutdownTracker *utils.ShutdownTracker) utils.HealthCheck {
+	return func(context.Context) error {
+		select {
+		case <-done:
+			return errors.New("gRPC server stopped serving requests")
+		default:
+		}
+		if shutdownTracker.IsShuttingDown() {
+			return errors.New("provider is shutting down")
+		}
+		return nil
+	}
+}
+
 func serveRequests(grpcServer *grpc.Server, listener net.Listener, done chan struct{}) {
 	log.Info().Msg("Serving gRPC requests")
 	err := grpcServer.Serve(listener) // blocking
@@ -0,0 +1,100 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the components depending on it, e.g. caches and delays,
+// so tests could control time instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the returned channel once the duration elapses
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// OrReal returns the clock, or the system clock if it's nil, so zero values of the components are ready to use.
+func OrReal(clock Clock) Clock { //nolint:ireturn // clock abstraction
+	if clock == nil {
+		return Real
+	}
+	return clock
+}
+
+// Fake is a manually advanced clock for tests.
+type Fake struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	channel  chan time.Time
+}
+
+// NewFake returns a fake clock stopped at the time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (fake *Fake) Now() time.Time {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.now
+}
+
+func (fake *Fake) After(d time.Duration) <-chan time.Time {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- fake.now
+		return channel
+	}
+	fake.waiters = append(fake.waiters, fakeWaiter{deadline: fake.now.Add(d), channel: channel})
+	return channel
+}
+
+// Advance moves the clock forward, firing the channels of After calls which durations have elapsed.
+func (fake *Fake) Advance(d time.Duration) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.now = fake.now.Add(d)
+	pending := fake.waiters[:0]
+	for _, waiter := range fake.waiters {
+		if fake.now.Before(waiter.deadline) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.channel <- fake.now
+	}
+	fake.waiters = pending
+}
+
+// Waiters returns the number of After calls which durations have not elapsed yet,
+// so tests could wait until the component under test is blocked on the clock.
+func (fake *Fake) Waiters() int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return len(fake.waiters)
+}
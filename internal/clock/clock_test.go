@@ -0,0 +1,66 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance_FireElapsedWaitersOnly(t *testing.T) {
+	start := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	fake := NewFake(start)
+	short, long := fake.After(time.Second), fake.After(time.Minute)
+
+	fake.Advance(30 * time.Second)
+
+	if now := fake.Now(); !now.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("Unexpected time: %v", now)
+	}
+	select {
+	case fired := <-short:
+		if !fired.Equal(start.Add(30 * time.Second)) {
+			t.Errorf("Unexpected fired time: %v", fired)
+		}
+	default:
+		t.Error("Elapsed waiter was not fired")
+	}
+	select {
+	case <-long:
+		t.Error("Waiter fired too early")
+	default:
+	}
+	if fake.Waiters() != 1 {
+		t.Errorf("Unexpected number of waiters: %v", fake.Waiters())
+	}
+
+	fake.Advance(30 * time.Second)
+	select {
+	case <-long:
+	default:
+		t.Error("Elapsed waiter was not fired")
+	}
+}
+
+func TestFake_AfterNonPositiveDuration_FireImmediately(t *testing.T) {
+	fake := NewFake(time.Now())
+	select {
+	case <-fake.After(0):
+	default:
+		t.Error("Waiter was not fired")
+	}
+}
+
+func TestOrReal_NilClock_ReturnReal(t *testing.T) {
+	if OrReal(nil) != Real {
+		t.Error("Nil clock should fall back to the real one")
+	}
+	fake := NewFake(time.Now())
+	if OrReal(fake) != fake {
+		t.Error("Non-nil clock should be returned as is")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package diagnostics
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Path of the diagnostic endpoint on the health server
+const Path = "/diagnostics"
+
+// Error categories reported by the secret service
+const (
+	SecretFetchFailed  = "secret_fetch_failed"
+	SecretFetchTimeout = "secret_fetch_timeout"
+	AuthFailed         = "auth_failed"
+)
+
+// recentErrorsCapacity is the number of the latest errors kept in memory
+const recentErrorsCapacity = 50
+
+const redactedValue = "<redacted>"
+
+// sensitiveNameParts mark configuration entries, values of which are never exposed
+var sensitiveNameParts = []string{"token", "password", "passphrase", "private-key"}
+
+var recentErrors = newErrorRing(recentErrorsCapacity)
+
+// ErrorRecord is an occurrence of an error category
+type ErrorRecord struct {
+	Time     time.Time `json:"time"`
+	Category string    `json:"category"`
+}
+
+// Snapshot is the state of the provider returned by the diagnostic endpoint
+type Snapshot struct {
+	UptimeSeconds float64           `json:"uptimeSeconds"`
+	Config        map[string]string `json:"config"`
+	RecentErrors  []ErrorRecord     `json:"recentErrors"`
+}
+
+// RecordError remembers the error category among the latest errors of the provider.
+func RecordError(category string) {
+	recentErrors.add(ErrorRecord{Time: time.Now().UTC(), Category: category})
+}
+
+// NewHandler creates HTTP handler of the diagnostic endpoint, which requires the token as bearer authorization.
+// Sensitive values of the configuration are redacted.
+func NewHandler(token string, config map[string]string, started time.Time) http.Handler {
+	redactedConfig := Redact(config)
+	expectedAuthorization := []byte("Bearer " + token)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		authorization := []byte(request.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(authorization, expectedAuthorization) != 1 {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		snapshot := Snapshot{
+			UptimeSeconds: time.Since(started).Seconds(),
+			Config:        redactedConfig,
+			RecentErrors:  recentErrors.list(),
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(snapshot); err != nil {
+			log.Error().Err(err).Msg("Failed to write diagnostics")
+		}
+	})
+}
+
+// Redact returns a copy of the configuration with sensitive values replaced.
+func Redact(config map[string]string) map[string]string {
+	redacted := make(map[string]string, len(config))
+	for name, value := range config {
+		if value != "" && isSensitive(name) {
+			value = redactedValue
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+func isSensitive(name string) bool {
+	lowerName := strings.ToLower(name)
+	for _, part := range sensitiveNameParts {
+		if strings.Contains(lowerName, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorRing keeps the latest error records, overwriting the oldest ones
+type errorRing struct {
+	mutex   sync.Mutex
+	records []ErrorRecord
+	next    int
+}
+
+func newErrorRing(capacity int) *errorRing {
+	return &errorRing{records: make([]ErrorRecord, 0, capacity)}
+}
+
+func (ring *errorRing) add(record ErrorRecord) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	if len(ring.records) < cap(ring.records) {
+		ring.records = append(ring.records, record)
+		return
+	}
+	ring.records[ring.next] = record
+	ring.next = (ring.next + 1) % len(ring.records)
+}
+
+// list returns the records from the oldest to the latest
+func (ring *errorRing) list() []ErrorRecord {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	records := make([]ErrorRecord, 0, len(ring.records))
+	records = append(records, ring.records[ring.next:]...)
+	return append(records, ring.records[:ring.next]...)
+}
@@ -0,0 +1,88 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
+)
+
+func TestMain(m *testing.M) {
+	testutils.RunTestCase(m)
+}
+
+func serveDiagnostics(t *testing.T, handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, Path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestHandler_ValidToken_ReturnRedactedSnapshot(t *testing.T) {
+	RecordError(SecretFetchTimeout)
+	config := map[string]string{"healthz-port": "8098", "diagnostics-token": "s3cr3t", "user-password": ""}
+	handler := NewHandler("s3cr3t", config, time.Now().Add(-time.Minute))
+
+	recorder := serveDiagnostics(t, handler, "Bearer s3cr3t")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %v", recorder.Code)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(recorder.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if snapshot.UptimeSeconds < 60 {
+		t.Errorf("Unexpected uptime: %v", snapshot.UptimeSeconds)
+	}
+	if snapshot.Config["healthz-port"] != "8098" || snapshot.Config["diagnostics-token"] != redactedValue ||
+		snapshot.Config["user-password"] != "" {
+		t.Errorf("Unexpected config: %v", snapshot.Config)
+	}
+	lastError := snapshot.RecentErrors[len(snapshot.RecentErrors)-1]
+	if lastError.Category != SecretFetchTimeout || lastError.Time.IsZero() {
+		t.Errorf("Unexpected error record: %v", lastError)
+	}
+}
+
+func TestHandler_WrongOrMissedToken_ReturnUnauthorized(t *testing.T) {
+	handler := NewHandler("s3cr3t", map[string]string{}, time.Now())
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cr3t"} {
+		recorder := serveDiagnostics(t, handler, authorization)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status for %q: %v", authorization, recorder.Code)
+		}
+	}
+}
+
+func TestHandler_EmptyToken_ReturnUnauthorized(t *testing.T) {
+	recorder := serveDiagnostics(t, NewHandler("", map[string]string{}, time.Now()), "Bearer ")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Unexpected status: %v", recorder.Code)
+	}
+}
+
+func TestErrorRing_Overflow_KeepLatestRecordsInOrder(t *testing.T) {
+	ring := newErrorRing(3)
+	for _, category := range []string{"a", "b", "c", "d", "e"} {
+		ring.add(ErrorRecord{Category: category})
+	}
+
+	records := ring.list()
+	if len(records) != 3 || records[0].Category != "c" || records[1].Category != "d" || records[2].Category != "e" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying the logger, e.g. the one of a single mount with its correlation ID.
+func WithLogger(ctx context.Context, logger zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, &logger)
+}
+
+// FromContext returns the logger carried by the context, or the global logger if there is none.
+// Unlike zerolog.Ctx, lines logged with a context without logger are not discarded.
+func FromContext(ctx context.Context) *zerolog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zerolog.Logger); ok {
+		return logger
+	}
+	return &log.Logger
+}
+
+// NewCorrelationID returns a random ID correlating log lines of a single request, e.g. "3f2a9c1e0b7d4e65".
+func NewCorrelationID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
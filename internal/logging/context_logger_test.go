@@ -0,0 +1,48 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package logging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestFromContext_ContextWithLogger_ReturnItsLogger(t *testing.T) {
+	var out bytes.Buffer
+	ctx := WithLogger(context.Background(), zerolog.New(&out).With().Str("mountId", "abc").Logger())
+
+	FromContext(ctx).Info().Msg("mounted")
+
+	if !strings.Contains(out.String(), `"mountId":"abc"`) {
+		t.Errorf("Line should be logged by the context logger: %v", out.String())
+	}
+}
+
+func TestFromContext_ContextWithoutLogger_ReturnGlobalLogger(t *testing.T) {
+	originalLogger := log.Logger
+	defer func() { log.Logger = originalLogger }()
+	var out bytes.Buffer
+	log.Logger = zerolog.New(&out)
+
+	FromContext(context.Background()).Info().Msg("mounted")
+
+	if !strings.Contains(out.String(), `"message":"mounted"`) {
+		t.Errorf("Line should be logged by the global logger: %v", out.String())
+	}
+}
+
+func TestNewCorrelationID_ConsecutiveCalls_ReturnDistinctIDs(t *testing.T) {
+	first, second := NewCorrelationID(), NewCorrelationID()
+	if len(first) != 16 || first == second {
+		t.Errorf("Unexpected correlation IDs: %v, %v", first, second)
+	}
+}
@@ -7,6 +7,8 @@
 package logging
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"time"
 
@@ -15,10 +17,61 @@ import (
 	"github.com/rs/zerolog/pkgerrors"
 )
 
-// ConfigureGlobalLogger configures globally accessible logger
-func ConfigureGlobalLogger() {
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+const (
+	// ConsoleFormat is human-readable colorized output
+	ConsoleFormat = "console"
+	// JSONFormat is newline-delimited JSON output suitable for log aggregation
+	JSONFormat = "json"
+)
+
+// Log levels accepted by ConfigureGlobalLogger
+const (
+	DebugLevel = "debug"
+	InfoLevel  = "info"
+	WarnLevel  = "warn"
+	ErrorLevel = "error"
+)
+
+// ConfigureGlobalLogger configures globally accessible logger writing to stderr in the given format,
+// unknown level falls back to info with a warning.
+func ConfigureGlobalLogger(format string, level string) error {
+	writer, err := newWriter(format, os.Stderr)
+	if err != nil {
+		return err
+	}
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	writer := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
 	log.Logger = log.Output(writer).With().Caller().Logger()
+	globalLevel, ok := parseLevel(level)
+	zerolog.SetGlobalLevel(globalLevel)
+	if !ok {
+		log.Warn().Str("level", level).Msg("Unknown log level, falling back to info")
+	}
+	return nil
+}
+
+// parseLevel accepts the levels exposed to the users, reports false and info level for others
+func parseLevel(level string) (zerolog.Level, bool) {
+	switch level {
+	case DebugLevel:
+		return zerolog.DebugLevel, true
+	case InfoLevel:
+		return zerolog.InfoLevel, true
+	case WarnLevel:
+		return zerolog.WarnLevel, true
+	case ErrorLevel:
+		return zerolog.ErrorLevel, true
+	default:
+		return zerolog.InfoLevel, false
+	}
+}
+
+func newWriter(format string, out io.Writer) (io.Writer, error) {
+	switch format {
+	case ConsoleFormat:
+		return zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}, nil
+	case JSONFormat:
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %v", format)
+	}
 }
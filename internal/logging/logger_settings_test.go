@@ -0,0 +1,91 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNewWriter_JSONFormat_WriteJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := newWriter(JSONFormat, &out)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger := zerolog.New(writer).With().Caller().Logger()
+	logger.Error().Err(errors.New("failure")).Msg("first")
+	logger.Info().Msg("second")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %v: %v", len(lines), out.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Log line is not JSON: %v", err)
+	}
+	if record["message"] != "first" || record["error"] != "failure" || record["caller"] == nil {
+		t.Errorf("Wrong log record: %v", record)
+	}
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Errorf("JSON output should not be colorized: %v", out.String())
+	}
+}
+
+func TestNewWriter_ConsoleFormat_WriteText(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := newWriter(ConsoleFormat, &out)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	logger := zerolog.New(writer)
+	logger.Info().Msg("hello")
+
+	if !strings.Contains(out.String(), "hello") || json.Valid(out.Bytes()) {
+		t.Errorf("Wrong console output: %v", out.String())
+	}
+}
+
+func TestNewWriter_UnknownFormat_ReturnError(t *testing.T) {
+	_, err := newWriter("xml", &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unknown log format: xml" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestParseLevel_KnownLevels_ReturnLevel(t *testing.T) {
+	expected := map[string]zerolog.Level{
+		DebugLevel: zerolog.DebugLevel,
+		InfoLevel:  zerolog.InfoLevel,
+		WarnLevel:  zerolog.WarnLevel,
+		ErrorLevel: zerolog.ErrorLevel,
+	}
+	for name, level := range expected {
+		parsed, ok := parseLevel(name)
+		if !ok || parsed != level {
+			t.Errorf("Wrong level for %v: %v", name, parsed)
+		}
+	}
+}
+
+func TestParseLevel_UnknownLevel_FallBackToInfo(t *testing.T) {
+	for _, name := range []string{"", "trace", "DEBUG", "fatal"} {
+		parsed, ok := parseLevel(name)
+		if ok || parsed != zerolog.InfoLevel {
+			t.Errorf("Unexpected level for %q: %v", name, parsed)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package logging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const serviceName = "oci-secrets-store-csi-driver-provider"
+
+// OTLPSink exports the log records to OpenTelemetry Collector, it's stopped like the metrics exporter
+type OTLPSink struct {
+	provider *sdklog.LoggerProvider
+}
+
+// ConfigureOTLPSink exports the lines of the global logger over OTLP gRPC to the endpoint "host:port",
+// in addition to the stderr output configured by ConfigureGlobalLogger, so it must be called afterwards.
+// Only the message and the level of each line are exported, the fields remain in stderr output,
+// so a value which is not logged in the message is never sent to the collector.
+// The records are exported in batches in the background, an unavailable collector doesn't block logging.
+func ConfigureOTLPSink(endpoint string) (*OTLPSink, error) {
+	exporter, err := otlploggrpc.New(context.Background(),
+		otlploggrpc.WithInsecure(), otlploggrpc.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP log exporter: %w", err)
+	}
+	return addSink(sdklog.NewBatchProcessor(exporter)), nil
+}
+
+// addSink hooks the global logger, so each line is emitted to the processor
+func addSink(processor sdklog.Processor) *OTLPSink {
+	provider := sdklog.NewLoggerProvider(sdklog.WithProcessor(processor),
+		sdklog.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))))
+	log.Logger = log.Logger.Hook(otlpHook{logger: provider.Logger(serviceName)})
+	return &OTLPSink{provider: provider}
+}
+
+// Shutdown exports the pending records and closes the connection to the collector
+func (sink *OTLPSink) Shutdown(ctx context.Context) error {
+	return sink.provider.Shutdown(ctx)
+}
+
+// Close stops the sink without waiting for the collector
+func (sink *OTLPSink) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return sink.provider.Shutdown(ctx)
+}
+
+// otlpHook emits the logged line as OpenTelemetry log record,
+// the hook is copied to the loggers derived from the global one, e.g. the loggers of single mounts
+type otlpHook struct {
+	logger otellog.Logger
+}
+
+func (hook otlpHook) Run(_ *zerolog.Event, level zerolog.Level, message string) {
+	var record otellog.Record
+	record.SetTimestamp(time.Now())
+	record.SetSeverity(severity(level))
+	record.SetSeverityText(level.String())
+	record.SetBody(otellog.StringValue(message))
+	hook.logger.Emit(context.Background(), record)
+}
+
+// severity maps zerolog level to OpenTelemetry severity, lines without level have undefined severity
+func severity(level zerolog.Level) otellog.Severity {
+	switch level {
+	case zerolog.TraceLevel:
+		return otellog.SeverityTrace
+	case zerolog.DebugLevel:
+		return otellog.SeverityDebug
+	case zerolog.InfoLevel:
+		return otellog.SeverityInfo
+	case zerolog.WarnLevel:
+		return otellog.SeverityWarn
+	case zerolog.ErrorLevel:
+		return otellog.SeverityError
+	case zerolog.FatalLevel, zerolog.PanicLevel:
+		return otellog.SeverityFatal
+	default:
+		return otellog.SeverityUndefined
+	}
+}
@@ -0,0 +1,128 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package logging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// memoryExporter - in-memory OTLP log exporter recording the exported records
+type memoryExporter struct {
+	mutex   sync.Mutex
+	records []sdklog.Record
+}
+
+func (exporter *memoryExporter) Export(_ context.Context, records []sdklog.Record) error {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	for _, record := range records {
+		exporter.records = append(exporter.records, record.Clone())
+	}
+	return nil
+}
+
+func (exporter *memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func (exporter *memoryExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (exporter *memoryExporter) exported() []sdklog.Record {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	return append([]sdklog.Record(nil), exporter.records...)
+}
+
+// addMemorySink replaces the global logger with the one writing to the buffer and exporting to the returned exporter
+func addMemorySink(t *testing.T, out *bytes.Buffer) *memoryExporter {
+	t.Helper()
+	originalLogger := log.Logger
+	t.Cleanup(func() { log.Logger = originalLogger })
+	log.Logger = zerolog.New(out)
+	exporter := &memoryExporter{}
+	sink := addSink(sdklog.NewSimpleProcessor(exporter))
+	t.Cleanup(func() { _ = sink.Shutdown(context.Background()) })
+	return exporter
+}
+
+func TestAddSink_LinesLogged_RecordsExportedAndWrittenToStderr(t *testing.T) {
+	var out bytes.Buffer
+	exporter := addMemorySink(t, &out)
+
+	log.Info().Msg("first")
+	log.Error().Msg("second")
+
+	records := exporter.exported()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %v", len(records))
+	}
+	if records[0].Body().AsString() != "first" || records[0].Severity() != otellog.SeverityInfo ||
+		records[0].SeverityText() != "info" {
+		t.Errorf("Wrong record: %v %v", records[0].Body(), records[0].Severity())
+	}
+	if records[1].Body().AsString() != "second" || records[1].Severity() != otellog.SeverityError {
+		t.Errorf("Wrong record: %v %v", records[1].Body(), records[1].Severity())
+	}
+	if !strings.Contains(out.String(), "first") || !strings.Contains(out.String(), "second") {
+		t.Errorf("Lines should be written to stderr output too: %v", out.String())
+	}
+}
+
+func TestAddSink_DerivedLogger_RecordsExported(t *testing.T) {
+	exporter := addMemorySink(t, &bytes.Buffer{})
+
+	logger := log.With().Str("correlationID", "3f2a9c1e0b7d4e65").Logger()
+	ctx := WithLogger(context.Background(), logger)
+	FromContext(ctx).Warn().Msg("mount failed")
+
+	records := exporter.exported()
+	if len(records) != 1 || records[0].Body().AsString() != "mount failed" {
+		t.Fatalf("Unexpected records: %v", records)
+	}
+}
+
+func TestAddSink_LineWithFields_FieldsNotExported(t *testing.T) {
+	var out bytes.Buffer
+	exporter := addMemorySink(t, &out)
+
+	log.Info().Str("content", "c2VjcmV0").Msg("Secret decoded")
+
+	records := exporter.exported()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %v", len(records))
+	}
+	if records[0].Body().AsString() != "Secret decoded" || records[0].AttributesLen() != 0 {
+		t.Errorf("Only the message should be exported: %v", records[0].Body())
+	}
+	if !strings.Contains(out.String(), "c2VjcmV0") {
+		t.Errorf("Fields should remain in stderr output: %v", out.String())
+	}
+}
+
+func TestAddSink_LevelDisabled_RecordNotExported(t *testing.T) {
+	exporter := addMemorySink(t, &bytes.Buffer{})
+	originalLevel := zerolog.GlobalLevel()
+	defer zerolog.SetGlobalLevel(originalLevel)
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	log.Debug().Msg("hidden")
+
+	if records := exporter.exported(); len(records) != 0 {
+		t.Errorf("Unexpected records: %v", len(records))
+	}
+}
@@ -7,21 +7,59 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/rs/zerolog/log"
 )
 
 const prometheusExporter = "prometheus"
+const otlpExporter = "otlp"
 const MetricsPath = "/metrics"
 
-func InitMetricsExporter(metricsBackend string, port int) error {
-	log.Info().Str("backend", metricsBackend).Msg("initializing metrics backend")
-	switch metricsBackend {
-	// Prometheus is the only exporter for now
+// Exporter is the running metrics backend stopped on exit, e.g. the server scraped by Prometheus
+type Exporter interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// ExporterConfig selects the metrics backend and its settings
+type ExporterConfig struct {
+	// Backend is either "prometheus" serving the metrics on Port, or "otlp" pushing them to OTLPEndpoint
+	Backend string
+	Port    int
+	// OTLPEndpoint is the "host:port" of OpenTelemetry Collector receiving OTLP over gRPC
+	OTLPEndpoint string
+}
+
+// InitMetricsExporter starts the metrics backend, which is returned to be shut down on exit.
+// Unless metrics are required, the failure is logged and no exporter is returned,
+// so secrets are served without metrics.
+func InitMetricsExporter(config ExporterConfig, required bool) (Exporter, error) { //nolint:ireturn // backends differ
+	exporter, err := initExporter(config)
+	if err != nil && !required {
+		log.Warn().Err(err).Str("backend", config.Backend).Msg("Metrics are not exported, they are not required")
+		return nil, nil
+	}
+	return exporter, err
+}
+
+func initExporter(config ExporterConfig) (Exporter, error) { //nolint:ireturn // backends differ
+	log.Info().Str("backend", config.Backend).Msg("initializing metrics backend")
+	switch config.Backend {
 	case prometheusExporter:
-		return initPrometheusExporter(port, MetricsPath)
+		server, err := initPrometheusExporter(config.Port, MetricsPath)
+		if err != nil {
+			return nil, err
+		}
+		return server, nil
+	case otlpExporter:
+		pusher, err := initOTLPExporter(config.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		return pusher, nil
 	default:
-		return fmt.Errorf("unsupported metrics backend %v", metricsBackend)
+		return nil, fmt.Errorf("unsupported metrics backend %v", config.Backend)
 	}
 }
@@ -0,0 +1,48 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestInitMetricsExporter_RequiredMetricsFailed_ReturnError(t *testing.T) {
+	server, err := InitMetricsExporter(ExporterConfig{Backend: "statsd"}, true)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if server != nil {
+		t.Errorf("No server should be returned: %v", server)
+	}
+	if err.Error() != "unsupported metrics backend statsd" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestInitMetricsExporter_OptionalMetricsFailed_LogWarningAndContinue(t *testing.T) {
+	originalLogger := log.Logger
+	defer func() { log.Logger = originalLogger }()
+	logs := &bytes.Buffer{}
+	log.Logger = zerolog.New(logs)
+
+	server, err := InitMetricsExporter(ExporterConfig{Backend: "statsd"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server != nil {
+		t.Errorf("No server should be returned: %v", server)
+	}
+	if !strings.Contains(logs.String(), `"level":"warn"`) ||
+		!strings.Contains(logs.String(), "unsupported metrics backend statsd") {
+		t.Errorf("Failure should be logged as warning: %v", logs.String())
+	}
+}
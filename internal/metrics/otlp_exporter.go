@@ -0,0 +1,49 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// otlpPusher pushes the metrics to OpenTelemetry Collector periodically, it's stopped like the metrics server
+type otlpPusher struct {
+	provider *sdkmetric.MeterProvider
+}
+
+// initOTLPExporter installs the pipeline pushing the metrics of the reporter instruments over OTLP gRPC.
+// The connection is established in the background, so an unavailable collector doesn't fail the startup.
+func initOTLPExporter(endpoint string) (*otlpPusher, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("OTLP endpoint is required by %v metrics backend", otlpExporter)
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background(),
+		otlpmetricgrpc.WithInsecure(), otlpmetricgrpc.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP exporter: %w", err)
+	}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	otel.SetMeterProvider(provider)
+	return &otlpPusher{provider: provider}, nil
+}
+
+// Shutdown pushes the last metrics and closes the connection to the collector
+func (pusher *otlpPusher) Shutdown(ctx context.Context) error {
+	return pusher.provider.Shutdown(ctx)
+}
+
+// Close stops the pusher without waiting for the collector
+func (pusher *otlpPusher) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return pusher.provider.Shutdown(ctx)
+}
@@ -0,0 +1,96 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	collectormetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+)
+
+// stubCollector - stub of OpenTelemetry Collector recording names of the pushed metrics
+type stubCollector struct {
+	collectormetricpb.UnimplementedMetricsServiceServer
+	mutex   sync.Mutex
+	metrics map[string]bool
+}
+
+func (collector *stubCollector) Export(_ context.Context,
+	request *collectormetricpb.ExportMetricsServiceRequest) (*collectormetricpb.ExportMetricsServiceResponse, error) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	for _, resourceMetrics := range request.ResourceMetrics {
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, pushedMetric := range scopeMetrics.Metrics {
+				collector.metrics[pushedMetric.Name] = true
+			}
+		}
+	}
+	return &collectormetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (collector *stubCollector) received(name string) bool {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return collector.metrics[name]
+}
+
+func startStubCollector(t *testing.T) (*stubCollector, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to listen: %v", err)
+	}
+	collector := &stubCollector{metrics: make(map[string]bool)}
+	server := grpc.NewServer()
+	collectormetricpb.RegisterMetricsServiceServer(server, collector)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return collector, listener.Addr().String()
+}
+
+func TestInitMetricsExporter_OTLPBackend_PushMetricsToCollector(t *testing.T) {
+	// the tests of the package scrape the Prometheus pipeline installed by TestMain
+	originalProvider := otel.GetMeterProvider()
+	defer otel.SetMeterProvider(originalProvider)
+	collector, endpoint := startStubCollector(t)
+
+	exporter, err := InitMetricsExporter(ExporterConfig{Backend: "otlp", OTLPEndpoint: endpoint}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter, err := otel.Meter("otlp-test").Int64Counter("otlp_test_total")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	counter.Add(context.Background(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := exporter.Shutdown(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !collector.received("otlp_test_total") {
+		t.Error("Metrics should be pushed to the collector on shutdown")
+	}
+}
+
+func TestInitMetricsExporter_OTLPBackendWithoutEndpoint_ReturnError(t *testing.T) {
+	_, err := InitMetricsExporter(ExporterConfig{Backend: "otlp"}, true)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "OTLP endpoint is required by otlp metrics backend" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
@@ -11,24 +11,42 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
-
-	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
-func initPrometheusExporter(port int, path string) error {
-	pusher, err := prometheus.InstallNewPipeline(prometheus.Config{})
+func initPrometheusExporter(port int, path string) (*http.Server, error) {
+	handler, err := installPrometheusPipeline()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	http.Handle(path, handler)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%v", port),
+		ReadHeaderTimeout: 3 * time.Second,
 	}
-	http.HandleFunc(path, pusher.ServeHTTP)
 	go func() {
-		server := &http.Server{
-			Addr:              fmt.Sprintf(":%v", port),
-			ReadHeaderTimeout: 3 * time.Second,
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Metrics: listen and server error")
 		}
-		log.Error().Err(server.ListenAndServe()).Msg("Metrics: listen and server error")
 	}()
 
-	return err
+	return server, nil
+}
+
+// installPrometheusPipeline registers the global meter provider collecting the metrics on each scrape
+// of the returned handler. Only the reported metrics are served, without scope and target info.
+func installPrometheusPipeline() (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry),
+		otelprometheus.WithoutScopeInfo(), otelprometheus.WithoutTargetInfo())
+	if err != nil {
+		return nil, err
+	}
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
 }
@@ -8,19 +8,45 @@ package metrics
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
-	"go.opentelemetry.io/otel/metric/global"
 )
 
 var (
-	grpcRequest     metric.Float64ValueRecorder
+	grpcRequest     metric.Float64Histogram
+	doubleEncoded   metric.Int64Counter
+	rejectedOnStop  metric.Int64Counter
+	decodeErrors    metric.Int64Counter
+	emptyStages     metric.Int64Counter
+	secretFetches   metric.Int64Counter
+	fetchFailures   metric.Int64Counter
+	versionChanges  metric.Int64Counter
+	inFlightMounts  metric.Int64UpDownCounter
 	providerAttr    = attribute.String("provider", "oci-provider")
 	serviceNameAttr = attribute.String("service.name", "oci-secrets-store-csi-driver-provider")
 	grpcMethodKey   = "grpc_method"
 	grpcCodeKey     = "grpc_code"
 	grpcMessageKey  = "grpc_message"
+	reasonKey       = "reason"
+	secretIDKey     = "secret_id"
+	secretNameKey   = "secret_name"
+	vaultIDKey      = "vault_id"
+	spcKey          = "secret_provider_class"
+	principalKey    = "principal_type"
+
+	// requestBuckets are the boundaries of gRPC request durations in seconds, the defaults are meant for milliseconds
+	requestBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	createInstruments sync.Once
+
+	// expiry observer is registered once, since it reports all the known expiries on each collection
+	registerExpiryObserver sync.Once
+	secretExpiries         = &expiryRegistry{expiries: make(map[string]secretExpiry)}
 )
 
 type reporter struct {
@@ -30,29 +56,200 @@ type reporter struct {
 // StatsReporter is the interface for reporting metrics
 type StatsReporter interface {
 	ReportGRPCRequest(ctx context.Context, duration float64, method, code, message string)
+	ReportDoubleEncodedSecret(ctx context.Context)
+	ReportRejectedOnShutdown(ctx context.Context, method string)
+	ReportDecodeError(ctx context.Context, reason string)
+	ReportEmptyStages(ctx context.Context)
+	ReportSecretFetch(ctx context.Context, vaultID, spc, principalType string, success bool)
+	ReportSecretExpiry(secretID, secretName string, expiry time.Time)
+	ReportSecretVersionChange(ctx context.Context, vaultID, secretName string)
+	ReportMountInFlight(ctx context.Context, delta int64)
 }
 
-// NewStatsReporter creates a new StatsReporter
+// NewStatsReporter creates a new StatsReporter.
+// The instruments are created once and shared by all the reporters, since reporters are created concurrently.
 func NewStatsReporter() StatsReporter { //nolint:ireturn //known
-	meter := global.Meter("oci-secrets-store-csi-driver-provider")
-
-	grpcRequest = metric.Must(meter).NewFloat64ValueRecorder("grpc_request",
-		metric.WithDescription("Distribution of how long it took for the gRPC requests"))
+	meter := otel.Meter("oci-secrets-store-csi-driver-provider")
+	createInstruments.Do(func() {
+		grpcRequest = must(meter.Float64Histogram("grpc_request",
+			metric.WithDescription("Distribution of how long it took for the gRPC requests"),
+			metric.WithExplicitBucketBoundaries(requestBuckets...)))
+		doubleEncoded = must(meter.Int64Counter("double_encoded_secrets_total",
+			metric.WithDescription("Number of mounted secrets which content looks base64-encoded twice")))
+		rejectedOnStop = must(meter.Int64Counter("rejected_shutdown_total",
+			metric.WithDescription("Number of gRPC requests rejected since the provider is shutting down")))
+		decodeErrors = must(meter.Int64Counter("decode_error_total",
+			metric.WithDescription("Number of secrets which content could not be decoded")))
+		emptyStages = must(meter.Int64Counter("empty_stages_secrets_total",
+			metric.WithDescription("Number of secret bundles returned by OCI Vault without stages")))
+		secretFetches = must(meter.Int64Counter("secret_fetch_total",
+			metric.WithDescription("Number of requested secrets")))
+		fetchFailures = must(meter.Int64Counter("secret_fetch_failures_total",
+			metric.WithDescription("Number of requested secrets which could not be retrieved")))
+		versionChanges = must(meter.Int64Counter("secret_version_changed_total",
+			metric.WithDescription("Number of times the version retrieved for a secret stage differed from the previous one")))
+		inFlightMounts = must(meter.Int64UpDownCounter("mount_requests_in_flight",
+			metric.WithDescription("Number of Mount requests being executed")))
+	})
 	return &reporter{meter: meter}
 }
 
+// must panics if the instrument could not be created, which happens only for an invalid name
+func must[T any](instrument T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return instrument
+}
+
 // ReportGRPCRequest reports the duration of the gRPC request
 // method and code are used to identify the gRPC request
 func (r *reporter) ReportGRPCRequest(ctx context.Context, duration float64, method, code, message string) {
-	attributes := []attribute.KeyValue{
+	attributes := metric.WithAttributes(
 		serviceNameAttr,
 		providerAttr,
 		attribute.String(grpcMethodKey, method),
 		attribute.String(grpcCodeKey, code),
 		attribute.String(grpcMessageKey, message),
-	}
-	r.meter.RecordBatch(ctx,
-		attributes,
-		grpcRequest.Measurement(duration),
 	)
+	grpcRequest.Record(ctx, duration, attributes)
+	summaryCounters.recordRequest(duration, code)
+}
+
+// ReportDoubleEncodedSecret counts mounted secret which content looks base64-encoded twice
+func (r *reporter) ReportDoubleEncodedSecret(ctx context.Context) {
+	doubleEncoded.Add(ctx, 1, metric.WithAttributes(serviceNameAttr, providerAttr))
+}
+
+// ReportRejectedOnShutdown counts gRPC request rejected since the provider is shutting down
+func (r *reporter) ReportRejectedOnShutdown(ctx context.Context, method string) {
+	rejectedOnStop.Add(ctx, 1,
+		metric.WithAttributes(serviceNameAttr, providerAttr, attribute.String(grpcMethodKey, method)))
+}
+
+// ReportDecodeError counts secret which content could not be decoded for the reason
+func (r *reporter) ReportDecodeError(ctx context.Context, reason string) {
+	decodeErrors.Add(ctx, 1, metric.WithAttributes(serviceNameAttr, providerAttr, attribute.String(reasonKey, reason)))
+}
+
+// ReportEmptyStages counts secret bundle returned by OCI Vault without stages
+func (r *reporter) ReportEmptyStages(ctx context.Context) {
+	emptyStages.Add(ctx, 1, metric.WithAttributes(serviceNameAttr, providerAttr))
+}
+
+// ReportSecretFetch counts requested secret, and failure to retrieve it unless success,
+// labeled by the vault, the SecretProviderClass and the principal type secret is requested with.
+// The vault is identified by the digest of its OCID logged by the provider, not the OCID itself.
+func (r *reporter) ReportSecretFetch(ctx context.Context, vaultID, spc, principalType string, success bool) {
+	attributes := metric.WithAttributes(
+		serviceNameAttr,
+		providerAttr,
+		attribute.String(vaultIDKey, vaultID),
+		attribute.String(spcKey, spc),
+		attribute.String(principalKey, principalType),
+	)
+	secretFetches.Add(ctx, 1, attributes)
+	if !success {
+		fetchFailures.Add(ctx, 1, attributes)
+	}
+	summaryCounters.recordFetch(success)
+}
+
+// ReportSecretVersionChange counts secret which version retrieved by stage differs from the previously retrieved one,
+// e.g. once the secret is rotated, labeled by the digest of the vault OCID and the secret name
+func (r *reporter) ReportSecretVersionChange(ctx context.Context, vaultID, secretName string) {
+	versionChanges.Add(ctx, 1, metric.WithAttributes(
+		serviceNameAttr,
+		providerAttr,
+		attribute.String(vaultIDKey, vaultID),
+		attribute.String(secretNameKey, secretName),
+	))
+}
+
+// ReportMountInFlight adds delta to the number of Mount requests being executed,
+// it's incremented once the request starts and decremented once it's finished
+func (r *reporter) ReportMountInFlight(ctx context.Context, delta int64) {
+	inFlightMounts.Add(ctx, delta, metric.WithAttributes(serviceNameAttr, providerAttr))
+}
+
+// ReportSecretExpiry remembers the expiry of the mounted secret,
+// so the time left until the expiry is reported on each metrics collection.
+func (r *reporter) ReportSecretExpiry(secretID, secretName string, expiry time.Time) {
+	registerExpiryObserver.Do(func() {
+		must(r.meter.Float64ObservableGauge("secret_seconds_until_expiry",
+			metric.WithDescription("Number of seconds left until the mounted secret expires"),
+			metric.WithFloat64Callback(secretExpiries.observe)))
+	})
+	secretExpiries.put(secretID, secretExpiry{name: secretName, expiry: expiry})
+}
+
+// expiryRetention is the time the expiry of a secret is reported after its last mount, long enough to outlive
+// the rotation interval of the driver, so only the secrets which are no longer mounted are forgotten
+const expiryRetention = 24 * time.Hour
+
+// maxReportedExpiries caps the number of the reported expiries, the least recently mounted ones are forgotten
+const maxReportedExpiries = 10000
+
+type secretExpiry struct {
+	name      string
+	expiry    time.Time
+	mountedAt time.Time
+}
+
+// expiryRegistry stores expiries of the mounted secrets by secret id, forgetting the secrets not mounted
+// for expiryRetention, e.g. deleted ones, so the number of the reported series is bounded
+type expiryRegistry struct {
+	mutex    sync.Mutex
+	expiries map[string]secretExpiry
+	clock    clock.Clock // system clock when nil
+}
+
+func (registry *expiryRegistry) put(secretID string, expiry secretExpiry) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := clock.OrReal(registry.clock).Now()
+	// the stale expiries are forgotten on each collection, here only once the registry is full
+	if _, ok := registry.expiries[secretID]; !ok && len(registry.expiries) >= maxReportedExpiries {
+		registry.forgetStale(now)
+		if len(registry.expiries) >= maxReportedExpiries {
+			registry.forgetLeastRecentlyMounted()
+		}
+	}
+	expiry.mountedAt = now
+	registry.expiries[secretID] = expiry
+}
+
+func (registry *expiryRegistry) forgetStale(now time.Time) {
+	for secretID, expiry := range registry.expiries {
+		if now.Sub(expiry.mountedAt) > expiryRetention {
+			delete(registry.expiries, secretID)
+		}
+	}
+}
+
+func (registry *expiryRegistry) forgetLeastRecentlyMounted() {
+	var oldestID string
+	var oldest time.Time
+	for secretID, expiry := range registry.expiries {
+		if oldestID == "" || expiry.mountedAt.Before(oldest) {
+			oldestID, oldest = secretID, expiry.mountedAt
+		}
+	}
+	delete(registry.expiries, oldestID)
+}
+
+func (registry *expiryRegistry) observe(_ context.Context, result metric.Float64Observer) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	now := clock.OrReal(registry.clock).Now()
+	registry.forgetStale(now)
+	for secretID, expiry := range registry.expiries {
+		result.Observe(expiry.expiry.Sub(now).Seconds(), metric.WithAttributes(
+			serviceNameAttr,
+			providerAttr,
+			attribute.String(secretIDKey, secretID),
+			attribute.String(secretNameKey, expiry.name),
+		))
+	}
+	return nil
 }
@@ -0,0 +1,219 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
+)
+
+// metricsHandler serves metrics reported by the tests
+var metricsHandler http.Handler
+
+func TestMain(m *testing.M) {
+	_ = logging.ConfigureGlobalLogger(logging.ConsoleFormat, logging.InfoLevel) // console format is always valid
+	var err error
+	// the metrics are collected on each scrape, so it returns the latest values
+	metricsHandler, err = installPrometheusPipeline()
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// scrapeMetrics returns metrics in Prometheus text format.
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(recorder, httptest.NewRequest("GET", MetricsPath, nil))
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatalf("Unable to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+// findMetricLine returns the sample line of the metric with the given name and label, or empty string.
+func findMetricLine(metrics string, name string, label string) string {
+	for _, line := range strings.Split(metrics, "\n") {
+		if strings.HasPrefix(line, name+"{") && strings.Contains(line, label) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestReportRejectedOnShutdown_TwoRequests_CounterIncremented(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportRejectedOnShutdown(context.Background(), "/v1alpha1.CSIDriverProvider/Mount")
+	reporter.ReportRejectedOnShutdown(context.Background(), "/v1alpha1.CSIDriverProvider/Mount")
+
+	line := findMetricLine(scrapeMetrics(t), "rejected_shutdown_total",
+		`grpc_method="/v1alpha1.CSIDriverProvider/Mount"`)
+	if !strings.HasSuffix(line, " 2") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportDoubleEncodedSecret_SingleSecret_CounterIncremented(t *testing.T) {
+	NewStatsReporter().ReportDoubleEncodedSecret(context.Background())
+
+	line := findMetricLine(scrapeMetrics(t), "double_encoded_secrets_total", `provider="oci-provider"`)
+	if !strings.HasSuffix(line, " 1") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportDecodeError_DifferentReasons_CounterIncrementedPerReason(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportDecodeError(context.Background(), "malformed_content")
+	reporter.ReportDecodeError(context.Background(), "malformed_content")
+	reporter.ReportDecodeError(context.Background(), "missed_content")
+
+	metrics := scrapeMetrics(t)
+	line := findMetricLine(metrics, "decode_error_total", `reason="malformed_content"`)
+	if !strings.HasSuffix(line, " 2") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+	line = findMetricLine(metrics, "decode_error_total", `reason="missed_content"`)
+	if !strings.HasSuffix(line, " 1") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportEmptyStages_TwoSecrets_CounterIncremented(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportEmptyStages(context.Background())
+	reporter.ReportEmptyStages(context.Background())
+
+	line := findMetricLine(scrapeMetrics(t), "empty_stages_secrets_total", `provider="oci-provider"`)
+	if !strings.HasSuffix(line, " 2") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportSecretFetch_SuccessAndFailure_CountersIncremented(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-fetch", "instance", true)
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-fetch", "instance", true)
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-fetch", "instance", false)
+
+	metrics := scrapeMetrics(t)
+	line := findMetricLine(metrics, "secret_fetch_total", `secret_provider_class="spc-fetch"`)
+	if !strings.HasSuffix(line, " 3") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+	if !strings.Contains(line, `vault_id="vault1"`) || !strings.Contains(line, `principal_type="instance"`) {
+		t.Errorf("Unexpected metric labels: %v", line)
+	}
+	line = findMetricLine(metrics, "secret_fetch_failures_total", `secret_provider_class="spc-fetch"`)
+	if !strings.HasSuffix(line, " 1") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportSecretVersionChange_TwoRotations_CounterIncrementedPerSecret(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportSecretVersionChange(context.Background(), "vault1", "rotated")
+	reporter.ReportSecretVersionChange(context.Background(), "vault1", "rotated")
+
+	line := findMetricLine(scrapeMetrics(t), "secret_version_changed_total", `secret_name="rotated"`)
+	if !strings.HasSuffix(line, " 2") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+	if !strings.Contains(line, `vault_id="vault1"`) {
+		t.Errorf("Unexpected metric labels: %v", line)
+	}
+}
+
+func TestReportMountInFlight_IncrementsAndDecrements_ReportDifference(t *testing.T) {
+	reporter := NewStatsReporter()
+	reporter.ReportMountInFlight(context.Background(), 1)
+	reporter.ReportMountInFlight(context.Background(), 1)
+	reporter.ReportMountInFlight(context.Background(), -1)
+
+	line := findMetricLine(scrapeMetrics(t), "mount_requests_in_flight", `provider="oci-provider"`)
+	if !strings.HasSuffix(line, " 1") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+
+	reporter.ReportMountInFlight(context.Background(), -1)
+
+	line = findMetricLine(scrapeMetrics(t), "mount_requests_in_flight", `provider="oci-provider"`)
+	if !strings.HasSuffix(line, " 0") {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportSecretExpiry_ExpiryInOneHour_ReportSecondsUntilExpiry(t *testing.T) {
+	NewStatsReporter().ReportSecretExpiry("stub-secret-id", "foo", time.Now().Add(time.Hour))
+
+	line := findMetricLine(scrapeMetrics(t), "secret_seconds_until_expiry", `secret_id="stub-secret-id"`)
+	if !strings.Contains(line, `secret_name="foo"`) {
+		t.Fatalf("Unexpected metric labels: %v", line)
+	}
+	seconds, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seconds <= 3500 || seconds > 3600 {
+		t.Errorf("Unexpected metric value: %v", line)
+	}
+}
+
+func TestReportSecretExpiry_SecretNotMountedForRetention_ForgetExpiry(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	secretExpiries.mutex.Lock()
+	secretExpiries.clock = fakeClock
+	secretExpiries.mutex.Unlock()
+	defer func() {
+		secretExpiries.mutex.Lock()
+		secretExpiries.clock = nil
+		secretExpiries.mutex.Unlock()
+	}()
+	reporter := NewStatsReporter()
+	reporter.ReportSecretExpiry("deleted-secret-id", "foo", fakeClock.Now().Add(48*time.Hour))
+
+	fakeClock.Advance(expiryRetention / 2)
+	reporter.ReportSecretExpiry("mounted-secret-id", "bar", fakeClock.Now().Add(48*time.Hour))
+	fakeClock.Advance(expiryRetention/2 + time.Minute)
+	reporter.ReportSecretExpiry("mounted-secret-id", "bar", fakeClock.Now().Add(48*time.Hour))
+
+	metrics := scrapeMetrics(t)
+	if line := findMetricLine(metrics, "secret_seconds_until_expiry", `secret_id="deleted-secret-id"`); line != "" {
+		t.Errorf("Expiry of the secret not mounted for the retention should be forgotten: %v", line)
+	}
+	if findMetricLine(metrics, "secret_seconds_until_expiry", `secret_id="mounted-secret-id"`) == "" {
+		t.Error("Expiry of the mounted secret should be reported")
+	}
+}
+
+func TestExpiryRegistry_LimitReached_ForgetLeastRecentlyMounted(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	registry := &expiryRegistry{expiries: make(map[string]secretExpiry), clock: fakeClock}
+	for i := 0; i <= maxReportedExpiries; i++ {
+		registry.put("secret-id-"+strconv.Itoa(i), secretExpiry{name: "foo", expiry: fakeClock.Now().Add(time.Hour)})
+		fakeClock.Advance(time.Millisecond)
+	}
+
+	if len(registry.expiries) != maxReportedExpiries {
+		t.Fatalf("Unexpected number of expiries: %v", len(registry.expiries))
+	}
+	if _, ok := registry.expiries["secret-id-0"]; ok {
+		t.Error("Expiry of the least recently mounted secret should be forgotten")
+	}
+}
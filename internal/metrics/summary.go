@@ -0,0 +1,102 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/rs/zerolog/log"
+)
+
+// okCode is the gRPC code of successful requests
+const okCode = "OK"
+
+// summaryCounters accumulates the values reported to the instruments, since OpenTelemetry API doesn't allow
+// to read them back, so the summary could be logged where metrics are not scraped.
+var summaryCounters = &summary{}
+
+// summary holds the totals of the reported values
+type summary struct {
+	mutex  sync.Mutex
+	totals summaryTotals
+}
+
+type summaryTotals struct {
+	requests       int64
+	failedRequests int64
+	requestSeconds float64
+	secretFetches  int64
+	fetchFailures  int64
+}
+
+func (counters *summary) recordRequest(duration float64, code string) {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	counters.totals.requests++
+	counters.totals.requestSeconds += duration
+	if code != okCode {
+		counters.totals.failedRequests++
+	}
+}
+
+func (counters *summary) recordFetch(success bool) {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	counters.totals.secretFetches++
+	if !success {
+		counters.totals.fetchFailures++
+	}
+}
+
+func (counters *summary) snapshot() summaryTotals {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+	return counters.totals
+}
+
+// LogSummaries logs the summary of the metrics reported within each interval until the stop channel is closed.
+func LogSummaries(interval time.Duration, stop <-chan struct{}) {
+	logSummaries(clock.Real, interval, stop)
+}
+
+func logSummaries(summaryClock clock.Clock, interval time.Duration, stop <-chan struct{}) {
+	previous := summaryCounters.snapshot()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-summaryClock.After(interval):
+			current := summaryCounters.snapshot()
+			logSummary(interval, previous, current)
+			previous = current
+		}
+	}
+}
+
+// logSummary logs the difference between the totals, so each summary covers its interval only
+func logSummary(interval time.Duration, previous summaryTotals, current summaryTotals) {
+	requests := current.requests - previous.requests
+	failedRequests := current.failedRequests - previous.failedRequests
+	var errorRate float64
+	var avgLatency time.Duration
+	if requests > 0 {
+		errorRate = float64(failedRequests) / float64(requests)
+		avgLatency = time.Duration((current.requestSeconds - previous.requestSeconds) / float64(requests) *
+			float64(time.Second))
+	}
+	log.Info().
+		Dur("interval", interval).
+		Int64("requests", requests).
+		Int64("failedRequests", failedRequests).
+		Float64("errorRate", errorRate).
+		Dur("avgLatency", avgLatency).
+		Int64("secretFetches", current.secretFetches-previous.secretFetches).
+		Int64("fetchFailures", current.fetchFailures-previous.fetchFailures).
+		Msg("Metrics summary")
+}
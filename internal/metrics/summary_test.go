@@ -0,0 +1,98 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// waitForSummaryLoop waits until the summary loop is blocked on the clock, i.e. it has logged the previous summary
+func waitForSummaryLoop(t *testing.T, fakeClock *clock.Fake) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for fakeClock.Waiters() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Summary loop is not waiting for the next interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLogSummaries_ReportedMetrics_LogSummaryOfInterval(t *testing.T) {
+	originalLogger := log.Logger
+	defer func() { log.Logger = originalLogger }()
+	logs := &bytes.Buffer{}
+	log.Logger = zerolog.New(logs)
+
+	fakeClock := clock.NewFake(time.Now())
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logSummaries(fakeClock, time.Minute, stop)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	waitForSummaryLoop(t, fakeClock)
+
+	reporter := NewStatsReporter()
+	reporter.ReportGRPCRequest(context.Background(), 0.1, "/v1alpha1.CSIDriverProvider/Mount", "OK", "")
+	reporter.ReportGRPCRequest(context.Background(), 0.3, "/v1alpha1.CSIDriverProvider/Mount", "NotFound", "")
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-summary", "instance", true)
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-summary", "instance", false)
+	reporter.ReportSecretFetch(context.Background(), "vault1", "spc-summary", "instance", true)
+	fakeClock.Advance(time.Minute)
+	waitForSummaryLoop(t, fakeClock)
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Malformed log record %v: %v", logs.String(), err)
+	}
+	expected := map[string]interface{}{
+		"message":        "Metrics summary",
+		"level":          "info",
+		"interval":       float64(60000),
+		"requests":       float64(2),
+		"failedRequests": float64(1),
+		"errorRate":      0.5,
+		"avgLatency":     float64(200),
+		"secretFetches":  float64(3),
+		"fetchFailures":  float64(1),
+	}
+	for field, value := range expected {
+		if record[field] != value {
+			t.Errorf("Unexpected %v: %v", field, record[field])
+		}
+	}
+}
+
+func TestLogSummaries_NoRequests_LogZeroRates(t *testing.T) {
+	originalLogger := log.Logger
+	defer func() { log.Logger = originalLogger }()
+	logs := &bytes.Buffer{}
+	log.Logger = zerolog.New(logs)
+
+	logSummary(time.Minute, summaryTotals{requests: 3, requestSeconds: 1}, summaryTotals{requests: 3, requestSeconds: 1})
+
+	record := make(map[string]interface{})
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Malformed log record %v: %v", logs.String(), err)
+	}
+	if record["requests"] != float64(0) || record["errorRate"] != float64(0) || record["avgLatency"] != float64(0) {
+		t.Errorf("Unexpected summary: %v", logs.String())
+	}
+}
@@ -0,0 +1,33 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package network
+
+import "fmt"
+
+const maxPort = 65535
+
+// NamedPort is a TCP port served by the provider, named after the flag configuring it.
+type NamedPort struct {
+	Name   string
+	Number int
+}
+
+// ValidatePorts checks that every port is in valid range and no two servers share the same port,
+// since a listener failing in the background would otherwise go unnoticed.
+func ValidatePorts(ports []NamedPort) error {
+	owners := make(map[int]string, len(ports))
+	for _, port := range ports {
+		if port.Number < 1 || port.Number > maxPort {
+			return fmt.Errorf("%v %v is out of range 1-%v", port.Name, port.Number, maxPort)
+		}
+		if owner, ok := owners[port.Number]; ok {
+			return fmt.Errorf("%v and %v are both set to %v", owner, port.Name, port.Number)
+		}
+		owners[port.Number] = port.Name
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package network
+
+import "testing"
+
+func TestValidatePorts_DistinctPorts_ReturnNoError(t *testing.T) {
+	err := ValidatePorts([]NamedPort{{"healthz-port", 8098}, {"metrics-port", 8198}, {"pprof-port", 6060}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidatePorts_ConflictingPorts_ReturnError(t *testing.T) {
+	err := ValidatePorts([]NamedPort{{"healthz-port", 8098}, {"metrics-port", 8198}, {"pprof-port", 8198}})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "metrics-port and pprof-port are both set to 8198" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestValidatePorts_PortOutOfRange_ReturnError(t *testing.T) {
+	for _, port := range []int{0, -1, 65536} {
+		err := ValidatePorts([]NamedPort{{"metrics-port", port}})
+		if err == nil {
+			t.Fatalf("An error was expected for port %v", port)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig locates PEM files used to serve TCP endpoint with mutual TLS.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// CAFile contains certificates of the authorities allowed to sign client certificates
+	CAFile string
+}
+
+// Enabled reports whether any of TLS files is configured.
+func (config TLSConfig) Enabled() bool {
+	return config.CertFile != "" || config.KeyFile != "" || config.CAFile != ""
+}
+
+// NewServerCredentials creates gRPC transport credentials which require client certificate
+// signed by one of the configured authorities.
+func NewServerCredentials(config TLSConfig) (credentials.TransportCredentials, error) { //nolint:ireturn // gRPC API
+	if config.CertFile == "" || config.KeyFile == "" || config.CAFile == "" {
+		return nil, fmt.Errorf("certificate, key and CA files are required for mutual TLS")
+	}
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	caCertificates, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caCertificates) {
+		return nil, fmt.Errorf("no valid certificates found in CA file %v", config.CAFile)
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
@@ -0,0 +1,201 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package network
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+func TestMain(m *testing.M) {
+	testutils.RunTestCase(m)
+}
+
+// testPKI - CA with server and client certificates signed by it
+type testPKI struct {
+	config            TLSConfig
+	caPool            *x509.CertPool
+	clientCertificate tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, caCertificate := issueCertificate(t, "test-ca", nil, nil)
+	serverKey, serverCertificate := issueCertificate(t, "localhost", caCertificate, caKey)
+	clientKey, clientCertificate := issueCertificate(t, "csi-driver", caCertificate, caKey)
+
+	config := TLSConfig{
+		CertFile: writePEM(t, dir, "server.crt", "CERTIFICATE", serverCertificate.Raw),
+		KeyFile:  writePEM(t, dir, "server.key", "EC PRIVATE KEY", marshalKey(t, serverKey)),
+		CAFile:   writePEM(t, dir, "ca.crt", "CERTIFICATE", caCertificate.Raw),
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCertificate)
+	return &testPKI{
+		config: config,
+		caPool: caPool,
+		clientCertificate: tls.Certificate{
+			Certificate: [][]byte{clientCertificate.Raw},
+			PrivateKey:  clientKey,
+		},
+	}
+}
+
+// issueCertificate creates self-signed certificate when parent is nil
+func issueCertificate(t *testing.T, commonName string,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to parse certificate: %v", err)
+	}
+	return key, certificate
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to marshal key: %v", err)
+	}
+	return der
+}
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write %v: %v", name, err)
+	}
+	return path
+}
+
+// serveTLS starts gRPC server with the credentials on a TCP loopback port and returns its address
+func serveTLS(t *testing.T, config TLSConfig) string {
+	t.Helper()
+	serverCredentials, err := NewServerCredentials(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(serverCredentials))
+	provider.RegisterCSIDriverProviderServer(grpcServer, &provider.UnimplementedCSIDriverProviderServer{})
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+func callVersion(t *testing.T, address string, clientConfig *tls.Config) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connection, err := grpc.DialContext(ctx, address,
+		grpc.WithTransportCredentials(credentials.NewTLS(clientConfig)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connection.Close()
+	_, err = provider.NewCSIDriverProviderClient(connection).Version(ctx, &provider.VersionRequest{})
+	return err
+}
+
+func TestNewServerCredentials_ValidClientCertificate_AcceptConnection(t *testing.T) {
+	pki := newTestPKI(t)
+	address := serveTLS(t, pki.config)
+
+	err := callVersion(t, address, &tls.Config{
+		RootCAs:      pki.caPool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{pki.clientCertificate},
+		MinVersion:   tls.VersionTLS12,
+	})
+	// the stub server does not implement Version, so the call reaches the handler only over established TLS
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Connection with valid client certificate should be accepted: %v", err)
+	}
+}
+
+func TestNewServerCredentials_NoClientCertificate_RejectConnection(t *testing.T) {
+	pki := newTestPKI(t)
+	address := serveTLS(t, pki.config)
+
+	err := callVersion(t, address, &tls.Config{
+		RootCAs:    pki.caPool,
+		ServerName: "localhost",
+		MinVersion: tls.VersionTLS12,
+	})
+	if err == nil || status.Code(err) == codes.Unimplemented {
+		t.Errorf("Connection without client certificate should be rejected: %v", err)
+	}
+}
+
+func TestNewServerCredentials_MissedCAFile_ReturnError(t *testing.T) {
+	pki := newTestPKI(t)
+	config := pki.config
+	config.CAFile = ""
+
+	_, err := NewServerCredentials(config)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestNewServerCredentials_InvalidCAFile_ReturnError(t *testing.T) {
+	pki := newTestPKI(t)
+	config := pki.config
+	config.CAFile = writePEM(t, t.TempDir(), "ca.crt", "GARBAGE", []byte("garbage"))
+
+	_, err := NewServerCredentials(config)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
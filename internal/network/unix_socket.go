@@ -7,7 +7,9 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"strings"
@@ -15,30 +17,46 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// ListenUDS announces on the Unix domain socket (UDS) network address.
-// Socket located by socketPath would be created automatically if it does not exist.
-// In case when there is pre-existing socket, it will be replaced with the new one.
-// It returns UDS listener.
-func ListenUDS(endpoint string) (net.Listener, error) {
+// Protocols of the endpoints the provider listens on
+const (
+	UnixProto = "unix"
+	TCPProto  = "tcp"
+)
 
+// Listen announces on the endpoint, which is either Unix domain socket, e.g. unix:///tmp/provider.sock,
+// or TCP address, e.g. tcp://0.0.0.0:1234. It returns the listener and the protocol of the endpoint.
+func Listen(endpoint string) (net.Listener, string, error) {
 	proto, addr, err := ParseSocketEndpoint(endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse endpoint")
+		return nil, "", fmt.Errorf("failed to parse endpoint")
+	}
+	if proto == TCPProto {
+		log.Info().Str("address", addr).Msg("Opening TCP socket")
+		listener, err := net.Listen(proto, addr)
+		return listener, proto, err
 	}
+	listener, err := listenUDS(addr)
+	return listener, proto, err
+}
 
-	if addr == "" {
+// listenUDS announces on the Unix domain socket (UDS) network address.
+// Socket located by socketPath would be created automatically if it does not exist.
+// In case when there is pre-existing socket, it will be replaced with the new one.
+// It returns UDS listener.
+func listenUDS(socketPath string) (net.Listener, error) {
+	if socketPath == "" {
 		return nil, fmt.Errorf("socket path is empty")
 	}
 
 	// Attempt to remove the Unix domain socket (UDS) to handle cases where a previous execution was
 	// terminated before fully closing the socket listener and unlinking.
-	err = removeSocketIfExists(addr)
+	err := removeSocketIfExists(socketPath)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Info().Str("socketPath", addr).Msg("Opening unix domain socket")
-	return net.Listen(proto, addr) // creates socket file automatically
+	log.Info().Str("socketPath", socketPath).Msg("Opening unix domain socket")
+	return net.Listen(UnixProto, socketPath) // creates socket file automatically
 }
 
 func removeSocketIfExists(socketPath string) error {
@@ -57,10 +75,36 @@ func removeSocketIfExists(socketPath string) error {
 	return nil
 }
 
+// UnchangedOwner keeps the owner or the group of the socket file when passed to SetSocketAccess
+const UnchangedOwner = -1
+
+// SetSocketAccess applies the mode to the socket file, then changes its owner and group unless they are
+// UnchangedOwner, e.g. to make the socket group-owned by the group the driver runs as.
+// Only root, or a process with CAP_CHOWN, could give the socket away, so the failure explains that.
+func SetSocketAccess(socketPath string, mode os.FileMode, uid int, gid int) error {
+	if err := os.Chmod(socketPath, mode); err != nil {
+		return fmt.Errorf("failed to change mode of unix socket: %w", err)
+	}
+	if uid == UnchangedOwner && gid == UnchangedOwner {
+		return nil
+	}
+	err := os.Chown(socketPath, uid, gid)
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("not permitted to change owner of unix socket to uid %v and gid %v, "+
+			"the provider should run as root or with CAP_CHOWN capability: %w", uid, gid, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change owner of unix socket: %w", err)
+	}
+	log.Info().Str("socketPath", socketPath).Int("uid", uid).Int("gid", gid).Msg("Changed owner of unix socket")
+	return nil
+}
+
+// ParseSocketEndpoint splits the endpoint into the lower-case protocol and the address.
 func ParseSocketEndpoint(endpoint string) (string, string, error) {
 	if strings.HasPrefix(strings.ToLower(endpoint), "unix://") || strings.HasPrefix(strings.ToLower(endpoint), "tcp://") {
 		endpointParts := strings.SplitN(endpoint, "://", 2)
-		proto, addr := endpointParts[0], endpointParts[1]
+		proto, addr := strings.ToLower(endpointParts[0]), endpointParts[1]
 		if addr != "" {
 			return proto, addr, nil
 		}
@@ -0,0 +1,155 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package network
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestListen_UnixEndpoint_ReplaceStaleSocketFile(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "provider.sock")
+	if err := os.WriteFile(socketPath, nil, 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write stale socket file: %v", err)
+	}
+
+	listener, proto, err := Listen("unix://" + socketPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	if proto != UnixProto {
+		t.Errorf("Wrong proto: %v", proto)
+	}
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		t.Errorf("Socket file is expected, mode: %v", info.Mode())
+	}
+}
+
+func TestListen_TCPEndpoint_ListenWithoutSocketFile(t *testing.T) {
+	listener, proto, err := Listen("TCP://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	if proto != TCPProto {
+		t.Errorf("Wrong proto: %v", proto)
+	}
+	if _, ok := listener.Addr().(*net.TCPAddr); !ok {
+		t.Errorf("TCP listener is expected, address: %v", listener.Addr())
+	}
+	if _, err := os.Stat("127.0.0.1:0"); !os.IsNotExist(err) {
+		t.Errorf("Socket file should not be created: %v", err)
+	}
+}
+
+func TestListen_InvalidEndpoint_ReturnError(t *testing.T) {
+	for _, endpoint := range []string{"", "/tmp/provider.sock", "unix://", "udp://127.0.0.1:1234"} {
+		if _, _, err := Listen(endpoint); err == nil {
+			t.Errorf("An error was expected for endpoint %q", endpoint)
+		}
+	}
+}
+
+// listenTestSocket listens on a socket file in temp dir, the listener is closed at the end of the test
+func listenTestSocket(t *testing.T) string {
+	socketPath := filepath.Join(t.TempDir(), "provider.sock")
+	listener, _, err := Listen("unix://" + socketPath)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return socketPath
+}
+
+func socketOwner(t *testing.T, socketPath string) (os.FileMode, uint32, uint32) {
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	return info.Mode().Perm(), stat.Uid, stat.Gid
+}
+
+func TestSetSocketAccess_UnchangedOwner_ChangeModeOnly(t *testing.T) {
+	socketPath := listenTestSocket(t)
+
+	if err := SetSocketAccess(socketPath, 0660, UnchangedOwner, UnchangedOwner); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mode, uid, gid := socketOwner(t, socketPath)
+	if mode != 0660 {
+		t.Errorf("Wrong mode: %v", mode)
+	}
+	if uid != uint32(os.Getuid()) || gid != uint32(os.Getgid()) {
+		t.Errorf("Owner should not change, uid: %v, gid: %v", uid, gid)
+	}
+}
+
+func TestSetSocketAccess_OwnGroup_ChangeGroup(t *testing.T) {
+	socketPath := listenTestSocket(t)
+
+	if err := SetSocketAccess(socketPath, 0660, UnchangedOwner, os.Getgid()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, _, gid := socketOwner(t, socketPath); gid != uint32(os.Getgid()) {
+		t.Errorf("Wrong gid: %v", gid)
+	}
+}
+
+func TestSetSocketAccess_RunAsRoot_ChangeOwnerAndGroup(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing owner of the socket to another user requires root")
+	}
+	socketPath := listenTestSocket(t)
+
+	if err := SetSocketAccess(socketPath, 0660, 1234, 5678); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mode, uid, gid := socketOwner(t, socketPath)
+	if mode != 0660 || uid != 1234 || gid != 5678 {
+		t.Errorf("Wrong mode or owner, mode: %v, uid: %v, gid: %v", mode, uid, gid)
+	}
+}
+
+func TestSetSocketAccess_NotPermitted_ReturnPermissionError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Root is permitted to change owner of the socket")
+	}
+	socketPath := listenTestSocket(t)
+
+	err := SetSocketAccess(socketPath, 0660, 0, 0)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !errors.Is(err, fs.ErrPermission) || !strings.Contains(err.Error(), "CAP_CHOWN") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestSetSocketAccess_MissingSocketFile_ReturnError(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "provider.sock")
+
+	if err := SetSocketAccess(socketPath, 0660, UnchangedOwner, UnchangedOwner); err == nil {
+		t.Fatal("An error was expected")
+	}
+}
@@ -0,0 +1,45 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// fileVersion is a cheap way to detect the file change without reading it
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFileVersion(path string) (fileVersion, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: fileInfo.ModTime(), size: fileInfo.Size()}, nil
+}
+
+// watchFile calls reloadIfChanged with the given interval until the stop channel is closed.
+// Failures are only logged, so the active policy is kept until the file is fixed.
+func watchFile(interval time.Duration, stop <-chan struct{}, reloadIfChanged func() error, path string, what string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := reloadIfChanged(); err != nil {
+				log.Error().Err(err).Str("path", path).Msgf("Failed to reload %v, keeping the active list", what)
+			}
+		}
+	}
+}
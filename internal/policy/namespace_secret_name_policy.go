@@ -0,0 +1,117 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// otherNamespaces is the key of the patterns applied to the namespaces not listed in the file,
+// it can't clash with a namespace name
+const otherNamespaces = "*"
+
+// namespacePatterns are the allowed and denied secret name patterns of a namespace in the file
+type namespacePatterns struct {
+	Allowed []string `yaml:"allowed"`
+	Denied  []string `yaml:"denied"`
+}
+
+// NamespaceSecretNamePolicy restricts names of the secrets pods of each namespace could mount, so tenants sharing
+// a vault are kept apart. The file is a YAML map of namespace to its "allowed" and "denied" patterns,
+// which follow SecretNamePolicy rules. The "*" entry applies to unlisted namespaces, which are unrestricted otherwise.
+// The file is expected to be a ConfigMap projection, so it's reloaded when changed.
+type NamespaceSecretNamePolicy struct {
+	path     string
+	policies atomic.Pointer[map[string]*SecretNamePolicy]
+	// fileVersion identifies the loaded file content, it's accessed by a single watching goroutine
+	fileVersion fileVersion
+}
+
+// NewNamespaceSecretNamePolicy loads the patterns of the namespaces from the file.
+func NewNamespaceSecretNamePolicy(path string) (*NamespaceSecretNamePolicy, error) {
+	namespacePolicy := &NamespaceSecretNamePolicy{path: path}
+	if err := namespacePolicy.Reload(); err != nil {
+		return nil, err
+	}
+	return namespacePolicy, nil
+}
+
+// IsAllowed reports whether pods of the namespace could mount the secret with the name.
+func (namespacePolicy *NamespaceSecretNamePolicy) IsAllowed(namespace string, name string) bool {
+	policies := *namespacePolicy.policies.Load()
+	namePolicy, ok := policies[namespace]
+	if !ok {
+		namePolicy, ok = policies[otherNamespaces]
+	}
+	return !ok || namePolicy.IsAllowed(name)
+}
+
+// Reload reads the file and replaces the active patterns.
+// The active patterns are kept when the file could not be read or has invalid patterns.
+func (namespacePolicy *NamespaceSecretNamePolicy) Reload() error {
+	version, err := statFileVersion(namespacePolicy.path)
+	if err != nil {
+		return fmt.Errorf("unable to read namespace secret names file: %w", err)
+	}
+	content, err := os.ReadFile(namespacePolicy.path)
+	if err != nil {
+		return fmt.Errorf("unable to read namespace secret names file: %w", err)
+	}
+	policies, err := parseNamespacePolicies(content)
+	if err != nil {
+		return err
+	}
+	namespacePolicy.policies.Store(&policies)
+	namespacePolicy.fileVersion = version
+	log.Info().Str("path", namespacePolicy.path).Int("namespaces", len(policies)).
+		Msg("Loaded secret names of namespaces")
+	return nil
+}
+
+// Watch reloads the patterns each time the file changes, it checks the file with the given interval
+// until the stop channel is closed.
+func (namespacePolicy *NamespaceSecretNamePolicy) Watch(interval time.Duration, stop <-chan struct{}) {
+	watchFile(interval, stop, namespacePolicy.reloadIfChanged, namespacePolicy.path, "namespace secret names")
+}
+
+func (namespacePolicy *NamespaceSecretNamePolicy) reloadIfChanged() error {
+	version, err := statFileVersion(namespacePolicy.path)
+	if err != nil {
+		return fmt.Errorf("unable to read namespace secret names file: %w", err)
+	}
+	if namespacePolicy.fileVersion == version {
+		return nil
+	}
+	return namespacePolicy.Reload()
+}
+
+func parseNamespacePolicies(content []byte) (map[string]*SecretNamePolicy, error) {
+	var namespaces map[string]namespacePatterns
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true) // a misspelled "denied" must not silently allow everything
+	if err := decoder.Decode(&namespaces); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to parse namespace secret names file: %w", err)
+	}
+	policies := make(map[string]*SecretNamePolicy, len(namespaces))
+	for namespace, patterns := range namespaces {
+		namePolicy, err := NewSecretNamePolicy(patterns.Allowed, patterns.Denied)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret names of namespace %v: %w", namespace, err)
+		}
+		policies[namespace] = namePolicy
+	}
+	return policies, nil
+}
@@ -0,0 +1,131 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tenantPatterns = `
+team-a:
+  allowed: ["team-a-*", "shared-*"]
+  denied: ["*-admin"]
+team-b:
+  allowed: ["team-b-*"]
+`
+
+func newNamespacePolicy(t *testing.T, content string) *NamespaceSecretNamePolicy {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "namespace-secret-names")
+	writeAllowList(t, path, content, time.Now())
+	namespacePolicy, err := NewNamespaceSecretNamePolicy(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return namespacePolicy
+}
+
+func TestNamespaceSecretNamePolicy_ListedNamespaces_ApplyPatternsOfNamespace(t *testing.T) {
+	namespacePolicy := newNamespacePolicy(t, tenantPatterns)
+
+	tests := []struct {
+		namespace string
+		name      string
+		allowed   bool
+	}{
+		{"team-a", "team-a-db", true},
+		{"team-a", "shared-ca", true},
+		{"team-a", "team-a-admin", false},
+		{"team-a", "team-b-db", false},
+		{"team-b", "team-b-db", true},
+		{"team-b", "team-a-db", false},
+		{"team-b", "shared-ca", false},
+	}
+	for _, test := range tests {
+		if namespacePolicy.IsAllowed(test.namespace, test.name) != test.allowed {
+			t.Errorf("Unexpected result for %v in %v", test.name, test.namespace)
+		}
+	}
+}
+
+func TestNamespaceSecretNamePolicy_UnlistedNamespace_AllowAnyName(t *testing.T) {
+	namespacePolicy := newNamespacePolicy(t, tenantPatterns)
+
+	if !namespacePolicy.IsAllowed("team-c", "team-a-db") {
+		t.Error("Any name should be allowed in unlisted namespace")
+	}
+}
+
+func TestNamespaceSecretNamePolicy_OtherNamespacesEntry_ApplyToUnlistedNamespaces(t *testing.T) {
+	namespacePolicy := newNamespacePolicy(t, tenantPatterns+"\"*\":\n  allowed: [\"shared-*\"]\n")
+
+	if !namespacePolicy.IsAllowed("team-c", "shared-ca") {
+		t.Error("Name allowed for other namespaces should be allowed")
+	}
+	if namespacePolicy.IsAllowed("team-c", "team-a-db") {
+		t.Error("Name not allowed for other namespaces should be denied")
+	}
+	if !namespacePolicy.IsAllowed("team-a", "team-a-db") {
+		t.Error("Listed namespace should use its own patterns")
+	}
+}
+
+func TestNewNamespaceSecretNamePolicy_EmptyFile_AllowAnyName(t *testing.T) {
+	namespacePolicy := newNamespacePolicy(t, "# no restrictions yet\n")
+
+	if !namespacePolicy.IsAllowed("team-a", "team-b-db") {
+		t.Error("Any name should be allowed")
+	}
+}
+
+func TestNewNamespaceSecretNamePolicy_InvalidFile_ReturnError(t *testing.T) {
+	tests := map[string]string{
+		"team-a:\n  allowed: [\"app-[\"]\n": `invalid secret names of namespace team-a: invalid secret name pattern "app-["`,
+		"team-a:\n  deny: [\"*\"]\n":        "unable to parse namespace secret names file",
+		"- team-a\n":                        "unable to parse namespace secret names file",
+	}
+	for content, message := range tests {
+		path := filepath.Join(t.TempDir(), "namespace-secret-names")
+		writeAllowList(t, path, content, time.Now())
+		_, err := NewNamespaceSecretNamePolicy(path)
+		if err == nil {
+			t.Fatal("An error was expected")
+		}
+		if !strings.HasPrefix(err.Error(), message) {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestNamespaceSecretNamePolicy_InvalidFileOnReload_KeepActivePatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "namespace-secret-names")
+	modTime := time.Now().Add(-time.Hour)
+	writeAllowList(t, path, tenantPatterns, modTime)
+	namespacePolicy, err := NewNamespaceSecretNamePolicy(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	writeAllowList(t, path, "team-a:\n  alowed: [\"*\"]\n", modTime.Add(time.Minute))
+	if err := namespacePolicy.reloadIfChanged(); err == nil {
+		t.Error("An error was expected")
+	}
+	if namespacePolicy.IsAllowed("team-a", "team-b-db") {
+		t.Error("Active patterns should be kept when the file is invalid")
+	}
+
+	writeAllowList(t, path, "team-a:\n  allowed: [\"*\"]\n", modTime.Add(2*time.Minute))
+	if err := namespacePolicy.reloadIfChanged(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !namespacePolicy.IsAllowed("team-a", "team-b-db") {
+		t.Error("Patterns should be replaced by the fixed file")
+	}
+}
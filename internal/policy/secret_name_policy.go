@@ -0,0 +1,67 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// SecretNamePolicy restricts names of the secrets SecretProviderClass could request with glob patterns,
+// e.g. "app-*". Denied patterns take precedence over allowed ones, any name is allowed when no allowed patterns set.
+type SecretNamePolicy struct {
+	allowed []string
+	denied  []string
+}
+
+// NewSecretNamePolicy validates the patterns, they follow path.Match syntax.
+func NewSecretNamePolicy(allowed []string, denied []string) (*SecretNamePolicy, error) {
+	namePolicy := &SecretNamePolicy{allowed: trimPatterns(allowed), denied: trimPatterns(denied)}
+	for _, pattern := range append(append([]string{}, namePolicy.allowed...), namePolicy.denied...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid secret name pattern %q: %w", pattern, err)
+		}
+	}
+	return namePolicy, nil
+}
+
+// ParsePatterns splits comma-separated list of patterns.
+func ParsePatterns(patterns string) []string {
+	if strings.TrimSpace(patterns) == "" {
+		return nil
+	}
+	return strings.Split(patterns, ",")
+}
+
+// IsAllowed reports whether the secret with the name could be mounted.
+func (namePolicy *SecretNamePolicy) IsAllowed(name string) bool {
+	if matchesAny(name, namePolicy.denied) {
+		return false
+	}
+	return len(namePolicy.allowed) == 0 || matchesAny(name, namePolicy.allowed)
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		// patterns are validated on creation
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func trimPatterns(patterns []string) []string {
+	var trimmedPatterns []string
+	for _, pattern := range patterns {
+		if trimmedPattern := strings.TrimSpace(pattern); trimmedPattern != "" {
+			trimmedPatterns = append(trimmedPatterns, trimmedPattern)
+		}
+	}
+	return trimmedPatterns
+}
@@ -0,0 +1,56 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecretNamePolicy_NoPatterns_AllowAnyName(t *testing.T) {
+	namePolicy, err := NewSecretNamePolicy(nil, ParsePatterns(""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !namePolicy.IsAllowed("db-root-password") {
+		t.Error("Any name should be allowed")
+	}
+}
+
+func TestSecretNamePolicy_DeniedPattern_DenyMatchingNames(t *testing.T) {
+	namePolicy, err := NewSecretNamePolicy(nil, ParsePatterns("*-root-*, admin"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, allowed := range map[string]bool{"db-root-password": false, "admin": false, "app-password": true} {
+		if namePolicy.IsAllowed(name) != allowed {
+			t.Errorf("Unexpected result for %v", name)
+		}
+	}
+}
+
+func TestSecretNamePolicy_AllowedAndDeniedPatterns_DenyTakesPrecedence(t *testing.T) {
+	namePolicy, err := NewSecretNamePolicy(ParsePatterns("app-*"), ParsePatterns("*-root-*"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for name, allowed := range map[string]bool{"app-password": true, "app-root-password": false, "db-password": false} {
+		if namePolicy.IsAllowed(name) != allowed {
+			t.Errorf("Unexpected result for %v", name)
+		}
+	}
+}
+
+func TestNewSecretNamePolicy_InvalidPattern_ReturnError(t *testing.T) {
+	_, err := NewSecretNamePolicy(nil, []string{"app-["})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), `invalid secret name pattern "app-["`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
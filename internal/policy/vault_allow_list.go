@@ -0,0 +1,99 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/rs/zerolog/log"
+)
+
+// VaultAllowList restricts vaults the secrets could be mounted from.
+// The list is loaded from a file with a vault OCID per line, lines starting with '#' are ignored.
+// The file is expected to be a ConfigMap projection, so it's reloaded when changed.
+type VaultAllowList struct {
+	path   string
+	vaults atomic.Pointer[map[types.VaultID]struct{}]
+	// fileVersion identifies the loaded file content, it's accessed by a single watching goroutine
+	fileVersion fileVersion
+}
+
+// NewVaultAllowList loads the allow list from the file.
+func NewVaultAllowList(path string) (*VaultAllowList, error) {
+	allowList := &VaultAllowList{path: path}
+	if err := allowList.Reload(); err != nil {
+		return nil, err
+	}
+	return allowList, nil
+}
+
+// IsAllowed reports whether the secrets could be mounted from the vault.
+func (allowList *VaultAllowList) IsAllowed(vaultID types.VaultID) bool {
+	_, ok := (*allowList.vaults.Load())[vaultID]
+	return ok
+}
+
+// Reload reads the file and replaces the active set of allowed vaults.
+// The active set is kept when the file could not be read.
+func (allowList *VaultAllowList) Reload() error {
+	version, err := statFileVersion(allowList.path)
+	if err != nil {
+		return fmt.Errorf("unable to read allowed vaults file: %w", err)
+	}
+	content, err := os.ReadFile(allowList.path)
+	if err != nil {
+		return fmt.Errorf("unable to read allowed vaults file: %w", err)
+	}
+	vaults, err := parseVaultIDs(content)
+	if err != nil {
+		return err
+	}
+	allowList.vaults.Store(&vaults)
+	allowList.fileVersion = version
+	log.Info().Str("path", allowList.path).Int("vaults", len(vaults)).Msg("Loaded allowed vaults")
+	return nil
+}
+
+// Watch reloads the allow list each time the file changes, it checks the file with the given interval
+// until the stop channel is closed.
+func (allowList *VaultAllowList) Watch(interval time.Duration, stop <-chan struct{}) {
+	watchFile(interval, stop, allowList.reloadIfChanged, allowList.path, "allowed vaults")
+}
+
+func (allowList *VaultAllowList) reloadIfChanged() error {
+	version, err := statFileVersion(allowList.path)
+	if err != nil {
+		return fmt.Errorf("unable to read allowed vaults file: %w", err)
+	}
+	if allowList.fileVersion == version {
+		return nil
+	}
+	return allowList.Reload()
+}
+
+func parseVaultIDs(content []byte) (map[types.VaultID]struct{}, error) {
+	vaults := make(map[types.VaultID]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		vaults[types.VaultID(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to parse allowed vaults file: %w", err)
+	}
+	return vaults, nil
+}
@@ -0,0 +1,133 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
+)
+
+func TestMain(m *testing.M) {
+	testutils.RunTestCase(m)
+}
+
+// writeAllowList writes the content and moves file modification time forward,
+// so the change is detected regardless of filesystem timestamp granularity.
+func writeAllowList(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write allow list: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Precondition failed: unable to change file time: %v", err)
+	}
+}
+
+func TestNewVaultAllowList_FileWithCommentsAndBlankLines_AllowListedVaultsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed-vaults")
+	writeAllowList(t, path, "# production vaults\nocid1.vault.1\n\n  ocid1.vault.2  \n", time.Now())
+
+	allowList, err := NewVaultAllowList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !allowList.IsAllowed("ocid1.vault.1") || !allowList.IsAllowed("ocid1.vault.2") {
+		t.Error("Listed vaults should be allowed")
+	}
+	if allowList.IsAllowed("ocid1.vault.3") || allowList.IsAllowed("# production vaults") {
+		t.Error("Unlisted vault should not be allowed")
+	}
+}
+
+func TestNewVaultAllowList_MissedFile_ReturnError(t *testing.T) {
+	_, err := NewVaultAllowList(filepath.Join(t.TempDir(), "missed"))
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestReloadIfChanged_FileChanged_ReplaceActiveSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed-vaults")
+	modTime := time.Now().Add(-time.Hour)
+	writeAllowList(t, path, "ocid1.vault.1\n", modTime)
+	allowList, err := NewVaultAllowList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	writeAllowList(t, path, "ocid1.vault.2\n", modTime.Add(time.Minute))
+	if err := allowList.reloadIfChanged(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if allowList.IsAllowed("ocid1.vault.1") {
+		t.Error("Vault removed from the file should not be allowed")
+	}
+	if !allowList.IsAllowed("ocid1.vault.2") {
+		t.Error("Vault added to the file should be allowed")
+	}
+}
+
+func TestReloadIfChanged_FileRemoved_KeepActiveSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed-vaults")
+	writeAllowList(t, path, "ocid1.vault.1\n", time.Now())
+	allowList, err := NewVaultAllowList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Precondition failed: unable to remove file: %v", err)
+	}
+	if err := allowList.reloadIfChanged(); err == nil {
+		t.Error("An error was expected")
+	}
+	if !allowList.IsAllowed("ocid1.vault.1") {
+		t.Error("Active set should be kept when the file could not be read")
+	}
+}
+
+func TestWatch_ConfigMapUpdate_ReloadAllowList(t *testing.T) {
+	// ConfigMap projection replaces the file by swapping a symlink to a new data directory
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeAllowList(t, filepath.Join(dir, "data-1"), "ocid1.vault.1\n", modTime)
+	writeAllowList(t, filepath.Join(dir, "data-2"), "ocid1.vault.2\n", modTime.Add(time.Minute))
+	path := filepath.Join(dir, "allowed-vaults")
+	if err := os.Symlink(filepath.Join(dir, "data-1"), path); err != nil {
+		t.Fatalf("Precondition failed: unable to create symlink: %v", err)
+	}
+
+	allowList, err := NewVaultAllowList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go allowList.Watch(10*time.Millisecond, stop)
+
+	if err := os.Symlink(filepath.Join(dir, "data-2"), path+".tmp"); err != nil {
+		t.Fatalf("Precondition failed: unable to create symlink: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatalf("Precondition failed: unable to swap symlink: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !allowList.IsAllowed("ocid1.vault.2") {
+		if time.Now().After(deadline) {
+			t.Fatal("Allow list is not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if allowList.IsAllowed("ocid1.vault.1") {
+		t.Error("Vault removed from the file should not be allowed")
+	}
+}
@@ -0,0 +1,49 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package profiling
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const ProfilingPath = "/debug/pprof"
+
+// InitProfileServer starts pprof server, which is returned to be shut down on exit, no server is started if disabled.
+// The endpoints have no access control, so the server listens on all interfaces only if bind address is empty,
+// e.g. "127.0.0.1" keeps it reachable only from the pod network namespace, via port forwarding.
+func InitProfileServer(enabled bool, bindAddress string, port int) *http.Server {
+	if !enabled {
+		log.Info().Msg("Profiling is disabled")
+		return nil
+	}
+	dmux := http.NewServeMux()
+	dmux.HandleFunc(ProfilingPath+"/", pprof.Index)
+	dmux.HandleFunc(ProfilingPath+"/cmdline", pprof.Cmdline)
+	dmux.HandleFunc(ProfilingPath+"/profile", pprof.Profile)
+	dmux.HandleFunc(ProfilingPath+"/symbol", pprof.Symbol)
+	dmux.HandleFunc(ProfilingPath+"/trace", pprof.Trace)
+	address := net.JoinHostPort(bindAddress, strconv.Itoa(port))
+	ds := &http.Server{
+		Addr:              address,
+		Handler:           dmux,
+		ReadHeaderTimeout: 2 * time.Minute,
+	}
+	go func() {
+		err := ds.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("Profiling http server error")
+		}
+	}()
+	log.Info().Str("address", address+ProfilingPath).Msg("Initializing Profiling server at")
+	return ds
+}
@@ -0,0 +1,83 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freePort returns a port nothing listens on at localhost
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestInitProfileServer_Disabled_NotStarted(t *testing.T) {
+	port := freePort(t)
+
+	if server := InitProfileServer(false, "127.0.0.1", port); server != nil {
+		t.Fatalf("No server should be started: %v", server.Addr)
+	}
+
+	if connection, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%v", port), time.Second); err == nil {
+		connection.Close()
+		t.Error("Nothing should listen on profiling port")
+	}
+}
+
+func TestInitProfileServer_BindAddress_ServeOnBindAddress(t *testing.T) {
+	port := freePort(t)
+
+	server := InitProfileServer(true, "127.0.0.1", port)
+	if server == nil {
+		t.Fatal("Server should be started")
+	}
+	defer server.Shutdown(context.Background())
+
+	if server.Addr != fmt.Sprintf("127.0.0.1:%v", port) {
+		t.Errorf("Wrong server address: %v", server.Addr)
+	}
+	url := fmt.Sprintf("http://%v%v/", server.Addr, ProfilingPath)
+	var response *http.Response
+	var err error
+	for attempt := 0; attempt < 50; attempt++ {
+		if response, err = http.Get(url); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Wrong status code: %v", response.StatusCode)
+	}
+}
+
+func TestInitProfileServer_EmptyBindAddress_ListenOnAllInterfaces(t *testing.T) {
+	port := freePort(t)
+
+	server := InitProfileServer(true, "", port)
+	if server == nil {
+		t.Fatal("Server should be started")
+	}
+	defer server.Shutdown(context.Background())
+
+	if server.Addr != fmt.Sprintf(":%v", port) {
+		t.Errorf("Wrong server address: %v", server.Addr)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// FetchOutcomesSchemaVersion identifies the schema of FetchOutcomes. Fields are only added within the version,
+// removed or changed fields bump it, so consumers could rely on the fields they know.
+const FetchOutcomesSchemaVersion = "v1"
+
+// FetchOutcomes is the content of fetch outcomes file describing how each mounted secret was retrieved.
+// It never includes secret contents.
+type FetchOutcomes struct {
+	SchemaVersion string         `json:"schemaVersion"`
+	Files         []FetchOutcome `json:"files"`
+}
+
+// FetchOutcome describes the retrieval of a single mounted secret
+type FetchOutcome struct {
+	// Path is the path of the secret file, the parts of a chunked secret are not listed
+	Path       string `json:"path"`
+	SecretID   string `json:"secretId"`
+	SecretName string `json:"secretName"`
+	// VersionNumber is the served version, even if the object version reports content digest
+	VersionNumber int64 `json:"versionNumber"`
+	// Stages of the served version, e.g. CURRENT
+	Stages   []string `json:"stages"`
+	CacheHit bool     `json:"cacheHit"`
+	// Retries is the number of HTTP calls repeated by OCI SDK, zero for cache hits
+	Retries int `json:"retries"`
+}
+
+func newFetchOutcome(bundle *types.SecretBundle, path string) FetchOutcome {
+	stages := make([]string, len(bundle.Stages))
+	for i, stage := range bundle.Stages {
+		stages[i] = stage.String()
+	}
+	retries := 0
+	if bundle.Attempts > 1 {
+		retries = bundle.Attempts - 1
+	}
+	return FetchOutcome{
+		Path:          path,
+		SecretID:      bundle.ID,
+		SecretName:    bundle.Name,
+		VersionNumber: bundle.VersionNumber,
+		Stages:        stages,
+		CacheHit:      bundle.CacheHit,
+		Retries:       retries,
+	}
+}
+
+// fetchOutcomesFile writes the outcomes as JSON file, the path should not be taken by the other mounted files.
+func fetchOutcomesFile(path string, outcomes []FetchOutcome, files []*provider.File,
+	filePermission int32) (*provider.File, error) {
+	for _, file := range files {
+		if file.Path == path {
+			return nil, fmt.Errorf("fetch outcomes file %v collides with mounted file of the same path", path)
+		}
+	}
+	content, err := json.Marshal(FetchOutcomes{SchemaVersion: FetchOutcomesSchemaVersion, Files: outcomes})
+	if err != nil {
+		return nil, err
+	}
+	return &provider.File{Path: path, Contents: content, Mode: filePermission}, nil
+}
@@ -0,0 +1,271 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package response
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/metrics"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// minChunkIndexWidth is the minimal number of digits in the index of secret part file name
+const minChunkIndexWidth = 3
+const chunkManifestSuffix = ".manifest"
+
+// writePermissions are cleared from the mode of immutable secrets
+const writePermissions = 0222
+
+// Transform processes the content of a secret before it's mounted, e.g. inspects or rewrites it.
+// The content is already decoded, extracted by JSON key and validated against the expected format.
+type Transform func(ctx context.Context, bundle *types.SecretBundle, content []byte) ([]byte, error)
+
+// Generator produces additional files from the contents of all mounted secrets keyed by their file paths,
+// e.g. rendered templates. The files should be mounted with the given permission.
+type Generator func(contents map[string]string, filePermission int32) ([]*provider.File, error)
+
+// Options of the mapping of secret bundles to mount response
+type Options struct {
+	// FilePermission is the mode of the mounted files requested by the driver
+	FilePermission int32
+	// CheckFileMode restricts the mode of a secret overriding FilePermission, the mode is used as is when nil
+	CheckFileMode func(mode os.FileMode) (os.FileMode, error)
+	// ChunkSize splits files larger than the size in bytes into numbered parts, chunking is disabled when zero
+	ChunkSize int
+	// ContentHashObjectVersion reports digest of the mounted content as object version instead of version number
+	ContentHashObjectVersion bool
+	// StagesObjectVersion appends the stages of the mounted version to the object version, e.g. "2;CURRENT,LATEST"
+	StagesObjectVersion bool
+	// Transforms are applied in order to the content of each secret
+	Transforms []Transform
+	// Generators add files after the secret files, the generated files are chunked the same way
+	Generators []Generator
+	// FetchOutcomesFile is the path of the last file describing retrieval of each secret, it's not added when empty
+	FetchOutcomesFile string
+}
+
+// Build maps the secret bundles to mount response, a file and an object version per bundle,
+// followed by the generated files and the fetch outcomes file. Encoded content of each bundle is released once
+// it's decoded, so the encoded and the decoded content of all secrets are not held in memory at once.
+func Build(ctx context.Context, bundles []*types.SecretBundle, options Options) (*provider.MountResponse, error) {
+	files := make([]*provider.File, 0, len(bundles))
+	versions := make([]*provider.ObjectVersion, len(bundles))
+	var contents map[string]string
+	if len(options.Generators) > 0 {
+		contents = make(map[string]string, len(bundles))
+	}
+	var outcomes []FetchOutcome
+	if options.FetchOutcomesFile != "" {
+		outcomes = make([]FetchOutcome, 0, len(bundles))
+	}
+
+	for i, bundle := range bundles {
+		file, objectVersion, err := mapBundle(ctx, bundle, options)
+		if err != nil {
+			return nil, err
+		}
+		if contents != nil {
+			contents[file.Path] = string(file.Contents)
+		}
+		if outcomes != nil {
+			outcomes = append(outcomes, newFetchOutcome(bundle, file.Path))
+		}
+		files = append(files, splitIntoChunks(file, options.ChunkSize)...)
+		versions[i] = objectVersion
+		bundle.BundleContent = nil
+	}
+
+	for _, generate := range options.Generators {
+		generatedFiles, err := generate(contents, options.FilePermission)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range generatedFiles {
+			files = append(files, splitIntoChunks(file, options.ChunkSize)...)
+		}
+	}
+
+	if outcomes != nil {
+		outcomesFile, err := fetchOutcomesFile(options.FetchOutcomesFile, outcomes, files, options.FilePermission)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outcomesFile)
+	}
+
+	return &provider.MountResponse{
+		Files:         files,
+		ObjectVersion: versions,
+	}, nil
+}
+
+func mapBundle(ctx context.Context,
+	bundle *types.SecretBundle, options Options) (*provider.File, *provider.ObjectVersion, error) {
+	filePermission, err := fileMode(bundle, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	secretContent, err := decodeContent(ctx, bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bundle.JSONKey != "" {
+		if secretContent, err = types.ExtractJSONKey(secretContent, bundle.JSONKey); err != nil {
+			logging.FromContext(ctx).Info().Err(err).Str("secret", bundle.Name).Str("jsonKey", bundle.JSONKey).
+				Msg("Unable to extract JSON key from secret content")
+			return nil, nil, fmt.Errorf("unable to extract JSON key %q from secret %v: %w", bundle.JSONKey, bundle.Name, err)
+		}
+	}
+	if err := validateFormat(ctx, bundle, secretContent); err != nil {
+		return nil, nil, err
+	}
+	for _, transform := range options.Transforms {
+		if secretContent, err = transform(ctx, bundle, secretContent); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	file := &provider.File{
+		Path:     bundle.GetFilePath(),
+		Contents: secretContent,
+		Mode:     filePermission,
+	}
+	objectVersion := &provider.ObjectVersion{
+		Id:      bundle.ID,
+		Version: strconv.FormatInt(bundle.VersionNumber, 10),
+	}
+	if options.ContentHashObjectVersion {
+		objectVersion.Version = contentHashVersion(secretContent)
+	}
+	if options.StagesObjectVersion {
+		objectVersion.Version += ";" + stagesVersionSuffix(bundle.Stages)
+	}
+	return file, objectVersion, nil
+}
+
+// fileMode returns the mode of the secret file, the mode of the secret overrides the mode of the mount request,
+// but it's restricted the same way
+func fileMode(bundle *types.SecretBundle, options Options) (int32, error) {
+	filePermission := options.FilePermission
+	if bundle.Mode != 0 {
+		mode := os.FileMode(bundle.Mode)
+		if options.CheckFileMode != nil {
+			var err error
+			if mode, err = options.CheckFileMode(mode); err != nil {
+				return 0, err
+			}
+		}
+		filePermission = int32(mode)
+	}
+	// the driver has no immutable file attribute, so the most restrictive thing is to drop write permissions
+	if bundle.Immutable {
+		filePermission &^= writePermissions
+	}
+	return filePermission, nil
+}
+
+// decodeContent decodes secret bundle content, unless it's mounted base64-encoded, counting failures by reason.
+func decodeContent(ctx context.Context, bundle *types.SecretBundle) ([]byte, error) {
+	var secretContent []byte
+	var err error
+	if bundle.Encoding == types.Base64Encoding {
+		secretContent, err = bundle.BundleContent.EncodedBytes()
+	} else {
+		secretContent, err = bundle.BundleContent.DecodeBytes()
+	}
+	var decodeError *types.DecodeError
+	if errors.As(err, &decodeError) {
+		logging.FromContext(ctx).Info().Err(err).Str("secret", bundle.Name).Str("reason", string(decodeError.Reason)).
+			Msg("Unable to decode secret content")
+		metrics.NewStatsReporter().ReportDecodeError(ctx, string(decodeError.Reason))
+	}
+	return secretContent, err
+}
+
+// validateFormat fails the mount of the secret which content doesn't match the format expected by the consumer,
+// so the misconfigured secret is reported by the mount instead of the application.
+// Base64-encoded content is validated decoded.
+func validateFormat(ctx context.Context, bundle *types.SecretBundle, content []byte) error {
+	if bundle.Format == "" {
+		return nil
+	}
+	if bundle.Encoding == types.Base64Encoding {
+		var err error
+		if content, err = bundle.BundleContent.DecodeBytes(); err != nil {
+			return err
+		}
+	}
+	if err := types.ValidateFormat(content, bundle.Format); err != nil {
+		logging.FromContext(ctx).Info().Err(err).Str("secret", bundle.Name).Str("format", string(bundle.Format)).
+			Msg("Secret content doesn't match the expected format")
+		return fmt.Errorf("secret %v is expected to be %v: %w", bundle.Name, bundle.Format, err)
+	}
+	return nil
+}
+
+// contentHashVersion returns short digest of the content, identical content always yields the same version.
+func contentHashVersion(content []byte) string {
+	digest := sha256.Sum256(content)
+	return "sha256-" + hex.EncodeToString(digest[:])[:16]
+}
+
+// stagesVersionSuffix lists the stages in a fixed order, so the same stages returned by OCI Vault in another order
+// don't change the object version, which would make the driver rewrite the files.
+func stagesVersionSuffix(stages []types.Stage) string {
+	sortedStages := append([]types.Stage(nil), stages...)
+	sort.Slice(sortedStages, func(i, j int) bool { return sortedStages[i] < sortedStages[j] })
+	names := make([]string, len(sortedStages))
+	for i := range sortedStages {
+		names[i] = sortedStages[i].String()
+	}
+	return strings.Join(names, ",")
+}
+
+// splitIntoChunks splits the file larger than the chunk size into parts named "<path>.part-<index>",
+// where zero-padded index keeps lexical order of parts. The parts are listed in order, one per line,
+// in "<path>.manifest" file, so the secret could be reassembled with "cat $(cat <path>.manifest) > <path>".
+func splitIntoChunks(file *provider.File, chunkSize int) []*provider.File {
+	if chunkSize <= 0 || len(file.Contents) <= chunkSize {
+		return []*provider.File{file}
+	}
+
+	partsCount := (len(file.Contents) + chunkSize - 1) / chunkSize
+	indexWidth := len(strconv.Itoa(partsCount - 1))
+	if indexWidth < minChunkIndexWidth {
+		indexWidth = minChunkIndexWidth
+	}
+	chunks := make([]*provider.File, 0, partsCount+1)
+	var manifest strings.Builder
+	for i := 0; i < partsCount; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(file.Contents) {
+			end = len(file.Contents)
+		}
+		partPath := fmt.Sprintf("%v.part-%0*d", file.Path, indexWidth, i)
+		chunks = append(chunks, &provider.File{
+			Path:     partPath,
+			Contents: file.Contents[i*chunkSize : end],
+			Mode:     file.Mode,
+		})
+		manifest.WriteString(partPath + "\n")
+	}
+	return append(chunks, &provider.File{
+		Path:     file.Path + chunkManifestSuffix,
+		Contents: []byte(manifest.String()),
+		Mode:     file.Mode,
+	})
+}
@@ -0,0 +1,401 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package response
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+func TestMain(m *testing.M) {
+	testutils.RunTestCase(m)
+}
+
+// newBundle creates the bundle of the secret with the plain content
+func newBundle(name string, content string) *types.SecretBundle {
+	return &types.SecretBundle{
+		ID: name + "-id", Name: name, VersionNumber: 3,
+		Stages: []types.Stage{types.Current},
+		BundleContent: &types.SecretBundleContent{
+			Content: base64.StdEncoding.EncodeToString([]byte(content)), ContentType: types.Base64,
+		},
+	}
+}
+
+func TestBuild_PlainSecrets_ReturnFileAndVersionPerSecret(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("baz", "qux")}
+	bundles[1].FileName = "dir/baz.txt"
+
+	response, err := Build(context.Background(), bundles, Options{FilePermission: 0444})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 2 || len(response.ObjectVersion) != 2 {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+	expectedFiles := []struct{ path, content string }{{"foo", "bar"}, {"dir/baz.txt", "qux"}}
+	for i, expected := range expectedFiles {
+		file := response.Files[i]
+		if file.Path != expected.path || string(file.Contents) != expected.content || file.Mode != 0444 {
+			t.Errorf("Unexpected file: %v", file)
+		}
+	}
+	if response.ObjectVersion[1].Id != "baz-id" || response.ObjectVersion[1].Version != "3" {
+		t.Errorf("Unexpected object version: %v", response.ObjectVersion[1])
+	}
+}
+
+func TestBuild_Base64Encoding_ReturnEncodedContent(t *testing.T) {
+	bundle := newBundle("foo", "bar")
+	bundle.Encoding = types.Base64Encoding
+
+	response, err := Build(context.Background(), []*types.SecretBundle{bundle}, Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if string(response.Files[0].Contents) != "YmFy" {
+		t.Errorf("Unexpected content: %s", response.Files[0].Contents)
+	}
+}
+
+func TestBuild_EncodedContent_ReleasedAfterDecoding(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("baz", "qux")}
+
+	if _, err := Build(context.Background(), bundles, Options{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, bundle := range bundles {
+		if bundle.BundleContent != nil {
+			t.Errorf("Encoded content of %v is not released", bundle.Name)
+		}
+	}
+}
+
+func TestBuild_UndecodableContent_ReturnDecodeError(t *testing.T) {
+	bundle := newBundle("foo", "bar")
+	bundle.BundleContent.Content = "YmFy!"
+
+	_, err := Build(context.Background(), []*types.SecretBundle{bundle}, Options{})
+
+	var decodeError *types.DecodeError
+	if !errors.As(err, &decodeError) || decodeError.Reason != types.MalformedContent {
+		t.Fatalf("Malformed content error was expected: %v", err)
+	}
+}
+
+func TestBuild_JSONKey_ReturnExtractedValue(t *testing.T) {
+	bundle := newBundle("db", `{"db": {"password": "s3cr3t"}}`)
+	bundle.JSONKey = "db.password"
+
+	response, err := Build(context.Background(), []*types.SecretBundle{bundle}, Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(response.Files[0].Contents) != "s3cr3t" {
+		t.Errorf("Unexpected content: %s", response.Files[0].Contents)
+	}
+
+	bundle = newBundle("db", `{"db": {}}`)
+	bundle.JSONKey = "db.password"
+	_, err = Build(context.Background(), []*types.SecretBundle{bundle}, Options{})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), `unable to extract JSON key "db.password" from secret db`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_ContentNotMatchingFormat_ReturnError(t *testing.T) {
+	bundle := newBundle("config", "not json")
+	bundle.Format = types.JSONFormat
+	bundle.Encoding = types.Base64Encoding // validated decoded
+
+	_, err := Build(context.Background(), []*types.SecretBundle{bundle}, Options{})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "secret config is expected to be json") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_SecretModes_ReturnCheckedAndImmutableModes(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("key", "pem"), newBundle("cert", "pem")}
+	bundles[1].Mode = 0640
+	bundles[2].Mode = 0660
+	bundles[2].Immutable = true
+	var checkedModes []os.FileMode
+	options := Options{
+		FilePermission: 0644,
+		CheckFileMode: func(mode os.FileMode) (os.FileMode, error) {
+			checkedModes = append(checkedModes, mode)
+			return mode &^ 0020, nil
+		},
+	}
+
+	response, err := Build(context.Background(), bundles, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, expectedMode := range []int32{0644, 0640, 0440} {
+		if response.Files[i].Mode != expectedMode {
+			t.Errorf("Unexpected mode of %v: %#o", response.Files[i].Path, response.Files[i].Mode)
+		}
+	}
+	// the mode of the mount request is checked by the caller
+	if fmt.Sprint(checkedModes) != fmt.Sprint([]os.FileMode{0640, 0660}) {
+		t.Errorf("Unexpected checked modes: %v", checkedModes)
+	}
+}
+
+func TestBuild_FileModeRejected_ReturnError(t *testing.T) {
+	bundle := newBundle("key", "pem")
+	bundle.Mode = 0644
+	options := Options{CheckFileMode: func(mode os.FileMode) (os.FileMode, error) {
+		return 0, fmt.Errorf("file mode %#o is too loose", mode)
+	}}
+
+	_, err := Build(context.Background(), []*types.SecretBundle{bundle}, options)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "file mode 0644 is too loose" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_ContentHashObjectVersion_ReturnSameVersionForSameContent(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("baz", "bar"), newBundle("qux", "other")}
+	bundles[1].VersionNumber = 4
+
+	response, err := Build(context.Background(), bundles, Options{ContentHashObjectVersion: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	versions := response.ObjectVersion
+	if !strings.HasPrefix(versions[0].Version, "sha256-") || len(versions[0].Version) != len("sha256-")+16 {
+		t.Errorf("Unexpected version format: %v", versions[0].Version)
+	}
+	if versions[0].Version != versions[1].Version || versions[0].Version == versions[2].Version {
+		t.Errorf("Versions should follow the content: %v", versions)
+	}
+}
+
+func TestBuild_StagesObjectVersion_AppendStagesInFixedOrder(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("baz", "qux"), newBundle("qux", "quux")}
+	bundles[1].Stages = []types.Stage{types.Latest, types.Current}
+	bundles[2].Stages = nil
+
+	response, err := Build(context.Background(), bundles, Options{StagesObjectVersion: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, expectedVersion := range []string{"3;CURRENT", "3;CURRENT,LATEST", "3;"} {
+		if response.ObjectVersion[i].Version != expectedVersion {
+			t.Errorf("Unexpected version of %v: %v", response.ObjectVersion[i].Id, response.ObjectVersion[i].Version)
+		}
+	}
+	if len(bundles[1].Stages) != 2 || bundles[1].Stages[0] != types.Latest {
+		t.Errorf("Stages of the bundle should not be reordered: %v", bundles[1].Stages)
+	}
+}
+
+func TestBuild_StagesObjectVersionWithContentHash_AppendStagesToHash(t *testing.T) {
+	bundle := newBundle("foo", "bar")
+	bundle.Stages = []types.Stage{types.Previous}
+
+	response, err := Build(context.Background(), []*types.SecretBundle{bundle},
+		Options{ContentHashObjectVersion: true, StagesObjectVersion: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	version := response.ObjectVersion[0].Version
+	if !strings.HasPrefix(version, "sha256-") || !strings.HasSuffix(version, ";PREVIOUS") {
+		t.Errorf("Unexpected version: %v", version)
+	}
+}
+
+func TestBuild_Transforms_AppliedInOrder(t *testing.T) {
+	appendSuffix := func(suffix string) Transform {
+		return func(_ context.Context, bundle *types.SecretBundle, content []byte) ([]byte, error) {
+			return append(content, suffix...), nil
+		}
+	}
+	options := Options{Transforms: []Transform{appendSuffix("-1"), appendSuffix("-2")}}
+
+	response, err := Build(context.Background(), []*types.SecretBundle{newBundle("foo", "bar")}, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if string(response.Files[0].Contents) != "bar-1-2" {
+		t.Errorf("Unexpected content: %s", response.Files[0].Contents)
+	}
+}
+
+func TestBuild_TransformFailed_ReturnError(t *testing.T) {
+	options := Options{Transforms: []Transform{
+		func(_ context.Context, bundle *types.SecretBundle, content []byte) ([]byte, error) {
+			return nil, fmt.Errorf("secret %v is refused", bundle.Name)
+		},
+	}}
+
+	_, err := Build(context.Background(), []*types.SecretBundle{newBundle("foo", "bar")}, options)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "secret foo is refused" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_Generators_ReturnGeneratedFilesAfterSecrets(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("user", "admin"), newBundle("password", "s3cr3t")}
+	generator := func(contents map[string]string, filePermission int32) ([]*provider.File, error) {
+		content := contents["user"] + ":" + contents["password"]
+		return []*provider.File{{Path: "credentials", Contents: []byte(content), Mode: filePermission}}, nil
+	}
+
+	options := Options{FilePermission: 0400, Generators: []Generator{generator}}
+
+	response, err := Build(context.Background(), bundles, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 3 || len(response.ObjectVersion) != 2 {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+	generated := response.Files[2]
+	if generated.Path != "credentials" || string(generated.Contents) != "admin:s3cr3t" || generated.Mode != 0400 {
+		t.Errorf("Unexpected generated file: %v", generated)
+	}
+}
+
+func TestBuild_GeneratorFailed_ReturnError(t *testing.T) {
+	generator := func(contents map[string]string, filePermission int32) ([]*provider.File, error) {
+		return nil, fmt.Errorf("unable to render")
+	}
+
+	_, err := Build(context.Background(), []*types.SecretBundle{newBundle("foo", "bar")},
+		Options{Generators: []Generator{generator}})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to render" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_FetchOutcomesFile_DescribeEachSecretWithoutContent(t *testing.T) {
+	bundles := []*types.SecretBundle{newBundle("foo", "bar"), newBundle("baz", "qux")}
+	bundles[0].CacheHit = true
+	bundles[1].FileName, bundles[1].Attempts = "dir/baz.txt", 3
+
+	response, err := Build(context.Background(), bundles, Options{FilePermission: 0400, FetchOutcomesFile: "outcomes"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 3 || len(response.ObjectVersion) != 2 {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+	outcomesFile := response.Files[2]
+	if outcomesFile.Path != "outcomes" || outcomesFile.Mode != 0400 {
+		t.Errorf("Unexpected outcomes file: %v", outcomesFile)
+	}
+	if strings.Contains(string(outcomesFile.Contents), "bar") || strings.Contains(string(outcomesFile.Contents), "qux") {
+		t.Errorf("Outcomes should not include secret contents: %s", outcomesFile.Contents)
+	}
+	outcomes := FetchOutcomes{}
+	if err := json.Unmarshal(outcomesFile.Contents, &outcomes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := FetchOutcomes{SchemaVersion: "v1", Files: []FetchOutcome{
+		{Path: "foo", SecretID: "foo-id", SecretName: "foo", VersionNumber: 3, Stages: []string{"CURRENT"},
+			CacheHit: true},
+		{Path: "dir/baz.txt", SecretID: "baz-id", SecretName: "baz", VersionNumber: 3, Stages: []string{"CURRENT"},
+			Retries: 2},
+	}}
+	if !reflect.DeepEqual(outcomes, expected) {
+		t.Errorf("Unexpected outcomes: %+v", outcomes)
+	}
+}
+
+func TestBuild_FetchOutcomesFileTakenBySecret_ReturnError(t *testing.T) {
+	_, err := Build(context.Background(), []*types.SecretBundle{newBundle("foo", "bar")},
+		Options{FetchOutcomesFile: "foo"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "fetch outcomes file foo collides with mounted file of the same path" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestBuild_ChunkSize_SplitSecretAndGeneratedFiles(t *testing.T) {
+	generator := func(contents map[string]string, filePermission int32) ([]*provider.File, error) {
+		return []*provider.File{{Path: "copy", Contents: []byte(contents["foo"]), Mode: filePermission}}, nil
+	}
+	options := Options{FilePermission: 0444, ChunkSize: 4, Generators: []Generator{generator}}
+
+	response, err := Build(context.Background(), []*types.SecretBundle{newBundle("foo", "0123456789")}, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var paths []string
+	for _, file := range response.Files {
+		paths = append(paths, file.Path)
+		if file.Mode != 0444 {
+			t.Errorf("Unexpected mode of %v: %#o", file.Path, file.Mode)
+		}
+	}
+	expectedPaths := "foo.part-000,foo.part-001,foo.part-002,foo.manifest," +
+		"copy.part-000,copy.part-001,copy.part-002,copy.manifest"
+	if strings.Join(paths, ",") != expectedPaths {
+		t.Errorf("Unexpected files: %v", paths)
+	}
+	if string(response.Files[2].Contents) != "89" {
+		t.Errorf("Unexpected last part: %s", response.Files[2].Contents)
+	}
+	if string(response.Files[3].Contents) != "foo.part-000\nfoo.part-001\nfoo.part-002\n" {
+		t.Errorf("Unexpected manifest: %s", response.Files[3].Contents)
+	}
+}
+
+func TestSplitIntoChunks_ThousandParts_IndexKeepsLexicalOrder(t *testing.T) {
+	chunks := splitIntoChunks(&provider.File{Path: "foo", Contents: make([]byte, 1001)}, 1)
+
+	if chunks[0].Path != "foo.part-0000" || chunks[1000].Path != "foo.part-1000" {
+		t.Errorf("Unexpected part names: %v, %v", chunks[0].Path, chunks[1000].Path)
+	}
+	for i := 1; i < 1001; i++ {
+		if chunks[i-1].Path >= chunks[i].Path {
+			t.Fatalf("Parts are not in lexical order: %v, %v", chunks[i-1].Path, chunks[i].Path)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+// ValidateAttributes checks the attributes of mount request, i.e. SecretProviderClass parameters, the way Mount does,
+// but without mounting. Neither Kubernetes API nor OCI Vault is called, so the auth config secret of user principal,
+// the service account token of workload identity and the config file of file principal are not checked.
+// It returns the requested secrets.
+func (server *ProviderServer) ValidateAttributes(attributesString string) ([]*types.SecretBundleRequest, error) {
+	attributes, err := server.unmarshalRequestAttributes(attributesString)
+	if err != nil {
+		return nil, fmt.Errorf("attributes should be a JSON object of string values: %v", err)
+	}
+	secretBundleRequests, err := server.retrieveSecretRequests(attributes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := retrieveSecretTemplates(attributes, secretBundleRequests); err != nil {
+		return nil, err
+	}
+	if _, err := server.checkVault(context.Background(), attributes); err != nil {
+		return nil, err
+	}
+	if err := server.checkAuthAttributes(attributes); err != nil {
+		return nil, err
+	}
+	return secretBundleRequests, nil
+}
+
+// checkAuthAttributes validates auth parameters like retrieveAuthConfig, except the ones requiring Kubernetes API
+func (server *ProviderServer) checkAuthAttributes(requestAttributes map[string]string) error {
+	principalType, err := server.retrievePrincipalType(requestAttributes)
+	if err != nil {
+		return err
+	}
+	switch principalType {
+	case types.Instance:
+		_, err = server.retrieveInstancePrincipalRegion(requestAttributes)
+	case types.User:
+		if _, err = retrieveAuthConfigSecretName(requestAttributes); err == nil {
+			_, err = server.retrieveAuthConfigSecretNamespace(requestAttributes, requestAttributes[podNamespaceField])
+		}
+	case types.Workload:
+		_, err = retrieveRegion(requestAttributes)
+	case types.File:
+		_, err = server.retrieveConfigFile(requestAttributes)
+	case types.Resource:
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+func marshalAttributes(t *testing.T, attributes map[string]string) string {
+	t.Helper()
+	attributesJSON, err := json.Marshal(attributes)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize attributes: %v", err)
+	}
+	return string(attributesJSON)
+}
+
+func TestValidateAttributes_ValidAttributes_ReturnSecretRequests(t *testing.T) {
+	providerServer := &ProviderServer{}
+	testCases := map[string]map[string]string{
+		"instance": {authTypeField: "instance", regionField: "iad"},
+		"user":     {authTypeField: "user", authConfigSecretNameField: "oci-config"},
+		"workload": {authTypeField: "workload", regionField: "us-ashburn-1"},
+		"resource": {authTypeField: "resource"},
+	}
+	for name, attributes := range testCases {
+		attributes[secretsField] = "- name: foo\n  stage: CURRENT\n- name: bar\n  versionNumber: 2\n"
+		attributes[vaultIDField] = testVaultID
+
+		secretBundleRequests, err := providerServer.ValidateAttributes(marshalAttributes(t, attributes))
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", name, err)
+		}
+		if len(secretBundleRequests) != 2 || secretBundleRequests[1].VersionNumber != 2 {
+			t.Errorf("Wrong secret requests for %v: %v", name, secretBundleRequests)
+		}
+	}
+}
+
+func TestValidateAttributes_InvalidAttributes_ReturnError(t *testing.T) {
+	providerServer := &ProviderServer{config: Config{AllowedPrincipals: []types.OCIPrincipalType{types.Instance}}}
+	valid := map[string]string{secretsField: "- name: foo\n", vaultIDField: testVaultID, authTypeField: "instance"}
+	testCases := map[string]struct {
+		field         string
+		value         string
+		expectedError string
+	}{
+		"missed secrets":  {field: secretsField, value: "", expectedError: "missed content"},
+		"secrets map":     {field: secretsField, value: "name: foo\n", expectedError: "should be a list of secrets"},
+		"unknown field":   {field: secretsField, value: "- name: foo\n  foo: bar\n", expectedError: "failed to unmarshal"},
+		"malformed vault": {field: vaultIDField, value: "vault1", expectedError: "malformed vault OCID"},
+		"unknown auth":    {field: authTypeField, value: "token", expectedError: "invalid auth principal type"},
+		"denied auth":     {field: authTypeField, value: "workload", expectedError: "principal type is not allowed"},
+		"unknown region":  {field: regionField, value: "moon-1", expectedError: "unknown OCI region"},
+		"template overwriting secret": {field: templatesField, value: "- fileName: foo\n  template: x\n",
+			expectedError: "duplicated fileName"},
+	}
+	for name, testCase := range testCases {
+		attributes := make(map[string]string, len(valid)+1)
+		for field, value := range valid {
+			attributes[field] = value
+		}
+		attributes[testCase.field] = testCase.value
+
+		_, err := providerServer.ValidateAttributes(marshalAttributes(t, attributes))
+		if err == nil {
+			t.Fatalf("An error was expected for %v", name)
+		}
+		if !strings.Contains(err.Error(), testCase.expectedError) {
+			t.Errorf("Wrong error message for %v: %v", name, err)
+		}
+	}
+
+	if _, err := providerServer.ValidateAttributes("[]"); err == nil {
+		t.Error("An error was expected for non-object attributes")
+	}
+}
@@ -0,0 +1,115 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// file principal attributes, the path and the profile of OCI config file
+const configFilePathField = "configFilePath"
+const configFileProfileField = "configFileProfile"
+
+// DefaultConfigFileDir, defaultConfigFileName and defaultConfigFileProfile are the defaults of OCI CLI and SDK
+const DefaultConfigFileDir = "~/.oci"
+const defaultConfigFileName = "config"
+const defaultConfigFileProfile = "DEFAULT"
+
+// maxConfigFileSize limits OCI config file, a profile takes a few hundred bytes
+const maxConfigFileSize = 64 * 1024
+
+// configFileProfilePattern matches the header of a profile the way the SDK does, e.g. "[DEFAULT]"
+var configFileProfilePattern = regexp.MustCompile(`^\[(.*)\]`)
+
+// retrieveConfigFile returns the profile of OCI config file of file principal specified by SecretProviderClass,
+// "config" file of the config file directory and "DEFAULT" by default. The file itself is not read, but the path
+// should be within the config file directory, so SecretProviderClass can't make the provider access other files.
+func (server *ProviderServer) retrieveConfigFile(requestAttributes map[string]string) (types.ConfigFile, error) {
+	dir := server.config.ConfigFileDir
+	if dir == "" {
+		return types.ConfigFile{}, status.Errorf(codes.PermissionDenied,
+			"file principal is not allowed, config file directory is not configured")
+	}
+	configFile := types.ConfigFile{Path: filepath.Join(dir, defaultConfigFileName), Profile: defaultConfigFileProfile}
+	if path := requestAttributes[configFilePathField]; path != "" {
+		configFile.Path = path
+	}
+	if profile := requestAttributes[configFileProfileField]; profile != "" {
+		configFile.Profile = profile
+	}
+	path, err := expandHomeDirectory(configFile.Path)
+	if err != nil {
+		return types.ConfigFile{}, fmt.Errorf("unable to expand config file path %q: %v", configFile.Path, err)
+	}
+	configFile.Path = path
+	if !filepath.IsAbs(configFile.Path) {
+		return types.ConfigFile{}, fmt.Errorf("invalid \"%v\" SecretProviderClass parameter: path %q should be absolute",
+			configFilePathField, configFile.Path)
+	}
+	if !isWithinDir(configFile.Path, dir) {
+		log.Info().Str("path", configFile.Path).Msg("Config file path is outside of config file directory")
+		return types.ConfigFile{}, status.Errorf(codes.PermissionDenied,
+			"invalid \"%v\" SecretProviderClass parameter: path is outside of config file directory", configFilePathField)
+	}
+	return configFile, nil
+}
+
+// expandHomeDirectory replaces "~/" prefix of the path with the home directory, the way OCI CLI does
+func expandHomeDirectory(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// checkConfigFile fails unless the config file is a regular file within the directory having the profile,
+// so the misconfiguration is reported by the mount instead of the SDK failing to sign the first OCI Vault call
+func checkConfigFile(configFile types.ConfigFile, dir string) error {
+	file, fileInfo, err := openFileInDir(configFile.Path, dir)
+	if errors.Is(err, errOutsideDir) {
+		log.Info().Str("path", configFile.Path).Msg("Config file resolves outside of config file directory")
+		return status.Errorf(codes.PermissionDenied, "config file is outside of config file directory")
+	}
+	if err != nil {
+		log.Info().Err(err).Str("path", configFile.Path).Msg("Unable to open OCI config file")
+		return fmt.Errorf("unable to open config file: %w", err)
+	}
+	defer file.Close()
+	if fileInfo.Size() > maxConfigFileSize {
+		return fmt.Errorf("config file %q exceeds %v bytes", configFile.Path, maxConfigFileSize)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(file, maxConfigFileSize))
+	for scanner.Scan() {
+		if match := configFileProfilePattern.FindStringSubmatch(scanner.Text()); match != nil &&
+			match[1] == configFile.Profile {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+	log.Info().Str("path", configFile.Path).Str("profile", configFile.Profile).
+		Msg("Profile is not found in OCI config file")
+	return fmt.Errorf("profile %q is not found in config file %q", configFile.Profile, configFile.Path)
+}
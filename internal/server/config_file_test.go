@@ -0,0 +1,180 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeConfigFile writes OCI config file with the content to the directory and returns its path
+func writeConfigFile(t *testing.T, dir string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write config file: %v", err)
+	}
+	return path
+}
+
+func TestRetrieveAuthConfig_FilePrincipal_ReturnConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "[DEFAULT]\ntenancy=tenancy1\n\n[prod]\ntenancy=tenancy2\n")
+	server := &ProviderServer{config: Config{ConfigFileDir: dir}}
+
+	testCases := map[string]struct {
+		attributes      map[string]string
+		expectedProfile string
+	}{
+		"default profile": {
+			attributes:      map[string]string{authTypeField: "file", configFilePathField: path},
+			expectedProfile: "DEFAULT",
+		},
+		"named profile": {
+			attributes: map[string]string{authTypeField: "file", configFilePathField: path,
+				configFileProfileField: "prod"},
+			expectedProfile: "prod",
+		},
+		"default path": {
+			attributes:      map[string]string{authTypeField: "file"},
+			expectedProfile: "DEFAULT",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			auth, err := server.retrieveAuthConfig(context.Background(), testCase.attributes, "default")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			expected := types.ConfigFile{Path: path, Profile: testCase.expectedProfile}
+			if auth.Type != types.File || auth.ConfigFile != expected {
+				t.Errorf("Wrong auth: %+v", auth)
+			}
+		})
+	}
+}
+
+func TestRetrieveAuthConfig_ConfigFileMissing_ReturnError(t *testing.T) {
+	dir := t.TempDir()
+	attributes := map[string]string{authTypeField: "file", configFilePathField: filepath.Join(dir, "config")}
+	server := &ProviderServer{config: Config{ConfigFileDir: dir}}
+
+	_, err := server.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "unable to open config file") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestRetrieveAuthConfig_ConfigFileProfileMissing_ReturnError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "[DEFAULT]\ntenancy=tenancy1\n")
+	attributes := map[string]string{authTypeField: "file", configFilePathField: path, configFileProfileField: "prod"}
+	server := &ProviderServer{config: Config{ConfigFileDir: dir}}
+
+	_, err := server.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != `profile "prod" is not found in config file "`+path+`"` {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestRetrieveAuthConfig_ConfigFileNotRegular_ReturnError(t *testing.T) {
+	dir := t.TempDir()
+	subDir := filepath.Join(dir, "profiles")
+	if err := os.Mkdir(subDir, 0700); err != nil {
+		t.Fatalf("Precondition failed: unable to create directory: %v", err)
+	}
+	fifoPath := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifoPath, 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to create FIFO: %v", err)
+	}
+	server := &ProviderServer{config: Config{ConfigFileDir: dir}}
+
+	for _, path := range []string{subDir, fifoPath} {
+		attributes := map[string]string{authTypeField: "file", configFilePathField: path}
+		_, err := server.retrieveAuthConfig(context.Background(), attributes, "default")
+		if err == nil {
+			t.Fatalf("An error was expected for %v", path)
+		}
+		if !strings.HasSuffix(err.Error(), "is not a regular file") {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestRetrieveAuthConfig_ConfigFileOutsideDirectory_ReturnPermissionDenied(t *testing.T) {
+	dir := t.TempDir()
+	outsidePath := writeConfigFile(t, t.TempDir(), "[DEFAULT]\ntenancy=tenancy1\n")
+	linkPath := filepath.Join(dir, "config")
+	if err := os.Symlink(outsidePath, linkPath); err != nil {
+		t.Fatalf("Precondition failed: unable to create symlink: %v", err)
+	}
+	server := &ProviderServer{config: Config{ConfigFileDir: dir}}
+
+	for _, path := range []string{outsidePath, filepath.Join(dir, "..", "config"), "/etc/passwd", linkPath} {
+		attributes := map[string]string{authTypeField: "file", configFilePathField: path}
+		_, err := server.retrieveAuthConfig(context.Background(), attributes, "default")
+		if err == nil {
+			t.Fatalf("An error was expected for %v", path)
+		}
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+		}
+		if strings.Contains(err.Error(), path) {
+			t.Errorf("Error message should not echo the path: %v", err)
+		}
+	}
+}
+
+func TestRetrieveConfigFile_ConfigFileDirNotConfigured_ReturnPermissionDenied(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "[DEFAULT]\ntenancy=tenancy1\n")
+
+	_, err := (&ProviderServer{}).retrieveConfigFile(map[string]string{configFilePathField: path})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+}
+
+func TestExpandHomeDirectory_HomePrefix_ReturnPathInHomeDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	path, err := expandHomeDirectory(DefaultConfigFileDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if path != filepath.Join(home, ".oci") {
+		t.Errorf("Wrong path: %v", path)
+	}
+}
+
+func TestRetrieveConfigFile_RelativePath_ReturnError(t *testing.T) {
+	server := &ProviderServer{config: Config{ConfigFileDir: t.TempDir()}}
+
+	_, err := server.retrieveConfigFile(map[string]string{configFilePathField: "oci/config"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), `invalid "configFilePath" SecretProviderClass parameter`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// k8sClientSupplier creates Kubernetes client on the first use and reuses it across the requests.
+// The client is not created at startup, since instance principal only workloads don't need in-cluster config,
+// and the failed creation is retried by the next request instead of being remembered.
+// Zero value creates the client from in-cluster config.
+type k8sClientSupplier struct {
+	mutex     sync.Mutex
+	clientSet *kubernetes.Clientset
+	newClient func() (*kubernetes.Clientset, error) // newInClusterClientSet when nil
+}
+
+func (supplier *k8sClientSupplier) get() (*kubernetes.Clientset, error) {
+	supplier.mutex.Lock()
+	defer supplier.mutex.Unlock()
+	if supplier.clientSet != nil {
+		return supplier.clientSet, nil
+	}
+	newClient := supplier.newClient
+	if newClient == nil {
+		newClient = newInClusterClientSet
+	}
+	clientSet, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	supplier.clientSet = clientSet
+	return clientSet, nil
+}
+
+func newInClusterClientSet() (*kubernetes.Clientset, error) {
+	clusterCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("can not get cluster config. error: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(clusterCfg)
+	if err != nil {
+		return nil, fmt.Errorf("can not initialize kubernetes client. error: %v", err)
+	}
+
+	return clientset, nil
+}
@@ -0,0 +1,263 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newCountingClientFactory creates clients of the fake API server serving the same secret and counts the creations
+func newCountingClientFactory(t *testing.T, created *int) func() (*kubernetes.Clientset, error) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		secret := core.Secret{
+			TypeMeta:   meta.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+			ObjectMeta: meta.ObjectMeta{Name: "user-creds", Namespace: "default"},
+			Data:       map[string][]byte{"private-key": []byte("key")},
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(secret); err != nil {
+			t.Errorf("Unable to write secret: %v", err)
+		}
+	}))
+	t.Cleanup(apiServer.Close)
+
+	return func() (*kubernetes.Clientset, error) {
+		*created++
+		return kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	}
+}
+
+func TestReadK8sSecret_MultipleReads_CreateClientOnce(t *testing.T) {
+	created := 0
+	server := &ProviderServer{}
+	server.k8sClients.newClient = newCountingClientFactory(t, &created)
+
+	for i := 0; i < 3; i++ {
+		secret, err := server.readK8sSecret(context.Background(), "default", "user-creds")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(secret.Data["private-key"]) != "key" {
+			t.Errorf("Wrong secret data: %v", secret.Data)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("Client should be created once, created: %v", created)
+	}
+}
+
+func TestK8sClientSupplier_CreationFailed_RetryOnNextUse(t *testing.T) {
+	created := 0
+	newClient := newCountingClientFactory(t, &created)
+	failures := 1
+	supplier := &k8sClientSupplier{newClient: func() (*kubernetes.Clientset, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("can not get cluster config")
+		}
+		return newClient()
+	}}
+
+	if _, err := supplier.get(); err == nil {
+		t.Fatal("An error was expected")
+	}
+	first, err := supplier.get()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := supplier.get()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first != second || created != 1 {
+		t.Errorf("Client should be created once after the failure, created: %v", created)
+	}
+}
+
+// newTokenServer creates provider server which token API grants at most maxSeconds and records the requests
+func newTokenServer(t *testing.T, config Config, maxSeconds int64,
+	requests *[]authenticationv1.TokenRequest) *ProviderServer {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		tokenRequest := authenticationv1.TokenRequest{}
+		if err := json.NewDecoder(request.Body).Decode(&tokenRequest); err != nil {
+			t.Errorf("Unable to read token request: %v", err)
+		}
+		*requests = append(*requests, tokenRequest)
+		if *tokenRequest.Spec.ExpirationSeconds > maxSeconds {
+			tokenRequest.Spec.ExpirationSeconds = &maxSeconds
+		}
+		tokenRequest.Status.Token = "sa-token"
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(tokenRequest); err != nil {
+			t.Errorf("Unable to write token: %v", err)
+		}
+	}))
+	t.Cleanup(apiServer.Close)
+
+	server := &ProviderServer{config: config}
+	server.k8sClients.newClient = func() (*kubernetes.Clientset, error) {
+		return kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	}
+	return server
+}
+
+func TestGetSAToken_ConfiguredTTL_RequestConfiguredExpiration(t *testing.T) {
+	requests := []authenticationv1.TokenRequest{}
+	server := newTokenServer(t, Config{SATokenTTL: time.Hour}, 3600, &requests)
+	logs := captureLogs(t)
+
+	token, err := server.getSAToken(context.Background(),
+		&types.PodInfo{Namespace: "default", Name: "app", ServiceAccountName: "app-sa"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if token != "sa-token" {
+		t.Errorf("Wrong token: %v", token)
+	}
+	if len(requests) != 1 || *requests[0].Spec.ExpirationSeconds != 3600 {
+		t.Fatalf("Token should be requested with 3600s expiration: %+v", requests)
+	}
+	if strings.Contains(logs.String(), saTokenClampedWarning) {
+		t.Errorf("Unexpected clamping warning: %v", logs.String())
+	}
+}
+
+func TestGetSAToken_TTLNotConfigured_RequestDefaultExpiration(t *testing.T) {
+	requests := []authenticationv1.TokenRequest{}
+	server := newTokenServer(t, Config{}, 3600, &requests)
+
+	podInfo := &types.PodInfo{Namespace: "default", ServiceAccountName: "app-sa"}
+	if _, err := server.getSAToken(context.Background(), podInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(requests) != 1 || *requests[0].Spec.ExpirationSeconds != 900 {
+		t.Fatalf("Token should be requested with 900s expiration: %+v", requests)
+	}
+}
+
+func TestGetSAToken_TTLClampedByAPIServer_LogWarning(t *testing.T) {
+	requests := []authenticationv1.TokenRequest{}
+	server := newTokenServer(t, Config{SATokenTTL: 2 * time.Hour}, 3600, &requests)
+	logs := captureLogs(t)
+
+	podInfo := &types.PodInfo{Namespace: "default", ServiceAccountName: "app-sa"}
+	if _, err := server.getSAToken(context.Background(), podInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	record := findLogRecord(t, logs, saTokenClampedWarning)
+	if record["requestedSeconds"] != float64(7200) || record["grantedSeconds"] != float64(3600) {
+		t.Errorf("Wrong clamping warning: %v", record)
+	}
+}
+
+// newSecretPathServer creates provider server which API server records the paths of requested secrets
+func newSecretPathServer(t *testing.T, config Config, paths *[]string) *ProviderServer {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		*paths = append(*paths, request.URL.Path)
+		writer.Header().Set("Content-Type", "application/json")
+		secret := core.Secret{TypeMeta: meta.TypeMeta{Kind: "Secret", APIVersion: "v1"}}
+		if err := json.NewEncoder(writer).Encode(secret); err != nil {
+			t.Errorf("Unable to write secret: %v", err)
+		}
+	}))
+	t.Cleanup(apiServer.Close)
+
+	server := &ProviderServer{config: config}
+	server.k8sClients.newClient = func() (*kubernetes.Clientset, error) {
+		return kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	}
+	return server
+}
+
+func TestRetrieveAuthConfig_AuthSecretNamespace_ReadSecretOfNamespace(t *testing.T) {
+	testCases := map[string]struct {
+		attributes   map[string]string
+		expectedPath string
+	}{
+		"default": {
+			attributes:   map[string]string{authTypeField: "user", authConfigSecretNameField: "oci-creds"},
+			expectedPath: "/api/v1/namespaces/app/secrets/oci-creds",
+		},
+		"override": {
+			attributes: map[string]string{authTypeField: "user", authConfigSecretNameField: "oci-creds",
+				authConfigSecretNamespaceField: "oci-credentials"},
+			expectedPath: "/api/v1/namespaces/oci-credentials/secrets/oci-creds",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			paths := []string{}
+			server := newSecretPathServer(t, Config{AuthSecretNamespaces: []string{"oci-credentials"}}, &paths)
+
+			// the stub secret is empty, so only the read secret is checked
+			if _, err := server.retrieveAuthConfig(context.Background(), testCase.attributes, "app"); err == nil {
+				t.Fatal("An error was expected")
+			}
+
+			if len(paths) != 1 || paths[0] != testCase.expectedPath {
+				t.Errorf("Wrong secret is read: %v", paths)
+			}
+		})
+	}
+}
+
+func TestRetrieveAuthConfig_AuthSecretNamespaceNotAllowed_ReturnPermissionDenied(t *testing.T) {
+	for _, allowedNamespaces := range [][]string{nil, {"oci-credentials"}} {
+		paths := []string{}
+		server := newSecretPathServer(t, Config{AuthSecretNamespaces: allowedNamespaces}, &paths)
+		attributes := map[string]string{authTypeField: "user", authConfigSecretNameField: "oci-creds",
+			authConfigSecretNamespaceField: "other-team"}
+
+		_, err := server.retrieveAuthConfig(context.Background(), attributes, "app")
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+		}
+		if !strings.Contains(err.Error(), "auth config secret namespace is not allowed: other-team") {
+			t.Errorf("Wrong error message: %v", err)
+		}
+		if len(paths) != 0 {
+			t.Errorf("Secret should not be read: %v", paths)
+		}
+	}
+}
+
+func TestRetrieveAuthConfig_AuthSecretNamespaceOfPod_ReadSecretWithoutAllowList(t *testing.T) {
+	paths := []string{}
+	server := newSecretPathServer(t, Config{}, &paths)
+	attributes := map[string]string{authTypeField: "user", authConfigSecretNameField: "oci-creds",
+		authConfigSecretNamespaceField: "app"}
+
+	_, err := server.retrieveAuthConfig(context.Background(), attributes, "app")
+	if status.Code(err) == codes.PermissionDenied {
+		t.Fatalf("Pod namespace should be allowed: %v", err)
+	}
+
+	if len(paths) != 1 || paths[0] != "/api/v1/namespaces/app/secrets/oci-creds" {
+		t.Errorf("Wrong secret is read: %v", paths)
+	}
+}
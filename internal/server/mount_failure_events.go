@@ -0,0 +1,195 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/status"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiMachineryTypes "k8s.io/apimachinery/pkg/types"
+)
+
+const mountFailedReason = "SecretMountFailed"
+
+const eventComponent = "oci-secrets-store-csi-driver-provider"
+
+// defaultMountFailureEventInterval is the minimal interval between events of the same pod
+const defaultMountFailureEventInterval = 5 * time.Minute
+
+// eventTimeout bounds creation of an event, since the mount context could be already exhausted
+const eventTimeout = 5 * time.Second
+
+// eventQueueSize bounds the events waiting to be created, the events beyond it are dropped while API server is slow
+const eventQueueSize = 64
+
+// eventSink creates Kubernetes events, it's needed as abstraction to Kubernetes client in tests.
+type eventSink interface {
+	createEvent(ctx context.Context, event *core.Event) error
+}
+
+// k8sEventSink creates events with Kubernetes client shared with the other requests
+type k8sEventSink struct {
+	clients *k8sClientSupplier
+}
+
+func (sink *k8sEventSink) createEvent(ctx context.Context, event *core.Event) error {
+	clientSet, err := sink.clients.get()
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().Events(event.Namespace).Create(ctx, event, meta.CreateOptions{})
+	return err
+}
+
+// mountedPod identifies the pod the secrets are mounted to
+type mountedPod struct {
+	name      string
+	namespace string
+	uid       apiMachineryTypes.UID
+}
+
+type podFailures struct {
+	consecutive int
+	lastFailure time.Time
+	lastEvent   time.Time
+}
+
+// mountFailureTracker emits Warning event on the pod after the number of consecutive mount failures,
+// repeating it no more often than the interval. The events are created in the background,
+// so the mount doesn't wait for API server.
+type mountFailureTracker struct {
+	sink      eventSink
+	threshold int
+	interval  time.Duration
+	clock     clock.Clock
+
+	mutex    sync.Mutex
+	failures map[mountedPod]*podFailures
+
+	events  chan *core.Event
+	pending sync.WaitGroup
+}
+
+func newMountFailureTracker(sink eventSink, threshold int) *mountFailureTracker {
+	tracker := &mountFailureTracker{
+		sink:      sink,
+		threshold: threshold,
+		interval:  defaultMountFailureEventInterval,
+		clock:     clock.Real,
+		failures:  make(map[mountedPod]*podFailures),
+		events:    make(chan *core.Event, eventQueueSize),
+	}
+	go tracker.createEvents()
+	return tracker
+}
+
+// record accounts the mount result of the pod, mount error reset the consecutive failures.
+func (tracker *mountFailureTracker) record(pod mountedPod, mountErr error) {
+	if pod.name == "" || pod.namespace == "" {
+		return
+	}
+	if mountErr == nil {
+		tracker.mutex.Lock()
+		delete(tracker.failures, pod)
+		tracker.mutex.Unlock()
+		return
+	}
+	consecutive, ok := tracker.countFailure(pod)
+	if !ok {
+		return
+	}
+	category := status.Code(mountErr).String()
+	tracker.pending.Add(1)
+	select {
+	case tracker.events <- tracker.newEvent(pod, consecutive, category):
+	default:
+		tracker.pending.Done()
+		log.Info().Str("pod", pod.name).Msg("Mount failure event is dropped, too many events are pending")
+	}
+}
+
+// createEvents creates the queued events one by one for the lifetime of the provider
+func (tracker *mountFailureTracker) createEvents() {
+	for event := range tracker.events {
+		tracker.createEvent(event)
+	}
+}
+
+func (tracker *mountFailureTracker) createEvent(event *core.Event) {
+	defer tracker.pending.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	if err := tracker.sink.createEvent(ctx, event); err != nil {
+		log.Info().Err(err).Str("pod", event.InvolvedObject.Name).Msg("Unable to create mount failure event")
+	}
+}
+
+// flush waits until the queued events are created
+func (tracker *mountFailureTracker) flush() {
+	tracker.pending.Wait()
+}
+
+// countFailure returns the number of consecutive failures and whether the event should be emitted.
+func (tracker *mountFailureTracker) countFailure(pod mountedPod) (int, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	now := tracker.clock.Now()
+	tracker.forgetStalePods(now)
+
+	failures, ok := tracker.failures[pod]
+	if !ok {
+		failures = &podFailures{}
+		tracker.failures[pod] = failures
+	}
+	failures.consecutive++
+	failures.lastFailure = now
+	if failures.consecutive < tracker.threshold || now.Sub(failures.lastEvent) < tracker.interval {
+		return failures.consecutive, false
+	}
+	failures.lastEvent = now
+	return failures.consecutive, true
+}
+
+// forgetStalePods drops pods which are not mounted for a while, e.g. deleted while failing
+func (tracker *mountFailureTracker) forgetStalePods(now time.Time) {
+	for pod, failures := range tracker.failures {
+		if now.Sub(failures.lastFailure) > 2*tracker.interval {
+			delete(tracker.failures, pod)
+		}
+	}
+}
+
+func (tracker *mountFailureTracker) newEvent(pod mountedPod, consecutive int, category string) *core.Event {
+	timestamp := meta.NewTime(tracker.clock.Now())
+	return &core.Event{
+		ObjectMeta: meta.ObjectMeta{
+			GenerateName: pod.name + ".",
+			Namespace:    pod.namespace,
+		},
+		InvolvedObject: core.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Name:       pod.name,
+			Namespace:  pod.namespace,
+			UID:        pod.uid,
+		},
+		Reason:         mountFailedReason,
+		Message:        fmt.Sprintf("Mounting secrets failed %v times in a row: %v", consecutive, category),
+		Type:           core.EventTypeWarning,
+		Source:         core.EventSource{Component: eventComponent},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+}
@@ -0,0 +1,204 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	core "k8s.io/api/core/v1"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// fakeEventSink - stub of Kubernetes client collecting created events
+type fakeEventSink struct {
+	mutex  sync.Mutex
+	events []*core.Event
+}
+
+func (sink *fakeEventSink) createEvent(_ context.Context, event *core.Event) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+func (sink *fakeEventSink) count() int {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return len(sink.events)
+}
+
+var failingPod = mountedPod{name: "app", namespace: "default", uid: "app-uid"}
+
+var mountError = status.Error(codes.NotFound, "unable to retrieve secrets: secret value")
+
+func newTestTracker(sink eventSink, threshold int) (*mountFailureTracker, *clock.Fake) {
+	fakeClock := clock.NewFake(time.Now())
+	tracker := newMountFailureTracker(sink, threshold)
+	tracker.clock = fakeClock
+	return tracker, fakeClock
+}
+
+func TestMountFailureTracker_FailuresBelowThreshold_NoEvent(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, _ := newTestTracker(sink, 3)
+
+	tracker.record(failingPod, mountError)
+	tracker.record(failingPod, mountError)
+	tracker.flush()
+
+	if sink.count() != 0 {
+		t.Errorf("Unexpected events: %v", sink.events)
+	}
+}
+
+func TestMountFailureTracker_FailuresReachThreshold_EmitWarningEvent(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, _ := newTestTracker(sink, 3)
+
+	for i := 0; i < 3; i++ {
+		tracker.record(failingPod, mountError)
+	}
+	tracker.flush()
+
+	if sink.count() != 1 {
+		t.Fatalf("Unexpected number of events: %v", sink.count())
+	}
+	event := sink.events[0]
+	if event.Reason != mountFailedReason || event.Type != core.EventTypeWarning ||
+		event.Namespace != "default" || event.InvolvedObject.Name != "app" || event.InvolvedObject.UID != "app-uid" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if event.Message != "Mounting secrets failed 3 times in a row: NotFound" {
+		t.Errorf("Unexpected event message: %v", event.Message)
+	}
+	if strings.Contains(event.Message, "secret value") {
+		t.Errorf("Event should not contain error details: %v", event.Message)
+	}
+}
+
+func TestMountFailureTracker_RepeatedFailures_EventsThrottledByInterval(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, fakeClock := newTestTracker(sink, 1)
+
+	for i := 0; i < 5; i++ {
+		tracker.record(failingPod, mountError)
+		fakeClock.Advance(time.Minute)
+	}
+	tracker.flush()
+	if sink.count() != 1 {
+		t.Fatalf("Events should be throttled: %v", sink.count())
+	}
+
+	fakeClock.Advance(defaultMountFailureEventInterval)
+	tracker.record(failingPod, mountError)
+	tracker.flush()
+	if sink.count() != 2 {
+		t.Errorf("Event should be emitted after the interval: %v", sink.count())
+	}
+}
+
+func TestMountFailureTracker_SuccessfulMount_ResetConsecutiveFailures(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, _ := newTestTracker(sink, 2)
+
+	tracker.record(failingPod, mountError)
+	tracker.record(failingPod, nil)
+	tracker.record(failingPod, mountError)
+	tracker.flush()
+
+	if sink.count() != 0 {
+		t.Errorf("Unexpected events: %v", sink.events)
+	}
+}
+
+func TestMountFailureTracker_DifferentPods_CountedSeparately(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, _ := newTestTracker(sink, 2)
+
+	tracker.record(failingPod, mountError)
+	tracker.record(mountedPod{name: "other", namespace: "default"}, mountError)
+	tracker.flush()
+
+	if sink.count() != 0 {
+		t.Errorf("Unexpected events: %v", sink.events)
+	}
+}
+
+// blockingEventSink - stub of Kubernetes client which doesn't answer until released
+type blockingEventSink struct {
+	fakeEventSink
+	release chan struct{}
+}
+
+func (sink *blockingEventSink) createEvent(ctx context.Context, event *core.Event) error {
+	<-sink.release
+	return sink.fakeEventSink.createEvent(ctx, event)
+}
+
+func TestMountFailureTracker_SlowAPIServer_RecordWithoutWaiting(t *testing.T) {
+	sink := &blockingEventSink{release: make(chan struct{})}
+	tracker, _ := newTestTracker(sink, 1)
+
+	recorded := make(chan struct{})
+	go func() {
+		// the first event is taken by the worker, the queue holds the others up to its size, the rest is dropped
+		for i := 0; i < eventQueueSize+10; i++ {
+			tracker.record(mountedPod{name: fmt.Sprintf("app-%d", i), namespace: "default"}, mountError)
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Mount failures should be recorded without waiting for API server")
+	}
+	close(sink.release)
+	tracker.flush()
+
+	if count := sink.count(); count < eventQueueSize || count > eventQueueSize+1 {
+		t.Errorf("Events beyond the queue should be dropped: %v", count)
+	}
+}
+
+func TestMount_ConsecutiveFailures_EmitEventOnPod(t *testing.T) {
+	sink := &fakeEventSink{}
+	tracker, _ := newTestTracker(sink, 2)
+	providerServer := &ProviderServer{secretService: &mockSecretService{}, mountFailures: tracker}
+
+	attributes, err := json.Marshal(map[string]string{
+		secretsField:      "- name: foo",
+		vaultIDField:      testVaultID,
+		authTypeField:     string(types.Instance),
+		podNameField:      "app",
+		podNamespaceField: "default",
+	})
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	for i := 0; i < 2; i++ {
+		request := provider.MountRequest{Attributes: string(attributes), Permission: readOnlyFilePermission}
+		if _, err := providerServer.Mount(context.Background(), &request); err == nil {
+			t.Fatal("An error was expected")
+		}
+	}
+	tracker.flush()
+
+	if sink.count() != 1 || sink.events[0].InvolvedObject.Name != "app" {
+		t.Errorf("Unexpected events: %v", sink.events)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errOutsideDir is returned for the paths outside the directory the provider reads the files from
+var errOutsideDir = errors.New("path is outside of the allowed directory")
+
+// openFileInDir opens the regular file at the absolute path for reading, provided the path is within the directory
+// both as written and with symlinks resolved, e.g. "..data" links of a mounted secret volume. The paths come from
+// SecretProviderClass and auth config secrets, so nothing outside the directory is accessed, not even to tell
+// whether it exists. The file is checked before it's opened, so e.g. a FIFO doesn't block the mount.
+func openFileInDir(path string, dir string) (*os.File, os.FileInfo, error) {
+	if dir == "" || !isWithinDir(path, dir) {
+		return nil, nil, errOutsideDir
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isWithinDir(resolvedPath, resolvedDir) {
+		return nil, nil, errOutsideDir
+	}
+	fileInfo, err := os.Stat(resolvedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%q is not a regular file", path)
+	}
+	file, err := os.Open(resolvedPath) //nolint:gosec // the path is checked to be a regular file within the directory
+	if err != nil {
+		return nil, nil, err
+	}
+	openedInfo, err := file.Stat()
+	if err == nil && !os.SameFile(fileInfo, openedInfo) {
+		err = fmt.Errorf("%q was replaced while opening", path)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, openedInfo, nil
+}
+
+// isWithinDir tells whether the cleaned path is below the directory, without accessing the filesystem
+func isWithinDir(path string, dir string) bool {
+	relativePath, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && relativePath != "." && relativePath != ".." &&
+		!strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
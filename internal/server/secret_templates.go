@@ -0,0 +1,115 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/response"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+const templatesField = "templates"
+
+// secretTemplate is a file computed from the content of several mounted secrets,
+// e.g. a connection string built of user and password.
+type secretTemplate struct {
+	FileName string `yaml:"fileName"`
+	Template string `yaml:"template"`
+	parsed   *template.Template
+}
+
+// templateData is exposed to the templates, secrets are referenced by their file names,
+// which are the secret names unless file names are set explicitly.
+type templateData struct {
+	Secrets map[string]string
+}
+
+// retrieveSecretTemplates parses optional "templates" SecretProviderClass parameter,
+// verifying that the template files don't overwrite the secret files.
+func retrieveSecretTemplates(requestAttributes map[string]string,
+	requests []*types.SecretBundleRequest) ([]*secretTemplate, error) {
+	templatesYaml := requestAttributes[templatesField]
+	if templatesYaml == "" {
+		return nil, nil
+	}
+
+	var templates []*secretTemplate
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(templatesYaml)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&templates); err != nil {
+		log.Info().Err(err).Msg("Failed to unmarshal templates")
+		return nil, fmt.Errorf("failed to unmarshal SecretProviderClass parameter \"%v\"", templatesField)
+	}
+
+	fileNames := make(map[string]bool, len(requests)+len(templates))
+	for _, request := range requests {
+		fileNames[request.GetFilePath()] = true
+	}
+	for _, secretTemplate := range templates {
+		if secretTemplate.FileName == "" {
+			return nil, fmt.Errorf("missed fileName of template")
+		}
+		secretTemplate.FileName = filepath.Clean(secretTemplate.FileName)
+		if err := types.ValidateFilePath(secretTemplate.FileName); err != nil {
+			return nil, err
+		}
+		if fileNames[secretTemplate.FileName] {
+			return nil, fmt.Errorf("duplicated fileName name: %v", secretTemplate.FileName)
+		}
+		fileNames[secretTemplate.FileName] = true
+
+		parsed, err := template.New(secretTemplate.FileName).Option("missingkey=error").Parse(secretTemplate.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %v: %w", secretTemplate.FileName, err)
+		}
+		secretTemplate.parsed = parsed
+	}
+	return templates, nil
+}
+
+// templatesGenerator renders the templates as additional files of the mount response,
+// a template which could not be rendered is reported as invalid argument.
+func templatesGenerator(templates []*secretTemplate) response.Generator {
+	return func(contents map[string]string, filePermission int32) ([]*provider.File, error) {
+		files, err := renderTemplates(templates, contents, filePermission)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return files, nil
+	}
+}
+
+// renderTemplates executes the templates over the decoded secret contents keyed by file names.
+// A template referencing a secret which is not mounted fails, instead of silently producing incomplete content.
+func renderTemplates(templates []*secretTemplate, contents map[string]string,
+	filePermission int32) ([]*provider.File, error) {
+	files := make([]*provider.File, 0, len(templates))
+	data := templateData{Secrets: contents}
+	for _, secretTemplate := range templates {
+		var rendered bytes.Buffer
+		if err := secretTemplate.parsed.Execute(&rendered, data); err != nil {
+			log.Info().Err(err).Str("template", secretTemplate.FileName).Msg("Unable to render template")
+			return nil, fmt.Errorf("unable to render template %v, "+
+				"check that it references only mounted secrets: %w", secretTemplate.FileName, err)
+		}
+		files = append(files, &provider.File{
+			Path:     secretTemplate.FileName,
+			Contents: rendered.Bytes(),
+			Mode:     filePermission,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,131 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// mountWithTemplates mounts "user" and "password" secrets with the templates SecretProviderClass parameter
+func mountWithTemplates(t *testing.T, templatesYaml string) (*provider.MountResponse, error) {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "user", VersionNumber: 1},
+		{Name: "password", VersionNumber: 1},
+	}
+	mockService := &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock: []*types.SecretBundle{
+			{
+				ID: "uid1", Name: "user", VersionNumber: 1, Stages: []types.Stage{types.Current},
+				BundleContent: &types.SecretBundleContent{Content: "YWRtaW4=", ContentType: types.Base64}, // admin
+			},
+			{
+				ID: "uid2", Name: "password", VersionNumber: 1, Stages: []types.Stage{types.Current},
+				BundleContent: &types.SecretBundleContent{Content: "czNjcjN0", ContentType: types.Base64}, // s3cr3t
+			},
+		},
+	}
+	providerServer := &ProviderServer{secretService: mockService}
+
+	attributesJSON, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	var attributes map[string]string
+	if err := json.Unmarshal([]byte(attributesJSON), &attributes); err != nil {
+		t.Fatalf("Precondition failed: unable to deserialize request attributes")
+	}
+	attributes[templatesField] = templatesYaml
+	attributesBytes, err := json.Marshal(attributes)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	request := provider.MountRequest{
+		Attributes: string(attributesBytes),
+		TargetPath: "/some/path",
+		Permission: readOnlyFilePermission,
+	}
+	return providerServer.Mount(context.Background(), &request)
+}
+
+func TestMount_TemplateOfTwoSecrets_ReturnSecretsAndTemplateFile(t *testing.T) {
+	response, err := mountWithTemplates(t, `
+- fileName: db-url
+  template: "postgres://{{ .Secrets.user }}:{{ .Secrets.password }}@db:5432/app"
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 3 || len(response.ObjectVersion) != 2 {
+		t.Fatalf("Unexpected response: %v", response)
+	}
+	file := response.Files[2]
+	if file.Path != "db-url" || string(file.Contents) != "postgres://admin:s3cr3t@db:5432/app" {
+		t.Errorf("Unexpected template file %v: %v", file.Path, string(file.Contents))
+	}
+	if file.Mode != readOnlyPermission {
+		t.Errorf("Unexpected file mode: %v", file.Mode)
+	}
+}
+
+func TestMount_TemplateReferencesMissedSecret_ReturnInvalidArgument(t *testing.T) {
+	_, err := mountWithTemplates(t, `
+- fileName: db-url
+  template: "{{ .Secrets.user }}@{{ .Secrets.host }}"
+`)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "unable to render template db-url") ||
+		!strings.Contains(err.Error(), `map has no entry for key "host"`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_TemplatesReferenceEachOther_ReturnInvalidArgument(t *testing.T) {
+	_, err := mountWithTemplates(t, `
+- fileName: a
+  template: "{{ .Secrets.b }}"
+- fileName: b
+  template: "{{ .Secrets.a }}"
+`)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "unable to render template a") ||
+		!strings.Contains(err.Error(), `map has no entry for key "b"`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_InvalidTemplates_ReturnInvalidArgument(t *testing.T) {
+	testCases := map[string]string{
+		"not a list":          "fileName: db-url",
+		"missed file name":    "- template: \"{{ .Secrets.user }}\"",
+		"secret file name":    "- fileName: user\n  template: \"{{ .Secrets.user }}\"",
+		"unknown field":       "- fileName: db-url\n  value: \"{{ .Secrets.user }}\"",
+		"malformed template":  "- fileName: db-url\n  template: \"{{ .Secrets.user \"",
+		"duplicate file name": "- fileName: a\n  template: x\n- fileName: a\n  template: y",
+		"path traversal":      "- fileName: ../db-url\n  template: x",
+	}
+	for name, templatesYaml := range testCases {
+		if _, err := mountWithTemplates(t, templatesYaml); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Invalid gRPC code for %v: %v", name, status.Code(err))
+		}
+	}
+}
@@ -10,39 +10,182 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
-	"os"
-
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/metrics"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/policy"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/response"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/service"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apiMachineryTypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
+// Config contains settings of ProviderServer.
+type Config struct {
+	Service service.Config
+	// InstancePrincipalRegion is used by instance principal unless SecretProviderClass specifies the region
+	InstancePrincipalRegion string
+	// DetectDoubleEncoding enables warnings about secrets which content looks base64-encoded twice
+	DetectDoubleEncoding bool
+	// MaxFileNameLength limits the length in bytes of mounted file names, defaults to defaultMaxFileNameLength
+	MaxFileNameLength int
+	// AllowedVaults restricts vaults the secrets could be mounted from, any vault is allowed when nil
+	AllowedVaults *policy.VaultAllowList
+	// SecretNames restricts names of the secrets could be requested, any name is allowed when nil
+	SecretNames *policy.SecretNamePolicy
+	// NamespaceSecretNames restricts names of the secrets pods of each namespace could request on top of SecretNames,
+	// any name is allowed when nil
+	NamespaceSecretNames *policy.NamespaceSecretNamePolicy
+	// AllowedPrincipals restricts principal types SecretProviderClass could authenticate with,
+	// e.g. to forbid user principal with long-lived keys, any principal type is allowed when empty
+	AllowedPrincipals []types.OCIPrincipalType
+	// AuthSecretNamespaces are the namespaces SecretProviderClass could read user principal auth config secret from
+	// instead of the pod namespace, e.g. a namespace centralizing OCI credentials, none is allowed when empty
+	AuthSecretNamespaces []string
+	// PrivateKeyDir is the absolute path of the directory user principal private key files are read from,
+	// the keys of auth config secret of a namespace are read from the subdirectory named after the namespace,
+	// e.g. "/etc/oci/keys/team-a", privateKeyPath of auth config is refused when empty
+	PrivateKeyDir string
+	// ConfigFileDir is the absolute path of the directory file principal reads OCI config files from,
+	// see DefaultConfigFileDir, file principal is refused when empty
+	ConfigFileDir string
+	// ReportSecretExpiry enables logging and metric of expiry time of the mounted secrets
+	ReportSecretExpiry bool
+	// AllowUnknownSecretFields makes unknown fields of SecretProviderClass secrets logged instead of failing the mount
+	AllowUnknownSecretFields bool
+	// ChunkSize splits secrets larger than the size in bytes into numbered parts, chunking is disabled when zero
+	ChunkSize int
+	// PendingDeletion defines how secrets scheduled for deletion are mounted, defaults to PendingDeletionWarn
+	PendingDeletion PendingDeletionPolicy
+	// RefuseEmptyStages fails the mount of a secret bundle returned without stages instead of logging a warning
+	RefuseEmptyStages bool
+	// ContentHashObjectVersion reports digest of the mounted content as object version instead of version number,
+	// so the driver doesn't rewrite files when a stage moves to a new version with the same content
+	ContentHashObjectVersion bool
+	// StagesObjectVersion appends the stages of the mounted version to the object version, e.g. "2;CURRENT,LATEST",
+	// so the consumers of the object versions could tell the mounted CURRENT version from PREVIOUS one
+	StagesObjectVersion bool
+	// MaxFileMode is the loosest mode of the mounted files, e.g. 0600, any mode is allowed when zero
+	MaxFileMode os.FileMode
+	// FileMode defines how modes looser than MaxFileMode are handled, defaults to FileModeReject
+	FileMode FileModePolicy
+	// MountFailureEventThreshold is the number of consecutive mount failures of a pod
+	// emitting Warning event on the pod, events are disabled when zero
+	MountFailureEventThreshold int
+	// SATokenTTL is the requested expiration of service account token used by workload identity,
+	// defaults to defaultSATokenTTL, should be within the range accepted by the token API
+	SATokenTTL time.Duration
+	// FetchOutcomesFile is the path of the file added to each mount describing retrieval of each secret,
+	// e.g. served version and cache hits, for observability tools, the file is not added when empty
+	FetchOutcomesFile string
+}
+
+// PendingDeletionPolicy defines handling of the secrets scheduled for deletion
+type PendingDeletionPolicy string
+
+const (
+	// PendingDeletionWarn mounts the secret and logs a warning
+	PendingDeletionWarn PendingDeletionPolicy = "warn"
+	// PendingDeletionRefuse fails the mount
+	PendingDeletionRefuse PendingDeletionPolicy = "refuse"
+)
+
+// FileModePolicy defines handling of the requested file modes looser than the maximum
+type FileModePolicy string
+
+const (
+	// FileModeReject fails the mount
+	FileModeReject FileModePolicy = "reject"
+	// FileModeTighten mounts the files with the permissions exceeding the maximum cleared
+	FileModeTighten FileModePolicy = "tighten"
+)
+
+// defaultMaxFileNameLength is the file name limit of the most common filesystems
+const defaultMaxFileNameLength = 255
+
+// defaultSATokenTTL is the expiration of service account token, long enough for the OCI calls of a mount
+const defaultSATokenTTL = 15 * time.Minute
+
+const saTokenClampedWarning = "Service account token TTL was clamped by API server"
+
+// Expiration range of service account token accepted by Kubernetes token API
+const (
+	minSATokenTTL = 10 * time.Minute
+	maxSATokenTTL = (1 << 32) * time.Second
+)
+
 // ProviderServer implements predefined provider API
 type ProviderServer struct {
 	secretService service.SecretService
+	config        Config
+	mountFailures *mountFailureTracker
+	k8sClients    k8sClientSupplier
 }
 
-func NewOCIVaultProviderServer() (*ProviderServer, error) {
-	ociService, err := service.NewOCISecretService()
+func NewOCIVaultProviderServer(config Config) (*ProviderServer, error) {
+	switch config.PendingDeletion {
+	case "", PendingDeletionWarn, PendingDeletionRefuse:
+	default:
+		return nil, fmt.Errorf("unknown pending deletion policy: %v", config.PendingDeletion)
+	}
+	switch config.FileMode {
+	case "", FileModeReject, FileModeTighten:
+	default:
+		return nil, fmt.Errorf("unknown file mode policy: %v", config.FileMode)
+	}
+	if config.SATokenTTL != 0 && (config.SATokenTTL < minSATokenTTL || config.SATokenTTL > maxSATokenTTL) {
+		return nil, fmt.Errorf("service account token TTL %v is out of range [%v, %v]",
+			config.SATokenTTL, minSATokenTTL, maxSATokenTTL)
+	}
+	if config.PrivateKeyDir != "" && !filepath.IsAbs(config.PrivateKeyDir) {
+		return nil, fmt.Errorf("private key directory %q should be absolute", config.PrivateKeyDir)
+	}
+	if config.ConfigFileDir != "" {
+		configFileDir, err := expandHomeDirectory(config.ConfigFileDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand config file directory %q: %v", config.ConfigFileDir, err)
+		}
+		if !filepath.IsAbs(configFileDir) {
+			return nil, fmt.Errorf("config file directory %q should be absolute", config.ConfigFileDir)
+		}
+		config.ConfigFileDir = configFileDir
+	}
+	if config.FetchOutcomesFile != "" {
+		if err := types.ValidateFilePath(config.FetchOutcomesFile); err != nil {
+			return nil, fmt.Errorf("invalid fetch outcomes file: %w", err)
+		}
+	}
+	ociService, err := service.NewOCISecretService(config.Service)
 	if err != nil {
 		return nil, err
 	}
 	log.Info().Msg("Created OCI Vault service")
-	return &ProviderServer{ociService}, nil
+	providerServer := &ProviderServer{secretService: ociService, config: config}
+	if config.MountFailureEventThreshold > 0 {
+		sink := &k8sEventSink{clients: &providerServer.k8sClients}
+		providerServer.mountFailures = newMountFailureTracker(sink, config.MountFailureEventThreshold)
+	}
+	return providerServer, nil
 }
 
 // attributes' fields
@@ -50,7 +193,9 @@ const secretsField = "secrets"
 
 const authTypeField = "authType"
 const authConfigSecretNameField = "authSecretName" //#nosec G101
+const authConfigSecretNamespaceField = "authSecretNamespace"
 const vaultIDField = "vaultId"
+const regionField = "region"
 
 const secretProviderClassField = "secretProviderClass"
 const podNameField = "csi.storage.k8s.io/pod.name"
@@ -58,14 +203,66 @@ const podNamespaceField = "csi.storage.k8s.io/pod.namespace"
 const podUIDField = "csi.storage.k8s.io/pod.uid"
 const podServiceAccountField = "csi.storage.k8s.io/serviceAccount.name"
 
+// privateKeyPathField of user auth config references private key file instead of private-key data of the secret
+const privateKeyPathField = "privateKeyPath"
+
+// maxPrivateKeyFileSize limits private key file, PEM keys take a few kilobytes
+const maxPrivateKeyFileSize = 64 * 1024
+
+// ociCallsHeader is the response metadata key holding the number of OCI API calls made by the mount
+const ociCallsHeader = "x-oci-calls"
+
 // BuildVersion set during the build with ldflags
 var BuildVersion string
 
+// GitCommit set during the build with ldflags
+var GitCommit string
+
+// BuildDate set during the build with ldflags
+var BuildDate string
+
+// runtimeName is the name of the provider reported by the driver
+const runtimeName = "oci-secrets-store-csi-driver-provider"
+
+// BuildDetails describes the provider binary, e.g. for /version endpoint.
+type BuildDetails struct {
+	RuntimeName    string
+	RuntimeVersion string
+	GitCommit      string
+	BuildDate      string
+	GoVersion      string
+}
+
+// GetBuildDetails returns details of the provider binary, values not set during the build are "unknown".
+func GetBuildDetails() BuildDetails {
+	return BuildDetails{
+		RuntimeName:    runtimeName,
+		RuntimeVersion: valueOrUnknown(BuildVersion),
+		GitCommit:      valueOrUnknown(GitCommit),
+		BuildDate:      valueOrUnknown(BuildDate),
+		GoVersion:      runtime.Version(),
+	}
+}
+
+// BuildInfo describes the provider binary, e.g. for --version flag.
+func BuildInfo() string {
+	details := GetBuildDetails()
+	return fmt.Sprintf("%v\nversion: %v\ncommit: %v\ndate: %v\ngo: %v\n", details.RuntimeName,
+		details.RuntimeVersion, details.GitCommit, details.BuildDate, details.GoVersion)
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
 // Version returns the name and version of the Secrets Store CSI Driver Provider.
 func (*ProviderServer) Version(context.Context, *provider.VersionRequest) (*provider.VersionResponse, error) {
 	return &provider.VersionResponse{
 		Version:        "v1alpha1",
-		RuntimeName:    "oci-secrets-store-csi-driver-provider",
+		RuntimeName:    runtimeName,
 		RuntimeVersion: BuildVersion,
 	}, nil
 }
@@ -73,10 +270,11 @@ func (*ProviderServer) Version(context.Context, *provider.VersionRequest) (*prov
 // Mount returns secrets to mount.
 // The mount request's `Attribute` field consists of parameters section from the SecretProviderClass
 // and pod metadata provided by the driver. `Attribute` field is plain JSON.
-// Note that `ObjectVersion` and `Files` array fields of mount response share the same index for each secret.
+// Note that `ObjectVersion` and `Files` array fields of mount response share the same index for each secret,
+// unless large secrets are split into chunks.
 func (server *ProviderServer) Mount(
-	ctx context.Context, mountRequest *provider.MountRequest) (*provider.MountResponse, error) {
-	var filePermission os.FileMode
+	ctx context.Context, mountRequest *provider.MountRequest) (response *provider.MountResponse, err error) {
+	start := time.Now()
 
 	attributes, err := server.unmarshalRequestAttributes(mountRequest.GetAttributes())
 	if err != nil {
@@ -84,102 +282,373 @@ func (server *ProviderServer) Mount(
 			codes.InvalidArgument,
 			"failed to unmarshal SecretProviderClass parameters or attributes provided by driver")
 	}
+	if server.mountFailures != nil {
+		defer func() { server.mountFailures.record(podFromAttributes(attributes), err) }()
+	}
+	// every line logged for the mount carries its ID, so concurrent mounts could be told apart
+	ctx = logging.WithLogger(ctx, log.With().
+		Str("mountId", logging.NewCorrelationID()).
+		Str("pod", attributes[podNameField]).
+		Str("SecretProviderClass", attributes[secretProviderClassField]).Logger())
+	logger := logging.FromContext(ctx)
 
 	secretBundleRequests, err := server.retrieveSecretRequests(attributes)
+	if _, isStatus := status.FromError(err); err != nil && isStatus {
+		return nil, err
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "unable to handle SecretProviderClass secrets: %v", err)
 	}
+	templates, err := retrieveSecretTemplates(attributes, secretBundleRequests)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unable to handle SecretProviderClass templates: %v", err)
+	}
+
+	// the mode is checked before retrieval, so a request that can't be mounted doesn't cost OCI calls
+	filePermission, err := parseFilePermission(mountRequest.GetPermission())
+	if err != nil {
+		return nil, err
+	}
+	filePermission, err = server.checkFileMode(filePermission)
+	if err != nil {
+		return nil, err
+	}
 
-	podName := attributes[podNameField]
 	namespace := attributes[podNamespaceField]
 	secretProviderClass := attributes[secretProviderClassField]
 
-	vaultID := types.VaultID(attributes[vaultIDField])
+	vaultID, err := server.checkVault(ctx, attributes)
+	if err != nil {
+		return nil, err
+	}
 
 	// create or get auth provider
 	auth, err := server.retrieveAuthConfig(ctx, attributes, namespace)
 	if err != nil {
-		log.Error().Stack().Err(err).Msg("Unable to handle SecretProviderClass auth parameters")
+		logger.Error().Stack().Err(err).Msg("Unable to handle SecretProviderClass auth parameters")
 		return nil, err
 	}
 
+	ctx, callCounter := service.WithCallCounter(service.WithSecretProviderClass(ctx, secretProviderClass))
 	secretBundles, err := server.secretService.GetSecretBundles(ctx, secretBundleRequests, auth, vaultID)
+	reportOCICalls(ctx, callCounter)
 	if err != nil {
-		log.Info().
-			Err(err).
-			Str("pod", podName).
-			Str("SecretProviderClass", secretProviderClass).Msg("Unable to retrieve all secrets")
+		logger.Info().Err(err).Msg("Unable to retrieve all secrets")
 
-		return nil, status.Errorf(codes.NotFound, "unable to retrieve secrets: %v", err)
+		return nil, status.Errorf(retrievalErrorCode(err), "unable to retrieve secrets: %v", err)
 	}
-	log.Info().
-		Str("pod", podName).
-		Str("SecretProviderClass", secretProviderClass).Msg("Successfully found requested secrets")
+	logger.Debug().Msg("Successfully found requested secrets")
 
-	err = json.Unmarshal([]byte(mountRequest.GetPermission()), &filePermission)
+	err = server.checkPendingDeletion(ctx, secretBundles)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal file permission, error: %w", err)
+		return nil, err
 	}
 
-	return server.createResponse(secretBundles, int32(filePermission))
+	err = checkMinVersions(ctx, secretBundles)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.checkEmptyStages(ctx, secretBundles)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err = server.createResponse(ctx, secretBundles, templates, int32(filePermission))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info().
+		Int("secrets", len(secretBundles)).
+		Str("vault", vaultID.Digest()).
+		Str("principalType", string(auth.Type)).
+		Dur("duration", time.Since(start)).
+		Dict("versions", resolvedVersions(secretBundles)).
+		Msg("Mounted secrets")
+	return response, nil
 }
 
-func (server *ProviderServer) retrieveAuthConfig(ctx context.Context,
-	requestAttributes map[string]string, namespace string) (*types.Auth, error) {
+// checkVault returns the vault of SecretProviderClass, failing on malformed OCID or the vault not allowed
+func (server *ProviderServer) checkVault(ctx context.Context,
+	attributes map[string]string) (types.VaultID, error) {
+	vaultID := types.VaultID(attributes[vaultIDField])
+	if err := vaultID.Validate(); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %v of SecretProviderClass: %v", vaultIDField, err)
+	}
+	if server.config.AllowedVaults != nil && !server.config.AllowedVaults.IsAllowed(vaultID) {
+		logging.FromContext(ctx).Info().Str("vault", vaultID.Digest()).Msg("Vault is not allowed")
+		return "", status.Errorf(codes.PermissionDenied, "vault is not allowed: %v", vaultID.Digest())
+	}
+	return vaultID, nil
+}
+
+// retrievalErrorCode maps the category of retrieval failure to gRPC code, so the driver and the pod events
+// tell a missing secret from denied access or throttling. Uncategorized failures are internal errors.
+func retrievalErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, service.ErrSecretNotFound):
+		return codes.NotFound
+	case errors.Is(err, service.ErrNotAuthorized):
+		return codes.PermissionDenied
+	case errors.Is(err, service.ErrThrottled):
+		return codes.ResourceExhausted
+	case errors.Is(err, service.ErrVaultUnavailable):
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+func podFromAttributes(attributes map[string]string) mountedPod {
+	return mountedPod{
+		name:      attributes[podNameField],
+		namespace: attributes[podNamespaceField],
+		uid:       apiMachineryTypes.UID(attributes[podUIDField]),
+	}
+}
+
+// checkEmptyStages warns about or refuses secret bundles returned without stages,
+// since every secret version is expected to be at least in one stage, so it may indicate an anomaly.
+func (server *ProviderServer) checkEmptyStages(ctx context.Context, secretBundles []*types.SecretBundle) error {
+	for _, bundle := range secretBundles {
+		if len(bundle.Stages) > 0 {
+			continue
+		}
+		metrics.NewStatsReporter().ReportEmptyStages(ctx)
+		if server.config.RefuseEmptyStages {
+			logging.FromContext(ctx).Info().Str("secret", bundle.Name).Msg("Refused to mount secret without stages")
+			return status.Errorf(codes.FailedPrecondition, "secret %v has no stages", bundle.Name)
+		}
+		logging.FromContext(ctx).Warn().Str("secret", bundle.Name).Msg("Mounted secret has no stages")
+	}
+	return nil
+}
+
+// checkPendingDeletion warns about or refuses secrets scheduled for deletion,
+// since such a secret disappears from the vault unexpectedly for the workload.
+func (server *ProviderServer) checkPendingDeletion(ctx context.Context, secretBundles []*types.SecretBundle) error {
+	for _, bundle := range secretBundles {
+		if bundle.TimeOfDeletion == nil {
+			continue
+		}
+		if server.config.PendingDeletion == PendingDeletionRefuse {
+			logging.FromContext(ctx).Info().
+				Str("secret", bundle.Name).
+				Time("deletion", *bundle.TimeOfDeletion).Msg("Refused to mount secret scheduled for deletion")
+			return status.Errorf(codes.FailedPrecondition, "secret %v is scheduled for deletion at %v",
+				bundle.Name, bundle.TimeOfDeletion.Format(time.RFC3339))
+		}
+		logging.FromContext(ctx).Warn().
+			Str("secret", bundle.Name).
+			Time("deletion", *bundle.TimeOfDeletion).Msg("Mounted secret is scheduled for deletion")
+	}
+	return nil
+}
+
+// checkMinVersions refuses the secrets resolved to a version older than the minimum, e.g. when a stage
+// is moved back to an old version by mistake, so the workload doesn't silently roll back.
+func checkMinVersions(ctx context.Context, secretBundles []*types.SecretBundle) error {
+	for _, bundle := range secretBundles {
+		if bundle.VersionNumber >= bundle.MinVersionNumber {
+			continue
+		}
+		logging.FromContext(ctx).Info().
+			Str("secret", bundle.Name).
+			Int64("version", bundle.VersionNumber).
+			Int64("minVersion", bundle.MinVersionNumber).Msg("Refused to mount secret version below minimum")
+		return status.Errorf(codes.FailedPrecondition, "secret %v version %v is below the minimum version number %v",
+			bundle.Name, bundle.VersionNumber, bundle.MinVersionNumber)
+	}
+	return nil
+}
+
+// parseFilePermission parses the mode of the mounted files, which the driver sends as JSON number, e.g. "420".
+func parseFilePermission(permission string) (os.FileMode, error) {
+	if permission == "" {
+		return 0, status.Error(codes.InvalidArgument, "file permission is missing in the mount request")
+	}
+	var filePermission os.FileMode
+	if err := json.Unmarshal([]byte(permission), &filePermission); err != nil {
+		return 0, status.Errorf(codes.InvalidArgument,
+			"file permission %q of the mount request should be a non-negative decimal number: %v", permission, err)
+	}
+	return filePermission, nil
+}
+
+// checkFileMode rejects or tightens the requested file mode looser than the configured maximum,
+// so secrets are never mounted e.g. world-readable on clusters mandating it.
+func (server *ProviderServer) checkFileMode(mode os.FileMode) (os.FileMode, error) {
+	maxMode := server.config.MaxFileMode
+	if maxMode == 0 || mode&^maxMode == 0 {
+		return mode, nil
+	}
+	if server.config.FileMode == FileModeTighten {
+		log.Info().Str("requested", fmt.Sprintf("%#o", mode)).Str("mounted", fmt.Sprintf("%#o", mode&maxMode)).
+			Msg("Tightened file mode of mounted secrets")
+		return mode & maxMode, nil
+	}
+	return 0, status.Errorf(codes.InvalidArgument, "file mode %#o is looser than the allowed maximum %#o",
+		uint32(mode), uint32(maxMode))
+}
+
+// reportOCICalls exposes the number of OCI API calls made by the mount in the log and response metadata.
+func reportOCICalls(ctx context.Context, callCounter *service.CallCounter) {
+	logger := logging.FromContext(ctx)
+	logger.Debug().Int("ociCalls", callCounter.Calls()).Msg("OCI API calls made by the mount")
+	err := grpc.SetHeader(ctx, metadata.Pairs(ociCallsHeader, strconv.Itoa(callCounter.Calls())))
+	if err != nil {
+		logger.Debug().Err(err).Msg("Unable to set OCI calls response header")
+	}
+}
+
+// resolvedVersions maps mounted secret names to their version numbers.
+func resolvedVersions(secretBundles []*types.SecretBundle) *zerolog.Event {
+	versions := zerolog.Dict()
+	for _, bundle := range secretBundles {
+		versions.Int64(bundle.Name, bundle.VersionNumber)
+	}
+	return versions
+}
+
+func (server *ProviderServer) isPrincipalAllowed(principalType types.OCIPrincipalType) bool {
+	if len(server.config.AllowedPrincipals) == 0 {
+		return true
+	}
+	for _, allowedType := range server.config.AllowedPrincipals {
+		if allowedType == principalType {
+			return true
+		}
+	}
+	return false
+}
+
+// retrievePrincipalType returns the principal type of SecretProviderClass, failing if it's not allowed
+func (server *ProviderServer) retrievePrincipalType(
+	requestAttributes map[string]string) (types.OCIPrincipalType, error) {
 	authType, ok := requestAttributes[authTypeField]
 	if !ok {
 		log.Info().Str("attribute", authTypeField).Msg("Missed attribute")
-		return nil, fmt.Errorf("missed \"%v\" SecretProviderClass parameters", authTypeField)
+		return "", fmt.Errorf("missed \"%v\" SecretProviderClass parameters", authTypeField)
 	}
 	principalType, err := types.MapToPrincipalType(authType)
 	if err != nil {
-		return nil, fmt.Errorf("invalid auth principal type, %v", authType)
+		return "", fmt.Errorf("invalid auth principal type, %v", authType)
 	}
+	if !server.isPrincipalAllowed(principalType) {
+		log.Info().Str("principalType", authType).Msg("Principal type is not allowed")
+		return "", status.Errorf(codes.PermissionDenied, "principal type is not allowed: %v", principalType)
+	}
+	return principalType, nil
+}
+
+// retrieveAuthConfigSecretNamespace returns the namespace of user principal auth config secret, the pod namespace
+// unless SecretProviderClass overrides it with one of the namespaces allowed by the provider. The provider could
+// read secrets of any namespace, so the override is restricted, otherwise any pod could use others' credentials.
+func (server *ProviderServer) retrieveAuthConfigSecretNamespace(
+	requestAttributes map[string]string, podNamespace string) (string, error) {
+	namespace, ok := requestAttributes[authConfigSecretNamespaceField]
+	if !ok || namespace == podNamespace {
+		return podNamespace, nil
+	}
+	for _, allowedNamespace := range server.config.AuthSecretNamespaces {
+		if allowedNamespace == namespace {
+			return namespace, nil
+		}
+	}
+	log.Info().Str("namespace", namespace).Msg("Auth config secret namespace is not allowed")
+	return "", status.Errorf(codes.PermissionDenied, "auth config secret namespace is not allowed: %v", namespace)
+}
+
+// retrieveAuthConfigSecretName returns the name of Kubernetes secret holding user principal auth config
+func retrieveAuthConfigSecretName(requestAttributes map[string]string) (string, error) {
+	authConfigSecretName, ok := requestAttributes[authConfigSecretNameField]
+	if !ok {
+		log.Info().Str("attribute", authConfigSecretNameField).Msg("Missed attribute")
+		return "", fmt.Errorf("missed \"%v\" SecretProviderClass parameters", authConfigSecretNameField)
+	}
+	return authConfigSecretName, nil
+}
+
+func (server *ProviderServer) retrieveAuthConfig(ctx context.Context,
+	requestAttributes map[string]string, namespace string) (*types.Auth, error) {
+	principalType, err := server.retrievePrincipalType(requestAttributes)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
 
 	var auth *types.Auth = &types.Auth{
 		Type: principalType,
 	}
 
+	if principalType == types.Instance {
+		auth.Region, err = server.retrieveInstancePrincipalRegion(requestAttributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if principalType == types.User {
-		authConfigSecretName, ok := requestAttributes[authConfigSecretNameField]
-		if !ok {
-			log.Info().Str("attribute", authConfigSecretNameField).Msg("Missed attribute")
-			return nil, fmt.Errorf("missed \"%v\" SecretProviderClass parameters", authConfigSecretNameField)
+		authConfigSecretName, err := retrieveAuthConfigSecretName(requestAttributes)
+		if err != nil {
+			return nil, err
+		}
+		authConfigNamespace, err := server.retrieveAuthConfigSecretNamespace(requestAttributes, namespace)
+		if err != nil {
+			return nil, err
 		}
 		// read it from k8s api
-		secret, err := server.readK8sSecret(ctx, namespace, authConfigSecretName)
+		secret, err := server.readK8sSecret(ctx, authConfigNamespace, authConfigSecretName)
 		if err != nil {
-			log.Err(err).Str("secretName", authConfigSecretName).Msg("Error while reading secret from k8s api")
+			logger.Err(err).Str("secretName", authConfigSecretName).Str("namespace", authConfigNamespace).
+				Msg("Error while reading secret from k8s api")
 			return nil, fmt.Errorf("error retrieving secret: %v", authConfigSecretName)
 		}
 
-		log.Info().Str("secret is retrieved from kubernets api:", authConfigSecretName)
+		logger.Debug().Str("secretName", authConfigSecretName).Msg("Secret is retrieved from kubernetes api")
 
 		if len(secret.Data) == 0 || len(secret.Data["config"]) == 0 {
-			log.Err(err).Str("secretName", authConfigSecretName).Msg("Empty Configuration is found in the secret")
+			logger.Err(err).Str("secretName", authConfigSecretName).Msg("Empty Configuration is found in the secret")
 			return nil, fmt.Errorf("auth config data is empty: %v", authConfigSecretName)
 		}
-		authCfg, err := parseAuthConfig(secret, authConfigSecretName)
+		authCfg, err := parseAuthConfig(ctx, secret, authConfigSecretName, server.privateKeyDir(authConfigNamespace))
 		if err != nil {
-			log.Err(err).Str("secretName", authConfigSecretName).Msg("Missing auth config data")
+			logger.Err(err).Str("secretName", authConfigSecretName).Msg("Missing auth config data")
 			return nil, fmt.Errorf("missing auth config data: %v", err)
 		}
 
 		err = authCfg.Validate()
 		if err != nil {
-			log.Err(err).Str("secretName", authConfigSecretName).Msg("Missing auth config data")
+			logger.Err(err).Str("secretName", authConfigSecretName).Msg("Missing auth config data")
 			return nil, fmt.Errorf("missing auth config data: %v", err)
 		}
+		authCfg.Region, err = types.NormalizeRegion(authCfg.Region)
+		if err != nil {
+			logger.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid auth config region")
+			return nil, fmt.Errorf("invalid auth config region: %v", err)
+		}
 		auth.Config = *authCfg
+	} else if principalType == types.File {
+		configFile, err := server.retrieveConfigFile(requestAttributes)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkConfigFile(configFile, server.config.ConfigFileDir); err != nil {
+			return nil, err
+		}
+		auth.ConfigFile = configFile
 	} else if principalType == types.Workload {
+		region, err := retrieveRegion(requestAttributes)
+		if err != nil {
+			return nil, err
+		}
 
-		podInfo := &types.PodInfo{
-			Name:               requestAttributes[podNameField],
-			UID:                apiMachineryTypes.UID(requestAttributes[podUIDField]),
-			ServiceAccountName: requestAttributes[podServiceAccountField],
-			Namespace:          requestAttributes[podNamespaceField],
+		podInfo, err := retrieveWorkloadPodInfo(requestAttributes)
+		if err != nil {
+			return nil, err
 		}
-		saTokenStr, err := server.getSAToken(podInfo)
+		saTokenStr, err := server.getSAToken(ctx, podInfo)
 		if err != nil {
 			err := fmt.Errorf("can not generate token for service account: %s, namespace: %s, Error: %v",
 				podInfo.ServiceAccountName, podInfo.Namespace, err)
@@ -187,25 +656,105 @@ func (server *ProviderServer) retrieveAuthConfig(ctx context.Context,
 		}
 
 		auth.WorkloadIdentityCfg = types.WorkloadIdentityConfig{
-			SaToken: []byte(saTokenStr),
-			// Region: region,
+			SaToken:        []byte(saTokenStr),
+			ServiceAccount: podInfo.Namespace + "/" + podInfo.ServiceAccountName,
+			Region:         region,
 		}
 	}
 	return auth, nil
 }
 
-func parseAuthConfig(secret *core.Secret, authConfigSecretName string) (*types.AuthConfig, error) {
+// workloadPodFields are the pod attributes the service account token of workload identity is bound to,
+// the driver provides them only if podInfoOnMount of its CSIDriver object is enabled
+var workloadPodFields = []string{podNamespaceField, podServiceAccountField, podNameField, podUIDField}
+
+// retrieveWorkloadPodInfo returns the pod the service account token is requested for,
+// failing with the missing attributes instead of requesting the token for an empty service account.
+func retrieveWorkloadPodInfo(requestAttributes map[string]string) (*types.PodInfo, error) {
+	var missingFields []string
+	for _, field := range workloadPodFields {
+		if requestAttributes[field] == "" {
+			missingFields = append(missingFields, field)
+		}
+	}
+	if len(missingFields) > 0 {
+		log.Info().Strs("attributes", missingFields).Msg("Missed pod attributes of workload identity")
+		return nil, status.Errorf(codes.InvalidArgument,
+			"workload identity requires pod attributes provided by the driver, missing: %v",
+			strings.Join(missingFields, ", "))
+	}
+	return &types.PodInfo{
+		Name:               requestAttributes[podNameField],
+		UID:                apiMachineryTypes.UID(requestAttributes[podUIDField]),
+		ServiceAccountName: requestAttributes[podServiceAccountField],
+		Namespace:          requestAttributes[podNamespaceField],
+	}, nil
+}
+
+// retrieveInstancePrincipalRegion returns the region explicitly configured for instance principal, if any.
+func (server *ProviderServer) retrieveInstancePrincipalRegion(requestAttributes map[string]string) (string, error) {
+	if requestAttributes[regionField] == "" && server.config.InstancePrincipalRegion != "" {
+		return normalizeRegionParameter(server.config.InstancePrincipalRegion)
+	}
+	return retrieveRegion(requestAttributes)
+}
+
+// retrieveRegion returns the region specified by SecretProviderClass, if any.
+func retrieveRegion(requestAttributes map[string]string) (string, error) {
+	region := requestAttributes[regionField]
+	if region == "" {
+		return "", nil
+	}
+	return normalizeRegionParameter(region)
+}
+
+func normalizeRegionParameter(region string) (string, error) {
+	normalizedRegion, err := types.NormalizeRegion(region)
+	if err != nil {
+		return "", fmt.Errorf("invalid \"%v\" SecretProviderClass parameter: %v", regionField, err)
+	}
+	return normalizedRegion, nil
+}
+
+// privateKeyDir returns the directory private key files of auth config secrets of the namespace are read from,
+// so a namespace can't reference the keys of others, it's empty unless the provider enables the key files
+func (server *ProviderServer) privateKeyDir(namespace string) string {
+	if server.config.PrivateKeyDir == "" {
+		return ""
+	}
+	return filepath.Join(server.config.PrivateKeyDir, namespace)
+}
+
+// parseAuthConfig returns user principal auth config of the secret, the private key file referenced by the config
+// should be within privateKeyDir
+func parseAuthConfig(ctx context.Context, secret *core.Secret,
+	authConfigSecretName string, privateKeyDir string) (*types.AuthConfig, error) {
+	logger := logging.FromContext(ctx)
 	authYaml := &types.AuthConfigYaml{}
 	err := yaml.Unmarshal(secret.Data["config"], &authYaml)
 	if err != nil {
-		log.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid auth config data")
+		logger.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid auth config data")
 		return nil, fmt.Errorf("invalid auth config data: %v", authConfigSecretName)
 	}
 
-	if len(secret.Data["private-key"]) > 0 {
+	privateKeyPath := authYaml.Auth[privateKeyPathField]
+	delete(authYaml.Auth, privateKeyPathField)
+	switch {
+	case len(secret.Data["private-key"]) > 0 && privateKeyPath != "":
+		logger.Error().Str("secretName", authConfigSecretName).Msg("Both private key and private key path are set")
+		return nil, fmt.Errorf("invalid user auth config data: %v, either private-key or %v should be set, not both",
+			authConfigSecretName, privateKeyPathField)
+	case len(secret.Data["private-key"]) > 0:
 		authYaml.Auth["privateKey"] = string(secret.Data["private-key"])
-	} else {
-		log.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid user auth private key")
+	case privateKeyPath != "":
+		privateKey, err := readPrivateKeyFile(privateKeyPath, privateKeyDir)
+		if err != nil {
+			logger.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid user auth private key file")
+			return nil, fmt.Errorf("invalid user auth config data: %v, %v", authConfigSecretName, err)
+		}
+		authYaml.Auth["privateKey"] = privateKey
+	default:
+		logger.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid user auth private key")
 		return nil, fmt.Errorf("invalid user auth config data: %v", authConfigSecretName)
 	}
 
@@ -213,35 +762,55 @@ func parseAuthConfig(secret *core.Secret, authConfigSecretName string) (*types.A
 	authCfg := &types.AuthConfig{}
 	err = yaml.Unmarshal(authCfgYaml, &authCfg)
 	if err != nil {
-		log.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid auth config data")
+		logger.Err(err).Str("secretName", authConfigSecretName).Msg("Invalid auth config data")
 		return nil, fmt.Errorf("invalid auth config data: %v", authConfigSecretName)
 	}
 	return authCfg, nil
 }
 
-func (server *ProviderServer) getK8sClientSet() (*kubernetes.Clientset, error) {
-	clusterCfg, err := rest.InClusterConfig()
+// readPrivateKeyFile reads private key of user principal from the provider's filesystem, e.g. mounted secret volume.
+// The key is a credential, so the file should be a regular file within the directory not accessible by others.
+func readPrivateKeyFile(path string, dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("%v is not allowed, private key directory is not configured", privateKeyPathField)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("private key path %q should be absolute", path)
+	}
+	file, fileInfo, err := openFileInDir(path, dir)
+	if errors.Is(err, errOutsideDir) {
+		return "", fmt.Errorf("private key path %q is not within %q", path, dir)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("can not get cluster config. error: %v", err)
+		return "", fmt.Errorf("unable to open private key file: %w", err)
 	}
-
-	clientset, err := kubernetes.NewForConfig(clusterCfg)
+	defer file.Close()
+	if fileInfo.Mode().Perm()&0007 != 0 {
+		return "", fmt.Errorf("private key file %q should not be accessible by others, mode %v",
+			path, fileInfo.Mode().Perm())
+	}
+	content, err := io.ReadAll(io.LimitReader(file, maxPrivateKeyFileSize+1))
 	if err != nil {
-		return nil, fmt.Errorf("can not initialize kubernetes client. error: %v", err)
+		return "", fmt.Errorf("unable to read private key file: %w", err)
 	}
-
-	return clientset, nil
+	if len(content) > maxPrivateKeyFileSize {
+		return "", fmt.Errorf("private key file %q exceeds %v bytes", path, maxPrivateKeyFileSize)
+	}
+	if len(content) == 0 {
+		return "", fmt.Errorf("private key file %q is empty", path)
+	}
+	return string(content), nil
 }
 
-func (server *ProviderServer) getSAToken(podInfo *types.PodInfo) (string, error) {
-	clientSet, err := server.getK8sClientSet()
+func (server *ProviderServer) getSAToken(ctx context.Context, podInfo *types.PodInfo) (string, error) {
+	clientSet, err := server.k8sClients.get()
 	if err != nil {
 		return "", fmt.Errorf("unable to get k8s client: %v", err)
 	}
-	ttl := int64((15 * time.Minute).Seconds())
+	ttl := int64(server.saTokenTTL().Seconds())
 	resp, err := clientSet.CoreV1().
 		ServiceAccounts(podInfo.Namespace).
-		CreateToken(context.Background(), podInfo.ServiceAccountName,
+		CreateToken(ctx, podInfo.ServiceAccountName,
 			&authenticationv1.TokenRequest{
 				Spec: authenticationv1.TokenRequestSpec{
 					ExpirationSeconds: &ttl,
@@ -259,19 +828,26 @@ func (server *ProviderServer) getSAToken(podInfo *types.PodInfo) (string, error)
 	if err != nil {
 		return "", fmt.Errorf("unable to fetch token from token api: %v", err)
 	}
+	if resp.Spec.ExpirationSeconds != nil && *resp.Spec.ExpirationSeconds < ttl {
+		logging.FromContext(ctx).Warn().Int64("requestedSeconds", ttl).Int64("grantedSeconds", *resp.Spec.ExpirationSeconds).
+			Str("serviceAccount", podInfo.ServiceAccountName).
+			Msg(saTokenClampedWarning)
+	}
 	return resp.Status.Token, nil
 }
 
-func (server *ProviderServer) readK8sSecret(ctx context.Context, namespace string,
-	secretName string) (*core.Secret, error) {
-	clusterCfg, err := rest.InClusterConfig()
-	if err != nil {
-		return &core.Secret{}, fmt.Errorf("can not get cluster config. error: %v", err)
+func (server *ProviderServer) saTokenTTL() time.Duration {
+	if server.config.SATokenTTL == 0 {
+		return defaultSATokenTTL
 	}
+	return server.config.SATokenTTL
+}
 
-	clientset, err := kubernetes.NewForConfig(clusterCfg)
+func (server *ProviderServer) readK8sSecret(ctx context.Context, namespace string,
+	secretName string) (*core.Secret, error) {
+	clientset, err := server.k8sClients.get()
 	if err != nil {
-		return &core.Secret{}, fmt.Errorf("can not initialize kubernetes client. error: %v", err)
+		return &core.Secret{}, err
 	}
 
 	k8client := clientset.CoreV1()
@@ -301,51 +877,171 @@ func (server *ProviderServer) retrieveSecretRequests(
 	}
 
 	// Secrets attribute is plain YAML value from SecretProviderClass provided as a plain string
+	secretBundleRequests, err := server.decodeSecretRequests(secretsYaml)
+	if err != nil {
+		log.Info().Err(err).Msg("Failed to unmarshal secrets")
+		if isYamlMap(secretsYaml) {
+			return nil, fmt.Errorf("SecretProviderClass parameter \"%v\" should be a list of secrets, "+
+				"each item starting with \"- name:\", but got a map", secretsField)
+		}
+		return nil, fmt.Errorf("failed to unmarshal SecretProviderClass parameter \"%v\"", secretsField)
+	}
+	for _, request := range secretBundleRequests {
+		if err := request.Validate(); err != nil {
+			return nil, err
+		}
+		if server.config.SecretNames != nil && !server.config.SecretNames.IsAllowed(request.Name) {
+			log.Info().Str("secret", request.Name).Msg("Secret name is not allowed")
+			return nil, status.Errorf(codes.PermissionDenied, "secret name is not allowed: %v", request.Name)
+		}
+		namespacePolicy := server.config.NamespaceSecretNames
+		namespace := requestAttributes[podNamespaceField]
+		if namespacePolicy != nil && !namespacePolicy.IsAllowed(namespace, request.Name) {
+			log.Info().Str("secret", request.Name).Str("namespace", namespace).Msg("Secret name is not allowed in namespace")
+			return nil, status.Errorf(codes.PermissionDenied,
+				"secret name is not allowed in namespace %v: %v", namespace, request.Name)
+		}
+	}
+	if err := server.checkFileNameLength(secretBundleRequests); err != nil {
+		return nil, err
+	}
+	return secretBundleRequests, nil
+}
+
+// decodeSecretRequests fails on unknown fields of secrets, unless they are allowed.
+// Allowed unknown fields are only logged, so newer SecretProviderClass schema could be used with older provider.
+func (server *ProviderServer) decodeSecretRequests(secretsYaml string) ([]*types.SecretBundleRequest, error) {
 	var secretBundleRequests []*types.SecretBundleRequest
 	decoder := yaml.NewDecoder(bytes.NewReader([]byte(secretsYaml)))
 	decoder.KnownFields(true) // fail on unknown fields
-	if err := decoder.Decode(&secretBundleRequests); err != nil {
-		log.Info().Err(err).Msg("Failed to unmarshal secrets")
-		return nil, fmt.Errorf("failed to unmarshal SecretProviderClass parameter \"%v\"", secretsField)
+	strictErr := decoder.Decode(&secretBundleRequests)
+	if strictErr == nil || !server.config.AllowUnknownSecretFields {
+		return secretBundleRequests, strictErr
+	}
+
+	secretBundleRequests = nil
+	if err := yaml.Unmarshal([]byte(secretsYaml), &secretBundleRequests); err != nil {
+		return nil, err
 	}
+	log.Warn().Err(strictErr).Msg("Ignored unknown fields of SecretProviderClass secrets")
 	return secretBundleRequests, nil
 }
 
-func (server *ProviderServer) createResponse(secretBundles []*types.SecretBundle,
-	filePermission int32) (*provider.MountResponse, error) {
-	files := make([]*provider.File, len(secretBundles))
-	versions := make([]*provider.ObjectVersion, len(secretBundles))
+// isYamlMap reports whether the YAML document is a map, which is a common mistake in place of a list.
+func isYamlMap(yamlContent string) bool {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(yamlContent), &document); err != nil {
+		return false
+	}
+	return len(document.Content) == 1 && document.Content[0].Kind == yaml.MappingNode
+}
 
-	for i, bundle := range secretBundles {
-		file, objectVersion, err := server.mapBundleToSecretResponse(bundle, filePermission)
-		if err != nil {
-			return nil, err
+// checkFileNameLength verifies that every element of the requested file paths fits the filesystem limit,
+// so the mount fails before the secrets are retrieved. The final names are checked by checkResponseFileNames.
+func (server *ProviderServer) checkFileNameLength(requests []*types.SecretBundleRequest) error {
+	maxLength := server.maxFileNameLength()
+	for _, request := range requests {
+		if length := longestFileName(request.GetFilePath()); length > maxLength {
+			return fmt.Errorf("file name of secret %v is %v bytes long, exceeding the maximum of %v",
+				request.Name, length, maxLength)
 		}
-		files[i] = file
-		versions[i] = objectVersion
 	}
+	return nil
+}
 
-	return &provider.MountResponse{
-		Files:         files,
-		ObjectVersion: versions,
-	}, nil
+// checkResponseFileNames verifies that every element of the mounted file paths fits the filesystem limit,
+// including the names known only once the secrets are retrieved, e.g. derived from secret metadata or chunk parts
+// with ".part-NNN" and ".manifest" suffixes, so the driver doesn't fail to write the secret.
+func (server *ProviderServer) checkResponseFileNames(files []*provider.File) error {
+	maxLength := server.maxFileNameLength()
+	for _, file := range files {
+		if length := longestFileName(file.Path); length > maxLength {
+			return status.Errorf(codes.InvalidArgument,
+				"file name of mounted file %v is %v bytes long, exceeding the maximum of %v", file.Path, length, maxLength)
+		}
+	}
+	return nil
+}
+
+func (server *ProviderServer) maxFileNameLength() int {
+	if server.config.MaxFileNameLength <= 0 {
+		return defaultMaxFileNameLength
+	}
+	return server.config.MaxFileNameLength
+}
+
+// longestFileName returns the length in bytes of the longest element of the slash-separated path
+func longestFileName(path string) int {
+	longest := 0
+	for _, fileName := range strings.Split(path, "/") {
+		if len(fileName) > longest {
+			longest = len(fileName)
+		}
+	}
+	return longest
 }
 
-func (server *ProviderServer) mapBundleToSecretResponse(
-	bundle *types.SecretBundle, filePermission int32) (*provider.File, *provider.ObjectVersion, error) {
-	secretContent, err := bundle.BundleContent.Decode()
+// createResponse maps all the bundles to files or fails as a whole, so the response never carries
+// a subset of the retrieved secrets or a partially decoded secret. Only the optional secrets which are not found
+// are missing from the response, they are skipped before.
+// The provider API has no atomic-write hint: the driver writes the files of a single response atomically itself.
+// The response is unary, so it can't be streamed; the content of the bundles is released while mapping instead.
+// Template files and the fetch outcomes file follow the secret files, since they have no object versions.
+func (server *ProviderServer) createResponse(ctx context.Context, secretBundles []*types.SecretBundle,
+	templates []*secretTemplate, filePermission int32) (*provider.MountResponse, error) {
+	options := response.Options{
+		FilePermission:           filePermission,
+		CheckFileMode:            server.checkFileMode,
+		ChunkSize:                server.config.ChunkSize,
+		ContentHashObjectVersion: server.config.ContentHashObjectVersion,
+		StagesObjectVersion:      server.config.StagesObjectVersion,
+		FetchOutcomesFile:        server.config.FetchOutcomesFile,
+	}
+	if server.config.DetectDoubleEncoding {
+		options.Transforms = append(options.Transforms, server.warnIfDoubleEncoded)
+	}
+	if server.config.ReportSecretExpiry {
+		options.Transforms = append(options.Transforms, reportExpiry)
+	}
+	if len(templates) > 0 {
+		options.Generators = append(options.Generators, templatesGenerator(templates))
+	}
+	mountResponse, err := response.Build(ctx, secretBundles, options)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if err := server.checkResponseFileNames(mountResponse.Files); err != nil {
+		return nil, err
 	}
+	return mountResponse, nil
+}
 
-	file := &provider.File{
-		Path:     bundle.GetFilePath(),
-		Contents: []byte(secretContent),
-		Mode:     filePermission,
+// reportExpiry exposes expiry time of the secret, so monitoring could alert before the secret expires.
+func reportExpiry(ctx context.Context, bundle *types.SecretBundle, content []byte) ([]byte, error) {
+	if bundle.TimeOfExpiry == nil {
+		return content, nil
 	}
-	objectVersion := &provider.ObjectVersion{
-		Id:      bundle.ID,
-		Version: strconv.FormatInt(bundle.VersionNumber, 10),
+	logging.FromContext(ctx).Info().
+		Str("secret", bundle.Name).
+		Int64("version", bundle.VersionNumber).
+		Time("expiry", *bundle.TimeOfExpiry).
+		Msg("Mounted secret has expiry time")
+	metrics.NewStatsReporter().ReportSecretExpiry(bundle.ID, bundle.Name, *bundle.TimeOfExpiry)
+	return content, nil
+}
+
+// warnIfDoubleEncoded reports the secret if its decoded content is still base64 of text.
+// The content is mounted as is, since it could be base64 by design.
+func (server *ProviderServer) warnIfDoubleEncoded(ctx context.Context,
+	bundle *types.SecretBundle, content []byte) ([]byte, error) {
+	// base64-encoded content is mounted on purpose, so it's not a sign of double encoding
+	if bundle.Encoding == types.Base64Encoding || !types.LooksLikeBase64Text(string(content)) {
+		return content, nil
 	}
-	return file, objectVersion, nil
+	logging.FromContext(ctx).Warn().
+		Str("secret", bundle.Name).
+		Int64("version", bundle.VersionNumber).
+		Msg("Secret content looks base64-encoded twice, check the value stored in the vault")
+	metrics.NewStatsReporter().ReportDoubleEncodedSecret(ctx)
+	return content, nil
 }
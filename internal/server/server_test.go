@@ -7,16 +7,30 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/policy"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/response"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/service"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	core "k8s.io/api/core/v1"
 	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -25,6 +39,8 @@ func TestMain(m *testing.M) {
 }
 
 const readOnlyFilePermission = "292" // Octal 0444 in decimal
+
+const testVaultID = "ocid1.vault.oc1.iad.bbpkgnvqaaeuk.abuwcljsexample"
 const readOnlyPermission = 0444
 
 // Note that real-life Secrets Store CSI Driver sends more detailed and complicated MountRequest
@@ -52,10 +68,10 @@ func TestMount_RequestTwoExistingSecrets_ReturnTwoSecrets(t *testing.T) {
 		requestsMock: secretBundleRequests,
 		bundlesMock:  mockBundles,
 	}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	var auth *types.Auth = &types.Auth{Type: types.Instance}
-	var vaultID = "vault1"
+	var vaultID = testVaultID
 	attributes, err := marshalRequestAttributes(secretBundleRequests, auth, vaultID)
 	if err != nil {
 		t.Fatalf("Precondition failed: unable to serialize request attributes")
@@ -92,10 +108,10 @@ func TestMount_RequestOneExistingSecretAndOneAbsent_ReturnError(t *testing.T) {
 	}
 
 	var mockService service.SecretService = &mockSecretService{}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	var auth *types.Auth = &types.Auth{Type: types.Instance}
-	var vaultID = "vault1"
+	var vaultID = testVaultID
 	attributes, err := marshalRequestAttributes(secretBundleRequests, auth, vaultID)
 	if err != nil {
 		t.Fatalf("Precondition failed: unable to serialize request attributes")
@@ -118,9 +134,41 @@ func TestMount_RequestOneExistingSecretAndOneAbsent_ReturnError(t *testing.T) {
 	}
 }
 
+func TestMount_RetrievalFailed_ReturnCodeOfFailureCategory(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("unable to retrieve secret from vault: %w", service.ErrSecretNotFound), codes.NotFound},
+		{fmt.Errorf("unable to retrieve secret from vault: %w", service.ErrNotAuthorized), codes.PermissionDenied},
+		{fmt.Errorf("unable to retrieve secret from vault: %w", service.ErrThrottled), codes.ResourceExhausted},
+		{fmt.Errorf("unable to retrieve secret from vault: %w", service.ErrVaultUnavailable), codes.Unavailable},
+		{errors.New("unable to retrieve secret from vault: connection refused"), codes.Internal},
+	}
+	for _, testCase := range testCases {
+		providerServer := &ProviderServer{secretService: &mockSecretService{errMock: testCase.err}}
+		attributes, err := marshalRequestAttributes([]*types.SecretBundleRequest{{Name: "foo"}},
+			&types.Auth{Type: types.Instance}, testVaultID)
+		if err != nil {
+			t.Fatalf("Precondition failed: unable to serialize request attributes")
+		}
+
+		_, err = providerServer.Mount(context.Background(), &provider.MountRequest{
+			Attributes: attributes, TargetPath: "/some/path", Permission: readOnlyFilePermission,
+		})
+
+		if status.Code(err) != testCase.code {
+			t.Errorf("Invalid gRPC code of %v: %v", testCase.err, status.Code(err))
+		}
+		if !strings.Contains(status.Convert(err).Message(), testCase.err.Error()) {
+			t.Errorf("Error should contain the cause: %v", err)
+		}
+	}
+}
+
 func TestMount_InvalidFormatAttributes_ReturnError(t *testing.T) {
 	var mockService service.SecretService = &mockSecretService{}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	request := provider.MountRequest{
 		Attributes: "invalid-value",
@@ -143,7 +191,7 @@ func TestMount_InvalidFormatAttributes_ReturnError(t *testing.T) {
 
 func TestMount_InvalidSecretsAttribute_ReturnError(t *testing.T) {
 	var mockService service.SecretService = &mockSecretService{}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	invalidMountRequests, err := prepareInvalidMountRequests()
 	if err != nil {
@@ -187,10 +235,10 @@ func TestMount_RequestTwoSecretsWithAliasForOnlyOne_ReturnTwoSecretsWithOnePathO
 		requestsMock: secretBundleRequests,
 		bundlesMock:  mockBundles,
 	}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	var auth *types.Auth = &types.Auth{Type: types.Instance}
-	var vaultID = "vault1"
+	var vaultID = testVaultID
 	attributes, err := marshalRequestAttributes(secretBundleRequests, auth, vaultID)
 	if err != nil {
 		t.Fatalf("Precondition failed: unable to serialize request attributes")
@@ -242,10 +290,10 @@ func TestMount_RequestTwoSecretsWithAliases_ReturnTwoSecretsWithAliases(t *testi
 		requestsMock: secretBundleRequests,
 		bundlesMock:  mockBundles,
 	}
-	providerServer := &ProviderServer{mockService}
+	providerServer := &ProviderServer{secretService: mockService}
 
 	var auth *types.Auth = &types.Auth{Type: types.Instance}
-	var vaultID = "vault1"
+	var vaultID = testVaultID
 	attributes, err := marshalRequestAttributes(secretBundleRequests, auth, vaultID)
 	if err != nil {
 		t.Fatalf("Precondition failed: unable to serialize request attributes")
@@ -275,6 +323,70 @@ func TestMount_RequestTwoSecretsWithAliases_ReturnTwoSecretsWithAliases(t *testi
 	assertMountResponse(t, mountResponse, expectedMountResponse)
 }
 
+func TestMount_SuccessfulMount_LogSummary(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionNumber: 2},
+		{Name: "hello", VersionNumber: 1},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 2,
+			Stages:        []types.Stage{types.Current, types.Latest},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		},
+		{
+			ID: "uid2", Name: "hello", VersionNumber: 1,
+			Stages:        []types.Stage{types.Current, types.Latest},
+			BundleContent: &types.SecretBundleContent{Content: "d29ybGQ=", ContentType: types.Base64},
+		},
+	}
+
+	var mockService service.SecretService = &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock:  mockBundles,
+	}
+	providerServer := &ProviderServer{secretService: mockService}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var vaultID = testVaultID
+	attributes, err := marshalRequestAttributes(secretBundleRequests, auth, vaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	request := provider.MountRequest{
+		Attributes: attributes,
+		TargetPath: "/some/path",
+		Permission: readOnlyFilePermission,
+	}
+
+	logs := captureLogs(t)
+	_, err = providerServer.Mount(context.Background(), &request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	summary := findLogRecord(t, logs, "Mounted secrets")
+	if summary["level"] != "info" {
+		t.Errorf("Unexpected summary level: %v", summary["level"])
+	}
+	if summary["secrets"] != float64(2) {
+		t.Errorf("Unexpected number of secrets: %v", summary["secrets"])
+	}
+	if summary["vault"] != types.VaultID(vaultID).Digest() || strings.Contains(logs.String(), vaultID) {
+		t.Errorf("Vault id is not hashed: %v", summary["vault"])
+	}
+	if summary["principalType"] != "instance" {
+		t.Errorf("Unexpected principal type: %v", summary["principalType"])
+	}
+	if _, ok := summary["duration"]; !ok {
+		t.Errorf("Missed mount duration")
+	}
+	versions, ok := summary["versions"].(map[string]interface{})
+	if !ok || versions["foo"] != float64(2) || versions["hello"] != float64(1) {
+		t.Errorf("Unexpected resolved versions: %v", summary["versions"])
+	}
+}
+
 func prepareInvalidMountRequests() ([]*provider.MountRequest, error) {
 	invalidParameters := []map[string]string{
 		{"someField": "someValue"},   // missed 'secrets' attribute
@@ -282,6 +394,14 @@ func prepareInvalidMountRequests() ([]*provider.MountRequest, error) {
 		{"secrets": "invalid-value"}, // plain string instead of expected YAML
 		{"secrets": "- name: foo\n  versionNumber: 2\n  redundantField: test\n"}, // redundant secret field
 		{"secrets": "- name: foo\n  versionNumber: 0\n"},                         // non-positive version number
+		{"secrets": "foo:\n  versionNumber: 2\n"},                                // map instead of list
+		{"secrets": "- name: foo\n  versionNumber: 2\n  stage: CURRENT\n"},       // both version and stage
+		{"secrets": "- stage: CURRENT\n"},                                        // missed name
+		{"secrets": "- name: foo\n  encoding: hex\n"},                            // unknown encoding
+		{"secrets": "- name: foo\n  format: xml\n"},                              // unknown format
+		{"secrets": "- name: foo\n  fileName: ../../etc/passwd\n"},               // path traversal
+		{"secrets": "- name: foo\n  mode: \"0999\"\n"},                           // non-octal file mode
+		{"secrets": "- name: foo\n  mode: \"01777\"\n"},                          // not only permissions
 	}
 	var mountRequests []*provider.MountRequest
 
@@ -299,3 +419,1628 @@ func prepareInvalidMountRequests() ([]*provider.MountRequest, error) {
 	}
 	return mountRequests, nil
 }
+
+func TestRetrieveAuthConfig_InstancePrincipalWithRegionAttribute_ReturnNormalizedRegion(t *testing.T) {
+	providerServer := &ProviderServer{config: Config{InstancePrincipalRegion: "us-phoenix-1"}}
+	attributes := map[string]string{authTypeField: string(types.Instance), regionField: "iad"}
+
+	auth, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.Region != "us-ashburn-1" {
+		t.Errorf("Wrong region: %v", auth.Region)
+	}
+}
+
+func TestRetrieveAuthConfig_InstancePrincipalWithoutRegionAttribute_ReturnConfiguredRegion(t *testing.T) {
+	providerServer := &ProviderServer{config: Config{InstancePrincipalRegion: "phx"}}
+	attributes := map[string]string{authTypeField: string(types.Instance)}
+
+	auth, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.Region != "us-phoenix-1" {
+		t.Errorf("Wrong region: %v", auth.Region)
+	}
+}
+
+func TestRetrieveAuthConfig_InstancePrincipalWithoutRegion_ReturnEmptyRegion(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{authTypeField: string(types.Instance)}
+
+	auth, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if auth.Region != "" {
+		t.Errorf("Region should be discovered via IMDS, but got: %v", auth.Region)
+	}
+}
+
+func TestRetrieveAuthConfig_WorkloadIdentityWithUnknownRegion_ReturnError(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{authTypeField: string(types.Workload), regionField: "mars-north-1"}
+
+	_, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), `invalid "region" SecretProviderClass parameter:`) ||
+		!strings.Contains(err.Error(), "mars-north-1") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestRetrieveAuthConfig_WorkloadIdentityWithoutPodAttribute_ReturnInvalidArgument(t *testing.T) {
+	for _, missingField := range workloadPodFields {
+		t.Run(missingField, func(t *testing.T) {
+			providerServer := &ProviderServer{}
+			attributes := map[string]string{
+				authTypeField:          string(types.Workload),
+				podNamespaceField:      "default",
+				podServiceAccountField: "app-sa",
+				podNameField:           "app",
+				podUIDField:            "app-uid",
+			}
+			attributes[missingField] = ""
+
+			_, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+			}
+			if status.Convert(err).Message() !=
+				"workload identity requires pod attributes provided by the driver, missing: "+missingField {
+				t.Errorf("Wrong error message: %v", err)
+			}
+		})
+	}
+}
+
+func TestMount_WorkloadIdentityWithoutPodAttributes_ReturnInvalidArgumentListingThem(t *testing.T) {
+	providerServer := &ProviderServer{secretService: &mockSecretService{}}
+	attributes, err := marshalRequestAttributes([]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}},
+		&types.Auth{Type: types.Workload}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	_, err = providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.HasSuffix(err.Error(), "missing: csi.storage.k8s.io/pod.namespace, "+
+		"csi.storage.k8s.io/serviceAccount.name, csi.storage.k8s.io/pod.name, csi.storage.k8s.io/pod.uid") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestRetrieveRegion_RegionShortCode_ReturnRegionIdentifier(t *testing.T) {
+	region, err := retrieveRegion(map[string]string{regionField: "fra"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if region != "eu-frankfurt-1" {
+		t.Errorf("Wrong region: %v", region)
+	}
+}
+
+func TestRetrieveAuthConfig_InstancePrincipalWithUnknownRegion_ReturnError(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{authTypeField: string(types.Instance), regionField: "unknown-region"}
+
+	_, err := providerServer.retrieveAuthConfig(context.Background(), attributes, "default")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), "invalid \"region\" SecretProviderClass parameter") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// mountSecret mounts secret "foo" from testVaultID with the given file name and base64 content.
+func mountSecret(t *testing.T, config Config, fileName string, content string) (*provider.MountResponse, error) {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1, FileName: fileName}}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 1, FileName: fileName,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: content, ContentType: types.Base64},
+		},
+	}
+	var mockService service.SecretService = &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock:  mockBundles,
+	}
+	providerServer := &ProviderServer{secretService: mockService, config: config}
+
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	request := provider.MountRequest{
+		Attributes: attributes,
+		TargetPath: "/some/path",
+		Permission: readOnlyFilePermission,
+	}
+	return providerServer.Mount(context.Background(), &request)
+}
+
+// mountSingleSecret mounts a single secret with the given base64 content using the server configuration.
+func mountSingleSecret(t *testing.T, config Config, content string) *provider.MountResponse {
+	t.Helper()
+	response, err := mountSecret(t, config, "", content)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return response
+}
+
+const doubleEncodingWarning = "Secret content looks base64-encoded twice, check the value stored in the vault"
+
+func TestMount_DoubleEncodedSecret_LogWarning(t *testing.T) {
+	logs := captureLogs(t)
+	// base64 of "cGFzc3dvcmQ=", which is base64 of "password"
+	mountSingleSecret(t, Config{DetectDoubleEncoding: true}, "Y0dGemMzZHZjbVE9")
+
+	warning := findLogRecord(t, logs, doubleEncodingWarning)
+	if warning["level"] != "warn" || warning["secret"] != "foo" {
+		t.Errorf("Unexpected warning: %v", warning)
+	}
+	if strings.Contains(logs.String(), "cGFzc3dvcmQ=") {
+		t.Error("Secret content should not be logged")
+	}
+}
+
+func TestMount_NormalSecret_NoDoubleEncodingWarning(t *testing.T) {
+	logs := captureLogs(t)
+	mountSingleSecret(t, Config{DetectDoubleEncoding: true}, "cGFzc3dvcmQ=")
+
+	if strings.Contains(logs.String(), doubleEncodingWarning) {
+		t.Errorf("Unexpected double encoding warning: %v", logs.String())
+	}
+}
+
+func TestMount_DoubleEncodedSecretWithDetectionDisabled_NoDoubleEncodingWarning(t *testing.T) {
+	logs := captureLogs(t)
+	mountSingleSecret(t, Config{}, "Y0dGemMzZHZjbVE9")
+
+	if strings.Contains(logs.String(), doubleEncodingWarning) {
+		t.Errorf("Unexpected double encoding warning: %v", logs.String())
+	}
+}
+
+// mountWithFileName mounts secret "foo" under the given file name and returns the error of the mount.
+func mountWithFileName(t *testing.T, config Config, fileName string) error {
+	t.Helper()
+	_, err := mountSecret(t, config, fileName, "YmFyMQ==")
+	return err
+}
+
+func TestMount_FileNameAtDefaultLimit_ReturnSecret(t *testing.T) {
+	err := mountWithFileName(t, Config{}, strings.Repeat("a", 255))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestMount_FileNameOverDefaultLimit_ReturnInvalidArgument(t *testing.T) {
+	err := mountWithFileName(t, Config{}, strings.Repeat("a", 256))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "file name of secret foo is 256 bytes long, exceeding the maximum of 255") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_ChunkFileNameOverLimit_ReturnInvalidArgument(t *testing.T) {
+	// "bar1" is split into two parts, so the part and manifest suffixes exceed the limit
+	_, err := mountSecret(t, Config{MaxFileNameLength: 10, ChunkSize: 2}, strings.Repeat("a", 10), "YmFyMQ==")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "file name of mounted file aaaaaaaaaa.part-000 is 19 bytes long") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+
+	if _, err := mountSecret(t, Config{MaxFileNameLength: 19, ChunkSize: 2}, strings.Repeat("a", 10),
+		"YmFyMQ=="); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestMount_MetadataFileNameOverLimit_ReturnInvalidArgument(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}
+	mockBundles := []*types.SecretBundle{{
+		ID: "uid1", Name: "foo", VersionNumber: 1, MetadataFileName: "db/" + strings.Repeat("a", 11),
+		Stages:        []types.Stage{types.Current},
+		BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+	}}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+		config:        Config{MaxFileNameLength: 10},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	_, err = providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, TargetPath: "/some/path", Permission: readOnlyFilePermission})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+}
+
+func TestMount_NestedFileName_ReturnNormalizedNestedPath(t *testing.T) {
+	for _, fileName := range []string{"db/password", "./db//password", "db/tmp/../password"} {
+		response, err := mountSecret(t, Config{}, fileName, "YmFyMQ==")
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", fileName, err)
+		}
+		if len(response.Files) != 1 || response.Files[0].Path != "db/password" {
+			t.Errorf("Unexpected files for %v: %v", fileName, response.Files)
+		}
+	}
+}
+
+func TestMount_FileNameEscapingTarget_ReturnInvalidArgument(t *testing.T) {
+	for _, fileName := range []string{"../escape", "db/../../escape", "/etc/escape"} {
+		_, err := mountSecret(t, Config{}, fileName, "YmFyMQ==")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Invalid gRPC code for %v: %v", fileName, status.Code(err))
+		}
+	}
+}
+
+func TestMount_FileNameOverConfiguredLimit_ReturnInvalidArgument(t *testing.T) {
+	err := mountWithFileName(t, Config{MaxFileNameLength: 10}, "dir/"+strings.Repeat("a", 10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = mountWithFileName(t, Config{MaxFileNameLength: 10}, "dir/"+strings.Repeat("a", 11))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Invalid gRPC code: %v", status.Code(err))
+	}
+}
+
+func TestMount_VaultNotInAllowList_ReturnPermissionDenied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowed-vaults")
+	if err := os.WriteFile(path, []byte("vault2\n"), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write allow list: %v", err)
+	}
+	allowedVaults, err := policy.NewVaultAllowList(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the mount helper requests secrets from testVaultID
+	err = mountWithFileName(t, Config{AllowedVaults: allowedVaults}, "")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if strings.Contains(err.Error(), testVaultID) {
+		t.Errorf("Vault OCID exposed in error message: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(testVaultID+"\nvault2\n"), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write allow list: %v", err)
+	}
+	if err := allowedVaults.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := mountWithFileName(t, Config{AllowedVaults: allowedVaults}, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestMount_SecretNamePolicy(t *testing.T) {
+	// the mount helper requests secret "foo"
+	allowed, err := policy.NewSecretNamePolicy([]string{"f*"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	denied, err := policy.NewSecretNamePolicy([]string{"f*"}, []string{"foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	notListed, err := policy.NewSecretNamePolicy([]string{"bar"}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		policy   *policy.SecretNamePolicy
+		expected codes.Code
+	}{
+		{name: "no restriction", policy: nil, expected: codes.OK},
+		{name: "allowed", policy: allowed, expected: codes.OK},
+		{name: "denied", policy: denied, expected: codes.PermissionDenied},
+		{name: "not allowed", policy: notListed, expected: codes.PermissionDenied},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := mountWithFileName(t, Config{SecretNames: testCase.policy}, "")
+			if status.Code(err) != testCase.expected {
+				t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+			}
+		})
+	}
+}
+
+// mountInNamespace mounts the secret foo for a pod of the namespace
+func mountInNamespace(t *testing.T, config Config, namespace string) error {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}
+	providerServer := &ProviderServer{secretService: &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock: []*types.SecretBundle{{
+			ID: "uid1", Name: "foo", VersionNumber: 1,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		}},
+	}, config: config}
+	attributesJSON, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	attributes := map[string]string{}
+	if err := json.Unmarshal([]byte(attributesJSON), &attributes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	attributes[podNamespaceField] = namespace
+	attributesBytes, err := json.Marshal(attributes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: string(attributesBytes), Permission: readOnlyFilePermission})
+	return err
+}
+
+func TestMount_NamespaceSecretNamePolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "namespace-secret-names")
+	content := "team-a:\n  allowed: [\"f*\"]\nteam-b:\n  denied: [\"foo\"]\n\"*\":\n  allowed: [\"bar\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write namespace secret names: %v", err)
+	}
+	namespaceSecretNames, err := policy.NewNamespaceSecretNamePolicy(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config := Config{NamespaceSecretNames: namespaceSecretNames}
+
+	testCases := []struct {
+		name      string
+		config    Config
+		namespace string
+		expected  codes.Code
+	}{
+		{name: "no restriction", config: Config{}, namespace: "team-b", expected: codes.OK},
+		{name: "allowed", config: config, namespace: "team-a", expected: codes.OK},
+		{name: "denied", config: config, namespace: "team-b", expected: codes.PermissionDenied},
+		{name: "not allowed in other namespaces", config: config, namespace: "team-c", expected: codes.PermissionDenied},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := mountInNamespace(t, testCase.config, testCase.namespace)
+			if status.Code(err) != testCase.expected {
+				t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+			}
+		})
+	}
+
+	err = mountInNamespace(t, config, "team-b")
+	if !strings.Contains(err.Error(), "secret name is not allowed in namespace team-b: foo") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_AllowedPrincipals(t *testing.T) {
+	// the mount helper authenticates with instance principal
+	testCases := []struct {
+		name       string
+		principals []types.OCIPrincipalType
+		expected   codes.Code
+	}{
+		{name: "default", principals: nil, expected: codes.OK},
+		{name: "allowed", principals: []types.OCIPrincipalType{types.Workload, types.Instance}, expected: codes.OK},
+		{name: "denied", principals: []types.OCIPrincipalType{types.Workload}, expected: codes.PermissionDenied},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := mountWithFileName(t, Config{AllowedPrincipals: testCase.principals}, "")
+			if status.Code(err) != testCase.expected {
+				t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+			}
+			if err != nil && !strings.Contains(err.Error(), "principal type is not allowed: instance") {
+				t.Errorf("Wrong error message: %v", err)
+			}
+		})
+	}
+}
+
+func TestRetrieveSecretRequests_SecretsAsMap_ReturnListFormatError(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{secretsField: "name: foo\nversionNumber: 2\n"}
+
+	_, err := providerServer.retrieveSecretRequests(attributes)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), "should be a list of secrets, each item starting with \"- name:\", but got a map") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestRetrieveSecretRequests_InvalidList_ReturnGenericError(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{secretsField: "- name: foo\n  unknownField: 2\n"}
+
+	_, err := providerServer.retrieveSecretRequests(attributes)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), "failed to unmarshal SecretProviderClass parameter \"secrets\"") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_SecretLargerThanChunkSize_SplitIntoPartsWithManifest(t *testing.T) {
+	secretContent := make([]byte, 2500)
+	for i := range secretContent {
+		secretContent[i] = byte(i % 251)
+	}
+	response := mountSingleSecret(t, Config{ChunkSize: 1000}, base64.StdEncoding.EncodeToString(secretContent))
+
+	expectedPaths := []string{"foo.part-000", "foo.part-001", "foo.part-002", "foo.manifest"}
+	if len(response.Files) != len(expectedPaths) {
+		t.Fatalf("Unexpected number of files: %v", len(response.Files))
+	}
+	files := make(map[string][]byte)
+	for i, file := range response.Files {
+		if file.Path != expectedPaths[i] {
+			t.Errorf("Unexpected file path: %v, expected %v", file.Path, expectedPaths[i])
+		}
+		if len(file.Contents) > 1000 {
+			t.Errorf("Part %v exceeds chunk size: %v", file.Path, len(file.Contents))
+		}
+		files[file.Path] = file.Contents
+	}
+	if len(response.ObjectVersion) != 1 || response.ObjectVersion[0].Id != "uid1" {
+		t.Errorf("Unexpected object versions: %v", response.ObjectVersion)
+	}
+
+	// reassembling the same way as "cat $(cat foo.manifest) > foo"
+	var reassembled []byte
+	for _, partPath := range strings.Fields(string(files["foo.manifest"])) {
+		reassembled = append(reassembled, files[partPath]...)
+	}
+	if !bytes.Equal(reassembled, secretContent) {
+		t.Error("Reassembled secret differs from the original one")
+	}
+}
+
+func TestMount_SecretWithinChunkSize_ReturnSingleFile(t *testing.T) {
+	response := mountSingleSecret(t, Config{ChunkSize: 4}, "YmFyMQ==")
+
+	if len(response.Files) != 1 || response.Files[0].Path != "foo" || string(response.Files[0].Contents) != "bar1" {
+		t.Errorf("Unexpected files: %v", response.Files)
+	}
+}
+
+func TestMount_UndecodableSecrets_DecodeErrorCountedPerReason(t *testing.T) {
+	scrapeMetrics(t) // the pipeline should be installed before reporting
+
+	for reason, content := range map[types.DecodeErrorReason]string{
+		types.MalformedContent: "YmFy!",
+		types.MissedContent:    "",
+	} {
+		before := metricValue(scrapeMetrics(t), "decode_error_total", `reason="`+string(reason)+`"`)
+		_, err := mountSecret(t, Config{}, "", content)
+		if err == nil {
+			t.Fatal("An error was expected")
+		}
+		after := metricValue(scrapeMetrics(t), "decode_error_total", `reason="`+string(reason)+`"`)
+		if before == after || after == "" {
+			t.Errorf("Decode error is not counted for %v reason: %v -> %v", reason, before, after)
+		}
+	}
+}
+
+func TestMount_OneOfSecretsUndecodable_ReturnNoFiles(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionNumber: 1},
+		{Name: "hello", VersionNumber: 1},
+		{Name: "world", VersionNumber: 1},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 1,
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		},
+		{
+			ID: "uid2", Name: "hello", VersionNumber: 1,
+			BundleContent: &types.SecretBundleContent{Content: "d29y!", ContentType: types.Base64},
+		},
+		{
+			ID: "uid3", Name: "world", VersionNumber: 1,
+			BundleContent: &types.SecretBundleContent{Content: "d29ybGQ=", ContentType: types.Base64},
+		},
+	}
+	var mockService service.SecretService = &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock:  mockBundles,
+	}
+	providerServer := &ProviderServer{secretService: mockService}
+
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	request := provider.MountRequest{
+		Attributes: attributes,
+		TargetPath: "/some/path",
+		Permission: readOnlyFilePermission,
+	}
+
+	response, err := providerServer.Mount(context.Background(), &request)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if response != nil {
+		t.Errorf("Partial response is returned: %v", response.Files)
+	}
+}
+
+func TestMount_ChunkedSecrets_AllPartsEmittedTogether(t *testing.T) {
+	secretContent := strings.Repeat("0123456789", 25)
+	response := mountSingleSecret(t, Config{ChunkSize: 100}, base64.StdEncoding.EncodeToString([]byte(secretContent)))
+
+	var emittedContent strings.Builder
+	for _, file := range response.Files {
+		if strings.Contains(file.Path, ".part-") {
+			emittedContent.Write(file.Contents)
+		}
+	}
+	if emittedContent.String() != secretContent {
+		t.Errorf("Emitted parts don't carry the full secret content: %v", emittedContent.String())
+	}
+}
+
+func TestRetrieveSecretRequests_UnknownFieldInStrictMode_ReturnError(t *testing.T) {
+	providerServer := &ProviderServer{}
+	attributes := map[string]string{secretsField: "- name: foo\n  versionNumber: 2\n  futureField: test\n"}
+
+	_, err := providerServer.retrieveSecretRequests(attributes)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestRetrieveSecretRequests_UnknownFieldInLenientMode_LogWarning(t *testing.T) {
+	logs := captureLogs(t)
+	providerServer := &ProviderServer{config: Config{AllowUnknownSecretFields: true}}
+	attributes := map[string]string{secretsField: "- name: foo\n  versionNumber: 2\n  futureField: test\n"}
+
+	requests, err := providerServer.retrieveSecretRequests(attributes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(requests) != 1 || requests[0].Name != "foo" || requests[0].VersionNumber != 2 {
+		t.Errorf("Unexpected secret requests: %v", requests)
+	}
+	warning := findLogRecord(t, logs, "Ignored unknown fields of SecretProviderClass secrets")
+	if warning["level"] != "warn" || !strings.Contains(warning["error"].(string), "futureField") {
+		t.Errorf("Unexpected warning: %v", warning)
+	}
+}
+
+func TestRetrieveSecretRequests_InvalidValueInLenientMode_ReturnError(t *testing.T) {
+	providerServer := &ProviderServer{config: Config{AllowUnknownSecretFields: true}}
+	attributes := map[string]string{secretsField: "- name: foo\n  versionNumber: two\n  futureField: test\n"}
+
+	_, err := providerServer.retrieveSecretRequests(attributes)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+// mountBundle mounts the secret bundle returned by the mocked service using the server configuration.
+func mountBundle(t *testing.T, config Config, bundle *types.SecretBundle) (*provider.MountResponse, error) {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: bundle.Name, VersionNumber: types.VersionNumber(bundle.VersionNumber)},
+	}
+	var mockService service.SecretService = &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock:  []*types.SecretBundle{bundle},
+	}
+	providerServer := &ProviderServer{secretService: mockService, config: config}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	request := provider.MountRequest{Attributes: attributes, TargetPath: "/some/path", Permission: readOnlyFilePermission}
+	return providerServer.Mount(context.Background(), &request)
+}
+
+func TestMount_ConsecutiveMounts_LogLinesOfEachMountShareItsID(t *testing.T) {
+	logs := captureLogs(t)
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}
+	providerServer := &ProviderServer{secretService: &mockSecretService{
+		requestsMock: secretBundleRequests,
+		bundlesMock:  []*types.SecretBundle{newPendingDeletionBundle()},
+	}}
+	attributesJSON, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	attributes := map[string]string{}
+	if err := json.Unmarshal([]byte(attributesJSON), &attributes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	attributes[podNameField], attributes[secretProviderClassField] = "app", "app-secrets"
+	attributesBytes, err := json.Marshal(attributes)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	mountIDs := make(map[interface{}]int)
+	for i := 0; i < 2; i++ {
+		logs.Reset()
+		_, err := providerServer.Mount(context.Background(),
+			&provider.MountRequest{Attributes: string(attributesBytes), Permission: readOnlyFilePermission})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		warning := findLogRecord(t, logs, "Mounted secret is scheduled for deletion")
+		mounted := findLogRecord(t, logs, "Mounted secrets")
+		if warning["mountId"] == nil || warning["mountId"] != mounted["mountId"] {
+			t.Errorf("Lines of the same mount should share its ID: %v, %v", warning, mounted)
+		}
+		if mounted["pod"] != "app" || mounted["SecretProviderClass"] != "app-secrets" {
+			t.Errorf("Wrong mount context: %v", mounted)
+		}
+		mountIDs[mounted["mountId"]]++
+	}
+	if len(mountIDs) != 2 {
+		t.Errorf("Each mount should have its own ID: %v", mountIDs)
+	}
+}
+
+func TestMount_ReportSecretExpiryEnabled_ExportSecondsUntilExpiry(t *testing.T) {
+	scrapeMetrics(t) // the pipeline should be installed before reporting
+
+	timeOfExpiry := time.Now().Add(time.Hour)
+	bundle := &types.SecretBundle{
+		ID: "expiring-uid", Name: "expiring", VersionNumber: 1,
+		Stages:        []types.Stage{types.Current},
+		BundleContent: &types.SecretBundleContent{Content: "YmFy", ContentType: types.Base64},
+		TimeOfExpiry:  &timeOfExpiry,
+	}
+
+	logs := captureLogs(t)
+	if _, err := mountBundle(t, Config{ReportSecretExpiry: true}, bundle); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if findLogRecord(t, logs, "Mounted secret has expiry time") == nil {
+		t.Errorf("Expiry was not logged: %v", logs)
+	}
+	value := metricValue(scrapeMetrics(t), "secret_seconds_until_expiry", `secret_name="expiring"`)
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 3500 || seconds > 3600 {
+		t.Errorf("Unexpected metric value: %v", value)
+	}
+}
+
+func newPendingDeletionBundle() *types.SecretBundle {
+	timeOfDeletion := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &types.SecretBundle{
+		ID: "uid1", Name: "foo", VersionNumber: 1,
+		Stages:         []types.Stage{types.Current},
+		BundleContent:  &types.SecretBundleContent{Content: "YmFy", ContentType: types.Base64},
+		TimeOfDeletion: &timeOfDeletion,
+	}
+}
+
+func TestMount_PendingDeletionWithWarnPolicy_MountWithWarning(t *testing.T) {
+	for _, policy := range []PendingDeletionPolicy{"", PendingDeletionWarn} {
+		logs := captureLogs(t)
+		response, err := mountBundle(t, Config{PendingDeletion: policy}, newPendingDeletionBundle())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(response.Files) != 1 {
+			t.Errorf("Unexpected files: %v", response.Files)
+		}
+		if findLogRecord(t, logs, "Mounted secret is scheduled for deletion") == nil {
+			t.Errorf("Pending deletion was not logged for policy %q: %v", policy, logs)
+		}
+	}
+}
+
+func TestMount_PendingDeletionWithRefusePolicy_ReturnError(t *testing.T) {
+	_, err := mountBundle(t, Config{PendingDeletion: PendingDeletionRefuse}, newPendingDeletionBundle())
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Unexpected status code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "secret foo is scheduled for deletion at 2030-01-02T03:04:05Z") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_SecretNotPendingDeletionWithRefusePolicy_MountSecret(t *testing.T) {
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	if _, err := mountBundle(t, Config{PendingDeletion: PendingDeletionRefuse}, bundle); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestNewOCIVaultProviderServer_UnknownPendingDeletionPolicy_ReturnError(t *testing.T) {
+	_, err := NewOCIVaultProviderServer(Config{PendingDeletion: "ignore"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unknown pending deletion policy: ignore" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_EmptyStages_MountWithWarning(t *testing.T) {
+	scrapeMetrics(t) // the pipeline should be installed before reporting
+	before := metricValue(scrapeMetrics(t), "empty_stages_secrets_total", `provider="oci-provider"`)
+	logs := captureLogs(t)
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	bundle.Stages = []types.Stage{}
+
+	response, err := mountBundle(t, Config{}, bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 1 {
+		t.Errorf("Unexpected files: %v", response.Files)
+	}
+	record := findLogRecord(t, logs, "Mounted secret has no stages")
+	if record == nil || record["secret"] != "foo" {
+		t.Errorf("Empty stages were not logged: %v", logs)
+	}
+	after := metricValue(scrapeMetrics(t), "empty_stages_secrets_total", `provider="oci-provider"`)
+	if before == after || after == "" {
+		t.Errorf("Empty stages are not counted: %v -> %v", before, after)
+	}
+}
+
+func TestMount_EmptyStagesRefused_ReturnError(t *testing.T) {
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	bundle.Stages = nil
+
+	_, err := mountBundle(t, Config{RefuseEmptyStages: true}, bundle)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Unexpected status code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "secret foo has no stages") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_StagesPresentAndEmptyStagesRefused_MountSecret(t *testing.T) {
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	if _, err := mountBundle(t, Config{RefuseEmptyStages: true}, bundle); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// newManySecretBundles creates the number of bundles, each with base64 content of the size in bytes
+func newManySecretBundles(count int, size int) []*types.SecretBundle {
+	content := base64.StdEncoding.EncodeToString(make([]byte, size))
+	bundles := make([]*types.SecretBundle, count)
+	for i := range bundles {
+		bundles[i] = &types.SecretBundle{
+			ID: fmt.Sprintf("uid%v", i), Name: fmt.Sprintf("secret%v", i), VersionNumber: 1,
+			BundleContent: &types.SecretBundleContent{Content: content, ContentType: types.Base64},
+		}
+	}
+	return bundles
+}
+
+func TestCreateResponse_ManySecrets_EncodedContentReleased(t *testing.T) {
+	providerServer := &ProviderServer{}
+	secretBundles := newManySecretBundles(100, 1024)
+
+	response, err := providerServer.createResponse(context.Background(), secretBundles, nil, 0o444)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 100 || len(response.Files[99].Contents) != 1024 {
+		t.Fatalf("Unexpected files: %v", len(response.Files))
+	}
+	for _, bundle := range secretBundles {
+		if bundle.BundleContent != nil {
+			t.Fatalf("Encoded content of %v is not released", bundle.Name)
+		}
+	}
+}
+
+func BenchmarkCreateResponse_ManySecrets(b *testing.B) {
+	providerServer := &ProviderServer{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		secretBundles := newManySecretBundles(500, 4096)
+		b.StartTimer()
+		if _, err := providerServer.createResponse(context.Background(), secretBundles, nil, 0o444); err != nil {
+			b.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCreateResponse_ManySecrets_DecodedContentNotCopied(t *testing.T) {
+	const decodedSize = 500 * 4096
+
+	// averaged over the benchmark iterations, so allocations of other goroutines don't dominate
+	result := testing.Benchmark(BenchmarkCreateResponse_ManySecrets)
+	if result.N == 0 {
+		t.Fatalf("Precondition failed: benchmark did not run")
+	}
+
+	// decoded content is allocated once, the rest is bookkeeping of files and versions
+	if allocated := result.AllocedBytesPerOp(); allocated > 2*decodedSize {
+		t.Errorf("Unexpected allocation per response: %v bytes", allocated)
+	}
+}
+
+// setBuildValues overrides the values set with ldflags for the test
+func setBuildValues(t *testing.T, version, commit, date string) {
+	t.Helper()
+	originalVersion, originalCommit, originalDate := BuildVersion, GitCommit, BuildDate
+	t.Cleanup(func() { BuildVersion, GitCommit, BuildDate = originalVersion, originalCommit, originalDate })
+	BuildVersion, GitCommit, BuildDate = version, commit, date
+}
+
+func TestBuildInfo_VersionSet_ReturnVersionCommitAndGoVersion(t *testing.T) {
+	setBuildValues(t, "1.2.3", "abc123", "2024.01.02.03.04")
+
+	expected := "oci-secrets-store-csi-driver-provider\nversion: 1.2.3\ncommit: abc123\ndate: 2024.01.02.03.04\n" +
+		"go: " + runtime.Version() + "\n"
+	if info := BuildInfo(); info != expected {
+		t.Errorf("Unexpected build info: %v", info)
+	}
+}
+
+func TestBuildInfo_VersionNotSet_ReturnUnknown(t *testing.T) {
+	setBuildValues(t, "", "", "")
+
+	info := BuildInfo()
+	if !strings.Contains(info, "version: unknown\n") || !strings.Contains(info, "commit: unknown\n") ||
+		!strings.Contains(info, "date: unknown\n") {
+		t.Errorf("Unexpected build info: %v", info)
+	}
+}
+
+func TestGetBuildDetails_VersionSet_ReturnDetailsSerializedWithFieldNames(t *testing.T) {
+	setBuildValues(t, "1.2.3", "abc123", "2024.01.02.03.04")
+
+	detailsJSON, err := json.Marshal(GetBuildDetails())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{"RuntimeName":"oci-secrets-store-csi-driver-provider","RuntimeVersion":"1.2.3","GitCommit":"abc123",` +
+		`"BuildDate":"2024.01.02.03.04","GoVersion":"` + runtime.Version() + `"}`
+	if string(detailsJSON) != expected {
+		t.Errorf("Unexpected build details: %v", string(detailsJSON))
+	}
+}
+
+// TestMount_ConcurrentMounts_NoDataRaces is meant to run with -race, imitating many pods mounting at once
+// with every mount feature touching shared state enabled.
+func TestMount_ConcurrentMounts_NoDataRaces(t *testing.T) {
+	const mounts = 100
+	expiry := time.Now().Add(time.Hour)
+	secretBundles := newManySecretBundles(20, 64)
+	secretBundleRequests := make([]*types.SecretBundleRequest, len(secretBundles))
+	for i, bundle := range secretBundles {
+		bundle.Stages = []types.Stage{types.Current}
+		bundle.TimeOfExpiry = &expiry
+		secretBundleRequests[i] = &types.SecretBundleRequest{Name: bundle.Name, VersionNumber: 1}
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: secretBundles},
+		config:        Config{DetectDoubleEncoding: true, ReportSecretExpiry: true},
+		mountFailures: newMountFailureTracker(&fakeEventSink{}, 1),
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	unexpectedAttributes, err := marshalRequestAttributes(
+		[]*types.SecretBundleRequest{{Name: "unexpected", VersionNumber: 1}}, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, mounts)
+	for i := 0; i < mounts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := &provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission}
+			if i%4 == 0 {
+				// failed mounts are tracked as well
+				request.Attributes = unexpectedAttributes
+			}
+			_, errs[i] = providerServer.Mount(context.Background(), request)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if (i%4 == 0) != (err != nil) {
+			t.Errorf("Unexpected result of mount %v: %v", i, err)
+		}
+	}
+}
+
+func TestMount_BinarySecret_ReturnSameBytes(t *testing.T) {
+	binaryContent := []byte{0x00, 0xfe, 0xed, 0xfe, 0xed, 0x00, 0x00, 0x02, 0xff, 0x00}
+
+	response, err := mountSecret(t, Config{DetectDoubleEncoding: true}, "keystore.jks",
+		base64.StdEncoding.EncodeToString(binaryContent))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 1 || !bytes.Equal(response.Files[0].Contents, binaryContent) {
+		t.Errorf("Unexpected files: %v", response.Files)
+	}
+}
+
+func TestCheckFileMode_Policies(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    Config
+		requested os.FileMode
+		expected  os.FileMode
+		code      codes.Code
+	}{
+		{name: "no maximum", config: Config{}, requested: 0644, expected: 0644},
+		{name: "compliant", config: Config{MaxFileMode: 0600}, requested: 0400, expected: 0400},
+		{name: "compliant with tighten", config: Config{MaxFileMode: 0600, FileMode: FileModeTighten},
+			requested: 0600, expected: 0600},
+		{name: "rejected by default", config: Config{MaxFileMode: 0600}, requested: 0644,
+			code: codes.InvalidArgument},
+		{name: "rejected", config: Config{MaxFileMode: 0400, FileMode: FileModeReject}, requested: 0440,
+			code: codes.InvalidArgument},
+		{name: "tightened", config: Config{MaxFileMode: 0600, FileMode: FileModeTighten},
+			requested: 0644, expected: 0600},
+		{name: "tightened to read only", config: Config{MaxFileMode: 0400, FileMode: FileModeTighten},
+			requested: 0666, expected: 0400},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			providerServer := &ProviderServer{config: testCase.config}
+			mode, err := providerServer.checkFileMode(testCase.requested)
+			if status.Code(err) != testCase.code {
+				t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+			}
+			if err == nil && mode != testCase.expected {
+				t.Errorf("Unexpected file mode: %#o", mode)
+			}
+		})
+	}
+}
+
+func TestMount_FileModeLooserThanMaximum_ReturnInvalidArgument(t *testing.T) {
+	// the mount helper requests read-only 0444 mode
+	_, err := mountSecret(t, Config{MaxFileMode: 0400}, "", "YmFyMQ==")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "file mode 0444 is looser than the allowed maximum 0400") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+
+	response, err := mountSecret(t, Config{MaxFileMode: 0400, FileMode: FileModeTighten}, "", "YmFyMQ==")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Files[0].Mode != 0400 {
+		t.Errorf("Unexpected file mode: %#o", response.Files[0].Mode)
+	}
+}
+
+func TestMount_MissingOrMalformedPermission_ReturnInvalidArgument(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}
+	// the retrieval fails differently, so the permission is expected to be checked before it
+	providerServer := &ProviderServer{secretService: &mockSecretService{errMock: errors.New("unexpected retrieval")}}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	tests := map[string]string{
+		"":      "file permission is missing in the mount request",
+		"rw":    `file permission "rw" of the mount request should be a non-negative decimal number`,
+		"-1":    `file permission "-1" of the mount request should be a non-negative decimal number`,
+		"0644":  `file permission "0644" of the mount request should be a non-negative decimal number`,
+		`"420"`: `file permission "\"420\"" of the mount request should be a non-negative decimal number`,
+	}
+	for permission, message := range tests {
+		_, err := providerServer.Mount(context.Background(),
+			&provider.MountRequest{Attributes: attributes, Permission: permission})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Invalid gRPC code of permission %q: %v", permission, status.Code(err))
+		}
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+// mountWithModes mounts the secret foo with the mode of the mount request and the private key with its own mode
+func mountWithModes(t *testing.T, config Config, keyMode types.FileMode) (*provider.MountResponse, error) {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionNumber: 1},
+		{Name: "key", VersionNumber: 1, Mode: keyMode},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 1,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		},
+		{
+			ID: "uid2", Name: "key", VersionNumber: 1, Mode: keyMode,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMg==", ContentType: types.Base64},
+		},
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+		config:        config,
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	return providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+}
+
+func TestMount_SecretFileMode_ReturnMixedFileModes(t *testing.T) {
+	response, err := mountWithModes(t, Config{}, 0400)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Files) != 2 {
+		t.Fatalf("Unexpected files: %v", response.Files)
+	}
+	if response.Files[0].Path != "foo" || response.Files[0].Mode != 0444 {
+		t.Errorf("Unexpected mode of %v: %#o", response.Files[0].Path, response.Files[0].Mode)
+	}
+	if response.Files[1].Path != "key" || response.Files[1].Mode != 0400 {
+		t.Errorf("Unexpected mode of %v: %#o", response.Files[1].Path, response.Files[1].Mode)
+	}
+}
+
+func TestMount_SecretFileModeLooserThanMaximum_ReturnInvalidArgument(t *testing.T) {
+	_, err := mountWithModes(t, Config{MaxFileMode: 0444}, 0644)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "file mode 0644 is looser than the allowed maximum 0444") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_ImmutableSecrets_ReturnModesWithoutWritePermissions(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionNumber: 1},
+		{Name: "bar", VersionNumber: 1, Immutable: true},
+		{Name: "key", VersionNumber: 1, Mode: 0640, Immutable: true},
+	}
+	mockBundles := make([]*types.SecretBundle, 0, len(secretBundleRequests))
+	for i, request := range secretBundleRequests {
+		mockBundles = append(mockBundles, &types.SecretBundle{
+			ID: fmt.Sprintf("uid%v", i), Name: request.Name, VersionNumber: 1,
+			Mode: request.Mode, Immutable: request.Immutable,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFy", ContentType: types.Base64},
+		})
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	response, err := providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: "420"}) // 0644
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedModes := map[string]int32{"foo": 0644, "bar": 0444, "key": 0440}
+	if len(response.Files) != len(expectedModes) {
+		t.Fatalf("Unexpected files: %v", response.Files)
+	}
+	for _, file := range response.Files {
+		if file.Mode != expectedModes[file.Path] {
+			t.Errorf("Unexpected mode of %v: %#o", file.Path, file.Mode)
+		}
+	}
+}
+
+// mountWithMinVersion mounts the secret without version, which is resolved to the served version
+func mountWithMinVersion(t *testing.T, servedVersion int64, minVersion int64) (*provider.MountResponse, error) {
+	t.Helper()
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", MinVersionNumber: types.VersionNumber(minVersion)},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: servedVersion, MinVersionNumber: minVersion,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFy", ContentType: types.Base64},
+		},
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+	return providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+}
+
+func TestMount_VersionAtLeastMinimum_ReturnSecret(t *testing.T) {
+	for _, servedVersion := range []int64{3, 4} {
+		response, err := mountWithMinVersion(t, servedVersion, 3)
+		if err != nil {
+			t.Fatalf("Unexpected error for version %v: %v", servedVersion, err)
+		}
+		if response.ObjectVersion[0].Version != strconv.FormatInt(servedVersion, 10) {
+			t.Errorf("Unexpected object version: %v", response.ObjectVersion[0])
+		}
+	}
+}
+
+func TestMount_VersionBelowMinimum_ReturnFailedPrecondition(t *testing.T) {
+	_, err := mountWithMinVersion(t, 2, 3)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), "secret foo version 2 is below the minimum version number 3") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestNewOCIVaultProviderServer_UnknownFileModePolicy_ReturnError(t *testing.T) {
+	_, err := NewOCIVaultProviderServer(Config{FileMode: "ignore"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unknown file mode policy: ignore" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestNewOCIVaultProviderServer_SATokenTTLOutOfRange_ReturnError(t *testing.T) {
+	for _, ttl := range []time.Duration{time.Minute, (1<<32)*time.Second + time.Second} {
+		_, err := NewOCIVaultProviderServer(Config{SATokenTTL: ttl})
+		if err == nil {
+			t.Fatalf("An error was expected for TTL %v", ttl)
+		}
+		if !strings.HasPrefix(err.Error(), "service account token TTL "+ttl.String()+" is out of range") {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestNewOCIVaultProviderServer_FetchOutcomesFileOutsideMount_ReturnError(t *testing.T) {
+	_, err := NewOCIVaultProviderServer(Config{FetchOutcomesFile: "../outcomes.json"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "invalid fetch outcomes file: ") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_FetchOutcomesFile_ReturnOutcomesOfCachedAndRetriedSecrets(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "cached", VersionNumber: 2},
+		{Name: "retried", VersionNumber: 4, FileName: "retried.txt"},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "cached", VersionNumber: 2, Stages: []types.Stage{types.Current}, CacheHit: true,
+			BundleContent: &types.SecretBundleContent{Content: "YmFy", ContentType: types.Base64},
+		},
+		{
+			ID: "uid2", Name: "retried", VersionNumber: 4, FileName: "retried.txt", Attempts: 3,
+			Stages:        []types.Stage{types.Previous},
+			BundleContent: &types.SecretBundleContent{Content: "YmF6", ContentType: types.Base64},
+		},
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+		config:        Config{FetchOutcomesFile: ".oci/fetch-outcomes.json"},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	mountResponse, err := providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(mountResponse.Files) != 3 || len(mountResponse.ObjectVersion) != 2 {
+		t.Fatalf("Unexpected response: %v", mountResponse)
+	}
+	outcomesFile := mountResponse.Files[2]
+	if outcomesFile.Path != ".oci/fetch-outcomes.json" {
+		t.Errorf("Wrong outcomes file path: %v", outcomesFile.Path)
+	}
+	outcomes := response.FetchOutcomes{}
+	if err := json.Unmarshal(outcomesFile.Contents, &outcomes); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if outcomes.SchemaVersion != response.FetchOutcomesSchemaVersion || len(outcomes.Files) != 2 {
+		t.Fatalf("Unexpected outcomes: %+v", outcomes)
+	}
+	cached, retried := outcomes.Files[0], outcomes.Files[1]
+	if cached.Path != "cached" || cached.VersionNumber != 2 || !cached.CacheHit || cached.Retries != 0 ||
+		len(cached.Stages) != 1 || cached.Stages[0] != "CURRENT" {
+		t.Errorf("Unexpected outcome of cached secret: %+v", cached)
+	}
+	if retried.Path != "retried.txt" || retried.SecretID != "uid2" || retried.VersionNumber != 4 ||
+		retried.CacheHit || retried.Retries != 2 || len(retried.Stages) != 1 || retried.Stages[0] != "PREVIOUS" {
+		t.Errorf("Unexpected outcome of retried secret: %+v", retried)
+	}
+}
+
+func TestMount_SameSecretPlainAndBase64Encoding_ReturnDecodedAndEncodedContent(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionNumber: 1, FileName: "foo-plain"},
+		{Name: "foo", VersionNumber: 1, FileName: "foo-base64", Encoding: types.Base64Encoding},
+	}
+	mockBundles := []*types.SecretBundle{
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 1, FileName: "foo-plain",
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		},
+		{
+			ID: "uid1", Name: "foo", VersionNumber: 1, FileName: "foo-base64", Encoding: types.Base64Encoding,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+		},
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	response, err := providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 2 {
+		t.Fatalf("Unexpected files: %v", response.Files)
+	}
+	if string(response.Files[0].Contents) != "bar1" {
+		t.Errorf("Unexpected plain content: %v", string(response.Files[0].Contents))
+	}
+	if string(response.Files[1].Contents) != "YmFyMQ==" {
+		t.Errorf("Unexpected base64 content: %v", string(response.Files[1].Contents))
+	}
+}
+
+func TestMount_ObjectVersionModes_ReturnNumberOrContentHash(t *testing.T) {
+	mountVersion := func(config Config, versionNumber int64, content string) string {
+		bundle := newPendingDeletionBundle()
+		bundle.TimeOfDeletion = nil
+		bundle.VersionNumber = versionNumber
+		bundle.BundleContent.Content = content
+		response, err := mountBundle(t, config, bundle)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return response.ObjectVersion[0].Version
+	}
+
+	// numeric versions change with the version number, even if the content doesn't
+	if first, second := mountVersion(Config{}, 1, "YmFy"), mountVersion(Config{}, 2, "YmFy"); first == second {
+		t.Errorf("Numeric versions should differ: %v, %v", first, second)
+	} else if first != "1" {
+		t.Errorf("Unexpected numeric version: %v", first)
+	}
+
+	hashConfig := Config{ContentHashObjectVersion: true}
+	first, second := mountVersion(hashConfig, 1, "YmFy"), mountVersion(hashConfig, 2, "YmFy")
+	if first != second {
+		t.Errorf("Content hash versions of the same content should be equal: %v, %v", first, second)
+	}
+	if !strings.HasPrefix(first, "sha256-") || len(first) != len("sha256-")+16 {
+		t.Errorf("Unexpected content hash version: %v", first)
+	}
+	if changed := mountVersion(hashConfig, 2, "YmF6"); changed == first {
+		t.Errorf("Content hash version should change with the content: %v", changed)
+	}
+}
+
+func TestMount_JSONSecretFannedOutByKeys_ReturnValues(t *testing.T) {
+	secretJSON := base64.StdEncoding.EncodeToString([]byte(`{"user": "admin", "db": {"password": "s3cr3t"}}`))
+	secretBundleRequests := []*types.SecretBundleRequest{
+		{Name: "creds", VersionNumber: 1, FileName: "user", JSONKey: "user"},
+		{Name: "creds", VersionNumber: 1, FileName: "password", JSONKey: "db.password"},
+	}
+	mockBundles := make([]*types.SecretBundle, len(secretBundleRequests))
+	for i, request := range secretBundleRequests {
+		mockBundles[i] = &types.SecretBundle{
+			ID: "uid1", Name: "creds", VersionNumber: 1, FileName: request.FileName, JSONKey: request.JSONKey,
+			Stages:        []types.Stage{types.Current},
+			BundleContent: &types.SecretBundleContent{Content: secretJSON, ContentType: types.Base64},
+		}
+	}
+	providerServer := &ProviderServer{
+		secretService: &mockSecretService{requestsMock: secretBundleRequests, bundlesMock: mockBundles},
+	}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, testVaultID)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	response, err := providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(response.Files) != 2 {
+		t.Fatalf("Unexpected files: %v", response.Files)
+	}
+	if response.Files[0].Path != "user" || string(response.Files[0].Contents) != "admin" {
+		t.Errorf("Unexpected file %v: %v", response.Files[0].Path, string(response.Files[0].Contents))
+	}
+	if response.Files[1].Path != "password" || string(response.Files[1].Contents) != "s3cr3t" {
+		t.Errorf("Unexpected file %v: %v", response.Files[1].Path, string(response.Files[1].Contents))
+	}
+}
+
+func TestMount_JSONKeyMissed_ReturnErrorNamingSecretAndKey(t *testing.T) {
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	bundle.JSONKey = "db.user"
+	bundle.BundleContent.Content = base64.StdEncoding.EncodeToString([]byte(`{"db": {"password": "s3cr3t"}}`))
+
+	_, err := mountBundle(t, Config{}, bundle)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), `unable to extract JSON key "db.user" from secret foo: key "db.user" is missing`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMount_ContentInExpectedFormat_ReturnSecret(t *testing.T) {
+	for _, encoding := range []types.Encoding{types.PlainEncoding, types.Base64Encoding} {
+		bundle := newPendingDeletionBundle()
+		bundle.TimeOfDeletion = nil
+		bundle.Format = types.JSONFormat
+		bundle.Encoding = encoding
+		bundle.BundleContent.Content = base64.StdEncoding.EncodeToString([]byte(`{"user": "admin"}`))
+
+		response, err := mountBundle(t, Config{}, bundle)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", encoding, err)
+		}
+		if len(response.Files) != 1 {
+			t.Errorf("Unexpected files: %v", response.Files)
+		}
+	}
+}
+
+func TestMount_ContentNotInExpectedFormat_ReturnErrorNamingSecretAndFormat(t *testing.T) {
+	bundle := newPendingDeletionBundle()
+	bundle.TimeOfDeletion = nil
+	bundle.Format = types.PEMFormat
+
+	_, err := mountBundle(t, Config{}, bundle)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), "secret foo is expected to be pem: no PEM block found") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// newUserAuthSecret creates user auth config secret, referencing the private key file if the path is set
+func newUserAuthSecret(privateKey string, privateKeyPath string) *core.Secret {
+	config := "auth:\n  region: us-ashburn-1\n  tenancy: tenancy-id\n  user: user-id\n  fingerprint: fingerprint\n"
+	if privateKeyPath != "" {
+		config += "  privateKeyPath: " + privateKeyPath + "\n"
+	}
+	data := map[string][]byte{"config": []byte(config)}
+	if privateKey != "" {
+		data["private-key"] = []byte(privateKey)
+	}
+	return &core.Secret{Data: data}
+}
+
+func writePrivateKeyFile(t *testing.T, dir string, mode os.FileMode) string {
+	keyPath := filepath.Join(dir, "oci_api_key.pem")
+	if err := os.WriteFile(keyPath, []byte("file-key"), mode); err != nil {
+		t.Fatalf("Precondition failed: unable to write private key file: %v", err)
+	}
+	if err := os.Chmod(keyPath, mode); err != nil { // not affected by umask
+		t.Fatalf("Precondition failed: unable to change private key file mode: %v", err)
+	}
+	return keyPath
+}
+
+func TestParseAuthConfig_InlinePrivateKey_ReturnInlineKey(t *testing.T) {
+	authConfig, err := parseAuthConfig(context.Background(), newUserAuthSecret("inline-key", ""), "oci-config", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if authConfig.PrivateKey != "inline-key" || authConfig.UserID != "user-id" {
+		t.Errorf("Wrong auth config: %+v", authConfig)
+	}
+}
+
+func TestParseAuthConfig_PrivateKeyPath_ReturnKeyFromFile(t *testing.T) {
+	keyDir := t.TempDir()
+	keyPath := writePrivateKeyFile(t, keyDir, 0440)
+
+	authConfig, err := parseAuthConfig(context.Background(), newUserAuthSecret("", keyPath), "oci-config", keyDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if authConfig.PrivateKey != "file-key" || authConfig.UserID != "user-id" {
+		t.Errorf("Wrong auth config: %+v", authConfig)
+	}
+}
+
+func TestParseAuthConfig_InlinePrivateKeyAndPath_ReturnError(t *testing.T) {
+	keyDir := t.TempDir()
+	keyPath := writePrivateKeyFile(t, keyDir, 0400)
+
+	_, err := parseAuthConfig(context.Background(), newUserAuthSecret("inline-key", keyPath), "oci-config", keyDir)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasSuffix(err.Error(), "either private-key or privateKeyPath should be set, not both") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestParseAuthConfig_NoPrivateKey_ReturnError(t *testing.T) {
+	_, err := parseAuthConfig(context.Background(), newUserAuthSecret("", ""), "oci-config", "")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "invalid user auth config data: oci-config" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestParseAuthConfig_InvalidPrivateKeyFile_ReturnError(t *testing.T) {
+	keyDir := t.TempDir()
+	oversizedKeyPath := filepath.Join(keyDir, "oversized.pem")
+	if err := os.WriteFile(oversizedKeyPath, make([]byte, maxPrivateKeyFileSize+1), 0400); err != nil {
+		t.Fatalf("Precondition failed: unable to write private key file: %v", err)
+	}
+	subDir := filepath.Join(keyDir, "keys")
+	if err := os.Mkdir(subDir, 0700); err != nil {
+		t.Fatalf("Precondition failed: unable to create directory: %v", err)
+	}
+	fifoPath := filepath.Join(keyDir, "fifo.pem")
+	if err := syscall.Mkfifo(fifoPath, 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to create FIFO: %v", err)
+	}
+	outsideKeyPath := writePrivateKeyFile(t, t.TempDir(), 0400)
+	linkPath := filepath.Join(keyDir, "link.pem")
+	if err := os.Symlink(outsideKeyPath, linkPath); err != nil {
+		t.Fatalf("Precondition failed: unable to create symlink: %v", err)
+	}
+	testCases := map[string]string{
+		writePrivateKeyFile(t, keyDir, 0644): "should not be accessible by others",
+		oversizedKeyPath:                     "exceeds 65536 bytes",
+		subDir:                               "is not a regular file",
+		fifoPath:                             "is not a regular file",
+		filepath.Join(keyDir, "missed.pem"):  "unable to open private key file",
+		"oci_api_key.pem":                    "should be absolute",
+		outsideKeyPath:                       "is not within",
+		filepath.Join(keyDir, "..", "oci_api_key.pem"): "is not within",
+		linkPath: "is not within",
+		filepath.Join(t.TempDir(), "missed", "key.pem"): "is not within",
+	}
+	for keyPath, expectedError := range testCases {
+		_, err := parseAuthConfig(context.Background(), newUserAuthSecret("", keyPath), "oci-config", keyDir)
+		if err == nil {
+			t.Fatalf("An error was expected for %v", keyPath)
+		}
+		if !strings.Contains(err.Error(), expectedError) {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestParseAuthConfig_PrivateKeyDirNotConfigured_ReturnError(t *testing.T) {
+	keyPath := writePrivateKeyFile(t, t.TempDir(), 0400)
+
+	_, err := parseAuthConfig(context.Background(), newUserAuthSecret("", keyPath), "oci-config", "")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasSuffix(err.Error(), "privateKeyPath is not allowed, private key directory is not configured") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestPrivateKeyDir_KeyDirConfigured_ReturnNamespaceSubdirectory(t *testing.T) {
+	server := &ProviderServer{config: Config{PrivateKeyDir: "/etc/oci/keys"}}
+
+	if dir := server.privateKeyDir("team-a"); dir != "/etc/oci/keys/team-a" {
+		t.Errorf("Wrong private key directory: %v", dir)
+	}
+	if dir := (&ProviderServer{}).privateKeyDir("team-a"); dir != "" {
+		t.Errorf("Wrong private key directory: %v", dir)
+	}
+}
+
+func TestMount_MalformedVaultID_ReturnInvalidArgument(t *testing.T) {
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}
+	// the mock service fails unexpected requests with NotFound, so InvalidArgument proves the vault is not requested
+	providerServer := &ProviderServer{secretService: &mockSecretService{}}
+	attributes, err := marshalRequestAttributes(secretBundleRequests, &types.Auth{Type: types.Instance}, "vault1")
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to serialize request attributes")
+	}
+
+	_, err = providerServer.Mount(context.Background(),
+		&provider.MountRequest{Attributes: attributes, Permission: readOnlyFilePermission})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Invalid gRPC code: %v", status.Code(err))
+	}
+	if !strings.Contains(err.Error(), `invalid vaultId of SecretProviderClass: malformed vault OCID "vault1"`) {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
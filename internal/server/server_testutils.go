@@ -7,13 +7,27 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/service"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"gopkg.in/yaml.v3"
 	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
@@ -41,15 +55,25 @@ func marshalRequestAttributes(requests []*types.SecretBundleRequest, auth *types
 type mockSecretService struct {
 	requestsMock []*types.SecretBundleRequest
 	bundlesMock  []*types.SecretBundle
+	errMock      error // returned instead of the bundles when set
 }
 
 func (mockService *mockSecretService) GetSecretBundles(
 	_ context.Context, requests []*types.SecretBundleRequest,
 	auth *types.Auth, vaultID types.VaultID) ([]*types.SecretBundle, error) {
+	if mockService.errMock != nil {
+		return nil, mockService.errMock
+	}
 	if !mockService.matchRequests(requests, mockService.requestsMock) {
-		return nil, fmt.Errorf("such secret requests are not expected")
+		return nil, fmt.Errorf("such secret requests are not expected: %w", service.ErrSecretNotFound)
+	}
+	// bundles are owned by the caller, so the mocked ones are copied
+	bundles := make([]*types.SecretBundle, len(mockService.bundlesMock))
+	for i, bundle := range mockService.bundlesMock {
+		bundleCopy := *bundle
+		bundles[i] = &bundleCopy
 	}
-	return mockService.bundlesMock, nil
+	return bundles, nil
 }
 
 func (mockService *mockSecretService) matchRequests(
@@ -149,3 +173,71 @@ func assertMountResponse(t *testing.T, actual *provider.MountResponse, expected
 		}
 	}
 }
+
+// captureLogs redirects global logger into the buffer until the end of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	originalLogger := log.Logger
+	t.Cleanup(func() { log.Logger = originalLogger })
+
+	buffer := &bytes.Buffer{}
+	log.Logger = zerolog.New(buffer)
+	return buffer
+}
+
+// findLogRecord returns the first JSON log record with the given message.
+func findLogRecord(t *testing.T, logs *bytes.Buffer, message string) map[string]interface{} {
+	t.Helper()
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Malformed log record %v: %v", line, err)
+		}
+		if record["message"] == message {
+			return record
+		}
+	}
+	t.Fatalf("Missed log record: %v", message)
+	return nil
+}
+
+var (
+	installMetricsPipeline sync.Once
+	metricsHandler         http.Handler
+)
+
+// scrapeMetrics returns metrics reported by the tests in Prometheus text format.
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	installMetricsPipeline.Do(func() {
+		// the metrics are collected on each scrape, so it returns the latest values
+		registry := prometheus.NewRegistry()
+		exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry),
+			otelprometheus.WithoutScopeInfo(), otelprometheus.WithoutTargetInfo())
+		if err != nil {
+			t.Fatalf("Unable to install metrics pipeline: %v", err)
+		}
+		otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter)))
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	})
+	recorder := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatalf("Unable to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+// metricValue returns the value of the metric sample with the given name and label, or empty string.
+func metricValue(metrics string, name string, label string) string {
+	for _, line := range strings.Split(metrics, "\n") {
+		if strings.HasPrefix(line, name+"{") && strings.Contains(line, label) {
+			return line[strings.LastIndex(line, " ")+1:]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+// bundleCacheKey identifies cached secret bundle.
+// Principal is a part of the key, so secrets are never shared between different OCI identities.
+type bundleCacheKey struct {
+	principal     string
+	vaultID       string
+	name          string
+	versionNumber types.VersionNumber
+	versionName   string
+	stage         types.Stage
+}
+
+func newBundleCacheKey(auth *types.Auth, vaultID string, request *types.SecretBundleRequest) bundleCacheKey {
+	return bundleCacheKey{
+		principal:     auth.PrincipalKey(),
+		vaultID:       vaultID,
+		name:          request.Name,
+		versionNumber: request.VersionNumber,
+		versionName:   request.VersionName,
+		stage:         request.Stage,
+	}
+}
+
+type bundleCacheEntry struct {
+	bundle    *types.SecretBundle
+	expiresAt time.Time
+}
+
+// bundleCache stores secret bundles retrieved from OCI Vault for a limited time.
+// Zero value is an empty cache ready to use.
+type bundleCache struct {
+	mutex   sync.Mutex
+	entries map[bundleCacheKey]bundleCacheEntry
+	clock   clock.Clock // system clock when nil
+}
+
+func (cache *bundleCache) get(key bundleCacheKey) (*types.SecretBundle, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !cache.currentTime().Before(entry.expiresAt) {
+		delete(cache.entries, key)
+		return nil, false
+	}
+	return entry.bundle, true
+}
+
+func (cache *bundleCache) put(key bundleCacheKey, bundle *types.SecretBundle, ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[bundleCacheKey]bundleCacheEntry)
+	}
+	now := cache.currentTime()
+	cache.evictExpired(now)
+	cache.entries[key] = bundleCacheEntry{bundle: bundle, expiresAt: now.Add(ttl)}
+}
+
+// evictExpired drops outdated entries, so the cache never outgrows the set of recently requested secrets.
+func (cache *bundleCache) evictExpired(now time.Time) {
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func (cache *bundleCache) currentTime() time.Time {
+	return clock.OrReal(cache.clock).Now()
+}
@@ -0,0 +1,62 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+type callCounterKey struct{}
+
+// CallCounter counts OCI API calls made on behalf of a single request.
+// Secrets served from cache are not counted.
+type CallCounter struct {
+	calls int32
+}
+
+// WithCallCounter returns a context carrying a new CallCounter.
+func WithCallCounter(ctx context.Context) (context.Context, *CallCounter) {
+	counter := &CallCounter{}
+	return context.WithValue(ctx, callCounterKey{}, counter), counter
+}
+
+// Calls returns the number of counted OCI API calls.
+func (counter *CallCounter) Calls() int {
+	return int(atomic.LoadInt32(&counter.calls))
+}
+
+// countCall increments the counter carried by the context, if any.
+func countCall(ctx context.Context) {
+	if counter, ok := ctx.Value(callCounterKey{}).(*CallCounter); ok {
+		atomic.AddInt32(&counter.calls, 1)
+	}
+}
+
+type attemptCounterKey struct{}
+
+// withAttemptCounter returns a context counting HTTP calls of a single secret fetch, including OCI SDK retries.
+func withAttemptCounter(ctx context.Context) (context.Context, *int32) {
+	attempts := new(int32)
+	return context.WithValue(ctx, attemptCounterKey{}, attempts), attempts
+}
+
+// attemptCountingDispatcher increments the attempt counter carried by the request context, if any.
+// OCI SDK retries the call with the same context, so each retry is counted.
+type attemptCountingDispatcher struct {
+	dispatcher common.HTTPRequestDispatcher
+}
+
+func (countingDispatcher *attemptCountingDispatcher) Do(request *http.Request) (*http.Response, error) {
+	if attempts, ok := request.Context().Value(attemptCounterKey{}).(*int32); ok {
+		atomic.AddInt32(attempts, 1)
+	}
+	return countingDispatcher.dispatcher.Do(request)
+}
@@ -0,0 +1,147 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// callOutcome tells how an OCI Vault call reflects the health of the vault
+type callOutcome int
+
+const (
+	// callSucceeded is any answer of the vault, including refusals like missing secret
+	callSucceeded callOutcome = iota
+	// callFailed is a failure of the vault or the way to it, e.g. server error, throttling or timeout
+	callFailed
+	// callAbandoned says nothing about the vault, e.g. the mount is canceled
+	callAbandoned
+)
+
+// outcomeOf classifies the error of the call made within the mount context
+func outcomeOf(ctx context.Context, err error) callOutcome {
+	if err == nil {
+		return callSucceeded
+	}
+	if ctx.Err() != nil {
+		return callAbandoned
+	}
+	var sizeErr *responseSizeError
+	if errors.As(err, &sizeErr) {
+		return callSucceeded
+	}
+	var serviceError common.ServiceError
+	if errors.As(err, &serviceError) && serviceError.GetHTTPStatusCode() < http.StatusInternalServerError &&
+		serviceError.GetHTTPStatusCode() != http.StatusTooManyRequests {
+		return callSucceeded
+	}
+	return callFailed
+}
+
+// circuitBreaker fails OCI Vault calls fast while a vault keeps failing, so mounts don't pile their retries up
+// on an outage. Once the calls to a vault fail a number of times in a row, its circuit opens for a cooldown
+// rejecting the calls, then a single probe call is let through: its success closes the circuit, its failure
+// opens it again. The circuits are keyed by the caller, see circuitKey. Zero value has all circuits closed.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	circuits map[string]*circuit // by circuit key, only the circuits which calls are failing
+	clock    clock.Clock         // system clock when nil
+}
+
+type circuit struct {
+	failures  int       // consecutive failures while closed
+	openUntil time.Time // zero while closed
+	probing   bool      // the probe call is in progress, the others are still rejected
+}
+
+// allow reserves the call unless the circuit of the key is open. The caller reports the outcome of the call
+// with the returned function. The circuit opens after failureThreshold consecutive failures.
+func (breaker *circuitBreaker) allow(
+	key string, failureThreshold int, cooldown time.Duration) (func(callOutcome), error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	now := clock.OrReal(breaker.clock).Now()
+	current := breaker.circuits[key]
+	probe := false
+	if current != nil && !current.openUntil.IsZero() {
+		if current.probing || now.Before(current.openUntil) {
+			return nil, &circuitOpenError{failures: failureThreshold, openUntil: current.openUntil}
+		}
+		current.probing = true
+		probe = true
+	}
+	return func(outcome callOutcome) {
+		breaker.record(key, probe, outcome, failureThreshold, cooldown)
+	}, nil
+}
+
+func (breaker *circuitBreaker) record(
+	key string, probe bool, outcome callOutcome, failureThreshold int, cooldown time.Duration) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	current := breaker.circuits[key]
+	switch outcome {
+	case callSucceeded:
+		// calls started before the circuit opened don't close it, only the probe does
+		if current != nil && (probe || current.openUntil.IsZero()) {
+			delete(breaker.circuits, key)
+		}
+	case callFailed:
+		if current == nil {
+			if breaker.circuits == nil {
+				breaker.circuits = make(map[string]*circuit)
+			}
+			current = &circuit{}
+			breaker.circuits[key] = current
+		}
+		if current.openUntil.IsZero() {
+			current.failures++
+		}
+		if probe || (current.openUntil.IsZero() && current.failures >= failureThreshold) {
+			current.openUntil = clock.OrReal(breaker.clock).Now().Add(cooldown)
+			current.probing = false
+		}
+	case callAbandoned:
+		if probe && current != nil {
+			current.probing = false
+		}
+	}
+}
+
+// circuitKey separates the circuits of the vault by principal and region, since failures like throttling
+// or timeouts could be caused by the caller, e.g. its tenancy limits or a misconfigured region,
+// so a failing tenant doesn't suspend the calls of the others
+func circuitKey(auth *types.Auth, vaultID string) string {
+	return strings.Join([]string{vaultID, auth.ExplicitRegion(), auth.PrincipalKey()}, "|")
+}
+
+// circuitOpenError is returned instead of calling the vault which circuit is open
+type circuitOpenError struct {
+	failures  int
+	openUntil time.Time
+}
+
+func (err *circuitOpenError) Error() string {
+	return fmt.Sprintf("OCI Vault calls are suspended until %v after %d consecutive failures",
+		err.openUntil.UTC().Format(time.RFC3339), err.failures)
+}
+
+func (err *circuitOpenError) Is(target error) bool {
+	return target == ErrVaultUnavailable
+}
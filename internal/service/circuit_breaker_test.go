@@ -0,0 +1,137 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+)
+
+// callThrough makes a call to the vault with the given outcome, unless the breaker rejects it
+func callThrough(t *testing.T, breaker *circuitBreaker, vaultID string, outcome callOutcome) error {
+	t.Helper()
+	done, err := breaker.allow(vaultID, 3, time.Minute)
+	if err != nil {
+		return err
+	}
+	done(outcome)
+	return nil
+}
+
+func TestCircuitBreaker_ConsecutiveFailures_OpenCircuitOfVault(t *testing.T) {
+	breaker := &circuitBreaker{clock: clock.NewFake(time.Now())}
+
+	for i := 0; i < 3; i++ {
+		if err := callThrough(t, breaker, "vault1", callFailed); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	err := callThrough(t, breaker, "vault1", callSucceeded)
+	if !errors.Is(err, ErrVaultUnavailable) {
+		t.Fatalf("Call should be rejected: %v", err)
+	}
+	if err := callThrough(t, breaker, "vault2", callSucceeded); err != nil {
+		t.Errorf("Calls to other vaults should be allowed: %v", err)
+	}
+}
+
+func TestCircuitBreaker_FailuresInterruptedBySuccess_KeepCircuitClosed(t *testing.T) {
+	breaker := &circuitBreaker{clock: clock.NewFake(time.Now())}
+
+	for _, outcome := range []callOutcome{callFailed, callFailed, callSucceeded, callFailed, callFailed, callAbandoned} {
+		if err := callThrough(t, breaker, "vault1", outcome); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestCircuitBreaker_CooldownElapsed_LetSingleProbeThrough(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	breaker := &circuitBreaker{clock: fakeClock}
+	for i := 0; i < 3; i++ {
+		_ = callThrough(t, breaker, "vault1", callFailed)
+	}
+
+	fakeClock.Advance(time.Minute)
+	done, err := breaker.allow("vault1", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("Probe should be allowed: %v", err)
+	}
+	if _, err := breaker.allow("vault1", 3, time.Minute); !errors.Is(err, ErrVaultUnavailable) {
+		t.Fatalf("Calls should be rejected while probing: %v", err)
+	}
+	done(callSucceeded)
+
+	for i := 0; i < 2; i++ {
+		if err := callThrough(t, breaker, "vault1", callFailed); err != nil {
+			t.Fatalf("Closed circuit should count failures from zero: %v", err)
+		}
+	}
+}
+
+func TestCircuitBreaker_ProbeFailed_ReopenCircuit(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	breaker := &circuitBreaker{clock: fakeClock}
+	for i := 0; i < 3; i++ {
+		_ = callThrough(t, breaker, "vault1", callFailed)
+	}
+
+	fakeClock.Advance(time.Minute)
+	if err := callThrough(t, breaker, "vault1", callFailed); err != nil {
+		t.Fatalf("Probe should be allowed: %v", err)
+	}
+	if err := callThrough(t, breaker, "vault1", callSucceeded); !errors.Is(err, ErrVaultUnavailable) {
+		t.Fatalf("Call should be rejected: %v", err)
+	}
+	fakeClock.Advance(time.Minute)
+	if err := callThrough(t, breaker, "vault1", callSucceeded); err != nil {
+		t.Errorf("Probe should be allowed after another cooldown: %v", err)
+	}
+}
+
+func TestCircuitBreaker_ProbeAbandoned_LetNextProbeThrough(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	breaker := &circuitBreaker{clock: fakeClock}
+	for i := 0; i < 3; i++ {
+		_ = callThrough(t, breaker, "vault1", callFailed)
+	}
+
+	fakeClock.Advance(time.Minute)
+	if err := callThrough(t, breaker, "vault1", callAbandoned); err != nil {
+		t.Fatalf("Probe should be allowed: %v", err)
+	}
+	if err := callThrough(t, breaker, "vault1", callSucceeded); err != nil {
+		t.Errorf("Next probe should be allowed: %v", err)
+	}
+}
+
+func TestOutcomeOf_Errors_ClassifyVaultHealth(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testCases := []struct {
+		ctx      context.Context
+		err      error
+		expected callOutcome
+	}{
+		{context.Background(), nil, callSucceeded},
+		{context.Background(), &responseSizeError{size: 10, maxSize: 1}, callSucceeded},
+		{context.Background(), fmt.Errorf("wrapped: %w", context.DeadlineExceeded), callFailed},
+		{context.Background(), errors.New("connection refused"), callFailed},
+		{canceledCtx, context.Canceled, callAbandoned},
+	}
+	for _, testCase := range testCases {
+		if outcome := outcomeOf(testCase.ctx, testCase.err); outcome != testCase.expected {
+			t.Errorf("Wrong outcome of %v: %v", testCase.err, outcome)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+// fetchCall is a fetch in flight, the result is set before done is closed
+type fetchCall struct {
+	done   chan struct{}
+	bundle *types.SecretBundle
+	err    error
+	// retry tells the waiting callers to fetch on their own, since the fetch was canceled along with its caller
+	retry bool
+}
+
+// fetchGroup coalesces concurrent fetches of the same secret bundle, e.g. of the replicas scheduled to the node
+// at once, so they share a single OCI Vault call. It's keyed like the cache, so different principals never share
+// the bundles. Zero value is ready to use.
+type fetchGroup struct {
+	mutex sync.Mutex
+	calls map[bundleCacheKey]*fetchCall
+}
+
+// do runs the fetch unless the same bundle is already being fetched, then the result of that fetch is returned
+// once it's done. shared reports the result fetched by another caller.
+func (group *fetchGroup) do(ctx context.Context, key bundleCacheKey,
+	fetch func() (*types.SecretBundle, error)) (bundle *types.SecretBundle, shared bool, err error) {
+	for {
+		group.mutex.Lock()
+		call, ok := group.calls[key]
+		if !ok {
+			call = &fetchCall{done: make(chan struct{})}
+			if group.calls == nil {
+				group.calls = make(map[bundleCacheKey]*fetchCall)
+			}
+			group.calls[key] = call
+			group.mutex.Unlock()
+			group.run(ctx, key, call, fetch)
+			return call.bundle, false, call.err
+		}
+		group.mutex.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if !call.retry {
+			return call.bundle, true, call.err
+		}
+	}
+}
+
+func (group *fetchGroup) run(ctx context.Context, key bundleCacheKey,
+	call *fetchCall, fetch func() (*types.SecretBundle, error)) {
+	// the waiting callers retry the fetch which didn't complete, e.g. panicked
+	call.retry = true
+	defer func() {
+		group.mutex.Lock()
+		delete(group.calls, key)
+		group.mutex.Unlock()
+		close(call.done)
+	}()
+	call.bundle, call.err = fetch()
+	call.retry = ctx.Err() != nil
+}
@@ -0,0 +1,97 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+func TestFetchGroup_FetchDone_ReturnFetchedBundle(t *testing.T) {
+	group := &fetchGroup{}
+	key := bundleCacheKey{name: "foo"}
+
+	bundle, shared, err := group.do(context.Background(), key, func() (*types.SecretBundle, error) {
+		return &types.SecretBundle{Name: "foo"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if bundle.Name != "foo" || shared {
+		t.Errorf("Unexpected result: %+v, shared: %v", bundle, shared)
+	}
+	if len(group.calls) != 0 {
+		t.Errorf("Finished fetch should be forgotten: %v", group.calls)
+	}
+}
+
+func TestFetchGroup_FirstCallerCanceled_WaitingCallerFetchesAgain(t *testing.T) {
+	group := &fetchGroup{}
+	key := bundleCacheKey{name: "foo"}
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	firstDone := make(chan error)
+	go func() {
+		_, _, err := group.do(canceledCtx, key, func() (*types.SecretBundle, error) {
+			close(started)
+			<-canceledCtx.Done()
+			return nil, canceledCtx.Err()
+		})
+		firstDone <- err
+	}()
+	<-started
+
+	secondDone := make(chan *types.SecretBundle)
+	go func() {
+		bundle, _, err := group.do(context.Background(), key, func() (*types.SecretBundle, error) {
+			return &types.SecretBundle{Name: "foo"}, nil
+		})
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		secondDone <- bundle
+	}()
+	cancel()
+
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("Canceled error was expected: %v", err)
+	}
+	if bundle := <-secondDone; bundle == nil || bundle.Name != "foo" {
+		t.Errorf("Bundle should be fetched again: %+v", bundle)
+	}
+}
+
+func TestFetchGroup_WaitingCallerCanceled_ReturnContextError(t *testing.T) {
+	group := &fetchGroup{}
+	key := bundleCacheKey{name: "foo"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _, _ = group.do(context.Background(), key, func() (*types.SecretBundle, error) {
+			close(started)
+			<-release
+			return &types.SecretBundle{Name: "foo"}, nil
+		})
+	}()
+	defer close(release)
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := group.do(ctx, key, func() (*types.SecretBundle, error) {
+		t.Error("Fetch in progress should not be repeated")
+		return nil, nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Canceled error was expected: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+)
+
+// jitterDelay returns a random delay below maxJitter.
+// Zero delay is returned when the jitter is disabled or it would take more than half of the time left
+// until the context deadline, so the jitter never causes the mount to time out.
+func jitterDelay(ctx context.Context, clock clock.Clock, maxJitter time.Duration,
+	randomInt63n func(int64) int64) time.Duration {
+	if maxJitter <= 0 {
+		return 0
+	}
+	delay := time.Duration(randomInt63n(int64(maxJitter)))
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(clock.Now()) < 2*delay {
+		return 0
+	}
+	return delay
+}
+
+// waitJitter blocks for the delay or until the context is done.
+func waitJitter(ctx context.Context, clock clock.Clock, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
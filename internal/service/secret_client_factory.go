@@ -7,8 +7,15 @@
 package service
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
@@ -17,7 +24,16 @@ import (
 	"github.com/oracle/oci-go-sdk/v65/secrets"
 )
 
-const httpClientTimeout = 20 * time.Second
+const defaultHTTPClientTimeout = 20 * time.Second
+
+// dialKeepAlive is the keep-alive period of connections, the same as of SDK transport
+const dialKeepAlive = 30 * time.Second
+
+// imdsRegionPath is the instance metadata endpoint used by instance principal to discover the region
+const imdsRegionPath = "/instance/region"
+
+// costCenterHeader carries the cost-center tag of OCI Vault calls for cost attribution
+const costCenterHeader = "X-Cost-Center"
 
 type SecretClientFactory interface {
 	createSecretClient(
@@ -25,13 +41,77 @@ type SecretClientFactory interface {
 	createConfigProvider(auth *types.Auth) (common.ConfigurationProvider, error)
 }
 
-type OCISecretClientFactory struct{}
+// OCISecretClientFactory creates OCI Vault clients, optionally targeting a private endpoint.
+type OCISecretClientFactory struct {
+	// endpoint overrides OCI Vault secrets endpoint derived from the region
+	endpoint string
+	// rootCAs verify the endpoint certificate instead of system authorities when set
+	rootCAs *x509.CertPool
+	// costCenter tags the OCI Vault calls when set
+	costCenter string
+	// maxResponseSize limits the size in bytes of OCI Vault responses, unlimited when zero
+	maxResponseSize int64
+	// httpTimeout bounds each HTTP call to OCI, defaultHTTPClientTimeout when zero
+	httpTimeout time.Duration
+	// dialTimeout bounds establishing of connections to OCI, the transport's own timeout is used when zero
+	dialTimeout time.Duration
+}
+
+func newOCISecretClientFactory(endpoint string, caBundleFile string,
+	costCenter string) (*OCISecretClientFactory, error) {
+	factory := &OCISecretClientFactory{endpoint: endpoint, costCenter: costCenter}
+	if caBundleFile == "" {
+		return factory, nil
+	}
+	caBundle, err := os.ReadFile(caBundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	factory.rootCAs = x509.NewCertPool()
+	if !factory.rootCAs.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no valid certificates found in CA bundle %v", caBundleFile)
+	}
+	return factory, nil
+}
 
 func (factory *OCISecretClientFactory) createSecretClient( //nolint:ireturn // factory method
 	configProvider common.ConfigurationProvider) (OCISecretClient, error) {
 
-	return secrets.NewSecretsClientWithConfigurationProvider(configProvider)
+	client, err := secrets.NewSecretsClientWithConfigurationProvider(configProvider)
+	if err != nil {
+		return nil, err
+	}
+	if factory.endpoint != "" {
+		client.Host = factory.endpoint
+	}
+	if factory.rootCAs != nil {
+		// SDK transport keeps honoring proxy settings from the environment, e.g. HTTPS_PROXY
+		transport, err := common.DefaultTransport(&tls.Config{RootCAs: factory.rootCAs, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	// SDK default timeout is longer, so the timeouts are set the same way for all principal types
+	if client.HTTPClient, err = factory.setHTTPClientTimeouts()(client.HTTPClient); err != nil {
+		return nil, err
+	}
+	if factory.costCenter != "" {
+		client.Interceptor = withCostCenter(factory.costCenter)
+	}
+	client.HTTPClient = &attemptCountingDispatcher{
+		dispatcher: withResponseSizeLimit(withEndpointResponseCheck(client.HTTPClient), factory.maxResponseSize),
+	}
+	return client, nil
+}
 
+// withCostCenter adds the cost-center header to the request. The interceptor is called before signing,
+// the header is not among the signed ones, so the signature is not affected.
+func withCostCenter(costCenter string) common.RequestInterceptor {
+	return func(request *http.Request) error {
+		request.Header.Set(costCenterHeader, costCenter)
+		return nil
+	}
 }
 
 func (factory *OCISecretClientFactory) createConfigProvider( //nolint:ireturn // factory method
@@ -41,7 +121,11 @@ func (factory *OCISecretClientFactory) createConfigProvider( //nolint:ireturn //
 
 	case types.Instance:
 		// note that we set timeout for HTTP client because it is absent by default
-		return auth.InstancePrincipalConfigurationProviderWithCustomClient(setHTTPClientTimeout(httpClientTimeout))
+		if authCfg.Region != "" {
+			return auth.InstancePrincipalConfigurationForRegionWithCustomClient(common.StringToRegion(authCfg.Region),
+				factory.instancePrincipalClient(authCfg.Region))
+		}
+		return auth.InstancePrincipalConfigurationProviderWithCustomClient(factory.instancePrincipalClient(""))
 
 	case types.User:
 		cfg := authCfg.Config
@@ -49,24 +133,297 @@ func (factory *OCISecretClientFactory) createConfigProvider( //nolint:ireturn //
 			cfg.Region, cfg.Fingerprint, cfg.PrivateKey, &cfg.Passphrase), nil
 
 	case types.Workload:
-		return auth.OkeWorkloadIdentityConfigurationProviderWithServiceAccountTokenProvider(
+		configProvider, err := auth.OkeWorkloadIdentityConfigurationProviderWithServiceAccountTokenProvider(
 			auth.NewSuppliedServiceAccountTokenProvider(string(authCfg.WorkloadIdentityCfg.SaToken)))
+		if err != nil || authCfg.WorkloadIdentityCfg.Region == "" {
+			return configProvider, err
+		}
+		return &regionOverrideProvider{ConfigurationProvider: configProvider, region: authCfg.WorkloadIdentityCfg.Region}, nil
+
+	case types.Resource:
+		// SDK reads resource principal settings from the environment, e.g. OCI_RESOURCE_PRINCIPAL_VERSION
+		configProvider, err := auth.ResourcePrincipalConfigurationProvider()
+		if err != nil {
+			return nil, fmt.Errorf("unable to create resource principal configuration provider: %w", err)
+		}
+		return configProvider, nil
+
+	case types.File:
+		configProvider, err := common.ConfigurationProviderFromFileWithProfile(
+			authCfg.ConfigFile.Path, authCfg.ConfigFile.Profile, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to create config file configuration provider: %w", err)
+		}
+		return configProvider, nil
 
 	default:
 		return nil, fmt.Errorf("unable to determine OCI principal type for configuration provider")
 	}
 }
 
-func setHTTPClientTimeout(
-	timeout time.Duration) func(common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+// regionOverrideProvider makes the secrets client target the region other than the principal's one,
+// while the credentials are still provided by the wrapped provider.
+type regionOverrideProvider struct {
+	common.ConfigurationProvider
+	region string
+}
+
+func (provider *regionOverrideProvider) Region() (string, error) {
+	return provider.region, nil
+}
+
+// Refreshable keeps refreshing of the wrapped provider's token on authentication errors.
+func (provider *regionOverrideProvider) Refreshable() bool {
+	refreshable, ok := provider.ConfigurationProvider.(common.RefreshableConfigurationProvider)
+	return ok && refreshable.Refreshable()
+}
+
+// httpClientTimeout returns the timeout of HTTP calls to OCI
+func (factory *OCISecretClientFactory) httpClientTimeout() time.Duration {
+	if factory.httpTimeout > 0 {
+		return factory.httpTimeout
+	}
+	return defaultHTTPClientTimeout
+}
+
+// instancePrincipalClient modifies the client of instance principal authentication, i.e. the calls
+// to instance metadata service and identity service, to time out like the OCI Vault calls.
+// The region discovery is answered with the region, if it's configured.
+func (factory *OCISecretClientFactory) instancePrincipalClient(
+	region string) func(common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+
+	if region == "" {
+		return factory.setHTTPClientTimeouts()
+	}
+	return withStaticRegion(factory.setHTTPClientTimeouts(), region)
+}
+
+// setHTTPClientTimeouts returns the modifier applying the timeouts of the factory to HTTP client
+func (factory *OCISecretClientFactory) setHTTPClientTimeouts() func(
+	common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+
+	return setHTTPClientTimeout(factory.httpClientTimeout(), factory.dialTimeout)
+}
+
+// setHTTPClientTimeout bounds each call of the client by the timeout, and establishing of its connections
+// by the dial timeout, so an unreachable endpoint fails fast while a slow response is still awaited.
+// The connections are established within the transport's own timeout when the dial timeout is zero.
+func setHTTPClientTimeout(timeout time.Duration,
+	dialTimeout time.Duration) func(common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
 
 	return func(dispatcher common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
 		switch client := dispatcher.(type) {
 		case *http.Client:
+			transport, err := withDialTimeout(client.Transport, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
 			client.Timeout = timeout
+			client.Transport = transport
 			return dispatcher, nil
 		default:
 			return nil, fmt.Errorf("unable to modify unknown HTTP client type")
 		}
 	}
 }
+
+// withDialTimeout returns the transport establishing connections within the timeout,
+// the transport is returned as is when the timeout is zero.
+// SDK transport wrapper recreates its transport when custom certificates change, so the dial timeout is set
+// on each recreated transport.
+func withDialTimeout(transport http.RoundTripper, timeout time.Duration) (http.RoundTripper, error) {
+	if timeout <= 0 {
+		return transport, nil
+	}
+	switch typedTransport := transport.(type) {
+	case nil:
+		return withDialTimeout(common.CloneHTTPDefaultTransport(), timeout)
+	case *http.Transport:
+		timeoutTransport := typedTransport.Clone()
+		timeoutTransport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: dialKeepAlive}).DialContext
+		return timeoutTransport, nil
+	case *common.OciHTTPTransportWrapper:
+		template := typedTransport.TransportTemplate
+		typedTransport.TransportTemplate = func(tlsClientConfig *tls.Config) (http.RoundTripper, error) {
+			delegate, err := template.NewOrDefault(tlsClientConfig)
+			if err != nil {
+				return nil, err
+			}
+			return withDialTimeout(delegate, timeout)
+		}
+		return typedTransport, nil
+	default:
+		return nil, fmt.Errorf("unable to set dial timeout of unknown HTTP transport type %T", transport)
+	}
+}
+
+// withStaticRegion chains the dispatcher modifier with staticRegionDispatcher.
+func withStaticRegion(
+	modifier func(common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error),
+	region string) func(common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+
+	return func(dispatcher common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+		modifiedDispatcher, err := modifier(dispatcher)
+		if err != nil {
+			return nil, err
+		}
+		return &staticRegionDispatcher{dispatcher: modifiedDispatcher, region: region}, nil
+	}
+}
+
+// staticRegionDispatcher answers the instance principal's region discovery with the preconfigured region,
+// so the instance metadata service is used only to retrieve instance certificates.
+type staticRegionDispatcher struct {
+	dispatcher common.HTTPRequestDispatcher
+	region     string
+}
+
+func (regionDispatcher *staticRegionDispatcher) Do(request *http.Request) (*http.Response, error) {
+	if request.Method != http.MethodGet || !strings.HasSuffix(request.URL.Path, imdsRegionPath) {
+		return regionDispatcher.dispatcher.Do(request)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		Body:          io.NopCloser(strings.NewReader(regionDispatcher.region)),
+		ContentLength: int64(len(regionDispatcher.region)),
+		Request:       request,
+	}, nil
+}
+
+// endpointResponseError is returned when the response doesn't come from OCI Vault,
+// e.g. it's a redirect or an HTML error page of a misconfigured proxy.
+type endpointResponseError struct {
+	message string
+}
+
+func (err *endpointResponseError) Error() string {
+	return err.message
+}
+
+// withEndpointResponseCheck wraps the dispatcher with endpointCheckingDispatcher.
+// Redirects are not followed, OCI Vault doesn't redirect, so a redirect is reported as misconfiguration.
+func withEndpointResponseCheck( //nolint:ireturn // wrapped dispatcher
+	dispatcher common.HTTPRequestDispatcher) common.HTTPRequestDispatcher {
+
+	if client, ok := dispatcher.(*http.Client); ok {
+		notRedirectingClient := *client
+		notRedirectingClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		dispatcher = &notRedirectingClient
+	}
+	return &endpointCheckingDispatcher{dispatcher: dispatcher}
+}
+
+// endpointCheckingDispatcher fails the calls answered by something other than OCI Vault,
+// instead of letting SDK fail opaquely on decoding of the unexpected body.
+type endpointCheckingDispatcher struct {
+	dispatcher common.HTTPRequestDispatcher
+}
+
+func (checkingDispatcher *endpointCheckingDispatcher) Do(request *http.Request) (*http.Response, error) {
+	response, err := checkingDispatcher.dispatcher.Do(request)
+	if err != nil {
+		return response, err
+	}
+	if err := checkEndpointResponse(response); err != nil {
+		common.CloseBodyIfValid(response)
+		return nil, err
+	}
+	return response, nil
+}
+
+// checkEndpointResponse accepts JSON responses only, both successful and service errors are JSON in OCI Vault.
+// Responses without body are left to SDK, which reports them by the status code.
+func checkEndpointResponse(response *http.Response) error {
+	if response.StatusCode >= http.StatusMultipleChoices && response.StatusCode < http.StatusBadRequest {
+		return &endpointResponseError{message: fmt.Sprintf(
+			"OCI Vault endpoint responded with redirect (status %d) to %q, check proxy and endpoint configuration",
+			response.StatusCode, response.Header.Get("Location"))}
+	}
+	if response.ContentLength == 0 {
+		return nil
+	}
+	contentType := response.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		return &endpointResponseError{message: fmt.Sprintf(
+			"OCI Vault endpoint responded with unexpected content type %q (status %d), "+
+				"check proxy and endpoint configuration", contentType, response.StatusCode)}
+	}
+	return nil
+}
+
+// responseSizeError is returned when OCI Vault response is larger than the configured limit
+type responseSizeError struct {
+	size    int64 // the response is read up to the limit when the size is not declared, i.e. negative
+	maxSize int64
+}
+
+func (err *responseSizeError) Error() string {
+	if err.size < 0 {
+		return fmt.Sprintf("OCI Vault response exceeds the limit of %d bytes", err.maxSize)
+	}
+	return fmt.Sprintf("OCI Vault response of %d bytes exceeds the limit of %d bytes", err.size, err.maxSize)
+}
+
+// withResponseSizeLimit wraps the dispatcher with sizeLimitingDispatcher, unless the limit is zero
+func withResponseSizeLimit( //nolint:ireturn // wrapped dispatcher
+	dispatcher common.HTTPRequestDispatcher, maxSize int64) common.HTTPRequestDispatcher {
+
+	if maxSize <= 0 {
+		return dispatcher
+	}
+	return &sizeLimitingDispatcher{dispatcher: dispatcher, maxSize: maxSize}
+}
+
+// sizeLimitingDispatcher fails the calls which response is larger than the limit. OCI Vault has no metadata call
+// declaring the size of secret content, so the declared length of the response is checked before its body is read,
+// and the oversized secret is not transferred. The body of undeclared length is read up to the limit.
+type sizeLimitingDispatcher struct {
+	dispatcher common.HTTPRequestDispatcher
+	maxSize    int64
+}
+
+func (limitingDispatcher *sizeLimitingDispatcher) Do(request *http.Request) (*http.Response, error) {
+	response, err := limitingDispatcher.dispatcher.Do(request)
+	if err != nil {
+		return response, err
+	}
+	if response.ContentLength > limitingDispatcher.maxSize {
+		common.CloseBodyIfValid(response)
+		return nil, &responseSizeError{size: response.ContentLength, maxSize: limitingDispatcher.maxSize}
+	}
+	if response.ContentLength < 0 && response.Body != nil {
+		response.Body = &limitedBody{ReadCloser: response.Body, remaining: limitingDispatcher.maxSize,
+			maxSize: limitingDispatcher.maxSize}
+	}
+	return response, nil
+}
+
+// limitedBody fails the read beyond the limit instead of silently truncating the body like io.LimitReader
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	maxSize   int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining < 0 {
+		return 0, &responseSizeError{size: -1, maxSize: body.maxSize}
+	}
+	// one byte over the limit is enough to tell the body is too large
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	if body.remaining < 0 {
+		return n - 1, &responseSizeError{size: -1, maxSize: body.maxSize}
+	}
+	return n, err
+}
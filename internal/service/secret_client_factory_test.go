@@ -0,0 +1,528 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/secrets"
+)
+
+// countingDispatcher - stub of HTTP dispatcher which counts the requests passed through
+type countingDispatcher struct {
+	requests []*http.Request
+}
+
+func (dispatcher *countingDispatcher) Do(request *http.Request) (*http.Response, error) {
+	dispatcher.requests = append(dispatcher.requests, request)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: request}, nil
+}
+
+func newStaticRegionDispatcher(t *testing.T, inner *countingDispatcher) common.HTTPRequestDispatcher {
+	t.Helper()
+	noopModifier := func(dispatcher common.HTTPRequestDispatcher) (common.HTTPRequestDispatcher, error) {
+		return dispatcher, nil
+	}
+	dispatcher, err := withStaticRegion(noopModifier, "us-ashburn-1")(inner)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return dispatcher
+}
+
+func TestStaticRegionDispatcher_RegionRequest_ReturnRegionWithoutIMDSCall(t *testing.T) {
+	inner := &countingDispatcher{}
+	dispatcher := newStaticRegionDispatcher(t, inner)
+
+	request, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/opc/v2/instance/region", nil)
+	response, err := dispatcher.Do(request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || string(body) != "us-ashburn-1" {
+		t.Errorf("Wrong response: %v %v", response.StatusCode, string(body))
+	}
+	if len(inner.requests) != 0 {
+		t.Errorf("IMDS should not be called, but got %v calls", len(inner.requests))
+	}
+}
+
+func TestStaticRegionDispatcher_CertificateRequest_PassThrough(t *testing.T) {
+	inner := &countingDispatcher{}
+	dispatcher := newStaticRegionDispatcher(t, inner)
+
+	request, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/opc/v2/identity/cert.pem", nil)
+	response, err := dispatcher.Do(request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer response.Body.Close()
+
+	if len(inner.requests) != 1 || inner.requests[0] != request {
+		t.Errorf("Request should be passed to IMDS, got %v calls", len(inner.requests))
+	}
+}
+
+// newFakeVaultServer starts TLS server answering OCI Vault secret bundle requests,
+// it's reachable only via endpoint override and trusted only via CA bundle.
+// Headers of the last request are stored to requestHeaders unless it's nil.
+func newFakeVaultServer(t *testing.T, requestHeaders *http.Header) (*httptest.Server, string) {
+	t.Helper()
+	return newTLSServerWithCABundle(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if requestHeaders != nil {
+			*requestHeaders = request.Header.Clone()
+		}
+		if request.Method != http.MethodPost || !strings.HasSuffix(request.URL.Path, "/secretbundles/actions/getByName") ||
+			request.Header.Get("Authorization") == "" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"secretId": "private-secret-id", "versionNumber": 1, "stages": ["CURRENT"],
+			"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}}`))
+	}))
+}
+
+// newTLSServerWithCABundle starts TLS server with the handler and writes its certificate to a CA bundle file
+func newTLSServerWithCABundle(t *testing.T, handler http.Handler) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	caBundleFile := filepath.Join(t.TempDir(), "ca.pem")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundleFile, caBundle, 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write CA bundle: %v", err)
+	}
+	return server, caBundleFile
+}
+
+func newUserAuth(t *testing.T) *types.Auth {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to generate key: %v", err)
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	return &types.Auth{Type: types.User, Config: types.AuthConfig{
+		Region: "us-ashburn-1", TenancyID: "tenancy", UserID: "user", Fingerprint: "fingerprint",
+		PrivateKey: string(privateKeyPEM),
+	}}
+}
+
+func TestCreateConfigProvider_ConfigFile_ReturnProfileOfFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	content := "[DEFAULT]\ntenancy=default-tenancy\n[prod]\ntenancy=prod-tenancy\nregion=us-phoenix-1\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write config file: %v", err)
+	}
+
+	configProvider, err := (&OCISecretClientFactory{}).createConfigProvider(
+		&types.Auth{Type: types.File, ConfigFile: types.ConfigFile{Path: path, Profile: "prod"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tenancy, err := configProvider.TenancyOCID()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	region, err := configProvider.Region()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if tenancy != "prod-tenancy" || region != "us-phoenix-1" {
+		t.Errorf("Wrong profile is read: %v, %v", tenancy, region)
+	}
+}
+
+func TestGetSecretBundles_PrivateEndpointWithCABundle_ReturnSecretBundle(t *testing.T) {
+	server, caBundleFile := newFakeVaultServer(t, nil)
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].ID != "private-secret-id" || secretBundles[0].BundleContent.Content != "YmFy" {
+		t.Errorf("Unexpected secret bundle: %v", secretBundles[0])
+	}
+}
+
+func TestGetSecretBundles_CostCenter_SendSignedRequestWithTag(t *testing.T) {
+	var requestHeaders http.Header
+	server, caBundleFile := newFakeVaultServer(t, &requestHeaders)
+	secretService, err := NewOCISecretService(Config{
+		Endpoint: server.URL, CABundleFile: caBundleFile, CostCenter: "team-a",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requestHeaders.Get(costCenterHeader) != "team-a" {
+		t.Errorf("Wrong cost-center header: %v", requestHeaders.Get(costCenterHeader))
+	}
+	authorization := requestHeaders.Get("Authorization")
+	if !strings.Contains(authorization, "signature=") ||
+		strings.Contains(strings.ToLower(authorization), strings.ToLower(costCenterHeader)) {
+		t.Errorf("Request should be signed without cost-center header: %v", authorization)
+	}
+}
+
+func TestGetSecretBundles_NoCostCenter_SendRequestWithoutTag(t *testing.T) {
+	var requestHeaders http.Header
+	server, caBundleFile := newFakeVaultServer(t, &requestHeaders)
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := requestHeaders[costCenterHeader]; ok {
+		t.Errorf("Cost-center header should not be sent: %v", requestHeaders.Get(costCenterHeader))
+	}
+}
+
+// getSecretBundleFrom retrieves the secret "foo" from the private endpoint served by the handler
+func getSecretBundleFrom(t *testing.T, handler http.HandlerFunc) error {
+	t.Helper()
+	server, caBundleFile := newTLSServerWithCABundle(t, handler)
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	return err
+}
+
+func TestGetSecretBundles_ProxyErrorPage_ReturnMisconfigurationError(t *testing.T) {
+	err := getSecretBundleFrom(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.WriteHeader(http.StatusBadGateway)
+		_, _ = writer.Write([]byte("<html><body>Bad Gateway</body></html>"))
+	})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: OCI Vault endpoint responded with unexpected "+
+		"content type \"text/html; charset=utf-8\" (status 502), check proxy and endpoint configuration" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_SuccessfulHTMLResponse_ReturnMisconfigurationError(t *testing.T) {
+	err := getSecretBundleFrom(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html")
+		_, _ = writer.Write([]byte("<html><body>Sign in</body></html>"))
+	})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.Contains(err.Error(), "check proxy and endpoint configuration") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_Redirect_ReturnMisconfigurationErrorWithoutFollowing(t *testing.T) {
+	redirected := false
+	err := getSecretBundleFrom(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/login" {
+			redirected = true
+			return
+		}
+		http.Redirect(writer, request, "/login", http.StatusFound)
+	})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: OCI Vault endpoint responded with redirect "+
+		"(status 302) to \"/login\", check proxy and endpoint configuration" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+	if redirected {
+		t.Error("Redirect should not be followed")
+	}
+}
+
+func TestGetSecretBundles_SecretNotFound_ReturnNotFoundError(t *testing.T) {
+	err := getSecretBundleFrom(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusNotFound)
+		_, _ = writer.Write([]byte(`{"code": "NotAuthorizedOrNotFound", "message": "stub"}`))
+	})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: secret foo is not found or access is not authorized "+
+		"(NotAuthorizedOrNotFound, status 404)" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// getSecretBundleWithSizeLimit retrieves the secret "foo" from the fake vault returning the content in a response
+// of the declared length, or of undeclared length if it's negative
+func getSecretBundleWithSizeLimit(t *testing.T, content string, declaredLength int, maxSize int64) error {
+	t.Helper()
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(func(writer http.ResponseWriter,
+		request *http.Request) {
+		body := `{"secretId": "secret-id", "versionNumber": 1, "stages": ["CURRENT"],
+			"secretBundleContent": {"contentType": "BASE64", "content": "` + content + `"}}`
+		writer.Header().Set("Content-Type", "application/json")
+		if declaredLength >= 0 {
+			writer.Header().Set("Content-Length", strconv.Itoa(declaredLength))
+		}
+		_, _ = writer.Write([]byte(body[:len(body)/2]))
+		// flushing the first part without declared length makes the response chunked
+		writer.(http.Flusher).Flush()
+		_, _ = writer.Write([]byte(body[len(body)/2:]))
+	}))
+	secretService, err := NewOCISecretService(Config{
+		Endpoint: server.URL, CABundleFile: caBundleFile, MaxResponseSize: maxSize,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	return err
+}
+
+func TestGetSecretBundles_DeclaredSizeAboveLimit_ReturnSizeError(t *testing.T) {
+	content := strings.Repeat("YmFy", 100)
+	err := getSecretBundleWithSizeLimit(t, content, 1<<20, 256)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret foo from vault: "+
+		"OCI Vault response of 1048576 bytes exceeds the limit of 256 bytes" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_UndeclaredSizeAboveLimit_ReturnSizeError(t *testing.T) {
+	err := getSecretBundleWithSizeLimit(t, strings.Repeat("YmFy", 100), -1, 256)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret foo from vault: OCI Vault response exceeds the limit of 256 bytes" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_SizeWithinLimit_ReturnSecretBundle(t *testing.T) {
+	if err := getSecretBundleWithSizeLimit(t, "YmFy", -1, 256); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestNewOCISecretService_NegativeMaxResponseSize_ReturnError(t *testing.T) {
+	if _, err := NewOCISecretService(Config{MaxResponseSize: -1}); err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestNewOCISecretService_InvalidCABundle_ReturnError(t *testing.T) {
+	caBundleFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caBundleFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Precondition failed: unable to write CA bundle: %v", err)
+	}
+	_, err := NewOCISecretService(Config{CABundleFile: caBundleFile})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "no valid certificates found in CA bundle") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// refreshableProvider - stub of configuration provider which token could be refreshed
+type refreshableProvider struct {
+	common.ConfigurationProvider
+}
+
+func (provider *refreshableProvider) Refreshable() bool {
+	return true
+}
+
+func TestRegionOverrideProvider_WorkloadRegion_ReturnOverriddenRegion(t *testing.T) {
+	principalProvider := common.NewRawConfigurationProvider(
+		"tenancy", "user", "us-ashburn-1", "fingerprint", "privatekey", nil)
+	configProvider := &regionOverrideProvider{
+		ConfigurationProvider: &refreshableProvider{principalProvider}, region: "eu-frankfurt-1"}
+
+	region, err := configProvider.Region()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if region != "eu-frankfurt-1" {
+		t.Errorf("Wrong region: %v", region)
+	}
+	if !configProvider.Refreshable() {
+		t.Error("Refreshable provider should stay refreshable")
+	}
+	tenancy, _ := configProvider.TenancyOCID()
+	if tenancy != "tenancy" {
+		t.Errorf("Credentials should be provided by the principal: %v", tenancy)
+	}
+}
+
+func TestRegionOverrideProvider_NotRefreshablePrincipal_ReturnNotRefreshable(t *testing.T) {
+	configProvider := &regionOverrideProvider{
+		ConfigurationProvider: common.NewRawConfigurationProvider(
+			"tenancy", "user", "us-ashburn-1", "fingerprint", "privatekey", nil),
+		region: "eu-frankfurt-1",
+	}
+	if configProvider.Refreshable() {
+		t.Error("Provider should not be refreshable")
+	}
+}
+
+// timeoutOf returns the timeout of HTTP client wrapped by the dispatchers of the factory
+func timeoutOf(t *testing.T, dispatcher common.HTTPRequestDispatcher) time.Duration {
+	t.Helper()
+	for {
+		switch wrapper := dispatcher.(type) {
+		case *attemptCountingDispatcher:
+			dispatcher = wrapper.dispatcher
+		case *endpointCheckingDispatcher:
+			dispatcher = wrapper.dispatcher
+		case *sizeLimitingDispatcher:
+			dispatcher = wrapper.dispatcher
+		case *staticRegionDispatcher:
+			dispatcher = wrapper.dispatcher
+		case *http.Client:
+			return wrapper.Timeout
+		default:
+			t.Fatalf("Unexpected dispatcher: %T", dispatcher)
+		}
+	}
+}
+
+// newUserSecretClient creates OCI Vault client of user principal with the factory, user principal makes no OCI calls
+// on creation unlike the others, while the HTTP client of OCI Vault is set up the same way for all of them
+func newUserSecretClient(t *testing.T, factory *OCISecretClientFactory) secrets.SecretsClient {
+	t.Helper()
+	configProvider, err := factory.createConfigProvider(newUserAuth(t))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	secretClient, err := factory.createSecretClient(configProvider)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return secretClient.(secrets.SecretsClient)
+}
+
+func TestCreateSecretClient_CustomHTTPTimeout_ApplyToVaultCalls(t *testing.T) {
+	factory := &OCISecretClientFactory{httpTimeout: 7 * time.Second, maxResponseSize: 1024}
+	if timeout := timeoutOf(t, newUserSecretClient(t, factory).HTTPClient); timeout != 7*time.Second {
+		t.Errorf("Wrong timeout: %v", timeout)
+	}
+}
+
+func TestCreateSecretClient_DefaultHTTPTimeoutWithCABundle_ApplyDefault(t *testing.T) {
+	factory := &OCISecretClientFactory{rootCAs: x509.NewCertPool()}
+	if timeout := timeoutOf(t, newUserSecretClient(t, factory).HTTPClient); timeout != defaultHTTPClientTimeout {
+		t.Errorf("Wrong timeout: %v", timeout)
+	}
+}
+
+func TestInstancePrincipalClient_CustomHTTPTimeout_ApplyToAuthenticationCalls(t *testing.T) {
+	factory := &OCISecretClientFactory{httpTimeout: 7 * time.Second}
+	for _, region := range []string{"", "us-ashburn-1"} {
+		dispatcher, err := factory.instancePrincipalClient(region)(&http.Client{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if timeout := timeoutOf(t, dispatcher); timeout != 7*time.Second {
+			t.Errorf("Wrong timeout of instance principal with region %q: %v", region, timeout)
+		}
+	}
+}
+
+func TestNewOCISecretService_NegativeHTTPTimeout_ReturnError(t *testing.T) {
+	if _, err := NewOCISecretService(Config{HTTPTimeout: -time.Second}); err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestSetHTTPClientTimeout_DialTimeout_ApplyToEachTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	t.Cleanup(server.Close)
+	newTransports := map[string]func() http.RoundTripper{
+		"default": func() http.RoundTripper { return nil },
+		"custom":  func() http.RoundTripper { return common.CloneHTTPDefaultTransport() },
+		"SDK wrapper": func() http.RoundTripper {
+			return &common.OciHTTPTransportWrapper{TLSConfigProvider: common.GetTLSConfigTemplateForTransport()}
+		},
+	}
+
+	for name, newTransport := range newTransports {
+		for _, dialTimeout := range []time.Duration{0, time.Nanosecond} {
+			dispatcher, err := setHTTPClientTimeout(time.Minute, dialTimeout)(&http.Client{Transport: newTransport()})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			response, err := dispatcher.Do(request)
+			if err == nil {
+				response.Body.Close()
+			}
+
+			// connection can't be established within nanosecond, so the call fails only if the timeout is applied
+			if timedOut := err != nil && strings.Contains(err.Error(), "i/o timeout"); timedOut != (dialTimeout > 0) {
+				t.Errorf("Dial timeout %v of %v transport is not applied: %v", dialTimeout, name, err)
+			}
+			if timeoutOf(t, dispatcher) != time.Minute {
+				t.Errorf("Wrong timeout of %v transport: %v", name, timeoutOf(t, dispatcher))
+			}
+		}
+	}
+}
+
+func TestNewOCISecretService_NegativeDialTimeout_ReturnError(t *testing.T) {
+	if _, err := NewOCISecretService(Config{DialTimeout: -time.Second}); err == nil {
+		t.Fatal("An error was expected")
+	}
+}
@@ -0,0 +1,23 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import "context"
+
+type secretProviderClassKey struct{}
+
+// WithSecretProviderClass returns a context carrying name of the SecretProviderClass secrets are requested by,
+// so the secret fetches could be reported per SecretProviderClass.
+func WithSecretProviderClass(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, secretProviderClassKey{}, name)
+}
+
+// secretProviderClass returns name of the SecretProviderClass carried by the context, or empty string.
+func secretProviderClass(ctx context.Context) string {
+	name, _ := ctx.Value(secretProviderClassKey{}).(string)
+	return name
+}
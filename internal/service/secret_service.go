@@ -8,11 +8,23 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/diagnostics"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/metrics"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/oracle/oci-go-sdk/v65/secrets"
-	"github.com/rs/zerolog/log"
 )
 
 // OCISecretClient - interface for OCI Vault client.
@@ -25,19 +37,114 @@ type OCISecretClient interface {
 // SecretService is interface that decouples provider server and OCI Vault client
 type SecretService interface {
 	// GetSecretBundles retrieves secrets for each types.SecretBundleRequest
-	// If one of the secrets is not present, error is returned
+	// If one of the secrets is not present, error is returned, unless the secret is optional.
+	// Optional secrets which are not present are skipped, so fewer bundles than requests could be returned.
+	// Returned bundles are owned by the caller, e.g. their content could be released once it's used.
 	GetSecretBundles(context.Context, []*types.SecretBundleRequest, *types.Auth,
 		types.VaultID) ([]*types.SecretBundle, error)
 }
 
+// defaultMaxStages is the number of stages defined by OCI Vault,
+// so a well-formed secret bundle never carries more of them.
+const defaultMaxStages = 5
+
+// defaultFetchTimeout bounds a single OCI call when no other timeout is configured.
+const defaultFetchTimeout = 10 * time.Second
+
+// defaultMaxConcurrentFetches bounds the number of secrets of a single mount retrieved at once.
+const defaultMaxConcurrentFetches = 5
+
+// defaultMaxSecretSize bounds the decoded content of a single secret, far above the content OCI Vault accepts.
+const defaultMaxSecretSize = 1 << 20
+
+// defaultCircuitCooldown is the time calls to a failing vault are rejected before probing it again.
+const defaultCircuitCooldown = 30 * time.Second
+
+// Config contains settings of OCISecretService.
+// Zero values fall back to defaults.
+type Config struct {
+	// MaxStages limits the number of stages accepted in a single OCI secret bundle
+	MaxStages int
+	// MaxJitter bounds random delay of the first OCI call of each mount, spreading the load
+	// when many pods start at once. Jitter is disabled when zero.
+	MaxJitter time.Duration
+	// FetchTimeout bounds each OCI call separately, so a slow secret fails on its own
+	// instead of consuming the deadline of the whole mount
+	FetchTimeout time.Duration
+	// CacheTTL is the default time retrieved bundles are served from the cache,
+	// unless the secret specifies its own TTL. Caching is disabled when zero.
+	CacheTTL time.Duration
+	// Endpoint overrides OCI Vault secrets endpoint, e.g. with a private endpoint reachable via service gateway
+	Endpoint string
+	// CABundleFile contains PEM certificates trusted for the endpoint instead of system authorities
+	CABundleFile string
+	// CostCenter is sent with each OCI Vault call for cost attribution, no tag is sent when empty
+	CostCenter string
+	// MaxConcurrentFetches bounds the number of secrets of a single mount retrieved at once
+	MaxConcurrentFetches int
+	// DefaultStages are tried in order when the secret specifies neither stage nor version, CURRENT only when empty
+	DefaultStages []types.Stage
+	// MaxResponseSize limits the size in bytes of OCI Vault responses, so a secret bundle of the declared size
+	// above the limit fails without being transferred. Responses are not limited when zero.
+	MaxResponseSize int64
+	// MaxSecretSize limits the size in bytes of decoded secret content, so a huge secret fails before it's decoded
+	// and copied for each mount. Defaults to defaultMaxSecretSize.
+	MaxSecretSize int
+	// FileNameMetadataKey is the key of secret metadata specifying the file path of the secret,
+	// used unless the request sets fileName. The metadata is not read when empty.
+	FileNameMetadataKey string
+	// HTTPTimeout bounds each HTTP call to OCI Vault of any principal type, and authentication calls
+	// of instance principal. SDK doesn't allow to customize the client of other principals' authentication.
+	HTTPTimeout time.Duration
+	// DialTimeout bounds establishing of each connection to OCI within HTTPTimeout, e.g. shorter on flaky networks.
+	// The transport's own timeout of 30 seconds is used when zero.
+	DialTimeout time.Duration
+	// CircuitFailureThreshold is the number of consecutive failures of the calls of a principal to a vault,
+	// e.g. server errors or timeouts, after which the calls fail fast for CircuitCooldown.
+	// The circuit breaker is disabled when zero.
+	CircuitFailureThreshold int
+	// CircuitCooldown is the time calls to a failing vault are rejected before a probe call is let through
+	CircuitCooldown time.Duration
+}
+
 // OCISecretService is implementation of SecretService
 type OCISecretService struct {
 	factory SecretClientFactory
+	config  Config
+	cache   bundleCache
+	flights fetchGroup // fetches in progress, shared by the concurrent requests of the same bundle
+	breaker circuitBreaker
+	history versionTracker // last version retrieved for each secret stage
+	clock   clock.Clock    // system clock when nil
 }
 
-func NewOCISecretService() (*OCISecretService, error) {
+func NewOCISecretService(config Config) (*OCISecretService, error) {
+	if config.HTTPTimeout < 0 {
+		return nil, fmt.Errorf("OCI HTTP timeout should not be negative: %v", config.HTTPTimeout)
+	}
+	if config.DialTimeout < 0 {
+		return nil, fmt.Errorf("OCI dial timeout should not be negative: %v", config.DialTimeout)
+	}
+	if config.CircuitFailureThreshold < 0 || config.CircuitCooldown < 0 {
+		return nil, fmt.Errorf("circuit breaker failure threshold and cooldown should not be negative: %v, %v",
+			config.CircuitFailureThreshold, config.CircuitCooldown)
+	}
+	if config.MaxSecretSize < 0 {
+		return nil, fmt.Errorf("maximum secret size should not be negative: %v", config.MaxSecretSize)
+	}
+	if config.MaxResponseSize < 0 {
+		return nil, fmt.Errorf("maximum OCI Vault response size should not be negative: %v", config.MaxResponseSize)
+	}
+	factory, err := newOCISecretClientFactory(config.Endpoint, config.CABundleFile, config.CostCenter)
+	if err != nil {
+		return nil, err
+	}
+	factory.maxResponseSize = config.MaxResponseSize
+	factory.httpTimeout = config.HTTPTimeout
+	factory.dialTimeout = config.DialTimeout
 	return &OCISecretService{
-		factory: &OCISecretClientFactory{},
+		factory: factory,
+		config:  config,
 	}, nil
 }
 
@@ -54,52 +161,302 @@ func (service *OCISecretService) GetSecretBundles(
 		return nil, err
 	}
 
-	configProvider, err := service.factory.createConfigProvider(auth)
-	if err != nil {
-		log.Error().Stack().Err(err).Msg("Unable to create OCI configuration provider")
-		return nil, err
+	serviceClock := clock.OrReal(service.clock)
+	//nolint:gosec // not security sensitive
+	jitter := jitterDelay(ctx, serviceClock, service.config.MaxJitter, rand.Int63n)
+	clientSupplier := &secretClientSupplier{
+		factory: service.factory,
+		auth:    auth,
+		clock:   serviceClock,
+		jitter:  jitter,
 	}
-	log.Info().Str("principalType", string(auth.Type)).Msg("Created OCI configuration provider")
+	return service.getSecretBundlesConcurrently(ctx, clientSupplier, auth, string(vaultID), requests)
+}
 
-	secretClient, err := service.factory.createSecretClient(configProvider)
-	if err != nil {
-		log.Error().Stack().Err(err).Msg("Unable to create OCI Vault client")
-		return nil, err
+// getSecretBundlesConcurrently retrieves the bundles by a bounded number of workers,
+// so a mount of many secrets doesn't make all round trips one after another.
+// The bundles keep the order of the requests. The first error cancels the fetches in progress
+// and prevents the remaining ones from starting. Optional secrets which are not found are skipped.
+func (service *OCISecretService) getSecretBundlesConcurrently(
+	ctx context.Context, clientSupplier *secretClientSupplier, auth *types.Auth, vaultID string,
+	requests []*types.SecretBundleRequest) ([]*types.SecretBundle, error) {
+
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		batchErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			batchErr = err
+			cancel()
+		})
 	}
-	log.Info().Msg("Created OCI Secrets client")
 
 	secretBundles := make([]*types.SecretBundle, len(requests))
+	workers := make(chan struct{}, service.maxConcurrentFetches())
 	for i, request := range requests {
-		secretBundle, err := service.getSecretBundle(ctx, secretClient, string(vaultID), request)
-		if err != nil {
+		workers <- struct{}{}
+		if err := batchCtx.Err(); err != nil {
+			// either a fetch has failed or the mount is canceled
+			fail(err)
+			break
+		}
+		wg.Add(1)
+		go func(i int, request *types.SecretBundleRequest) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			secretBundle, err := service.getSecretBundle(batchCtx, clientSupplier, auth, vaultID, request)
+			var notFoundErr *secretNotFoundError
+			if err != nil && request.Optional && errors.As(err, &notFoundErr) {
+				logging.FromContext(batchCtx).Warn().Err(err).Stringer("request", request).
+					Msg("Optional secret is not found, skipping it")
+				return
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			secretBundles[i] = secretBundle
+		}(i, request)
+	}
+	wg.Wait()
+
+	if batchErr != nil {
+		return nil, batchErr
+	}
+	foundBundles := secretBundles[:0]
+	for _, secretBundle := range secretBundles {
+		if secretBundle != nil {
+			foundBundles = append(foundBundles, secretBundle)
+		}
+	}
+	if service.config.FileNameMetadataKey != "" {
+		if err := checkBundleFilePaths(foundBundles); err != nil {
 			return nil, err
 		}
-		secretBundles[i] = secretBundle
 	}
-	return secretBundles, nil
+	return foundBundles, nil
 }
 
 func (service *OCISecretService) getSecretBundle(
-	ctx context.Context, secretClient OCISecretClient, vaultID string,
+	ctx context.Context, clientSupplier *secretClientSupplier, auth *types.Auth, vaultID string,
+	request *types.SecretBundleRequest) (bundle *types.SecretBundle, err error) {
+	defer func() {
+		metrics.NewStatsReporter().ReportSecretFetch(ctx,
+			types.VaultID(vaultID).Digest(), secretProviderClass(ctx), string(auth.Type), err == nil)
+	}()
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	if request.VersionNumber == 0 && request.Stage == types.None && request.VersionName == "" {
+		return service.getDefaultStageBundle(ctx, clientSupplier, auth, vaultID, request)
+	}
+	return service.getRequestedBundle(ctx, clientSupplier, auth, vaultID, request)
+}
+
+// getDefaultStageBundle looks for the version in the default stages in their order, e.g. CURRENT only.
+// The next stage is tried only if the secret is not found in the previous one, other failures are returned at once,
+// and it's not tried once the mount is canceled. The request keeps the stage the bundle is found in.
+func (service *OCISecretService) getDefaultStageBundle(
+	ctx context.Context, clientSupplier *secretClientSupplier, auth *types.Auth, vaultID string,
 	request *types.SecretBundleRequest) (*types.SecretBundle, error) {
-	if request.Name == "" {
-		return nil, fmt.Errorf("missed secret name")
+
+	stages := service.defaultStages()
+	for i, stage := range stages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		request.Stage = stage
+		bundle, err := service.getRequestedBundle(ctx, clientSupplier, auth, vaultID, request)
+		var notFoundErr *secretNotFoundError
+		if err == nil || i == len(stages)-1 || !errors.As(err, &notFoundErr) {
+			return bundle, err
+		}
+		logging.FromContext(ctx).Debug().Stringer("request", request).
+			Msg("Secret version is not found in default stage, trying next one")
 	}
-	if request.VersionNumber == 0 && request.Stage == types.None {
-		// by default looking for current secret version
-		request.Stage = types.Current
+	return nil, fmt.Errorf("no default stages configured")
+}
+
+func (service *OCISecretService) getRequestedBundle(
+	ctx context.Context, clientSupplier *secretClientSupplier, auth *types.Auth, vaultID string,
+	request *types.SecretBundleRequest) (*types.SecretBundle, error) {
+
+	cacheKey := newBundleCacheKey(auth, vaultID, request)
+	if cachedBundle, ok := service.cache.get(cacheKey); ok {
+		logging.FromContext(ctx).Debug().Stringer("request", request).Msg("Secret bundle is served from cache")
+		bundle := withRequestFields(cachedBundle, request)
+		bundle.CacheHit = true
+		bundle.Attempts = 0
+		return bundle, nil
 	}
-	if request.VersionNumber != 0 && request.Stage != types.None {
-		return nil, fmt.Errorf("secret should be identified either with a version number or with stage")
+
+	secretBundle, shared, err := service.flights.do(ctx, cacheKey, func() (*types.SecretBundle, error) {
+		return service.fetchAndCacheBundle(ctx, clientSupplier, auth, vaultID, request, cacheKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bundle := withRequestFields(secretBundle, request)
+	if shared {
+		logging.FromContext(ctx).Debug().Stringer("request", request).Msg("Secret bundle is shared by concurrent fetch")
+		bundle.Attempts = 0
 	}
+	return bundle, nil
+}
+
+// fetchAndCacheBundle retrieves the bundle from OCI Vault and caches it, unless caching is disabled.
+// The bundle is returned as retrieved for the request, without request specific fields.
+func (service *OCISecretService) fetchAndCacheBundle(
+	ctx context.Context, clientSupplier *secretClientSupplier, auth *types.Auth, vaultID string,
+	request *types.SecretBundleRequest, cacheKey bundleCacheKey) (*types.SecretBundle, error) {
 
+	// the circuit is checked before the jitter and the authentication, so the mount fails fast
+	done, err := service.allowCall(auth, vaultID)
+	if err != nil {
+		logging.FromContext(ctx).Info().Err(err).Stringer("request", request).Msg("Vault is failing, call is rejected")
+		return nil, fmt.Errorf("unable to retrieve secret from vault: %w", err)
+	}
+	secretClient, err := clientSupplier.get(ctx)
+	if err != nil {
+		done(callAbandoned)
+		return nil, err
+	}
 	ociRequest := service.mapToOCIRequest(vaultID, request)
-	response, err := secretClient.GetSecretBundleByName(ctx, ociRequest)
+	countCall(ctx)
+	attemptsCtx, attempts := withAttemptCounter(ctx)
+	response, err := service.fetchSecretBundle(attemptsCtx, secretClient, ociRequest, request)
+	done(outcomeOf(ctx, err))
 	if err != nil {
-		log.Info().Err(err).Stringer("request", request).Msg("Unable to retrieve secret from vault")
-		return nil, fmt.Errorf("unable to retrieve secret from vault")
+		logging.FromContext(ctx).Info().Err(err).Stringer("request", request).Msg("Unable to retrieve secret from vault")
+		return nil, retrievalError(err, request)
+	}
+	secretBundle, err := service.mapOCIResponseToSecretBundle(response, request)
+	if err != nil {
+		return nil, err
+	}
+	secretBundle.Attempts = int(atomic.LoadInt32(attempts))
+	service.trackVersion(ctx, vaultID, request, secretBundle.VersionNumber)
+
+	if cacheTTL := service.cacheTTL(request); cacheTTL > 0 {
+		// provider-wide TTL is chosen by the operator knowingly, so only per-secret TTL is warned about
+		if request.CacheTTL > 0 && request.Stage != types.None {
+			logging.FromContext(ctx).Warn().Stringer("request", request).Int("cacheTTL", request.CacheTTL).
+				Msg("Caching stage-based secret, rotated content is not served until cache entry expires")
+		}
+		service.cache.put(cacheKey, secretBundle, cacheTTL)
+	}
+	return secretBundle, nil
+}
+
+// fetchSecretBundle calls OCI with its own timeout derived from the mount context.
+// The mount context still cancels the call, e.g. when the driver gives up on the mount.
+func (service *OCISecretService) fetchSecretBundle(
+	ctx context.Context, secretClient OCISecretClient, ociRequest secrets.GetSecretBundleByNameRequest,
+	request *types.SecretBundleRequest) (secrets.GetSecretBundleByNameResponse, error) {
+
+	timeout := service.fetchTimeout()
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	response, err := secretClient.GetSecretBundleByName(fetchCtx, ociRequest)
+	switch {
+	case err == nil:
+	case ctx.Err() == nil && errors.Is(fetchCtx.Err(), context.DeadlineExceeded):
+		logging.FromContext(ctx).Warn().Stringer("request", request).Dur("timeout", timeout).Msg("Secret fetch timed out")
+		diagnostics.RecordError(diagnostics.SecretFetchTimeout)
+	default:
+		diagnostics.RecordError(diagnostics.SecretFetchFailed)
+	}
+	return response, err
+}
+
+// Categories of secret retrieval failures, the errors returned by GetSecretBundles match them with errors.Is,
+// so the caller could report them without knowing OCI Vault responses.
+var (
+	// ErrSecretNotFound matches the secret version missing in OCI Vault, or the one the principal can't access
+	ErrSecretNotFound = errors.New("secret is not found")
+	// ErrNotAuthorized matches the call refused by OCI Vault for the credentials or permissions of the principal
+	ErrNotAuthorized = errors.New("call is not authorized")
+	// ErrThrottled matches the call refused by OCI Vault for exceeding the rate limit
+	ErrThrottled = errors.New("call is throttled")
+	// ErrVaultUnavailable matches the call rejected without calling the vault, since its previous calls kept failing
+	ErrVaultUnavailable = errors.New("vault is unavailable")
+)
+
+// retrievalError tells the misconfigured endpoint or proxy, and the missing secret apart from other failures,
+// since they are fixed differently. The original error is wrapped, so the caller could tell OCI service errors apart
+// by their HTTP status, e.g. a missing secret from the throttled call.
+func retrievalError(err error, request *types.SecretBundleRequest) error {
+	var endpointErr *endpointResponseError
+	if errors.As(err, &endpointErr) {
+		return fmt.Errorf("unable to retrieve secret from vault: %w", endpointErr)
+	}
+	var sizeErr *responseSizeError
+	if errors.As(err, &sizeErr) {
+		return fmt.Errorf("unable to retrieve secret %v from vault: %w", request.Name, sizeErr)
+	}
+	var serviceError common.ServiceError
+	if errors.As(err, &serviceError) && serviceError.GetHTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("unable to retrieve secret from vault: %w",
+			&secretNotFoundError{name: request.Name, serviceError: serviceError, err: err})
+	}
+	if serviceError != nil {
+		return fmt.Errorf("unable to retrieve secret from vault: %w",
+			&vaultServiceError{name: request.Name, serviceError: serviceError, err: err})
+	}
+	return fmt.Errorf("unable to retrieve secret from vault: %w", err)
+}
+
+// secretNotFoundError is returned when OCI Vault has no such secret version, or the principal can't access it,
+// since OCI Vault doesn't tell these cases apart.
+type secretNotFoundError struct {
+	name         string
+	serviceError common.ServiceError
+	err          error // the original error of the call
+}
+
+func (err *secretNotFoundError) Error() string {
+	return fmt.Sprintf("secret %v is not found or access is not authorized (%v, status %d)",
+		err.name, err.serviceError.GetCode(), err.serviceError.GetHTTPStatusCode())
+}
+
+func (err *secretNotFoundError) Unwrap() error {
+	return err.err
+}
+
+func (err *secretNotFoundError) Is(target error) bool {
+	return target == ErrSecretNotFound
+}
+
+// vaultServiceError is returned when OCI Vault fails the call for other reasons, e.g. throttling.
+// It keeps the service code and message, but not the request details of the service error.
+type vaultServiceError struct {
+	name         string
+	serviceError common.ServiceError
+	err          error // the original error of the call
+}
+
+func (err *vaultServiceError) Error() string {
+	return fmt.Sprintf("OCI Vault failed to return secret %v: %v (%v, status %d)",
+		err.name, err.serviceError.GetMessage(), err.serviceError.GetCode(), err.serviceError.GetHTTPStatusCode())
+}
+
+func (err *vaultServiceError) Unwrap() error {
+	return err.err
+}
+
+func (err *vaultServiceError) Is(target error) bool {
+	switch err.serviceError.GetHTTPStatusCode() {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrNotAuthorized
+	case http.StatusTooManyRequests:
+		return target == ErrThrottled
+	default:
+		return false
 	}
-	return service.mapOCIResponseToSecretBundle(response, request)
 }
 
 func (service *OCISecretService) checkNameDuplication(requests []*types.SecretBundleRequest) error {
@@ -117,6 +474,39 @@ func (service *OCISecretService) checkNameDuplication(requests []*types.SecretBu
 	return nil
 }
 
+// metadataFileName returns the file path specified by the secret metadata under the configured key, if any.
+// It's returned regardless of fileName of the request, since the bundle could be served from the cache to others.
+func (service *OCISecretService) metadataFileName(metadata map[string]interface{},
+	request *types.SecretBundleRequest) (string, error) {
+
+	key := service.config.FileNameMetadataKey
+	if key == "" || metadata[key] == nil {
+		return "", nil
+	}
+	fileName, ok := metadata[key].(string)
+	if !ok {
+		return "", fmt.Errorf("metadata %q of secret %v should be a string", key, request.Name)
+	}
+	return fileName, nil
+}
+
+// checkBundleFilePaths validates file paths of the bundles again, since the ones specified by the secret metadata
+// are known only once the secrets are retrieved, so they could escape the mount target or collide with others.
+func checkBundleFilePaths(bundles []*types.SecretBundle) error {
+	secretNames := make(map[string]string, len(bundles))
+	for _, bundle := range bundles {
+		filePath := bundle.GetFilePath()
+		if err := types.ValidateFilePath(filePath); err != nil {
+			return fmt.Errorf("invalid file path of secret %v: %w", bundle.Name, err)
+		}
+		if otherName, ok := secretNames[filePath]; ok {
+			return fmt.Errorf("secrets %v and %v are mounted to the same file path %v", otherName, bundle.Name, filePath)
+		}
+		secretNames[filePath] = bundle.Name
+	}
+	return nil
+}
+
 func (service *OCISecretService) mapToOCIRequest(vaultID string,
 	request *types.SecretBundleRequest) secrets.GetSecretBundleByNameRequest {
 
@@ -128,6 +518,9 @@ func (service *OCISecretService) mapToOCIRequest(vaultID string,
 		requestedVersion := int64(request.VersionNumber)
 		ociRequest.VersionNumber = &requestedVersion
 	}
+	if request.VersionName != "" {
+		ociRequest.SecretVersionName = &request.VersionName
+	}
 	ociSecretStage, ok := secrets.GetMappingGetSecretBundleByNameStageEnum(request.Stage.String())
 	if request.Stage != types.None && ok {
 		ociRequest.Stage = ociSecretStage
@@ -139,9 +532,14 @@ func (service *OCISecretService) mapOCIResponseToSecretBundle(
 	response secrets.GetSecretBundleByNameResponse, request *types.SecretBundleRequest) (*types.SecretBundle, error) {
 	ociSecretBundle := response.SecretBundle
 
-	base64Content, ok := ociSecretBundle.SecretBundleContent.(secrets.Base64SecretBundleContentDetails)
-	if !ok {
-		return nil, fmt.Errorf("unable to cast secret content")
+	contentType, content, err := mapSecretBundleContent(ociSecretBundle.SecretBundleContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxStages := service.maxStages(); len(ociSecretBundle.Stages) > maxStages {
+		return nil, fmt.Errorf("secret bundle has %v stages, exceeding the maximum of %v",
+			len(ociSecretBundle.Stages), maxStages)
 	}
 
 	stages := make([]types.Stage, len(ociSecretBundle.Stages))
@@ -151,15 +549,204 @@ func (service *OCISecretService) mapOCIResponseToSecretBundle(
 		}
 	}
 
-	return &types.SecretBundle{
+	metadataFileName, err := service.metadataFileName(ociSecretBundle.Metadata, request)
+	if err != nil {
+		return nil, err
+	}
+
+	secretBundle := &types.SecretBundle{
 		ID:            *ociSecretBundle.SecretId,
 		Name:          request.Name,
 		VersionNumber: *ociSecretBundle.VersionNumber,
 		Stages:        stages,
 		FileName:      request.FileName,
+		Encoding:      request.Encoding,
+		JSONKey:       request.JSONKey,
+		Format:        request.Format,
+		Mode:          request.Mode,
 		BundleContent: &types.SecretBundleContent{
-			ContentType: types.Base64,
-			Content:     *base64Content.Content,
+			ContentType: contentType,
+			Content:     content,
 		},
-	}, nil
+		TimeCreated:      sdkTimeToTime(ociSecretBundle.TimeCreated),
+		TimeOfExpiry:     sdkTimeToTime(ociSecretBundle.TimeOfExpiry),
+		TimeOfDeletion:   sdkTimeToTime(ociSecretBundle.TimeOfDeletion),
+		MinVersionNumber: int64(request.MinVersionNumber),
+		Immutable:        request.Immutable,
+		MetadataFileName: metadataFileName,
+	}
+	// the content is checked before it's decoded, so an oversized secret is never copied
+	if size, maxSize := secretBundle.BundleContent.DecodedSize(), service.maxSecretSize(); size > maxSize {
+		return nil, fmt.Errorf("secret %v is %v bytes long, exceeding the maximum of %v bytes",
+			request.Name, size, maxSize)
+	}
+	return secretBundle, nil
+}
+
+// plaintextContentType is the type of OCI content details holding the content not base64-encoded
+const plaintextContentType = "TEXT"
+
+// mapSecretBundleContent selects the content type by the type of OCI content details
+func mapSecretBundleContent(details secrets.SecretBundleContentDetails) (types.ContentType, string, error) {
+	if base64Content, ok := details.(secrets.Base64SecretBundleContentDetails); ok {
+		return types.Base64, *base64Content.Content, nil
+	}
+	// SDK returns details of the types it doesn't know with their raw JSON
+	var unknownDetails struct {
+		RawJSON     []byte `json:"JsonData"`
+		ContentType string `json:"contentType"`
+	}
+	rawDetails, err := json.Marshal(details)
+	if err != nil || json.Unmarshal(rawDetails, &unknownDetails) != nil ||
+		!strings.EqualFold(unknownDetails.ContentType, plaintextContentType) {
+		return 0, "", fmt.Errorf("unable to cast secret content")
+	}
+	var plaintextDetails struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(unknownDetails.RawJSON, &plaintextDetails); err != nil {
+		return 0, "", fmt.Errorf("unable to cast secret content")
+	}
+	return types.Plaintext, plaintextDetails.Content, nil
+}
+
+func sdkTimeToTime(sdkTime *common.SDKTime) *time.Time {
+	if sdkTime == nil {
+		return nil
+	}
+	return &sdkTime.Time
+}
+
+// trackVersion reports the version retrieved for the secret stage which differs from the previous one,
+// so rotations could be confirmed to reach the mounts. Versions requested by number or name are pinned, not tracked.
+func (service *OCISecretService) trackVersion(
+	ctx context.Context, vaultID string, request *types.SecretBundleRequest, version int64) {
+	if request.Stage == types.None {
+		return
+	}
+	key := versionKey{vaultID: vaultID, name: request.Name, stage: request.Stage}
+	previous, changed := service.history.update(key, version)
+	if !changed {
+		return
+	}
+	logging.FromContext(ctx).Info().Str("secret", request.Name).Str("stage", request.Stage.String()).
+		Int64("previousVersion", previous).Int64("version", version).Msg("Secret version changed")
+	metrics.NewStatsReporter().ReportSecretVersionChange(ctx, types.VaultID(vaultID).Digest(), request.Name)
+}
+
+// allowCall reserves the call to the vault with the circuit breaker of the caller, if it's enabled.
+func (service *OCISecretService) allowCall(auth *types.Auth, vaultID string) (func(callOutcome), error) {
+	if service.config.CircuitFailureThreshold <= 0 {
+		return func(callOutcome) {}, nil
+	}
+	cooldown := service.config.CircuitCooldown
+	if cooldown <= 0 {
+		cooldown = defaultCircuitCooldown
+	}
+	return service.breaker.allow(circuitKey(auth, vaultID), service.config.CircuitFailureThreshold, cooldown)
+}
+
+func (service *OCISecretService) fetchTimeout() time.Duration {
+	if service.config.FetchTimeout <= 0 {
+		return defaultFetchTimeout
+	}
+	return service.config.FetchTimeout
+}
+
+// cacheTTL prefers TTL of the particular secret over the provider-wide one, zero disables caching
+func (service *OCISecretService) cacheTTL(request *types.SecretBundleRequest) time.Duration {
+	if request.CacheTTL > 0 {
+		return time.Duration(request.CacheTTL) * time.Second
+	}
+	if service.config.CacheTTL < 0 {
+		return 0
+	}
+	return service.config.CacheTTL
+}
+
+func (service *OCISecretService) maxConcurrentFetches() int {
+	if service.config.MaxConcurrentFetches <= 0 {
+		return defaultMaxConcurrentFetches
+	}
+	return service.config.MaxConcurrentFetches
+}
+
+func (service *OCISecretService) defaultStages() []types.Stage {
+	if len(service.config.DefaultStages) == 0 {
+		return []types.Stage{types.Current}
+	}
+	return service.config.DefaultStages
+}
+
+func (service *OCISecretService) maxSecretSize() int {
+	if service.config.MaxSecretSize <= 0 {
+		return defaultMaxSecretSize
+	}
+	return service.config.MaxSecretSize
+}
+
+func (service *OCISecretService) maxStages() int {
+	if service.config.MaxStages <= 0 {
+		return defaultMaxStages
+	}
+	return service.config.MaxStages
+}
+
+// withRequestFields returns a copy of the bundle with fields specific to the particular request,
+// since the same cached bundle could be requested with different settings, e.g. aliases.
+func withRequestFields(bundle *types.SecretBundle, request *types.SecretBundleRequest) *types.SecretBundle {
+	bundleCopy := *bundle
+	bundleCopy.FileName = request.FileName
+	bundleCopy.Encoding = request.Encoding
+	bundleCopy.JSONKey = request.JSONKey
+	bundleCopy.Format = request.Format
+	bundleCopy.Mode = request.Mode
+	bundleCopy.MinVersionNumber = int64(request.MinVersionNumber)
+	bundleCopy.Immutable = request.Immutable
+	return &bundleCopy
+}
+
+// secretClientSupplier creates OCI Vault client on the first use only,
+// so the mount served entirely from the cache doesn't authenticate against OCI at all.
+// The first use is delayed by jitter.
+type secretClientSupplier struct {
+	factory SecretClientFactory
+	auth    *types.Auth
+	clock   clock.Clock
+	jitter  time.Duration
+
+	once   sync.Once
+	client OCISecretClient
+	err    error
+}
+
+func (supplier *secretClientSupplier) get( //nolint:ireturn // OCI client abstraction
+	ctx context.Context) (OCISecretClient, error) {
+	supplier.once.Do(func() {
+		if supplier.err = waitJitter(ctx, supplier.clock, supplier.jitter); supplier.err != nil {
+			return
+		}
+		supplier.client, supplier.err = supplier.createSecretClient(ctx)
+	})
+	return supplier.client, supplier.err
+}
+
+func (supplier *secretClientSupplier) createSecretClient( //nolint:ireturn // OCI client abstraction
+	ctx context.Context) (OCISecretClient, error) {
+	logger := logging.FromContext(ctx)
+	configProvider, err := supplier.factory.createConfigProvider(supplier.auth)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Unable to create OCI configuration provider")
+		diagnostics.RecordError(diagnostics.AuthFailed)
+		return nil, err
+	}
+	logger.Debug().Str("principalType", string(supplier.auth.Type)).Msg("Created OCI configuration provider")
+
+	secretClient, err := supplier.factory.createSecretClient(configProvider)
+	if err != nil {
+		logger.Error().Stack().Err(err).Msg("Unable to create OCI Vault client")
+		return nil, err
+	}
+	logger.Debug().Msg("Created OCI Secrets client")
+	return secretClient, nil
 }
@@ -7,16 +7,31 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/logging"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
 	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/oracle/oci-go-sdk/v65/common/auth"
 	"github.com/oracle/oci-go-sdk/v65/secrets"
+	"github.com/rs/zerolog"
 )
 
 func TestMain(m *testing.M) {
@@ -25,12 +40,19 @@ func TestMain(m *testing.M) {
 
 type MockOCISecretClientFactory struct {
 	testCaseMockData testCaseMockData
+	apiCalls         int32
+	apiLatency       time.Duration
+	callsTracker     *concurrentCallsTracker
 }
 
 func (factory *MockOCISecretClientFactory) createSecretClient( //nolint:ireturn // factory method
 	configProvider common.ConfigurationProvider) (OCISecretClient, error) {
 
-	return newMockSecretClient(factory.testCaseMockData), nil
+	client := newMockSecretClient(factory.testCaseMockData)
+	client.apiCalls = &factory.apiCalls
+	client.latency = factory.apiLatency
+	client.callsTracker = factory.callsTracker
+	return client, nil
 }
 
 func (factory *MockOCISecretClientFactory) createConfigProvider( //nolint:ireturn // factory method
@@ -44,6 +66,8 @@ func (factory *MockOCISecretClientFactory) createConfigProvider( //nolint:iretur
 	case types.Workload:
 		return auth.OkeWorkloadIdentityConfigurationProviderWithServiceAccountTokenProvider(
 			auth.NewSuppliedServiceAccountTokenProvider(string(authCfg.WorkloadIdentityCfg.SaToken)))
+	case types.Resource:
+		return common.NewRawConfigurationProvider("tenancy", "resource", "region", "fingerprint", "privatekey", nil), nil
 	default:
 		return nil, fmt.Errorf("unable to determine OCI principal type for configuration provider")
 	}
@@ -255,7 +279,7 @@ func TestGetSecretBundles_NonExistingSecret_ReturnError(t *testing.T) {
 	if err == nil {
 		t.Fatal("An error was expected")
 	}
-	if err.Error() != "unable to retrieve secret from vault" {
+	if err.Error() != "unable to retrieve secret from vault: secret not found" {
 		t.Errorf("Wrong error message: %v", err)
 	}
 }
@@ -548,7 +572,1666 @@ func TestGetSecretBundles_ExistingSecretByNameAndNotExistingVersion_ReturnError(
 	if err == nil {
 		t.Fatal("An error was expected")
 	}
-	if err.Error() != "unable to retrieve secret from vault" {
+	if err.Error() != "unable to retrieve secret from vault: secret not found" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_TooManyOCIResponseStages_ReturnError(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  2,
+				requestSecretStage:    "",
+				responseSecretVersion: 2,
+				responseSecretStages: []secrets.SecretBundleStagesEnum{
+					secrets.SecretBundleStagesCurrent, secrets.SecretBundleStagesPending,
+					secrets.SecretBundleStagesLatest, secrets.SecretBundleStagesPrevious,
+					secrets.SecretBundleStagesDeprecated, secrets.SecretBundleStagesCurrent,
+				},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+
+	var secretService SecretService = &OCISecretService{factory: factory}
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 2}}
+	_, err := secretService.GetSecretBundles(context.Background(),
+		secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "secret bundle has 6 stages, exceeding the maximum of 5" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_StagesExceedConfiguredMaximum_ReturnError(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  2,
+				requestSecretStage:    "",
+				responseSecretVersion: 2,
+				responseSecretStages: []secrets.SecretBundleStagesEnum{
+					secrets.SecretBundleStagesCurrent, secrets.SecretBundleStagesLatest,
+				},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+
+	var secretService SecretService = &OCISecretService{factory: factory, config: Config{MaxStages: 1}}
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 2}}
+	_, err := secretService.GetSecretBundles(context.Background(),
+		secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "secret bundle has 2 stages, exceeding the maximum of 1" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_StageWithCacheTTL_SecondCallServedFromCache(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  0,
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 2,
+				responseSecretStages: []secrets.SecretBundleStagesEnum{
+					secrets.SecretBundleStagesCurrent, secrets.SecretBundleStagesLatest,
+				},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	fakeClock := clock.NewFake(time.Now())
+	secretService := &OCISecretService{factory: factory}
+	secretService.cache.clock = fakeClock
+
+	for i := 0; i < 2; i++ {
+		secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", CacheTTL: 60}}
+		secretBundles, err := secretService.GetSecretBundles(context.Background(),
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if secretBundles[0].VersionNumber != 2 {
+			t.Errorf("Secret version mismatched: %v", secretBundles[0].VersionNumber)
+		}
+	}
+	if factory.apiCalls != 1 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_CacheTTLExpired_SecretFetchedAgain(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  0,
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 2,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	fakeClock := clock.NewFake(time.Now())
+	secretService := &OCISecretService{factory: factory}
+	secretService.cache.clock = fakeClock
+
+	for _, elapsed := range []time.Duration{0, 59 * time.Second, 60 * time.Second} {
+		fakeClock.Advance(elapsed)
+		secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", CacheTTL: 60}}
+		_, err := secretService.GetSecretBundles(context.Background(),
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// the first call populates the cache, the second one hits it, the third one happens after expiration
+	if factory.apiCalls != 2 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_NoCacheTTL_SecretFetchedEveryTime(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  0,
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 2,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+
+	for i := 0; i < 2; i++ {
+		secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo"}}
+		_, err := secretService.GetSecretBundles(context.Background(),
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if factory.apiCalls != 2 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_CachedBundleRequestedWithAlias_ReturnBundleWithAlias(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  2,
+				requestSecretStage:    "",
+				responseSecretVersion: 2,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+
+	for _, fileName := range []string{"", "fooAlias"} {
+		secretBundleRequests := []*types.SecretBundleRequest{
+			{Name: "foo", VersionNumber: 2, FileName: fileName, CacheTTL: 60},
+		}
+		secretBundles, err := secretService.GetSecretBundles(context.Background(),
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if secretBundles[0].FileName != fileName {
+			t.Errorf("Unexpected file name: %v", secretBundles[0].FileName)
+		}
+	}
+	if factory.apiCalls != 1 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_CachedBundleRequestedByAnotherPrincipal_SecretFetchedAgain(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  2,
+				requestSecretStage:    "",
+				responseSecretVersion: 2,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+
+	for _, userID := range []string{"user1", "user2"} {
+		auth := &types.Auth{Type: types.User, Config: types.AuthConfig{UserID: userID}}
+		secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 2, CacheTTL: 60}}
+		_, err := secretService.GetSecretBundles(context.Background(),
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if factory.apiCalls != 2 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_NegativeCacheTTL_ReturnError(t *testing.T) {
+	testCaseMockData := testCaseMockData{vaultID: "stub-vault-id", secretsMockData: nil}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	var secretService SecretService = &OCISecretService{factory: factory}
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", CacheTTL: -1}}
+	_, err := secretService.GetSecretBundles(context.Background(),
+		secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "cache TTL should not be negative" {
 		t.Errorf("Wrong error message: %v", err)
 	}
 }
+
+func TestGetSecretBundles_PartiallyCachedSecrets_CountOnlyOCICalls(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+			{
+				secretID:              "stub-secret-id-2",
+				secretName:            "hello",
+				secretBase64Content:   "d29ybGQ=",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+
+	expectedCalls := []int{2, 1}
+	for _, expectedCallCount := range expectedCalls {
+		// only "foo" is cached
+		secretBundleRequests := []*types.SecretBundleRequest{
+			{Name: "foo", VersionNumber: 1, CacheTTL: 60},
+			{Name: "hello", VersionNumber: 1},
+		}
+		ctx, callCounter := WithCallCounter(context.Background())
+		_, err := secretService.GetSecretBundles(ctx,
+			secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if callCounter.Calls() != expectedCallCount {
+			t.Errorf("Unexpected number of counted OCI calls: %v, expected %v", callCounter.Calls(), expectedCallCount)
+		}
+	}
+	if factory.apiCalls != 3 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_ContextWithoutCallCounter_ReturnSecrets(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	secretService := &OCISecretService{factory: &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}}
+	_, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}, auth, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestJitterDelay_RandomDelays_BoundedByMaxJitter(t *testing.T) {
+	maxJitter := 100 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		delay := jitterDelay(context.Background(), clock.Real, maxJitter, rand.Int63n)
+		if delay < 0 || delay >= maxJitter {
+			t.Fatalf("Jitter is out of bounds: %v", delay)
+		}
+	}
+}
+
+func TestJitterDelay_TightDeadline_ReturnZero(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	ctx, cancel := context.WithDeadline(context.Background(), fakeClock.Now().Add(100*time.Millisecond))
+	defer cancel()
+
+	// random delay of 60ms takes more than half of the time left
+	delay := jitterDelay(ctx, fakeClock, time.Second, func(int64) int64 { return int64(60 * time.Millisecond) })
+	if delay != 0 {
+		t.Errorf("Jitter should be skipped, but got: %v", delay)
+	}
+}
+
+func TestJitterDelay_LooseDeadline_ReturnRandomDelay(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	ctx, cancel := context.WithDeadline(context.Background(), fakeClock.Now().Add(time.Minute))
+	defer cancel()
+
+	delay := jitterDelay(ctx, fakeClock, time.Second, func(int64) int64 { return int64(300 * time.Millisecond) })
+	if delay != 300*time.Millisecond {
+		t.Errorf("Unexpected jitter: %v", delay)
+	}
+}
+
+func TestJitterDelay_Disabled_ReturnZero(t *testing.T) {
+	delay := jitterDelay(context.Background(), clock.Real, 0, func(int64) int64 {
+		t.Fatal("Random delay should not be generated")
+		return 0
+	})
+	if delay != 0 {
+		t.Errorf("Jitter should be disabled, but got: %v", delay)
+	}
+}
+
+func TestGetSecretBundles_JitterWithCanceledContext_ReturnError(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory, config: Config{MaxJitter: time.Hour}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := secretService.GetSecretBundles(ctx, []*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}},
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if factory.apiCalls != 0 {
+		t.Errorf("OCI should not be called while waiting for jitter: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_CanceledContext_ReturnContextErrorWithoutOCICalls(t *testing.T) {
+	testCaseMockData, requests := newManySecretsMockData(10)
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := secretService.GetSecretBundles(ctx, requests,
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Canceled error was expected: %v", err)
+	}
+	if factory.apiCalls != 0 {
+		t.Errorf("OCI should not be called once the mount is canceled: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_JitterElapsed_SecretFetched(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	fakeClock := clock.NewFake(time.Now())
+	secretService := &OCISecretService{factory: factory, config: Config{MaxJitter: time.Hour}, clock: fakeClock}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}},
+			&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+		result <- err
+	}()
+	for fakeClock.Waiters() == 0 {
+		runtime.Gosched()
+	}
+	if atomic.LoadInt32(&factory.apiCalls) != 0 {
+		t.Errorf("OCI should not be called while waiting for jitter: %v", factory.apiCalls)
+	}
+
+	// the jitter is below the maximum
+	fakeClock.Advance(time.Hour)
+	if err := <-result; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if factory.apiCalls != 1 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_RequestByVersionName_ReturnPinnedVersion(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:                 "stub-secret-id-1",
+				secretName:               "foo",
+				secretBase64Content:      "YmFyMQ==",
+				requestSecretVersionName: "v2-approved",
+				responseSecretVersion:    2,
+				responseSecretStages:     []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesPrevious},
+			},
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMg==",
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 3,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	var secretService SecretService = &OCISecretService{factory: factory}
+	secretBundleRequests := []*types.SecretBundleRequest{{Name: "foo", VersionName: "v2-approved"}}
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedBundle := &types.SecretBundle{
+		ID: "stub-secret-id-1", Name: "foo", VersionNumber: 2,
+		Stages:        []types.Stage{types.Previous},
+		BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+	}
+	assertSecretBundle(t, secretBundles[0], expectedBundle)
+}
+
+func TestGetSecretBundles_VersionNameWithVersionNumberOrStage_ReturnError(t *testing.T) {
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData{vaultID: "stub-vault-id"}}
+	var secretService SecretService = &OCISecretService{factory: factory}
+
+	invalidRequests := []*types.SecretBundleRequest{
+		{Name: "foo", VersionName: "v2-approved", VersionNumber: 2},
+		{Name: "foo", VersionName: "v2-approved", Stage: types.Latest},
+	}
+	for _, request := range invalidRequests {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{request}, auth, "stub-vault-id")
+		if err == nil {
+			t.Fatal("An error was expected")
+		}
+		if err.Error() != "secret identified with a version name should not have a version number or stage" {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+	if factory.apiCalls != 0 {
+		t.Errorf("OCI should not be called for invalid requests: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_SecretWithExpiry_ReturnBundleWithExpiry(t *testing.T) {
+	timeOfExpiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+				responseTimeOfExpiry:  &timeOfExpiry,
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	var secretService SecretService = &OCISecretService{factory: factory}
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, auth, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if secretBundles[0].TimeOfExpiry == nil || !secretBundles[0].TimeOfExpiry.Equal(timeOfExpiry) {
+		t.Errorf("Unexpected time of expiry: %v", secretBundles[0].TimeOfExpiry)
+	}
+}
+
+func TestGetSecretBundles_SlowOCICall_FailAfterFetchTimeout(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData, apiLatency: time.Hour}
+	secretService := &OCISecretService{factory: factory, config: Config{FetchTimeout: 10 * time.Millisecond}}
+
+	start := time.Now()
+	_, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}},
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("Fetch was not interrupted by timeout: %v", elapsed)
+	}
+}
+
+func TestGetSecretBundles_OCICallWithinFetchTimeout_ReturnSecretBundle(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData, apiLatency: time.Millisecond}
+	secretService := &OCISecretService{factory: factory, config: Config{FetchTimeout: time.Minute}}
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}},
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(secretBundles) != 1 {
+		t.Errorf("Unexpected number of bundles: %v", len(secretBundles))
+	}
+}
+
+func TestGetSecretBundles_ProviderCacheTTL_SecondCallServedFromCache(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+				responseSecretVersion: 2,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Instance}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	fakeClock := clock.NewFake(time.Now())
+	secretService := &OCISecretService{factory: factory, config: Config{CacheTTL: time.Minute}}
+	secretService.cache.clock = fakeClock
+
+	for i := 0; i < 2; i++ {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "foo"}}, auth, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if factory.apiCalls != 1 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+
+	// stage-based entry expires on TTL, so the rotated secret is eventually fetched
+	fakeClock.Advance(time.Minute + time.Second)
+	_, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, auth, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if factory.apiCalls != 2 {
+		t.Errorf("Unexpected number of OCI API calls: %v", factory.apiCalls)
+	}
+}
+
+func TestGetSecretBundles_ExistingSecretWithResourcePrincipal_ReturnSecretBundle(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID:              "stub-secret-id-1",
+				secretName:            "foo",
+				secretBase64Content:   "YmFyMQ==",
+				requestSecretVersion:  1,
+				responseSecretVersion: 1,
+				responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+
+	var auth *types.Auth = &types.Auth{Type: types.Resource}
+	var factory = &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	var secretService SecretService = &OCISecretService{factory: factory}
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}, auth, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedBundle := &types.SecretBundle{
+		ID: "stub-secret-id-1", Name: "foo", VersionNumber: 1,
+		Stages:        []types.Stage{types.Current},
+		BundleContent: &types.SecretBundleContent{Content: "YmFyMQ==", ContentType: types.Base64},
+	}
+	assertSecretBundle(t, secretBundles[0], expectedBundle)
+}
+
+func TestCreateConfigProvider_ResourcePrincipalWithoutEnvironment_ReturnError(t *testing.T) {
+	for _, version := range []string{"", "2.2"} {
+		t.Setenv(auth.ResourcePrincipalVersionEnvVar, version)
+		t.Setenv(auth.ResourcePrincipalRPSTEnvVar, "") // restores the variable after the test
+		if err := os.Unsetenv(auth.ResourcePrincipalRPSTEnvVar); err != nil {
+			t.Fatalf("Precondition failed: %v", err)
+		}
+
+		configProvider, err := (&OCISecretClientFactory{}).createConfigProvider(&types.Auth{Type: types.Resource})
+		if err == nil {
+			t.Fatal("An error was expected")
+		}
+		if configProvider != nil {
+			t.Errorf("Configuration provider should be nil: %v", configProvider)
+		}
+		if !strings.HasPrefix(err.Error(), "unable to create resource principal configuration provider") {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestSecretProviderClass_ContextWithName_ReturnName(t *testing.T) {
+	ctx := WithSecretProviderClass(context.Background(), "my-spc")
+	if name := secretProviderClass(ctx); name != "my-spc" {
+		t.Errorf("Unexpected SecretProviderClass: %v", name)
+	}
+	if name := secretProviderClass(context.Background()); name != "" {
+		t.Errorf("Unexpected SecretProviderClass: %v", name)
+	}
+}
+
+// TestGetSecretBundles_ConcurrentCallsWithCache_NoDataRaces is meant to run with -race,
+// imitating many pods mounting the same secrets through the shared service and cache.
+func TestGetSecretBundles_ConcurrentCallsWithCache_NoDataRaces(t *testing.T) {
+	const calls = 100
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{
+			{
+				secretID: "stub-secret-id-1", secretName: "foo", secretBase64Content: "YmFyMQ==",
+				requestSecretVersion: 1, responseSecretVersion: 1,
+				responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+			{
+				secretID: "stub-secret-id-2", secretName: "hello", secretBase64Content: "d29ybGQ=",
+				requestSecretVersion: 2, responseSecretVersion: 2,
+				responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+			},
+		},
+	}
+	auth := &types.Auth{Type: types.Instance}
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory, config: Config{CacheTTL: time.Minute}}
+
+	var wg sync.WaitGroup
+	errs := make([]error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			secretBundleRequests := []*types.SecretBundleRequest{
+				{Name: "foo", VersionNumber: 1},
+				{Name: "hello", VersionNumber: 2, FileName: fmt.Sprintf("hello-%v", i)},
+			}
+			var secretBundles []*types.SecretBundle
+			secretBundles, errs[i] = secretService.GetSecretBundles(context.Background(),
+				secretBundleRequests, auth, types.VaultID(testCaseMockData.vaultID))
+			if errs[i] == nil && secretBundles[1].FileName != fmt.Sprintf("hello-%v", i) {
+				errs[i] = fmt.Errorf("file name of other call: %v", secretBundles[1].FileName)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestGetSecretBundles_ConcurrentIdenticalFetches_CallOCIOnce(t *testing.T) {
+	const calls = 10
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{{
+			secretID: "stub-secret-id-1", secretName: "foo", secretBase64Content: "YmFyMQ==",
+			requestSecretVersion: 1, responseSecretVersion: 1,
+			responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+		}},
+	}
+	auth := &types.Auth{Type: types.Instance}
+	// the callers coming once the fetch is done hit the cache, so the number of calls doesn't depend on timing
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData, apiLatency: 100 * time.Millisecond}
+	secretService := &OCISecretService{factory: factory, config: Config{CacheTTL: time.Minute}}
+
+	var wg sync.WaitGroup
+	errs := make([]error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fileName := fmt.Sprintf("foo-%v", i)
+			secretBundles, err := secretService.GetSecretBundles(context.Background(),
+				[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1, FileName: fileName}},
+				auth, types.VaultID(testCaseMockData.vaultID))
+			switch {
+			case err != nil:
+				errs[i] = err
+			case secretBundles[0].FileName != fileName || secretBundles[0].BundleContent.Content != "YmFyMQ==":
+				errs[i] = fmt.Errorf("unexpected bundle: %+v", secretBundles[0])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&factory.apiCalls); calls != 1 {
+		t.Errorf("Unexpected number of OCI API calls: %v", calls)
+	}
+}
+
+func TestGetSecretBundles_SameUserWithOtherPrivateKey_NotServedFromCacheOrFlight(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{{
+			secretID: "stub-secret-id-1", secretName: "foo", secretBase64Content: "YmFyMQ==",
+			requestSecretVersion: 1, responseSecretVersion: 1,
+			responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+		}},
+	}
+	victim := &types.Auth{Type: types.User, Config: types.AuthConfig{
+		TenancyID: "tenancy", UserID: "user", Fingerprint: "fingerprint", PrivateKey: "victim-key"}}
+	forged := &types.Auth{Type: types.User, Config: victim.Config}
+	forged.Config.PrivateKey = "forged-key"
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData, apiLatency: 100 * time.Millisecond}
+	secretService := &OCISecretService{factory: factory, config: Config{CacheTTL: time.Minute}}
+	getBundles := func(auth *types.Auth) error {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "foo", VersionNumber: 1}}, auth, types.VaultID(testCaseMockData.vaultID))
+		return err
+	}
+
+	// the forged request joins the fetch of the victim in flight, unless the principals differ
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, auth := range []*types.Auth{victim, forged} {
+		wg.Add(1)
+		go func(i int, auth *types.Auth) {
+			defer wg.Done()
+			errs[i] = getBundles(auth)
+		}(i, auth)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&factory.apiCalls); calls != 2 {
+		t.Errorf("Fetch in flight should not be shared: %v OCI API calls", calls)
+	}
+
+	// both bundles are cached now, the request of yet another key is not served by them
+	forged.Config.PrivateKey = "other-forged-key"
+	if err := getBundles(forged); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls := atomic.LoadInt32(&factory.apiCalls); calls != 3 {
+		t.Errorf("Cached bundle should not be served: %v OCI API calls", calls)
+	}
+}
+
+// newManySecretsMockData prepares mock data of secrets secret-0, secret-1, ... of version 1
+func newManySecretsMockData(count int) (testCaseMockData, []*types.SecretBundleRequest) {
+	testCaseMockData := testCaseMockData{vaultID: "stub-vault-id"}
+	requests := make([]*types.SecretBundleRequest, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("secret-%v", i)
+		testCaseMockData.secretsMockData = append(testCaseMockData.secretsMockData, secretMockData{
+			secretID: "id-" + name, secretName: name, secretBase64Content: "YmFyMQ==",
+			requestSecretVersion: 1, responseSecretVersion: 1,
+			responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+		})
+		requests[i] = &types.SecretBundleRequest{Name: name, VersionNumber: 1}
+	}
+	return testCaseMockData, requests
+}
+
+func TestGetSecretBundles_ManySecrets_ReturnBundlesInRequestOrder(t *testing.T) {
+	testCaseMockData, requests := newManySecretsMockData(12)
+	factory := &MockOCISecretClientFactory{
+		testCaseMockData: testCaseMockData,
+		apiLatency:       20 * time.Millisecond,
+		callsTracker:     &concurrentCallsTracker{},
+	}
+	secretService := &OCISecretService{factory: factory, config: Config{MaxConcurrentFetches: 3}}
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(), requests,
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(secretBundles) != len(requests) {
+		t.Fatalf("Unexpected number of bundles: %v", len(secretBundles))
+	}
+	for i, secretBundle := range secretBundles {
+		if secretBundle.Name != requests[i].Name || secretBundle.ID != "id-"+requests[i].Name {
+			t.Errorf("Bundle %v doesn't match the request %v", secretBundle.ID, requests[i].Name)
+		}
+	}
+	maxInProgress := atomic.LoadInt32(&factory.callsTracker.maxInProgress)
+	if maxInProgress < 2 || maxInProgress > 3 {
+		t.Errorf("Unexpected number of concurrent OCI calls: %v", maxInProgress)
+	}
+}
+
+func TestGetSecretBundles_SingleFetchFailed_AbortBatchWithFirstError(t *testing.T) {
+	testCaseMockData, requests := newManySecretsMockData(6)
+	// the second secret fails at once, while the first one is still being fetched
+	requests[1].Stage = types.Current
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData, apiLatency: time.Hour}
+	secretService := &OCISecretService{factory: factory, config: Config{MaxConcurrentFetches: 2}}
+
+	_, err := secretService.GetSecretBundles(context.Background(), requests,
+		&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "secret should be identified either with a version number or with stage" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+	if apiCalls := atomic.LoadInt32(&factory.apiCalls); apiCalls != 1 {
+		t.Errorf("Remaining secrets should not be fetched: %v", apiCalls)
+	}
+}
+
+// newStagedVaultService serves the secret versions by stage and records the requested stages,
+// the stages without version are not found
+func newStagedVaultService(t *testing.T, versions map[string]int64, config Config,
+	requestedStages *[]string) *OCISecretService {
+
+	t.Helper()
+	var mutex sync.Mutex
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			stage := request.URL.Query().Get("stage")
+			mutex.Lock()
+			*requestedStages = append(*requestedStages, stage)
+			mutex.Unlock()
+			writer.Header().Set("Content-Type", "application/json")
+			versionNumber, ok := versions[stage]
+			if !ok {
+				writer.WriteHeader(http.StatusNotFound)
+				_, _ = writer.Write([]byte(`{"code": "NotAuthorizedOrNotFound", "message": "stub"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(writer, `{"secretId": "secret-id", "versionNumber": %d, "stages": [%q],
+				"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}}`, versionNumber, stage)
+		}))
+	config.Endpoint, config.CABundleFile = server.URL, caBundleFile
+	secretService, err := NewOCISecretService(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return secretService
+}
+
+func TestGetSecretBundles_CustomDefaultStages_ReturnVersionOfFirstFoundStage(t *testing.T) {
+	var requestedStages []string
+	secretService := newStagedVaultService(t, map[string]int64{"CURRENT": 2},
+		Config{DefaultStages: []types.Stage{types.Latest, types.Current}}, &requestedStages)
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].VersionNumber != 2 {
+		t.Errorf("Wrong version number: %v", secretBundles[0].VersionNumber)
+	}
+	if strings.Join(requestedStages, ",") != "LATEST,CURRENT" {
+		t.Errorf("Wrong requested stages: %v", requestedStages)
+	}
+}
+
+func TestGetSecretBundles_FirstDefaultStageFound_ReturnItsVersionOnly(t *testing.T) {
+	var requestedStages []string
+	secretService := newStagedVaultService(t, map[string]int64{"LATEST": 3, "CURRENT": 2},
+		Config{DefaultStages: []types.Stage{types.Latest, types.Current}}, &requestedStages)
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].VersionNumber != 3 {
+		t.Errorf("Wrong version number: %v", secretBundles[0].VersionNumber)
+	}
+	if strings.Join(requestedStages, ",") != "LATEST" {
+		t.Errorf("Wrong requested stages: %v", requestedStages)
+	}
+}
+
+func TestGetSecretBundles_NoDefaultStagesConfigured_RequestCurrentStage(t *testing.T) {
+	var requestedStages []string
+	secretService := newStagedVaultService(t, map[string]int64{"LATEST": 3, "CURRENT": 2}, Config{}, &requestedStages)
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].VersionNumber != 2 || strings.Join(requestedStages, ",") != "CURRENT" {
+		t.Errorf("Unexpected version %v of stages %v", secretBundles[0].VersionNumber, requestedStages)
+	}
+}
+
+func TestGetSecretBundles_NoDefaultStageFound_ReturnNotFoundError(t *testing.T) {
+	var requestedStages []string
+	secretService := newStagedVaultService(t, map[string]int64{"PENDING": 4},
+		Config{DefaultStages: []types.Stage{types.Latest, types.Current}}, &requestedStages)
+
+	_, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: secret foo is not found or access is not authorized "+
+		"(NotAuthorizedOrNotFound, status 404)" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+	if strings.Join(requestedStages, ",") != "LATEST,CURRENT" {
+		t.Errorf("Wrong requested stages: %v", requestedStages)
+	}
+}
+
+func TestGetSecretBundles_ExplicitStage_IgnoreDefaultStages(t *testing.T) {
+	var requestedStages []string
+	secretService := newStagedVaultService(t, map[string]int64{"PENDING": 4},
+		Config{DefaultStages: []types.Stage{types.Latest, types.Current}}, &requestedStages)
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo", Stage: types.Pending}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].VersionNumber != 4 || strings.Join(requestedStages, ",") != "PENDING" {
+		t.Errorf("Unexpected version %v of stages %v", secretBundles[0].VersionNumber, requestedStages)
+	}
+}
+
+// newVaultServiceOfSecrets serves the secrets by name, responding with the status code given for each of them,
+// the secrets which are not listed are not found
+func newVaultServiceOfSecrets(t *testing.T, statusCodes map[string]int) *OCISecretService {
+	t.Helper()
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			name := request.URL.Query().Get("secretName")
+			writer.Header().Set("Content-Type", "application/json")
+			statusCode, ok := statusCodes[name]
+			if !ok {
+				statusCode = http.StatusNotFound
+			}
+			if statusCode != http.StatusOK {
+				writer.WriteHeader(statusCode)
+				_, _ = writer.Write([]byte(`{"code": "stub", "message": "stub"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(writer, `{"secretId": "%v-id", "versionNumber": 1, "stages": ["CURRENT"],
+				"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}}`, name)
+		}))
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return secretService
+}
+
+func TestGetSecretBundles_OptionalSecretNotFound_ReturnFoundSecretsOnly(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{"foo": http.StatusOK, "baz": http.StatusOK})
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(), []*types.SecretBundleRequest{
+		{Name: "foo"}, {Name: "missing", Optional: true}, {Name: "baz"},
+	}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(secretBundles) != 2 || secretBundles[0].Name != "foo" || secretBundles[1].Name != "baz" {
+		t.Errorf("Unexpected secret bundles: %v", secretBundles)
+	}
+}
+
+func TestGetSecretBundles_RequiredSecretNotFound_ReturnError(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{"foo": http.StatusOK})
+
+	_, err := secretService.GetSecretBundles(context.Background(), []*types.SecretBundleRequest{
+		{Name: "foo", Optional: true}, {Name: "missing"},
+	}, newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: secret missing is not found or access is not authorized "+
+		"(stub, status 404)" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_NotAuthenticated_WrapServiceError(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{"foo": http.StatusUnauthorized})
+
+	_, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	var serviceError common.ServiceError
+	if !errors.As(err, &serviceError) || serviceError.GetHTTPStatusCode() != http.StatusUnauthorized {
+		t.Errorf("Service error should be wrapped: %v", err)
+	}
+	if !errors.Is(err, ErrNotAuthorized) || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("Wrong failure category: %v", err)
+	}
+	if err.Error() != "unable to retrieve secret from vault: OCI Vault failed to return secret foo: "+
+		"stub (stub, status 401)" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestGetSecretBundles_FailedCalls_MatchFailureCategory(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{
+		"forbidden": http.StatusForbidden, "broken": http.StatusBadRequest,
+	})
+	testCases := map[string]error{"missing": ErrSecretNotFound, "forbidden": ErrNotAuthorized, "broken": nil}
+
+	for name, category := range testCases {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: name}}, newUserAuth(t), "stub-vault-id")
+		if err == nil {
+			t.Fatal("An error was expected")
+		}
+		for _, otherCategory := range []error{ErrSecretNotFound, ErrNotAuthorized, ErrThrottled, ErrVaultUnavailable} {
+			if errors.Is(err, otherCategory) != (otherCategory == category) {
+				t.Errorf("Wrong failure category of %v: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestGetSecretBundles_OptionalSecretFailedOtherwise_ReturnError(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{
+		"foo": http.StatusOK, "broken": http.StatusBadRequest,
+	})
+
+	_, err := secretService.GetSecretBundles(context.Background(), []*types.SecretBundleRequest{
+		{Name: "foo"}, {Name: "broken", Optional: true},
+	}, newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to retrieve secret from vault: OCI Vault failed to return secret broken: "+
+		"stub (stub, status 400)" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestMapOCIResponseToSecretBundle_PlaintextContent_ReturnContentAsIs(t *testing.T) {
+	ociBundle := secrets.SecretBundle{}
+	err := json.Unmarshal([]byte(`{"secretId": "foo-id", "versionNumber": 1, "stages": ["CURRENT"],
+		"secretBundleContent": {"contentType": "TEXT", "content": "bar"}}`), &ociBundle)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to parse OCI secret bundle: %v", err)
+	}
+	service := &OCISecretService{}
+
+	secretBundle, err := service.mapOCIResponseToSecretBundle(
+		secrets.GetSecretBundleByNameResponse{SecretBundle: ociBundle}, &types.SecretBundleRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if secretBundle.BundleContent.ContentType != types.Plaintext {
+		t.Errorf("Wrong content type: %v", secretBundle.BundleContent.ContentType.String())
+	}
+	content, err := secretBundle.BundleContent.Decode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content != "bar" {
+		t.Errorf("Wrong content: %v", content)
+	}
+}
+
+func TestMapOCIResponseToSecretBundle_UnknownContentType_ReturnError(t *testing.T) {
+	ociBundle := secrets.SecretBundle{}
+	err := json.Unmarshal([]byte(`{"secretId": "foo-id", "versionNumber": 1,
+		"secretBundleContent": {"contentType": "BINARY", "content": "bar"}}`), &ociBundle)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to parse OCI secret bundle: %v", err)
+	}
+	service := &OCISecretService{}
+
+	_, err = service.mapOCIResponseToSecretBundle(
+		secrets.GetSecretBundleByNameResponse{SecretBundle: ociBundle}, &types.SecretBundleRequest{Name: "foo"})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "unable to cast secret content" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// newVaultServiceWithMetadata creates the service reading file names from "mountPath" metadata of the secrets,
+// the vault returns the metadata JSON of the secret by its name
+func newVaultServiceWithMetadata(t *testing.T, metadata map[string]string) *OCISecretService {
+	t.Helper()
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			name := request.URL.Query().Get("secretName")
+			secretMetadata, ok := metadata[name]
+			if !ok {
+				secretMetadata = "null"
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(writer, `{"secretId": "%v-id", "versionNumber": 1, "stages": ["CURRENT"],
+				"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}, "metadata": %v}`, name, secretMetadata)
+		}))
+	secretService, err := NewOCISecretService(Config{
+		Endpoint: server.URL, CABundleFile: caBundleFile, FileNameMetadataKey: "mountPath",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return secretService
+}
+
+func TestGetSecretBundles_FileNameInMetadata_ReturnMetadataFilePath(t *testing.T) {
+	secretService := newVaultServiceWithMetadata(t, map[string]string{
+		"foo":     `{"mountPath": "db/password", "owner": "team-a"}`,
+		"aliased": `{"mountPath": "db/user"}`,
+		"other":   `{"owner": "team-a"}`,
+	})
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(), []*types.SecretBundleRequest{
+		{Name: "foo"}, {Name: "aliased", FileName: "user"}, {Name: "other"}, {Name: "plain"},
+	}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedPaths := []string{"db/password", "user", "other", "plain"}
+	for i, secretBundle := range secretBundles {
+		if secretBundle.GetFilePath() != expectedPaths[i] {
+			t.Errorf("Wrong file path of %v: %v", secretBundle.Name, secretBundle.GetFilePath())
+		}
+	}
+}
+
+func TestGetSecretBundles_MetadataKeyNotConfigured_ReturnSecretName(t *testing.T) {
+	secretService := newVaultServiceWithMetadata(t, map[string]string{"foo": `{"mountPath": "db/password"}`})
+	secretService.config.FileNameMetadataKey = ""
+
+	secretBundles, err := secretService.GetSecretBundles(context.Background(),
+		[]*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t), "stub-vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if secretBundles[0].GetFilePath() != "foo" {
+		t.Errorf("Wrong file path: %v", secretBundles[0].GetFilePath())
+	}
+}
+
+func TestGetSecretBundles_InvalidFileNameInMetadata_ReturnError(t *testing.T) {
+	testCases := map[string]struct {
+		metadata map[string]string
+		message  string
+	}{
+		"traversal": {
+			map[string]string{"foo": `{"mountPath": "../../etc/passwd"}`},
+			`invalid file path of secret foo: file path "../../etc/passwd" should not contain ".." elements`,
+		},
+		"absolute": {
+			map[string]string{"foo": `{"mountPath": "/etc/passwd"}`},
+			`invalid file path of secret foo: file path "/etc/passwd" should be relative to the mount target`,
+		},
+		"collision": {
+			map[string]string{"foo": `{"mountPath": "bar"}`},
+			"secrets foo and bar are mounted to the same file path bar",
+		},
+		"not string": {
+			map[string]string{"foo": `{"mountPath": 42}`},
+			`metadata "mountPath" of secret foo should be a string`,
+		},
+	}
+
+	for name, testCase := range testCases {
+		secretService := newVaultServiceWithMetadata(t, testCase.metadata)
+
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "foo"}, {Name: "bar"}}, newUserAuth(t), "stub-vault-id")
+		if err == nil {
+			t.Fatalf("An error was expected for %v", name)
+		}
+		if err.Error() != testCase.message {
+			t.Errorf("Wrong error message for %v: %v", name, err)
+		}
+	}
+}
+
+func TestGetSecretBundles_RetriedFetchAndCachedSecret_ReportFetchOutcomes(t *testing.T) {
+	var mutex sync.Mutex
+	failures := map[string]int{"flaky": 1}
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			name := request.URL.Query().Get("secretName")
+			writer.Header().Set("Content-Type", "application/json")
+			mutex.Lock()
+			failed := failures[name] > 0
+			failures[name]--
+			mutex.Unlock()
+			if failed {
+				writer.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = writer.Write([]byte(`{"code": "stub", "message": "stub"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(writer, `{"secretId": "%v-id", "versionNumber": 1, "stages": ["CURRENT"],
+				"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}}`, name)
+		}))
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile,
+		CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	requests := []*types.SecretBundleRequest{{Name: "flaky"}, {Name: "stable"}}
+	auth := newUserAuth(t)
+
+	fetchedBundles, err := secretService.GetSecretBundles(context.Background(), requests, auth, "vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cachedBundles, err := secretService.GetSecretBundles(context.Background(), requests, auth, "vault-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if fetchedBundles[0].Attempts != 2 || fetchedBundles[1].Attempts != 1 ||
+		fetchedBundles[0].CacheHit || fetchedBundles[1].CacheHit {
+		t.Errorf("Wrong outcomes of fetched secrets: %+v, %+v", fetchedBundles[0], fetchedBundles[1])
+	}
+	for _, bundle := range cachedBundles {
+		if !bundle.CacheHit || bundle.Attempts != 0 {
+			t.Errorf("Wrong outcome of cached secret: %+v", bundle)
+		}
+	}
+}
+
+func TestGetSecretBundles_ContextLogger_LogRetrievalFailureWithMountContext(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{"foo": http.StatusBadRequest})
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), zerolog.New(&logs).With().Str("mountId", "mount-1").Logger())
+
+	if _, err := secretService.GetSecretBundles(ctx, []*types.SecretBundleRequest{{Name: "foo"}}, newUserAuth(t),
+		"stub-vault-id"); err == nil {
+		t.Fatal("An error was expected")
+	}
+
+	if !strings.Contains(logs.String(), "Unable to retrieve secret from vault") ||
+		!strings.Contains(logs.String(), `"mountId":"mount-1"`) {
+		t.Errorf("Failure should be logged with the context logger: %v", logs.String())
+	}
+}
+
+func TestMapToOCIRequest_AllStages_RequestOCIStage(t *testing.T) {
+	expectedStages := map[types.Stage]secrets.GetSecretBundleByNameStageEnum{
+		types.Current:    secrets.GetSecretBundleByNameStageCurrent,
+		types.Pending:    secrets.GetSecretBundleByNameStagePending,
+		types.Latest:     secrets.GetSecretBundleByNameStageLatest,
+		types.Previous:   secrets.GetSecretBundleByNameStagePrevious,
+		types.Deprecated: secrets.GetSecretBundleByNameStageDeprecated,
+	}
+	service := &OCISecretService{}
+	for stage, expected := range expectedStages {
+		ociRequest := service.mapToOCIRequest("vault-id", &types.SecretBundleRequest{Name: "foo", Stage: stage})
+		if ociRequest.Stage != expected || ociRequest.VersionNumber != nil {
+			t.Errorf("Wrong OCI request of stage %v: %v", stage.String(), ociRequest)
+		}
+	}
+}
+
+func TestMapOCIResponseToSecretBundle_AllStages_ReturnBundleStages(t *testing.T) {
+	ociBundle := secrets.SecretBundle{}
+	err := json.Unmarshal([]byte(`{"secretId": "foo-id", "versionNumber": 1,
+		"stages": ["CURRENT", "PENDING", "LATEST", "PREVIOUS", "DEPRECATED"],
+		"secretBundleContent": {"contentType": "BASE64", "content": "YmFy"}}`), &ociBundle)
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to parse OCI secret bundle: %v", err)
+	}
+	service := &OCISecretService{}
+
+	secretBundle, err := service.mapOCIResponseToSecretBundle(
+		secrets.GetSecretBundleByNameResponse{SecretBundle: ociBundle}, &types.SecretBundleRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []types.Stage{types.Current, types.Pending, types.Latest, types.Previous, types.Deprecated}
+	if len(secretBundle.Stages) != len(expected) {
+		t.Fatalf("Wrong stages: %v", secretBundle.Stages)
+	}
+	for i, stage := range expected {
+		if secretBundle.Stages[i] != stage {
+			t.Errorf("Wrong stage %v: %v", i, secretBundle.Stages[i].String())
+		}
+	}
+}
+
+func TestGetSecretBundles_PendingAndDeprecatedStages_ReturnVersionOfRequestedStage(t *testing.T) {
+	for stage, versionNumber := range map[types.Stage]int64{types.Pending: 4, types.Deprecated: 1} {
+		var requestedStages []string
+		secretService := newStagedVaultService(t, map[string]int64{"CURRENT": 3, "PENDING": 4, "DEPRECATED": 1},
+			Config{}, &requestedStages)
+
+		secretBundles, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "foo", Stage: stage}}, newUserAuth(t), "stub-vault-id")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if secretBundles[0].VersionNumber != versionNumber || strings.Join(requestedStages, ",") != stage.String() {
+			t.Errorf("Unexpected version %v of stages %v", secretBundles[0].VersionNumber, requestedStages)
+		}
+		assertSecretBundleHasStage(t, secretBundles[0], stage)
+	}
+}
+
+func TestGetSecretBundles_VaultFailing_FailFastUntilProbeSucceeds(t *testing.T) {
+	testCaseMockData, requests := newManySecretsMockData(1)
+	// the mocked client fails the calls of unknown secrets like an unreachable vault
+	failingRequests := []*types.SecretBundleRequest{{Name: "unreachable", VersionNumber: 1}}
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	fakeClock := clock.NewFake(time.Now())
+	secretService := &OCISecretService{factory: factory,
+		config: Config{CircuitFailureThreshold: 2, CircuitCooldown: time.Minute}}
+	secretService.breaker.clock = fakeClock
+	auth := &types.Auth{Type: types.Instance}
+	vaultID := types.VaultID(testCaseMockData.vaultID)
+
+	for i := 0; i < 2; i++ {
+		_, err := secretService.GetSecretBundles(context.Background(), failingRequests, auth, vaultID)
+		if err == nil || errors.Is(err, ErrVaultUnavailable) {
+			t.Fatalf("Call should fail on its own: %v", err)
+		}
+	}
+	_, err := secretService.GetSecretBundles(context.Background(), requests, auth, vaultID)
+	if !errors.Is(err, ErrVaultUnavailable) {
+		t.Fatalf("Call should fail fast: %v", err)
+	}
+	if !strings.Contains(err.Error(), "OCI Vault calls are suspended until") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+	if apiCalls := atomic.LoadInt32(&factory.apiCalls); apiCalls != 2 {
+		t.Errorf("Vault should not be called while the circuit is open: %v", apiCalls)
+	}
+
+	fakeClock.Advance(time.Minute)
+	if _, err := secretService.GetSecretBundles(context.Background(), requests, auth, vaultID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = secretService.GetSecretBundles(context.Background(), failingRequests, auth, vaultID)
+	if err == nil || errors.Is(err, ErrVaultUnavailable) {
+		t.Errorf("Succeeded probe should close the circuit: %v", err)
+	}
+}
+
+func TestGetSecretBundles_VaultFailingForPrincipal_KeepCircuitOfOthersClosed(t *testing.T) {
+	testCaseMockData, requests := newManySecretsMockData(1)
+	failingRequests := []*types.SecretBundleRequest{{Name: "unreachable", VersionNumber: 1}}
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory,
+		config: Config{CircuitFailureThreshold: 1, CircuitCooldown: time.Minute}}
+	vaultID := types.VaultID(testCaseMockData.vaultID)
+	failingAuth := &types.Auth{Type: types.Instance, Region: "us-ashburn-1"}
+
+	_, err := secretService.GetSecretBundles(context.Background(), failingRequests, failingAuth, vaultID)
+	if err == nil || errors.Is(err, ErrVaultUnavailable) {
+		t.Fatalf("Call should fail on its own: %v", err)
+	}
+	if _, err := secretService.GetSecretBundles(context.Background(), requests, failingAuth, vaultID); !errors.Is(
+		err, ErrVaultUnavailable) {
+		t.Fatalf("Call should fail fast: %v", err)
+	}
+
+	otherAuths := []*types.Auth{{Type: types.Instance, Region: "us-phoenix-1"}, {Type: types.Resource}, newUserAuth(t)}
+	for _, auth := range otherAuths {
+		if _, err := secretService.GetSecretBundles(context.Background(), requests, auth, vaultID); err != nil {
+			t.Errorf("Calls of other principals and regions should be allowed: %v", err)
+		}
+	}
+}
+
+func TestGetSecretBundles_SecretNotFound_KeepCircuitClosed(t *testing.T) {
+	secretService := newVaultServiceOfSecrets(t, map[string]int{"foo": http.StatusOK})
+	secretService.config.CircuitFailureThreshold = 1
+
+	for i := 0; i < 2; i++ {
+		_, err := secretService.GetSecretBundles(context.Background(),
+			[]*types.SecretBundleRequest{{Name: "missing"}}, newUserAuth(t), "stub-vault-id")
+		if !errors.Is(err, ErrSecretNotFound) {
+			t.Fatalf("Answer of the vault should not open the circuit: %v", err)
+		}
+	}
+}
+
+func TestGetSecretBundles_CurrentVersionBumped_LogVersionChange(t *testing.T) {
+	testCaseMockData := testCaseMockData{
+		vaultID: "stub-vault-id",
+		secretsMockData: []secretMockData{{
+			secretID: "stub-secret-id", secretName: "foo", secretBase64Content: "YmFyMQ==",
+			requestSecretStage:    secrets.GetSecretBundleByNameStageCurrent,
+			responseSecretVersion: 1,
+			responseSecretStages:  []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+		}},
+	}
+	factory := &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}
+	secretService := &OCISecretService{factory: factory}
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), zerolog.New(&logs))
+	mount := func(version int64) {
+		t.Helper()
+		factory.testCaseMockData.secretsMockData[0].responseSecretVersion = version
+		_, err := secretService.GetSecretBundles(ctx, []*types.SecretBundleRequest{{Name: "foo"}},
+			&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	mount(1)
+	mount(1)
+	if strings.Contains(logs.String(), "Secret version changed") {
+		t.Fatalf("Unchanged version should not be reported: %v", logs.String())
+	}
+
+	mount(2)
+	var record map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Single log record is expected: %v", logs.String())
+	}
+	if record["message"] != "Secret version changed" || record["secret"] != "foo" || record["stage"] != "CURRENT" ||
+		record["previousVersion"] != float64(1) || record["version"] != float64(2) {
+		t.Errorf("Wrong version change record: %v", record)
+	}
+}
+
+func TestGetSecretBundles_PinnedVersions_NotTracked(t *testing.T) {
+	testCaseMockData, _ := newManySecretsMockData(1)
+	testCaseMockData.secretsMockData = append(testCaseMockData.secretsMockData, secretMockData{
+		secretID: "id-secret-0", secretName: "secret-0", secretBase64Content: "YmFyMQ==",
+		requestSecretVersion: 2, responseSecretVersion: 2,
+		responseSecretStages: []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesLatest},
+	})
+	secretService := &OCISecretService{factory: &MockOCISecretClientFactory{testCaseMockData: testCaseMockData}}
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), zerolog.New(&logs))
+
+	for _, version := range []types.VersionNumber{1, 2} {
+		requests := []*types.SecretBundleRequest{{Name: "secret-0", VersionNumber: version}}
+		_, err := secretService.GetSecretBundles(ctx, requests,
+			&types.Auth{Type: types.Instance}, types.VaultID(testCaseMockData.vaultID))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if strings.Contains(logs.String(), "Secret version changed") {
+		t.Errorf("Pinned versions should not be reported: %v", logs.String())
+	}
+}
+
+func TestVersionTracker_Full_EvictToStayBounded(t *testing.T) {
+	tracker := &versionTracker{}
+	for i := 0; i < maxTrackedVersions+10; i++ {
+		tracker.update(versionKey{vaultID: "vault1", name: fmt.Sprintf("secret-%v", i), stage: types.Current}, 1)
+	}
+
+	if len(tracker.versions) != maxTrackedVersions {
+		t.Errorf("Tracker should be bounded: %v", len(tracker.versions))
+	}
+	key := versionKey{vaultID: "vault1", name: fmt.Sprintf("secret-%v", maxTrackedVersions+9), stage: types.Current}
+	if previous, changed := tracker.update(key, 2); !changed || previous != 1 {
+		t.Errorf("Latest version should be tracked: %v, %v", previous, changed)
+	}
+}
+
+// mapBundleOfSize maps OCI response with base64-encoded content of the given size
+func mapBundleOfSize(t *testing.T, config Config, size int) (*types.SecretBundle, error) {
+	t.Helper()
+	content := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{'x'}, size))
+	ociBundle := secrets.SecretBundle{
+		SecretId:            common.String("foo-id"),
+		VersionNumber:       common.Int64(1),
+		Stages:              []secrets.SecretBundleStagesEnum{secrets.SecretBundleStagesCurrent},
+		SecretBundleContent: secrets.Base64SecretBundleContentDetails{Content: common.String(content)},
+	}
+	service := &OCISecretService{config: config}
+	return service.mapOCIResponseToSecretBundle(
+		secrets.GetSecretBundleByNameResponse{SecretBundle: ociBundle}, &types.SecretBundleRequest{Name: "foo"})
+}
+
+func TestMapOCIResponseToSecretBundle_SecretAtMaxSize_ReturnSecretBundle(t *testing.T) {
+	for _, config := range []Config{{}, {MaxSecretSize: 100}} {
+		maxSize := config.MaxSecretSize
+		if maxSize == 0 {
+			maxSize = defaultMaxSecretSize
+		}
+		secretBundle, err := mapBundleOfSize(t, config, maxSize)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		content, err := secretBundle.BundleContent.DecodeBytes()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(content) != maxSize {
+			t.Errorf("Wrong content size: %v", len(content))
+		}
+	}
+}
+
+func TestMapOCIResponseToSecretBundle_SecretOverMaxSize_ReturnError(t *testing.T) {
+	_, err := mapBundleOfSize(t, Config{MaxSecretSize: 100}, 101)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "secret foo is 101 bytes long, exceeding the maximum of 100 bytes" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+
+	_, err = mapBundleOfSize(t, Config{}, defaultMaxSecretSize+1)
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+}
+
+func TestNewOCISecretService_NegativeMaxSecretSize_ReturnError(t *testing.T) {
+	if _, err := NewOCISecretService(Config{MaxSecretSize: -1}); err == nil {
+		t.Fatal("An error was expected")
+	}
+}
@@ -9,9 +9,12 @@ package service
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/oracle/oci-go-sdk/v65/secrets"
 )
 
@@ -29,13 +32,16 @@ type secretMockData struct {
 	secretName          string
 	secretBase64Content string
 
-	// request secret version and stage could be empty
-	requestSecretVersion int64
-	requestSecretStage   secrets.GetSecretBundleByNameStageEnum
+	// request secret version, version name and stage could be empty
+	requestSecretVersion     int64
+	requestSecretVersionName string
+	requestSecretStage       secrets.GetSecretBundleByNameStageEnum
 
 	// expected response secret version and stage couldn't be empty
 	responseSecretVersion int64
 	responseSecretStages  []secrets.SecretBundleStagesEnum
+	// expected response expiry time could be empty
+	responseTimeOfExpiry *time.Time
 }
 
 func (testCase *testCaseMockData) prepareAPICallMocks() []apiCallMock {
@@ -48,12 +54,21 @@ func (testCase *testCaseMockData) prepareAPICallMocks() []apiCallMock {
 
 func (testCase *testCaseMockData) prepareAPICallMock(secretIndex int, vaultID string) apiCallMock {
 	secretMockData := testCase.secretsMockData[secretIndex]
+	var requestSecretVersionName *string
+	if secretMockData.requestSecretVersionName != "" {
+		requestSecretVersionName = &secretMockData.requestSecretVersionName
+	}
+	var responseTimeOfExpiry *common.SDKTime
+	if secretMockData.responseTimeOfExpiry != nil {
+		responseTimeOfExpiry = &common.SDKTime{Time: *secretMockData.responseTimeOfExpiry}
+	}
 	return apiCallMock{
 		request: secrets.GetSecretBundleByNameRequest{
-			SecretName:    &secretMockData.secretName,
-			VersionNumber: &secretMockData.requestSecretVersion,
-			Stage:         secretMockData.requestSecretStage,
-			VaultId:       &vaultID,
+			SecretName:        &secretMockData.secretName,
+			VersionNumber:     &secretMockData.requestSecretVersion,
+			SecretVersionName: requestSecretVersionName,
+			Stage:             secretMockData.requestSecretStage,
+			VaultId:           &vaultID,
 		},
 		response: secrets.GetSecretBundleByNameResponse{
 			SecretBundle: secrets.SecretBundle{
@@ -62,7 +77,8 @@ func (testCase *testCaseMockData) prepareAPICallMock(secretIndex int, vaultID st
 				SecretBundleContent: secrets.Base64SecretBundleContentDetails{
 					Content: &secretMockData.secretBase64Content,
 				},
-				Stages: secretMockData.responseSecretStages,
+				Stages:       secretMockData.responseSecretStages,
+				TimeOfExpiry: responseTimeOfExpiry,
 			},
 		},
 	}
@@ -77,6 +93,29 @@ type apiCallMock struct {
 // mockSecretClient - mocked OCI Vault client
 type mockSecretClient struct {
 	apiCallMocks []apiCallMock
+	apiCalls     *int32                  // optional counter of API calls
+	latency      time.Duration           // optional delay of each API call, interrupted by context
+	callsTracker *concurrentCallsTracker // optional tracker of API calls in progress
+}
+
+// concurrentCallsTracker records the maximum number of API calls in progress at once
+type concurrentCallsTracker struct {
+	inProgress    int32
+	maxInProgress int32
+}
+
+func (tracker *concurrentCallsTracker) start() {
+	inProgress := atomic.AddInt32(&tracker.inProgress, 1)
+	for {
+		maxInProgress := atomic.LoadInt32(&tracker.maxInProgress)
+		if inProgress <= maxInProgress || atomic.CompareAndSwapInt32(&tracker.maxInProgress, maxInProgress, inProgress) {
+			return
+		}
+	}
+}
+
+func (tracker *concurrentCallsTracker) finish() {
+	atomic.AddInt32(&tracker.inProgress, -1)
 }
 
 func newMockSecretClient(testCaseMockData testCaseMockData) *mockSecretClient {
@@ -85,9 +124,23 @@ func newMockSecretClient(testCaseMockData testCaseMockData) *mockSecretClient {
 }
 
 func (client *mockSecretClient) GetSecretBundleByName(
-	_ context.Context,
+	ctx context.Context,
 	request secrets.GetSecretBundleByNameRequest) (secrets.GetSecretBundleByNameResponse, error) {
 
+	if client.apiCalls != nil {
+		atomic.AddInt32(client.apiCalls, 1)
+	}
+	if client.callsTracker != nil {
+		client.callsTracker.start()
+		defer client.callsTracker.finish()
+	}
+	if client.latency > 0 {
+		select {
+		case <-ctx.Done():
+			return secrets.GetSecretBundleByNameResponse{}, ctx.Err()
+		case <-time.After(client.latency):
+		}
+	}
 	for _, expectedResult := range client.apiCallMocks {
 		if client.matchRequests(request, expectedResult.request) {
 			return expectedResult.response, nil
@@ -103,7 +156,14 @@ func (client *mockSecretClient) matchRequests(
 	if r1.VersionNumber != nil && r2.VersionNumber != nil {
 		match = match && *r1.VersionNumber == *r2.VersionNumber
 	}
-	return match
+	return match && stringValue(r1.SecretVersionName) == stringValue(r2.SecretVersionName)
+}
+
+func stringValue(pointer *string) string {
+	if pointer == nil {
+		return ""
+	}
+	return *pointer
 }
 
 // assertSecretBundle - assertion function for types.SecretBundle
@@ -0,0 +1,42 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// vaultAccessProbeSecret is requested by the vault access check, the secret is not expected to exist
+const vaultAccessProbeSecret = "oci-secrets-store-csi-driver-provider-access-probe"
+
+// CheckVaultAccess verifies that the principal authenticates against OCI Vault and the vault is reachable.
+// The secret which is not expected to exist is requested, so any response of OCI Vault,
+// except the authentication failure, means that secrets could be retrieved.
+func (service *OCISecretService) CheckVaultAccess(ctx context.Context, auth *types.Auth, vaultID types.VaultID) error {
+	clientSupplier := &secretClientSupplier{factory: service.factory, auth: auth, clock: clock.OrReal(service.clock)}
+	secretClient, err := clientSupplier.get(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to create OCI Vault client: %w", err)
+	}
+
+	request := &types.SecretBundleRequest{Name: vaultAccessProbeSecret, Stage: types.Current}
+	checkCtx, cancel := context.WithTimeout(ctx, service.fetchTimeout())
+	defer cancel()
+	_, err = secretClient.GetSecretBundleByName(checkCtx, service.mapToOCIRequest(string(vaultID), request))
+	var serviceError common.ServiceError
+	if err == nil || errors.As(err, &serviceError) && serviceError.GetHTTPStatusCode() != http.StatusUnauthorized {
+		return nil
+	}
+	return fmt.Errorf("unable to access OCI Vault: %w", err)
+}
@@ -0,0 +1,82 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+// newVaultServiceResponding creates the service calling fake OCI Vault which answers each request with the status
+func newVaultServiceResponding(t *testing.T, statusCode int) *OCISecretService {
+	t.Helper()
+	server, caBundleFile := newTLSServerWithCABundle(t, http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(statusCode)
+			_, _ = writer.Write([]byte(`{"code": "stub", "message": "stub"}`))
+		}))
+	secretService, err := NewOCISecretService(Config{Endpoint: server.URL, CABundleFile: caBundleFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return secretService
+}
+
+func TestCheckVaultAccess_ProbeSecretNotFound_ReturnNil(t *testing.T) {
+	secretService := newVaultServiceResponding(t, http.StatusNotFound)
+
+	if err := secretService.CheckVaultAccess(context.Background(), newUserAuth(t), "stub-vault-id"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestCheckVaultAccess_AuthenticationRejected_ReturnError(t *testing.T) {
+	secretService := newVaultServiceResponding(t, http.StatusUnauthorized)
+
+	err := secretService.CheckVaultAccess(context.Background(), newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "unable to access OCI Vault") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestCheckVaultAccess_VaultUnreachable_ReturnError(t *testing.T) {
+	secretService, err := NewOCISecretService(Config{Endpoint: "https://127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = secretService.CheckVaultAccess(context.Background(), newUserAuth(t), "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "unable to access OCI Vault") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+func TestCheckVaultAccess_InvalidPrincipal_ReturnError(t *testing.T) {
+	secretService, err := NewOCISecretService(Config{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err = secretService.CheckVaultAccess(context.Background(), &types.Auth{Type: "unknown"}, "stub-vault-id")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "unable to create OCI Vault client") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
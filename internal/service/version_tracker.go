@@ -0,0 +1,51 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package service
+
+import (
+	"sync"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/types"
+)
+
+// maxTrackedVersions bounds the memory of the version tracker, the provider serves far fewer secret stages
+const maxTrackedVersions = 10000
+
+// versionKey identifies a secret stage, e.g. CURRENT, which version changes on rotation
+type versionKey struct {
+	vaultID string
+	name    string
+	stage   types.Stage
+}
+
+// versionTracker remembers the last version retrieved for each secret stage, so rotations could be observed.
+// When the tracker is full, an arbitrary stage is forgotten, so its next change could go unnoticed.
+// Zero value is an empty tracker ready to use.
+type versionTracker struct {
+	mutex    sync.Mutex
+	versions map[versionKey]int64
+}
+
+// update remembers the version of the secret stage, it returns the previous version and whether it changed.
+// The first version retrieved for the stage is not a change.
+func (tracker *versionTracker) update(key versionKey, version int64) (int64, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if tracker.versions == nil {
+		tracker.versions = make(map[versionKey]int64)
+	}
+	previous, ok := tracker.versions[key]
+	if !ok && len(tracker.versions) >= maxTrackedVersions {
+		for evicted := range tracker.versions {
+			delete(tracker.versions, evicted)
+			break
+		}
+	}
+	tracker.versions[key] = version
+	return previous, ok && previous != version
+}
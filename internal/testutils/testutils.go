@@ -16,7 +16,7 @@ import (
 // RunTestCase intended to wrap execution of test case methods
 // in order to set up logging, configuration, and other settings.
 func RunTestCase(m *testing.M) {
-	logging.ConfigureGlobalLogger()
+	_ = logging.ConfigureGlobalLogger(logging.ConsoleFormat, logging.InfoLevel) // console format is always valid
 	exitCode := m.Run()
 	os.Exit(exitCode)
 }
@@ -7,48 +7,173 @@
 package types
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/oracle/oci-go-sdk/v65/common"
 	"gopkg.in/yaml.v3"
 	apiMachineryTypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // SecretBundleRequest represents request for a single secret bundle.
-// Bundle is identified by Name and either Stage or VersionNumber.
+// Bundle is identified by Name and one of Stage, VersionNumber or VersionName.
 type SecretBundleRequest struct {
 	Name          string        `yaml:"name"`
 	Stage         Stage         `yaml:"stage,omitempty"`
 	VersionNumber VersionNumber `yaml:"versionNumber,omitempty"`
+	VersionName   string        `yaml:"versionName,omitempty"`
 	FileName      string        `yaml:"fileName,omitempty"`
+	// CacheTTL is the number of seconds the retrieved bundle could be served from the provider's cache.
+	// Note that cached stage-based secrets are not refreshed on rotation until TTL expires.
+	CacheTTL int `yaml:"cacheTTL,omitempty"`
+	// Encoding of the mounted file content, PlainEncoding by default
+	Encoding Encoding `yaml:"encoding,omitempty"`
+	// JSONKey is the dot-separated path of the value mounted from the secret holding a JSON object
+	JSONKey string `yaml:"jsonKey,omitempty"`
+	// Format is the expected format of the mounted content, it's not validated when empty
+	Format Format `yaml:"format,omitempty"`
+	// Mode overrides the file permission of the mount request for this secret, e.g. 0400 for private keys
+	Mode FileMode `yaml:"mode,omitempty"`
+	// MinVersionNumber guards against rollback: the mount fails if the resolved version is older
+	MinVersionNumber VersionNumber `yaml:"minVersionNumber,omitempty"`
+	// Optional secret which is not found is skipped instead of failing the whole mount
+	Optional bool `yaml:"optional,omitempty"`
+	// Immutable secret is mounted without write permissions, regardless of the requested mode
+	Immutable bool `yaml:"immutable,omitempty"`
 }
 
+// Encoding defines whether the secret content is mounted decoded or as base64 returned by OCI Vault.
+type Encoding string
+
+const (
+	PlainEncoding  Encoding = "plain"
+	Base64Encoding Encoding = "base64"
+)
+
+// Format is the format the consumer expects the decoded secret content to have.
+type Format string
+
+const (
+	PEMFormat  Format = "pem"
+	JSONFormat Format = "json"
+	YAMLFormat Format = "yaml"
+)
+
 // String returns string representation of SecretBundleRequest.
 // Method is useful for secret bundle requests  logging.
 func (request *SecretBundleRequest) String() string {
+	if request.VersionName != "" {
+		return fmt.Sprintf("{name=%v, versionName=%v}", request.Name, request.VersionName)
+	}
 	return fmt.Sprintf("{name=%v, version=%v, stage=%v}",
 		request.Name, request.VersionNumber, request.Stage.String())
 }
 
+// NewSecretBundleRequest returns validated copy of the request.
+func NewSecretBundleRequest(request SecretBundleRequest) (*SecretBundleRequest, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// Validate checks that the request identifies a single secret version:
+// the name is required, version number, stage and version name exclude each other.
+// The request without any of them refers to the current version.
+func (request *SecretBundleRequest) Validate() error {
+	if request == nil || request.Name == "" {
+		return fmt.Errorf("missed secret name")
+	}
+	if request.VersionName != "" && (request.VersionNumber != 0 || request.Stage != None) {
+		return fmt.Errorf("secret identified with a version name should not have a version number or stage")
+	}
+	if request.VersionNumber != 0 && request.Stage != None {
+		return fmt.Errorf("secret should be identified either with a version number or with stage")
+	}
+	if request.CacheTTL < 0 {
+		return fmt.Errorf("cache TTL should not be negative")
+	}
+	if request.VersionNumber != 0 && request.VersionNumber < request.MinVersionNumber {
+		return fmt.Errorf("version number %v is below the minimum version number %v",
+			request.VersionNumber, request.MinVersionNumber)
+	}
+	switch request.Encoding {
+	case "", PlainEncoding, Base64Encoding:
+	default:
+		return fmt.Errorf("unknown encoding %q, should be %q or %q", request.Encoding, PlainEncoding, Base64Encoding)
+	}
+	if request.JSONKey != "" && request.Encoding == Base64Encoding {
+		return fmt.Errorf("secret with JSON key should not be mounted with base64 encoding")
+	}
+	switch request.Format {
+	case "", PEMFormat, JSONFormat, YAMLFormat:
+	default:
+		return fmt.Errorf("unknown format %q, should be %q, %q or %q", request.Format, PEMFormat, JSONFormat, YAMLFormat)
+	}
+	return ValidateFilePath(request.GetFilePath())
+}
+
+// ValidateFilePath rejects file paths which could escape the mount target, i.e. absolute paths
+// and paths with ".." elements remaining after normalization. Relative paths of nested directories are allowed.
+func ValidateFilePath(filePath string) error {
+	if filepath.IsAbs(filePath) || strings.HasPrefix(filePath, `\`) {
+		return fmt.Errorf("file path %q should be relative to the mount target", filePath)
+	}
+	if filepath.Clean(filePath) == "." {
+		return fmt.Errorf("file path %q should name a file inside the mount target", filePath)
+	}
+	for _, element := range strings.FieldsFunc(filePath, isPathSeparator) {
+		if element == ".." {
+			return fmt.Errorf("file path %q should not contain \"..\" elements", filePath)
+		}
+	}
+	return nil
+}
+
+// isPathSeparator accepts backslash as well, so paths are rejected the same way regardless of the node OS.
+func isPathSeparator(char rune) bool {
+	return char == '/' || char == '\\'
+}
+
 func (request *SecretBundleRequest) GetFilePath() string {
 	return determineFileName(request.Name, request.FileName)
 }
 
+// GetFilePath of the bundle falls back to the file name specified by the secret metadata, when the alias is not set
 func (request *SecretBundle) GetFilePath() string {
+	if strings.TrimSpace(request.FileName) == "" {
+		return determineFileName(request.Name, request.MetadataFileName)
+	}
 	return determineFileName(request.Name, request.FileName)
 }
 
+// determineFileName prefers the alias over the secret name. The alias could be a nested path like db/password,
+// it's normalized, so equivalent paths like ./db//password are mounted as the same file.
 func determineFileName(name string, alias string) string {
 	var fileName = strings.TrimSpace(name)
 
 	if fileAlias := strings.TrimSpace(alias); len(fileAlias) > 0 {
 		fileName = fileAlias
 	}
+	if fileName == "" {
+		return fileName
+	}
 
-	return fileName
+	return filepath.Clean(fileName)
 }
 
 type PodInfo struct {
@@ -58,6 +183,32 @@ type PodInfo struct {
 	ServiceAccountName string
 }
 
+// FileMode is the permission of a mounted file, it's given as an octal string like "0400".
+// Zero means that the mode is not set.
+type FileMode os.FileMode
+
+// MarshalYAML customizes marshaling of FileMode into a YAML document
+func (mode FileMode) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%04o", uint32(mode)), nil
+}
+
+// UnmarshalYAML customizes unmarshaling of YAML document into FileMode
+func (mode *FileMode) UnmarshalYAML(node *yaml.Node) error {
+	if node.Value == "" {
+		*mode = 0
+		return nil
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(node.Value, "0o"), 8, 32)
+	if err != nil || value > uint64(os.ModePerm) {
+		return fmt.Errorf("invalid file mode %q, should be octal permissions like \"0400\"", node.Value)
+	}
+	if value == 0 {
+		return fmt.Errorf("file mode %q should grant some permissions", node.Value)
+	}
+	*mode = FileMode(value)
+	return nil
+}
+
 type VersionNumber int64
 
 // UnmarshalYAML customizes unmarshaling of YAML document into VersionNumber
@@ -106,13 +257,14 @@ func (stage *Stage) String() string {
 	return stageMapping[*stage]
 }
 
+// FromString parses the stage case-insensitively like OCI SDK does, e.g. "pending" is PENDING
 func (stage *Stage) FromString(value string) error {
 	if value == "" {
 		*stage = None
 		return nil
 	}
 	for stageValue, stageString := range stageMapping {
-		if stageString == value {
+		if strings.EqualFold(stageString, value) {
 			*stage = stageValue
 			return nil
 		}
@@ -136,8 +288,27 @@ type SecretBundle struct {
 	Name          string
 	VersionNumber int64
 	FileName      string
+	Encoding      Encoding
+	JSONKey       string
+	Format        Format
+	Mode          FileMode
 	Stages        []Stage
 	BundleContent *SecretBundleContent
+	// TimeCreated and TimeOfExpiry are nil when OCI doesn't provide them
+	TimeCreated  *time.Time
+	TimeOfExpiry *time.Time
+	// TimeOfDeletion is set when the secret is scheduled for deletion
+	TimeOfDeletion *time.Time
+	// MinVersionNumber is the oldest version allowed to be mounted, any version is allowed when zero
+	MinVersionNumber int64
+	// Immutable bundle is mounted without write permissions
+	Immutable bool
+	// MetadataFileName is the file path specified by the secret metadata, if any
+	MetadataFileName string
+	// CacheHit is set when the bundle is served from the provider cache instead of OCI Vault
+	CacheHit bool
+	// Attempts is the number of HTTP calls retrieving the bundle including OCI SDK retries, zero for cache hits
+	Attempts int
 }
 
 // SecretBundleContent stores secrets content
@@ -146,16 +317,155 @@ type SecretBundleContent struct {
 	Content     string
 }
 
-// Decode decodes secret bundle content to plain text
+// DecodeErrorReason classifies failures of secret content decoding
+type DecodeErrorReason string
+
+const (
+	MissedContent      DecodeErrorReason = "missed_content"
+	UnknownContentType DecodeErrorReason = "unknown_content_type"
+	MalformedContent   DecodeErrorReason = "malformed_content"
+)
+
+// DecodeError is returned when secret bundle content could not be decoded
+type DecodeError struct {
+	Reason DecodeErrorReason
+	Err    error
+}
+
+func (decodeError *DecodeError) Error() string {
+	return decodeError.Err.Error()
+}
+
+func (decodeError *DecodeError) Unwrap() error {
+	return decodeError.Err
+}
+
+// Decode decodes secret bundle content to plain text, it's kept for callers expecting a string,
+// prefer DecodeBytes for binary secrets, e.g. keystores. Returned error is always *DecodeError.
 func (content *SecretBundleContent) Decode() (string, error) {
-	if content.Content == "" {
-		return "", fmt.Errorf("missed secret content")
+	decodedContent, err := content.DecodeBytes()
+	if err != nil {
+		return "", err
 	}
+	return string(decodedContent), nil
+}
+
+// DecodedSize returns the size in bytes of the decoded content without decoding it.
+// It's exact for well-formed content, malformed content fails to decode anyway.
+func (content *SecretBundleContent) DecodedSize() int {
 	if content.ContentType != Base64 {
-		return "", fmt.Errorf("unknown content type")
+		return len(content.Content)
+	}
+	return len(strings.TrimRight(content.Content, "=")) * 3 / 4
+}
+
+// DecodeBytes decodes the content like Decode, but without copying decoded content into a string,
+// so arbitrary binary content is returned as is. Plaintext content is returned without decoding.
+func (content *SecretBundleContent) DecodeBytes() ([]byte, error) {
+	if content.Content == "" {
+		return nil, &DecodeError{Reason: MissedContent, Err: fmt.Errorf("missed secret content")}
+	}
+	switch content.ContentType {
+	case Base64:
+	case Plaintext:
+		return []byte(content.Content), nil
+	default:
+		return nil, &DecodeError{Reason: UnknownContentType, Err: fmt.Errorf("unknown content type")}
 	}
 	decodedContent, err := base64.StdEncoding.DecodeString(content.Content)
-	return string(decodedContent), err
+	if err != nil {
+		return nil, &DecodeError{Reason: MalformedContent, Err: fmt.Errorf("malformed secret content: %w", err)}
+	}
+	return decodedContent, nil
+}
+
+// EncodedBytes returns the content still base64-encoded, failing like DecodeBytes on missed content
+// or unknown content type. The content itself is not validated. Plaintext content is encoded.
+func (content *SecretBundleContent) EncodedBytes() ([]byte, error) {
+	if content.Content == "" {
+		return nil, &DecodeError{Reason: MissedContent, Err: fmt.Errorf("missed secret content")}
+	}
+	switch content.ContentType {
+	case Base64:
+		return []byte(content.Content), nil
+	case Plaintext:
+		return []byte(base64.StdEncoding.EncodeToString([]byte(content.Content))), nil
+	default:
+		return nil, &DecodeError{Reason: UnknownContentType, Err: fmt.Errorf("unknown content type")}
+	}
+}
+
+// ExtractJSONKey returns the value at the dot-separated key path of the JSON object,
+// string values as is and other values serialized back to JSON.
+func ExtractJSONKey(content []byte, key string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber() // keep numbers as they are written
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("secret content is not valid JSON: %w", err)
+	}
+	for _, name := range strings.Split(key, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key %q is missing", key)
+		}
+		if value, ok = object[name]; !ok {
+			return nil, fmt.Errorf("key %q is missing", key)
+		}
+	}
+	if text, ok := value.(string); ok {
+		return []byte(text), nil
+	}
+	return json.Marshal(value)
+}
+
+// ValidateFormat checks that the decoded content parses as the format:
+// PEM should have at least one block, JSON should be a single value and YAML should be a mapping or a sequence,
+// since almost any text is a valid YAML scalar.
+func ValidateFormat(content []byte, format Format) error {
+	switch format {
+	case PEMFormat:
+		if block, _ := pem.Decode(content); block == nil {
+			return fmt.Errorf("no PEM block found")
+		}
+	case JSONFormat:
+		if !json.Valid(content) {
+			return fmt.Errorf("content is not valid JSON")
+		}
+	case YAMLFormat:
+		var document yaml.Node
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return fmt.Errorf("content is not valid YAML: %w", err)
+		}
+		if len(document.Content) == 0 ||
+			(document.Content[0].Kind != yaml.MappingNode && document.Content[0].Kind != yaml.SequenceNode) {
+			return fmt.Errorf("content is not a YAML mapping or sequence")
+		}
+	}
+	return nil
+}
+
+// minEncodedTextLength is the shortest content considered by LooksLikeBase64Text,
+// shorter values are too likely to be valid base64 by accident
+const minEncodedTextLength = 8
+
+// LooksLikeBase64Text reports whether the content is itself base64 of printable text,
+// which is a sign that the secret was base64-encoded twice before storing in the vault.
+func LooksLikeBase64Text(content string) bool {
+	trimmedContent := strings.TrimSpace(content)
+	if len(trimmedContent) < minEncodedTextLength {
+		return false
+	}
+	decodedContent, err := base64.StdEncoding.Strict().DecodeString(trimmedContent)
+	if err != nil || !utf8.Valid(decodedContent) {
+		return false
+	}
+	for _, symbol := range string(decodedContent) {
+		if !unicode.IsPrint(symbol) && !unicode.IsSpace(symbol) {
+			return false
+		}
+	}
+	return true
 }
 
 // ContentType is encoding type of secret content
@@ -163,12 +473,13 @@ type ContentType int
 
 const (
 	Base64 ContentType = iota
+	// Plaintext content is returned by OCI as is, without base64 encoding
+	Plaintext
 )
 
 // String returns string representation of ContentType
 func (contentType *ContentType) String() string {
-	// OCI Vault supports single content type: Base64
-	return []string{"BASE64"}[*contentType]
+	return []string{"BASE64", "TEXT"}[*contentType]
 }
 
 type OCIPrincipalType string
@@ -177,10 +488,35 @@ const (
 	Instance OCIPrincipalType = "instance"
 	User     OCIPrincipalType = "user"
 	Workload OCIPrincipalType = "workload"
+	// Resource principal is configured with environment variables of the provider
+	Resource OCIPrincipalType = "resource"
+	// File principal is configured with OCI config file on the provider's filesystem, e.g. mounted ~/.oci/config
+	File OCIPrincipalType = "file"
 )
 
 type VaultID string
 
+// vaultOCIDPattern matches vault OCID of any realm and region: ocid1.vault.<realm>.<region>[.<future use>].<unique ID>
+var vaultOCIDPattern = regexp.MustCompile(`^ocid1\.vault\.[a-z0-9]+\.[a-z0-9._-]*\.[a-z0-9]+$`)
+
+// Validate checks the format of vault OCID, so a typo is reported before any OCI call
+func (vaultID VaultID) Validate() error {
+	if vaultID == "" {
+		return fmt.Errorf("vault OCID is missing")
+	}
+	if !vaultOCIDPattern.MatchString(string(vaultID)) {
+		return fmt.Errorf("malformed vault OCID %q, expected ocid1.vault.<realm>.<region>.<unique ID>", vaultID)
+	}
+	return nil
+}
+
+// Digest returns short digest of vault OCID used in logs and metrics, so mounts could be correlated
+// without exposing the OCID itself
+func (vaultID VaultID) Digest() string {
+	digest := sha256.Sum256([]byte(vaultID))
+	return hex.EncodeToString(digest[:])[:12]
+}
+
 func MapToPrincipalType(authType string) (OCIPrincipalType, error) {
 	switch authType {
 	case string(Instance):
@@ -189,6 +525,10 @@ func MapToPrincipalType(authType string) (OCIPrincipalType, error) {
 		return User, nil
 	case string(Workload):
 		return Workload, nil
+	case string(Resource):
+		return Resource, nil
+	case string(File):
+		return File, nil
 	default:
 		return "", fmt.Errorf("unknown OCI principal type: %v", authType)
 	}
@@ -205,11 +545,58 @@ type Auth struct {
 	Type                OCIPrincipalType
 	Config              AuthConfig
 	WorkloadIdentityCfg WorkloadIdentityConfig
+	// Region is explicitly configured region of instance principal, it's discovered via IMDS when empty
+	Region string
+	// ConfigFile locates the credentials of file principal
+	ConfigFile ConfigFile
+}
+
+// ConfigFile is the profile of OCI config file, the file of the provider is read by the SDK
+type ConfigFile struct {
+	Path    string
+	Profile string
 }
 
 type WorkloadIdentityConfig struct {
-	// Region  string
+	// Region overrides the region of the workload identity, which is taken from the provider ENV when empty
+	Region  string
 	SaToken []byte
+	// ServiceAccount identifies the pod's service account as "namespace/name"
+	ServiceAccount string
+}
+
+// PrincipalKey returns the identity used to access OCI Vault, the bundles retrieved with it are shared by the requests
+// of the same key. Tenancy, user and fingerprint of user principal are not secret, so its key includes a digest
+// of the private key and the passphrase, otherwise anyone knowing them could get the bundles without the private key.
+// The key itself contains no credentials.
+func (auth *Auth) PrincipalKey() string {
+	switch auth.Type {
+	case User:
+		credentials := sha256.Sum256([]byte(auth.Config.PrivateKey + "\x00" + auth.Config.Passphrase))
+		return strings.Join([]string{string(auth.Type), auth.Config.TenancyID, auth.Config.UserID,
+			auth.Config.Fingerprint, hex.EncodeToString(credentials[:])}, "/")
+	case Workload:
+		return string(auth.Type) + "/" + auth.WorkloadIdentityCfg.ServiceAccount
+	case File:
+		return string(auth.Type) + "/" + auth.ConfigFile.Path + "[" + auth.ConfigFile.Profile + "]"
+	default:
+		return string(auth.Type)
+	}
+}
+
+// ExplicitRegion returns the region the principal is configured with, empty when it's discovered,
+// e.g. via IMDS or from the provider ENV
+func (auth *Auth) ExplicitRegion() string {
+	switch auth.Type {
+	case User:
+		return auth.Config.Region
+	case Workload:
+		return auth.WorkloadIdentityCfg.Region
+	case Instance:
+		return auth.Region
+	default:
+		return ""
+	}
 }
 
 type AuthConfig struct {
@@ -225,6 +612,20 @@ type AuthConfigYaml struct {
 	Auth map[string]string `yaml:"auth,omitempty"`
 }
 
+// NormalizeRegion maps either OCI region short code (e.g. "iad") or region identifier (e.g. "us-ashburn-1")
+// to the canonical region identifier. Unknown regions are reported as error.
+func NormalizeRegion(region string) (string, error) {
+	trimmedRegion := strings.TrimSpace(region)
+	if trimmedRegion == "" {
+		return "", fmt.Errorf("region is empty")
+	}
+	canonicalRegion := common.StringToRegion(trimmedRegion)
+	if _, err := canonicalRegion.RealmID(); err != nil {
+		return "", fmt.Errorf("unknown OCI region: %v", region)
+	}
+	return string(canonicalRegion), nil
+}
+
 func (config *AuthConfig) Validate() error {
 	return validateConfig(config).ToAggregate()
 }
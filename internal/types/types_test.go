@@ -7,6 +7,11 @@
 package types
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
@@ -48,6 +53,35 @@ func TestStageFromString_RegularStringRepresentation_ReturnValidStage(t *testing
 	}
 }
 
+func TestStageFromString_AnyCase_ReturnValidStage(t *testing.T) {
+	for value, expected := range map[string]Stage{"pending": Pending, "Deprecated": Deprecated, "LaTeSt": Latest} {
+		var stage Stage
+		if err := stage.FromString(value); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if stage != expected {
+			t.Errorf("Invalid stage value of %v: %v", value, stage)
+		}
+	}
+}
+
+func TestStageUnmarshalYAML_AllStages_RoundTripStringRepresentation(t *testing.T) {
+	for _, expected := range []Stage{Current, Pending, Latest, Previous, Deprecated} {
+		stage := expected
+		yamlValue, err := stage.MarshalYAML()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var unmarshaled Stage
+		if err := unmarshaled.UnmarshalYAML(&yaml.Node{Value: yamlValue.(string)}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if unmarshaled != expected {
+			t.Errorf("Stage %v is unmarshaled as %v", yamlValue, unmarshaled.String())
+		}
+	}
+}
+
 func TestStageFromString_EmptyString_ReturnStageNone(t *testing.T) {
 	var stage Stage
 	err := stage.FromString("")
@@ -141,6 +175,37 @@ func TestVersionNumberUnmarshalYAML_PositiveValue_ReturnValidVersion(t *testing.
 	}
 }
 
+func TestFileModeUnmarshalYAML_OctalValue_ReturnFileMode(t *testing.T) {
+	for _, value := range []string{"0400", "400", "0o400"} {
+		var mode FileMode
+		if err := mode.UnmarshalYAML(&yaml.Node{Value: value}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if mode != 0400 {
+			t.Errorf("Invalid unmarshaled value of %v: %#o", value, mode)
+		}
+	}
+}
+
+func TestFileModeUnmarshalYAML_InvalidValue_ReturnError(t *testing.T) {
+	for _, value := range []string{"0999", "rw", "-400", "01777", "0"} {
+		var mode FileMode
+		if err := mode.UnmarshalYAML(&yaml.Node{Value: value}); err == nil {
+			t.Errorf("An error was expected for %v", value)
+		}
+	}
+}
+
+func TestFileModeMarshalYAML_AnyMode_ReturnOctalString(t *testing.T) {
+	yamlValue, err := FileMode(0400).MarshalYAML()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if yamlValue != "0400" {
+		t.Errorf("Invalid YAML value: %v", yamlValue)
+	}
+}
+
 func TestDecodeSecretContent_ValidBase64Content_ReturnPlainText(t *testing.T) {
 	secretBundleContent := &SecretBundleContent{Content: "YmFy", ContentType: Base64}
 
@@ -154,6 +219,171 @@ func TestDecodeSecretContent_ValidBase64Content_ReturnPlainText(t *testing.T) {
 	}
 }
 
+func TestDecodeBytesSecretContent_BinaryContent_ReturnSameBytes(t *testing.T) {
+	// null bytes and invalid UTF-8 sequences, as in keystores
+	binaryContent := []byte{0x00, 0xfe, 0xed, 0xfe, 0xed, 0x00, 0x00, 0x02, 0xff, 0x00}
+	secretBundleContent := &SecretBundleContent{
+		Content: base64.StdEncoding.EncodeToString(binaryContent), ContentType: Base64,
+	}
+
+	decodedContent, err := secretBundleContent.DecodeBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(decodedContent, binaryContent) {
+		t.Errorf("Decoded value %v doesn't match expected one", decodedContent)
+	}
+
+	decodedText, err := secretBundleContent.Decode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decodedText != string(binaryContent) {
+		t.Errorf("Decoded value %q doesn't match expected one", decodedText)
+	}
+}
+
+func TestEncodedBytesSecretContent_ValidBase64Content_ReturnEncodedContent(t *testing.T) {
+	secretBundleContent := &SecretBundleContent{Content: "YmFy", ContentType: Base64}
+
+	encodedContent, err := secretBundleContent.EncodedBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(encodedContent) != "YmFy" {
+		t.Errorf("Encoded value %v doesn't match expected one", string(encodedContent))
+	}
+
+	_, err = (&SecretBundleContent{Content: "", ContentType: Base64}).EncodedBytes()
+	assertDecodeErrorReason(t, err, MissedContent)
+}
+
+func TestExtractJSONKey_ExistingKeys_ReturnValue(t *testing.T) {
+	content := []byte(`{"user": "admin", "port": 5432, "ratio": 1.50,
+		"db": {"password": "s3cr3t", "hosts": ["a", "b"], "tls": {"enabled": true}}}`)
+	for key, expected := range map[string]string{
+		"user":           "admin",
+		"port":           "5432",
+		"ratio":          "1.50",
+		"db.password":    "s3cr3t",
+		"db.hosts":       `["a","b"]`,
+		"db.tls":         `{"enabled":true}`,
+		"db.tls.enabled": "true",
+	} {
+		value, err := ExtractJSONKey(content, key)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", key, err)
+		}
+		if string(value) != expected {
+			t.Errorf("Unexpected value of %v: %v", key, string(value))
+		}
+	}
+}
+
+func TestExtractJSONKey_MissedKey_ReturnError(t *testing.T) {
+	content := []byte(`{"user": "admin", "db": {"password": "s3cr3t"}}`)
+	for _, key := range []string{"password", "db.user", "user.name", "db.password.value"} {
+		_, err := ExtractJSONKey(content, key)
+		if err == nil {
+			t.Fatalf("An error was expected for %v", key)
+		}
+		if err.Error() != fmt.Sprintf("key %q is missing", key) {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestExtractJSONKey_NotJSON_ReturnError(t *testing.T) {
+	_, err := ExtractJSONKey([]byte("user=admin"), "user")
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if !strings.HasPrefix(err.Error(), "secret content is not valid JSON") {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+const testCertificate = `-----BEGIN CERTIFICATE-----
+MIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw
+-----END CERTIFICATE-----
+`
+
+func TestValidateFormat_ValidContent_ReturnNil(t *testing.T) {
+	for _, testCase := range []struct {
+		format  Format
+		content string
+	}{
+		{PEMFormat, testCertificate},
+		{PEMFormat, "leading text\n" + testCertificate + testCertificate},
+		{JSONFormat, `{"user": "admin", "ports": [5432]}`},
+		{JSONFormat, `"s3cr3t"`},
+		{YAMLFormat, "user: admin\nports:\n  - 5432\n"},
+		{YAMLFormat, "- a\n- b\n"},
+		{YAMLFormat, `{"user": "admin"}`},
+	} {
+		if err := ValidateFormat([]byte(testCase.content), testCase.format); err != nil {
+			t.Errorf("Unexpected error for %v %q: %v", testCase.format, testCase.content, err)
+		}
+	}
+}
+
+func TestValidateFormat_InvalidContent_ReturnError(t *testing.T) {
+	for _, testCase := range []struct {
+		format          Format
+		content         string
+		expectedMessage string
+	}{
+		{PEMFormat, "s3cr3t", "no PEM block found"},
+		{PEMFormat, "-----BEGIN CERTIFICATE-----\nMIIB\n", "no PEM block found"},
+		{JSONFormat, `{"user": "admin"`, "content is not valid JSON"},
+		{JSONFormat, "user: admin", "content is not valid JSON"},
+		{YAMLFormat, "user: [admin", "content is not valid YAML"},
+		{YAMLFormat, "s3cr3t", "content is not a YAML mapping or sequence"},
+		{YAMLFormat, "", "content is not a YAML mapping or sequence"},
+	} {
+		err := ValidateFormat([]byte(testCase.content), testCase.format)
+		if err == nil {
+			t.Fatalf("An error was expected for %v %q", testCase.format, testCase.content)
+		}
+		if !strings.HasPrefix(err.Error(), testCase.expectedMessage) {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestDecodeSecretContent_PlaintextContent_ReturnContentAsIs(t *testing.T) {
+	secretBundleContent := &SecretBundleContent{Content: "YmFy", ContentType: Plaintext}
+
+	plainTextContent, err := secretBundleContent.Decode()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if plainTextContent != "YmFy" {
+		t.Errorf("Decoded value %v doesn't match expected one", plainTextContent)
+	}
+
+	encodedContent, err := secretBundleContent.EncodedBytes()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(encodedContent) != "WW1GeQ==" {
+		t.Errorf("Encoded value %v doesn't match expected one", string(encodedContent))
+	}
+}
+
+func TestDecodedSize_Base64AndPlaintextContent_ReturnSizeOfDecodedContent(t *testing.T) {
+	for _, content := range []string{"a", "ab", "abc", "abcd", strings.Repeat("x", 1000)} {
+		encoded := &SecretBundleContent{Content: base64.StdEncoding.EncodeToString([]byte(content)), ContentType: Base64}
+		if size := encoded.DecodedSize(); size != len(content) {
+			t.Errorf("Wrong size of base64-encoded %q: %v", content, size)
+		}
+		plaintext := &SecretBundleContent{Content: content, ContentType: Plaintext}
+		if size := plaintext.DecodedSize(); size != len(content) {
+			t.Errorf("Wrong size of plaintext %q: %v", content, size)
+		}
+	}
+}
+
 func TestDecodeSecretContent_InvalidBase64Content_ReturnError(t *testing.T) {
 	secretBundleContent := &SecretBundleContent{Content: "aaa", ContentType: Base64}
 
@@ -162,6 +392,7 @@ func TestDecodeSecretContent_InvalidBase64Content_ReturnError(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Missed expected error")
 	}
+	assertDecodeErrorReason(t, err, MalformedContent)
 }
 
 func TestDecodeSecretContent_EmptyContent_ReturnError(t *testing.T) {
@@ -175,6 +406,7 @@ func TestDecodeSecretContent_EmptyContent_ReturnError(t *testing.T) {
 	if err.Error() != "missed secret content" {
 		t.Errorf("Unexpected error message: %v", err)
 	}
+	assertDecodeErrorReason(t, err, MissedContent)
 }
 
 func TestDecodeSecretContent_UnknownContentType_ReturnError(t *testing.T) {
@@ -188,4 +420,277 @@ func TestDecodeSecretContent_UnknownContentType_ReturnError(t *testing.T) {
 	if err.Error() != "unknown content type" {
 		t.Errorf("Unexpected error message: %v", err)
 	}
+	assertDecodeErrorReason(t, err, UnknownContentType)
+}
+
+func assertDecodeErrorReason(t *testing.T, err error, expectedReason DecodeErrorReason) {
+	t.Helper()
+	var decodeError *DecodeError
+	if !errors.As(err, &decodeError) {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+	if decodeError.Reason != expectedReason {
+		t.Errorf("Unexpected decode error reason: %v", decodeError.Reason)
+	}
+}
+
+func TestVaultIDValidate_WellFormedOCID_ReturnNoError(t *testing.T) {
+	for _, vaultID := range []VaultID{
+		"ocid1.vault.oc1.iad.bbpkgnvqaaeuk.abuwcljsexample",
+		"ocid1.vault.oc1.eu-frankfurt-1.bbpkgnvqaaeuk.abuwcljsexample",
+		"ocid1.vault.oc19..abuwcljsexample",
+	} {
+		if err := vaultID.Validate(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestVaultIDDigest_VaultOCID_ReturnShortDigestWithoutOCID(t *testing.T) {
+	vaultID := VaultID("ocid1.vault.oc1.iad.bbpkgnvqaaeuk.abuwcljsexample")
+
+	digest := vaultID.Digest()
+	if len(digest) != 12 || strings.Contains(string(vaultID), digest) {
+		t.Errorf("Unexpected digest: %v", digest)
+	}
+	if digest != vaultID.Digest() || digest == VaultID("ocid1.vault.oc1.iad.other").Digest() {
+		t.Errorf("Digest should identify the vault: %v", digest)
+	}
+}
+
+func TestVaultIDValidate_MalformedOCID_ReturnError(t *testing.T) {
+	for _, vaultID := range []VaultID{
+		"vault1",
+		"ocid1.secret.oc1.iad.abuwcljsexample",
+		"ocid1.vault.oc1",
+		"ocid1.vault.oc1.iad.",
+		" ocid1.vault.oc1.iad.abuwcljsexample",
+		"ocid1.vault.oc1.iad.abuwcljsexample/",
+	} {
+		err := vaultID.Validate()
+		if err == nil {
+			t.Fatalf("An error was expected for %q", vaultID)
+		}
+		if !strings.HasPrefix(err.Error(), "malformed vault OCID") {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+
+	if err := VaultID("").Validate(); err == nil || err.Error() != "vault OCID is missing" {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestNormalizeRegion_ShortCode_ReturnRegionIdentifier(t *testing.T) {
+	for shortCode, expectedRegion := range map[string]string{
+		"iad": "us-ashburn-1",
+		"FRA": "eu-frankfurt-1",
+		"phx": "us-phoenix-1",
+	} {
+		region, err := NormalizeRegion(shortCode)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", shortCode, err)
+			continue
+		}
+		if region != expectedRegion {
+			t.Errorf("Invalid region for %v: %v", shortCode, region)
+		}
+	}
+}
+
+func TestNormalizeRegion_RegionIdentifier_ReturnSameIdentifier(t *testing.T) {
+	for _, regionID := range []string{"us-ashburn-1", "US-Phoenix-1", " ap-tokyo-1 "} {
+		region, err := NormalizeRegion(regionID)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", regionID, err)
+			continue
+		}
+		if region != strings.ToLower(strings.TrimSpace(regionID)) {
+			t.Errorf("Invalid region for %v: %v", regionID, region)
+		}
+	}
+}
+
+func TestNormalizeRegion_UnknownRegion_ReturnError(t *testing.T) {
+	_, err := NormalizeRegion("xyz")
+	if err == nil {
+		t.Fatalf("Missed expected error")
+	}
+	if err.Error() != "unknown OCI region: xyz" {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+func TestNormalizeRegion_EmptyRegion_ReturnError(t *testing.T) {
+	_, err := NormalizeRegion(" ")
+	if err == nil {
+		t.Fatalf("Missed expected error")
+	}
+	if err.Error() != "region is empty" {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+func TestAuthPrincipalKey_DifferentIdentities_ReturnDifferentKeys(t *testing.T) {
+	auths := []*Auth{
+		{Type: Instance},
+		{Type: User, Config: AuthConfig{TenancyID: "tenancy", UserID: "user1", Fingerprint: "fp"}},
+		{Type: User, Config: AuthConfig{TenancyID: "tenancy", UserID: "user2", Fingerprint: "fp"}},
+		{Type: Workload, WorkloadIdentityCfg: WorkloadIdentityConfig{ServiceAccount: "ns1/sa"}},
+		{Type: Workload, WorkloadIdentityCfg: WorkloadIdentityConfig{ServiceAccount: "ns2/sa"}},
+		{Type: File, ConfigFile: ConfigFile{Path: "/etc/oci/config", Profile: "DEFAULT"}},
+		{Type: File, ConfigFile: ConfigFile{Path: "/etc/oci/config", Profile: "prod"}},
+	}
+	keys := make(map[string]bool)
+	for _, auth := range auths {
+		key := auth.PrincipalKey()
+		if keys[key] {
+			t.Errorf("Duplicated principal key: %v", key)
+		}
+		keys[key] = true
+	}
+}
+
+func TestAuthPrincipalKey_SameUserWithDifferentCredentials_ReturnDifferentKeys(t *testing.T) {
+	auths := []*Auth{
+		{Type: User, Config: AuthConfig{TenancyID: "tenancy", UserID: "user", Fingerprint: "fp", PrivateKey: "key1"}},
+		{Type: User, Config: AuthConfig{TenancyID: "tenancy", UserID: "user", Fingerprint: "fp", PrivateKey: "key2"}},
+		{Type: User, Config: AuthConfig{TenancyID: "tenancy", UserID: "user", Fingerprint: "fp", PrivateKey: "key1",
+			Passphrase: "passphrase"}},
+	}
+	keys := make(map[string]bool)
+	for _, auth := range auths {
+		key := auth.PrincipalKey()
+		if keys[key] {
+			t.Errorf("Duplicated principal key: %v", key)
+		}
+		if strings.Contains(key, "key1") || strings.Contains(key, "passphrase") {
+			t.Errorf("Principal key should not contain credentials: %v", key)
+		}
+		keys[key] = true
+	}
+}
+
+func TestAuthPrincipalKey_SameWorkloadWithDifferentTokens_ReturnSameKey(t *testing.T) {
+	auth1 := &Auth{Type: Workload,
+		WorkloadIdentityCfg: WorkloadIdentityConfig{SaToken: []byte("token1"), ServiceAccount: "ns/sa"}}
+	auth2 := &Auth{Type: Workload,
+		WorkloadIdentityCfg: WorkloadIdentityConfig{SaToken: []byte("token2"), ServiceAccount: "ns/sa"}}
+	if auth1.PrincipalKey() != auth2.PrincipalKey() {
+		t.Errorf("Principal keys mismatched: %v, %v", auth1.PrincipalKey(), auth2.PrincipalKey())
+	}
+}
+
+func TestLooksLikeBase64Text_Base64OfText_ReturnTrue(t *testing.T) {
+	for _, content := range []string{"cGFzc3dvcmQ=", "dXNlcjogYWRtaW4K\n"} {
+		if !LooksLikeBase64Text(content) {
+			t.Errorf("Content should be detected as base64 of text: %v", content)
+		}
+	}
+}
+
+func TestLooksLikeBase64Text_PlainOrBinaryContent_ReturnFalse(t *testing.T) {
+	// plain text, short token, base64 of binary data and text with invalid padding
+	for _, content := range []string{"password", "abcd", "/////w==", "s3cr3t-value", "cGFzc3dvcmQ"} {
+		if LooksLikeBase64Text(content) {
+			t.Errorf("Content should not be detected as base64 of text: %v", content)
+		}
+	}
+}
+
+func TestNewSecretBundleRequest_ValidCombinations_ReturnRequest(t *testing.T) {
+	for _, request := range []SecretBundleRequest{
+		{Name: "foo"},
+		{Name: "foo", Stage: Previous},
+		{Name: "foo", VersionNumber: 2},
+		{Name: "foo", VersionName: "v2", CacheTTL: 60},
+		{Name: "foo", Encoding: PlainEncoding},
+		{Name: "foo", Encoding: Base64Encoding},
+		{Name: "foo", FileName: "db/password"},
+		{Name: "foo", Format: PEMFormat},
+		{Name: "foo", Encoding: Base64Encoding, Format: JSONFormat},
+		{Name: "foo", FileName: "db/..password"},
+	} {
+		created, err := NewSecretBundleRequest(request)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", &request, err)
+		}
+		if *created != request {
+			t.Errorf("Unexpected request: %v", created)
+		}
+	}
+}
+
+func TestGetFilePath_NestedFileName_ReturnCleanPath(t *testing.T) {
+	for fileName, expectedPath := range map[string]string{
+		"":                 "foo",
+		" db/password ":    "db/password",
+		"./db//password/":  "db/password",
+		"db/tmp/../secret": "db/secret",
+		"../escape":        "../escape",
+	} {
+		request := SecretBundleRequest{Name: "foo", FileName: fileName}
+		if filePath := request.GetFilePath(); filePath != expectedPath {
+			t.Errorf("Wrong file path of %q: %v", fileName, filePath)
+		}
+	}
+}
+
+func TestGetFilePath_BundleWithMetadataFileName_PreferAliasOverMetadata(t *testing.T) {
+	testCases := []struct {
+		bundle       SecretBundle
+		expectedPath string
+	}{
+		{SecretBundle{Name: "foo", MetadataFileName: "./db//password"}, "db/password"},
+		{SecretBundle{Name: "foo", FileName: "user", MetadataFileName: "db/password"}, "user"},
+		{SecretBundle{Name: "foo", FileName: " ", MetadataFileName: "db/password"}, "db/password"},
+		{SecretBundle{Name: "foo"}, "foo"},
+	}
+	for _, testCase := range testCases {
+		if filePath := testCase.bundle.GetFilePath(); filePath != testCase.expectedPath {
+			t.Errorf("Wrong file path of %+v: %v", testCase.bundle, filePath)
+		}
+	}
+}
+
+func TestNewSecretBundleRequest_InvalidCombinations_ReturnError(t *testing.T) {
+	const versionNameMessage = "secret identified with a version name should not have a version number or stage"
+	const versionMessage = "secret should be identified either with a version number or with stage"
+	for request, expectedMessage := range map[SecretBundleRequest]string{
+		{Stage: Current}: "missed secret name",
+		{Name: "foo", VersionNumber: 1, Stage: Current}:    versionMessage,
+		{Name: "foo", VersionName: "v2", VersionNumber: 1}: versionNameMessage,
+		{Name: "foo", VersionName: "v2", Stage: Current}:   versionNameMessage,
+		{Name: "foo", CacheTTL: -1}:                        "cache TTL should not be negative",
+		{Name: "foo", Encoding: "hex"}:                     `unknown encoding "hex", should be "plain" or "base64"`,
+		{Name: "foo", Format: "xml"}:                       `unknown format "xml", should be "pem", "json" or "yaml"`,
+		{Name: "foo", Encoding: Base64Encoding, JSONKey: "user"}: "secret with JSON key should not be mounted " +
+			"with base64 encoding",
+		{Name: "foo", VersionNumber: 2, MinVersionNumber: 3}: "version number 2 is below " +
+			"the minimum version number 3",
+		{Name: "foo", FileName: "../../etc/passwd"}: `file path "../../etc/passwd" should not contain ".." elements`,
+		{Name: "foo", FileName: "db/../../foo"}:     `file path "../foo" should not contain ".." elements`,
+		{Name: "foo", FileName: "db/.."}:            `file path "." should name a file inside the mount target`,
+		{Name: "foo", FileName: `..\foo`}:           `file path "..\\foo" should not contain ".." elements`,
+		{Name: "foo", FileName: "/etc/passwd"}:      `file path "/etc/passwd" should be relative to the mount target`,
+		{Name: "../foo"}:                            `file path "../foo" should not contain ".." elements`,
+	} {
+		created, err := NewSecretBundleRequest(request)
+		if err == nil {
+			t.Fatalf("An error was expected for %v", &request)
+		}
+		if created != nil {
+			t.Errorf("Request should not be created: %v", created)
+		}
+		if err.Error() != expectedMessage {
+			t.Errorf("Wrong error message: %v", err)
+		}
+	}
+}
+
+func TestSecretBundleRequestValidate_NilRequest_ReturnError(t *testing.T) {
+	var request *SecretBundleRequest
+	if err := request.Validate(); err == nil {
+		t.Fatal("An error was expected")
+	}
 }
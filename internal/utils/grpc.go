@@ -8,21 +8,106 @@ package utils
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/diagnostics"
 	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/metrics"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/status"
 )
 
+// InterceptorOptions enables optional gRPC interceptors.
+type InterceptorOptions struct {
+	// Recovery turns a panic of the gRPC handler into Internal error instead of crashing the provider
+	Recovery bool
+	// Shutdown enables rejection of new mounts once the provider starts shutting down
+	Shutdown *ShutdownTracker
+	// MountActivity enables tracking of the mount outcomes for the readiness
+	MountActivity *MountActivity
+}
+
+// UnaryInterceptors returns the enabled interceptors in the order they are applied to a request:
+// the first one is the outermost.
+func UnaryInterceptors(options InterceptorOptions) []grpc.UnaryServerInterceptor {
+	// logging goes first to report the final status code of the request
+	interceptors := []grpc.UnaryServerInterceptor{LogInterceptor()}
+	if options.Shutdown != nil {
+		interceptors = append(interceptors, ShutdownInterceptor(options.Shutdown))
+	}
+	// mounts rejected on shutdown are not tracked, recovered panics are
+	if options.MountActivity != nil {
+		interceptors = append(interceptors, MountActivityInterceptor(options.MountActivity))
+	}
+	if options.Recovery {
+		interceptors = append(interceptors, RecoveryInterceptor())
+	}
+	return interceptors
+}
+
+// UnaryInterceptorChain chains the enabled interceptors into a single gRPC server option.
+func UnaryInterceptorChain(options InterceptorOptions) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(UnaryInterceptors(options)...)
+}
+
+// ConnectionLimits restrict what a single driver connection could demand from the provider,
+// so a noisy connection doesn't starve the others.
+type ConnectionLimits struct {
+	// MaxConcurrentStreams limits the requests in progress over a connection, gRPC doesn't limit them when zero
+	MaxConcurrentStreams uint
+	// KeepaliveMinTime is the minimal interval of client keepalive pings, the client pinging more often is
+	// disconnected. gRPC default of 5 minutes is used when zero.
+	KeepaliveMinTime time.Duration
+	// KeepalivePermitWithoutStream allows client keepalive pings while no request is in progress
+	KeepalivePermitWithoutStream bool
+}
+
+// defaultKeepaliveMinTime is gRPC default minimal interval of client keepalive pings
+const defaultKeepaliveMinTime = 5 * time.Minute
+
+// ConnectionServerOptions returns the gRPC server options applying the limits, zero limits keep gRPC defaults.
+func ConnectionServerOptions(limits ConnectionLimits) ([]grpc.ServerOption, error) {
+	if limits.MaxConcurrentStreams > math.MaxUint32 {
+		return nil, fmt.Errorf("maximum concurrent streams should not exceed %v: %v",
+			uint32(math.MaxUint32), limits.MaxConcurrentStreams)
+	}
+	if limits.KeepaliveMinTime < 0 {
+		return nil, fmt.Errorf("keepalive minimal time should not be negative: %v", limits.KeepaliveMinTime)
+	}
+	policy := keepalive.EnforcementPolicy{
+		MinTime:             limits.KeepaliveMinTime,
+		PermitWithoutStream: limits.KeepalivePermitWithoutStream,
+	}
+	if policy.MinTime == 0 {
+		policy.MinTime = defaultKeepaliveMinTime
+	}
+	options := []grpc.ServerOption{grpc.KeepaliveEnforcementPolicy(policy)}
+	if limits.MaxConcurrentStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(uint32(limits.MaxConcurrentStreams)))
+	}
+	return options, nil
+}
+
 // LogInterceptor is a gRPC interceptor that logs the gRPC requests and responses.
-// It also publishes metrics for the gRPC requests.
+// It also publishes metrics for the gRPC requests, including the number of Mount requests in flight.
 func LogInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (interface{}, error) {
 		start := time.Now()
 		reporter := metrics.NewStatsReporter()
+		if strings.HasSuffix(info.FullMethod, mountMethodSuffix) {
+			reporter.ReportMountInFlight(ctx, 1)
+			defer reporter.ReportMountInFlight(ctx, -1)
+		}
 
 		ctxDeadline, _ := ctx.Deadline()
 		log.Debug().Str("method", info.FullMethod).Str("deadline", time.Until(ctxDeadline).String()).Msg("request")
@@ -32,7 +117,107 @@ func LogInterceptor() grpc.UnaryServerInterceptor {
 		log.Debug().Str("method", info.FullMethod).Str("duration",
 			time.Since(start).String()).Str("code", s.Code().String()).Str("message", s.Message()).Msg("response")
 		reporter.ReportGRPCRequest(ctx, time.Since(start).Seconds(), info.FullMethod, s.Code().String(), s.Message())
+		if s.Code() != codes.OK {
+			diagnostics.RecordError(s.Code().String())
+		}
+
+		return resp, err
+	}
+}
+
+// RecoveryInterceptor is a gRPC interceptor that recovers from a panic of the handler
+// and responds with Internal error.
+func RecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Error().Str("method", info.FullMethod).Interface("panic", recovered).
+					Str("stack", string(debug.Stack())).Msg("Recovered from panic")
+				resp, err = nil, status.Error(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
 
+// mountMethodSuffix identifies Mount method of the provider API
+const mountMethodSuffix = "/Mount"
+
+// ShutdownTracker remembers whether the provider started shutting down.
+type ShutdownTracker struct {
+	shuttingDown atomic.Bool
+}
+
+// Begin marks the beginning of shutdown.
+func (tracker *ShutdownTracker) Begin() {
+	tracker.shuttingDown.Store(true)
+}
+
+// IsShuttingDown reports whether shutdown has begun.
+func (tracker *ShutdownTracker) IsShuttingDown() bool {
+	return tracker.shuttingDown.Load()
+}
+
+// ShutdownInterceptor is a gRPC interceptor that rejects new mounts with Unavailable error
+// once the provider starts shutting down, so they are retried by the driver instead of being partially processed.
+func ShutdownInterceptor(tracker *ShutdownTracker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if tracker.IsShuttingDown() && strings.HasSuffix(info.FullMethod, mountMethodSuffix) {
+			log.Info().Str("method", info.FullMethod).Msg("Rejected request since the provider is shutting down")
+			metrics.NewStatsReporter().ReportRejectedOnShutdown(ctx, info.FullMethod)
+			return nil, status.Error(codes.Unavailable, "provider is shutting down")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// MountActivity tracks the outcomes of the mounts, so the readiness could report the mounts failing for too long.
+type MountActivity struct {
+	maxFailingPeriod time.Duration
+	clock            clock.Clock // system clock when nil
+
+	mutex sync.Mutex
+	// failingSince is the time of the first failed mount after the last successful one, zero if the last one succeeded
+	failingSince time.Time
+}
+
+func NewMountActivity(maxFailingPeriod time.Duration) *MountActivity {
+	return &MountActivity{maxFailingPeriod: maxFailingPeriod}
+}
+
+func (activity *MountActivity) record(success bool) {
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+	switch {
+	case success:
+		activity.failingSince = time.Time{}
+	case activity.failingSince.IsZero():
+		activity.failingSince = clock.OrReal(activity.clock).Now()
+	}
+}
+
+// Check fails if no mount has succeeded since the mounts started failing longer than the maximum period ago.
+// The node without mounts is healthy.
+func (activity *MountActivity) Check(context.Context) error {
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+	if activity.failingSince.IsZero() ||
+		clock.OrReal(activity.clock).Now().Sub(activity.failingSince) <= activity.maxFailingPeriod {
+		return nil
+	}
+	return fmt.Errorf("mounts are failing since %v", activity.failingSince.Format(time.RFC3339))
+}
+
+// MountActivityInterceptor is a gRPC interceptor that records the outcomes of the mounts.
+func MountActivityInterceptor(activity *MountActivity) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if strings.HasSuffix(info.FullMethod, mountMethodSuffix) {
+			activity.record(err == nil)
+		}
 		return resp, err
 	}
 }
@@ -0,0 +1,297 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	provider "sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// invokeChain calls the handler through the interceptors the same way grpc.ChainUnaryInterceptor does.
+func invokeChain(interceptors []grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/v1alpha1.CSIDriverProvider/Mount"}
+	chainedHandler := handler
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], chainedHandler
+		chainedHandler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return chainedHandler(context.Background(), "request")
+}
+
+// captureDebugLogs redirects the global logger with debug level into the returned buffer.
+func captureDebugLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buffer bytes.Buffer
+	originalLogger, originalLevel := log.Logger, zerolog.GlobalLevel()
+	log.Logger = zerolog.New(&buffer)
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	t.Cleanup(func() {
+		log.Logger = originalLogger
+		zerolog.SetGlobalLevel(originalLevel)
+	})
+	return &buffer
+}
+
+func findLogRecords(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		record := make(map[string]interface{})
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Unable to parse log record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestUnaryInterceptors_DefaultOptions_ReturnLogInterceptorOnly(t *testing.T) {
+	interceptors := UnaryInterceptors(InterceptorOptions{})
+	if len(interceptors) != 1 {
+		t.Fatalf("Wrong number of interceptors: %v", len(interceptors))
+	}
+
+	resp, err := invokeChain(interceptors, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "response", nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp != "response" {
+		t.Errorf("Wrong response: %v", resp)
+	}
+}
+
+func TestUnaryInterceptors_RecoveryEnabled_LogInterceptorReportsRecoveredPanic(t *testing.T) {
+	logs := captureDebugLogs(t)
+	interceptors := UnaryInterceptors(InterceptorOptions{Recovery: true})
+	if len(interceptors) != 2 {
+		t.Fatalf("Wrong number of interceptors: %v", len(interceptors))
+	}
+
+	_, err := invokeChain(interceptors, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("handler failure")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("Wrong gRPC code: %v", status.Code(err))
+	}
+
+	// the log interceptor wraps the recovery one, so it logs the request first and the recovered status last
+	var messages []string
+	records := findLogRecords(t, logs)
+	for _, record := range records {
+		messages = append(messages, record["message"].(string))
+	}
+	expectedMessages := []string{"request", "Recovered from panic", "response"}
+	if len(messages) != len(expectedMessages) {
+		t.Fatalf("Wrong log records: %v", messages)
+	}
+	for i := range expectedMessages {
+		if messages[i] != expectedMessages[i] {
+			t.Fatalf("Wrong log records order: %v", messages)
+		}
+	}
+	if records[2]["code"] != codes.Internal.String() {
+		t.Errorf("Wrong code is logged: %v", records[2]["code"])
+	}
+}
+
+func TestRecoveryInterceptor_NoPanic_ReturnHandlerResult(t *testing.T) {
+	interceptors := []grpc.UnaryServerInterceptor{RecoveryInterceptor()}
+	_, err := invokeChain(interceptors, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Wrong gRPC code: %v", status.Code(err))
+	}
+}
+
+func TestShutdownInterceptor_MountDuringDrain_ReturnUnavailable(t *testing.T) {
+	tracker := &ShutdownTracker{}
+	interceptors := UnaryInterceptors(InterceptorOptions{Recovery: true, Shutdown: tracker})
+	handlerCalls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalls++
+		return "response", nil
+	}
+
+	if _, err := invokeChain(interceptors, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tracker.Begin()
+	_, err := invokeChain(interceptors, handler)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Wrong gRPC code: %v", status.Code(err))
+	}
+	if handlerCalls != 1 {
+		t.Errorf("Mount should not be handled during drain, handler calls: %v", handlerCalls)
+	}
+}
+
+func TestShutdownInterceptor_VersionDuringDrain_ReturnHandlerResult(t *testing.T) {
+	tracker := &ShutdownTracker{}
+	tracker.Begin()
+	interceptor := ShutdownInterceptor(tracker)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/v1alpha1.CSIDriverProvider/Version"}
+	resp, err := interceptor(context.Background(), "request", info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", nil
+		})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp != "response" {
+		t.Errorf("Wrong response: %v", resp)
+	}
+}
+
+func TestMountActivity_MountsFailingLongerThanPeriod_ReturnError(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	activity := &MountActivity{maxFailingPeriod: time.Minute, clock: fakeClock}
+	interceptors := UnaryInterceptors(InterceptorOptions{MountActivity: activity})
+	var handlerErr error
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, handlerErr
+	}
+
+	if err := activity.Check(context.Background()); err != nil {
+		t.Fatalf("Node without mounts should be healthy: %v", err)
+	}
+	handlerErr = fmt.Errorf("mount failed")
+	_, _ = invokeChain(interceptors, handler)
+	fakeClock.Advance(time.Minute)
+	_, _ = invokeChain(interceptors, handler)
+	if err := activity.Check(context.Background()); err != nil {
+		t.Fatalf("Mounts failing within the period should be healthy: %v", err)
+	}
+
+	fakeClock.Advance(time.Second)
+	if err := activity.Check(context.Background()); err == nil {
+		t.Fatal("An error was expected")
+	}
+
+	handlerErr = nil
+	_, _ = invokeChain(interceptors, handler)
+	if err := activity.Check(context.Background()); err != nil {
+		t.Errorf("Successful mount should make the activity healthy: %v", err)
+	}
+}
+
+func TestMountActivityInterceptor_FailedVersion_NotTracked(t *testing.T) {
+	activity := NewMountActivity(0)
+	info := &grpc.UnaryServerInfo{FullMethod: "/v1alpha1.CSIDriverProvider/Version"}
+	_, _ = MountActivityInterceptor(activity)(context.Background(), "request", info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("version failed")
+		})
+	time.Sleep(time.Millisecond)
+
+	if err := activity.Check(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestConnectionServerOptions_DefaultLimits_ReturnKeepalivePolicyOnly(t *testing.T) {
+	options, err := ConnectionServerOptions(ConnectionLimits{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(options) != 1 {
+		t.Errorf("Unexpected number of options: %v", len(options))
+	}
+}
+
+func TestConnectionServerOptions_NegativeKeepaliveMinTime_ReturnError(t *testing.T) {
+	_, err := ConnectionServerOptions(ConnectionLimits{KeepaliveMinTime: -time.Second})
+	if err == nil {
+		t.Fatal("An error was expected")
+	}
+	if err.Error() != "keepalive minimal time should not be negative: -1s" {
+		t.Errorf("Wrong error message: %v", err)
+	}
+}
+
+// blockingVersionServer holds Version requests until released, signaling each request it receives
+type blockingVersionServer struct {
+	provider.UnimplementedCSIDriverProviderServer
+	received chan struct{}
+	release  chan struct{}
+}
+
+func (server *blockingVersionServer) Version(context.Context,
+	*provider.VersionRequest) (*provider.VersionResponse, error) {
+	server.received <- struct{}{}
+	<-server.release
+	return &provider.VersionResponse{}, nil
+}
+
+func TestConnectionServerOptions_MaxConcurrentStreams_QueueRequestsOverLimit(t *testing.T) {
+	options, err := ConnectionServerOptions(ConnectionLimits{MaxConcurrentStreams: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "provider.sock"))
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to listen: %v", err)
+	}
+	versionServer := &blockingVersionServer{received: make(chan struct{}, 2), release: make(chan struct{})}
+	grpcServer := grpc.NewServer(options...)
+	provider.RegisterCSIDriverProviderServer(grpcServer, versionServer)
+	go func() { _ = grpcServer.Serve(listener) }()
+	t.Cleanup(grpcServer.Stop)
+
+	connection, err := grpc.Dial("unix://"+listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connection.Close()
+	client := provider.NewCSIDriverProviderClient(connection)
+
+	firstDone := make(chan error, 1)
+	go func() {
+		_, err := client.Version(context.Background(), &provider.VersionRequest{})
+		firstDone <- err
+	}()
+	<-versionServer.received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = client.Version(ctx, &provider.VersionRequest{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Request over the limit should wait for the stream: %v", err)
+	}
+	if len(versionServer.received) != 0 {
+		t.Error("Request over the limit should not reach the handler")
+	}
+
+	close(versionServer.release)
+	if err := <-firstDone; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Statuses of the health report and its components
+const (
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+// HealthCheck reports whether a subsystem is healthy, the error explains why it's not.
+type HealthCheck func(ctx context.Context) error
+
+// HealthReport is the JSON body of the aggregated readiness endpoint.
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// HealthRegistry aggregates the health checks the subsystems register by name. It's HTTP handler responding
+// with 503 if any component is unhealthy. Details of the failed checks are logged, not exposed to the probe.
+type HealthRegistry struct {
+	mutex   sync.Mutex
+	checks  map[string]HealthCheck
+	failing map[string]bool
+}
+
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{checks: make(map[string]HealthCheck), failing: make(map[string]bool)}
+}
+
+// Register adds the check of the component, replacing the previous check of the same name.
+func (registry *HealthRegistry) Register(name string, check HealthCheck) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.checks[name] = check
+}
+
+// Report runs the checks of all components, the report is healthy only if each component is.
+func (registry *HealthRegistry) Report(ctx context.Context) HealthReport {
+	registry.mutex.Lock()
+	names := make([]string, 0, len(registry.checks))
+	for name := range registry.checks {
+		names = append(names, name)
+	}
+	checks := make(map[string]HealthCheck, len(registry.checks))
+	for name, check := range registry.checks {
+		checks[name] = check
+	}
+	registry.mutex.Unlock()
+	sort.Strings(names)
+
+	report := HealthReport{Status: HealthStatusOK, Components: make(map[string]string, len(names))}
+	for _, name := range names {
+		err := checks[name](ctx)
+		registry.logTransition(name, err)
+		if err != nil {
+			report.Status = HealthStatusFailed
+			report.Components[name] = HealthStatusFailed
+			continue
+		}
+		report.Components[name] = HealthStatusOK
+	}
+	return report
+}
+
+// logTransition logs the component becoming unhealthy or healthy again, not each probe.
+func (registry *HealthRegistry) logTransition(name string, err error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	switch {
+	case err != nil && !registry.failing[name]:
+		log.Warn().Err(err).Str("component", name).Msg("Health check failed")
+	case err == nil && registry.failing[name]:
+		log.Info().Str("component", name).Msg("Health check passed")
+	}
+	registry.failing[name] = err != nil
+}
+
+func (registry *HealthRegistry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	report := registry.Report(request.Context())
+	writer.Header().Set("Content-Type", "application/json")
+	if report.Status != HealthStatusOK {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(writer).Encode(report); err != nil {
+		log.Error().Err(err).Msg("Failed to write health report")
+	}
+}
@@ -0,0 +1,69 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probeRegistry(t *testing.T, registry *HealthRegistry) (int, HealthReport) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report HealthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Malformed health report %v: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, report
+}
+
+func TestHealthRegistry_AllComponentsHealthy_ReturnOK(t *testing.T) {
+	registry := NewHealthRegistry()
+	registry.Register("grpc", (&stubCheck{}).check)
+	registry.Register("ociVault", (&stubCheck{}).check)
+
+	code, report := probeRegistry(t, registry)
+	if code != http.StatusOK {
+		t.Errorf("Unexpected status code: %v", code)
+	}
+	if report.Status != HealthStatusOK || len(report.Components) != 2 ||
+		report.Components["grpc"] != HealthStatusOK || report.Components["ociVault"] != HealthStatusOK {
+		t.Errorf("Unexpected health report: %+v", report)
+	}
+}
+
+func TestHealthRegistry_OneComponentUnhealthy_ReturnServiceUnavailable(t *testing.T) {
+	registry := NewHealthRegistry()
+	vaultCheck := &stubCheck{err: fmt.Errorf("unable to access OCI Vault")}
+	registry.Register("grpc", (&stubCheck{}).check)
+	registry.Register("ociVault", vaultCheck.check)
+
+	code, report := probeRegistry(t, registry)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status code: %v", code)
+	}
+	if report.Status != HealthStatusFailed ||
+		report.Components["grpc"] != HealthStatusOK || report.Components["ociVault"] != HealthStatusFailed {
+		t.Errorf("Unexpected health report: %+v", report)
+	}
+
+	vaultCheck.err = nil
+	if code, report = probeRegistry(t, registry); code != http.StatusOK || report.Status != HealthStatusOK {
+		t.Errorf("Recovered component should make the report healthy: %v %+v", code, report)
+	}
+}
+
+func TestHealthRegistry_NoComponents_ReturnOK(t *testing.T) {
+	code, report := probeRegistry(t, NewHealthRegistry())
+	if code != http.StatusOK || report.Status != HealthStatusOK || len(report.Components) != 0 {
+		t.Errorf("Unexpected health report: %v %+v", code, report)
+	}
+}
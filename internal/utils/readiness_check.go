@@ -0,0 +1,73 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadinessCheck is HTTP handler of the readiness probe, which responds with 503 while the check fails.
+// The result is cached, so frequent probes don't call OCI each time.
+// Check is not performed at all if it's nil. Details of the failed check are logged, not exposed to the probe.
+type ReadinessCheck struct {
+	check    func(ctx context.Context) error
+	cacheTTL time.Duration
+	clock    clock.Clock // system clock when nil
+
+	mutex     sync.Mutex
+	checked   bool
+	checkedAt time.Time
+	err       error
+}
+
+func NewReadinessCheck(check func(ctx context.Context) error, cacheTTL time.Duration) *ReadinessCheck {
+	return &ReadinessCheck{check: check, cacheTTL: cacheTTL}
+}
+
+func (readiness *ReadinessCheck) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if err := readiness.result(); err != nil {
+		http.Error(writer, "readiness check failed", http.StatusServiceUnavailable)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Check returns the cached result of the check, so it could be registered in HealthRegistry.
+func (readiness *ReadinessCheck) Check(context.Context) error {
+	return readiness.result()
+}
+
+// result returns the cached result of the check, or checks again once the cached one expires.
+// Concurrent probes wait for a single check instead of calling OCI each.
+// The check is not canceled with the probe, so its result is cached even if the kubelet gives up on waiting.
+func (readiness *ReadinessCheck) result() error {
+	if readiness.check == nil {
+		return nil
+	}
+	readiness.mutex.Lock()
+	defer readiness.mutex.Unlock()
+	now := clock.OrReal(readiness.clock).Now()
+	if readiness.checked && now.Sub(readiness.checkedAt) < readiness.cacheTTL {
+		return readiness.err
+	}
+
+	err := readiness.check(context.Background())
+	switch {
+	case err != nil && (!readiness.checked || readiness.err == nil):
+		log.Warn().Err(err).Msg("Readiness check failed")
+	case err == nil && readiness.checked && readiness.err != nil:
+		log.Info().Msg("Readiness check passed")
+	}
+	readiness.checked, readiness.checkedAt, readiness.err = true, now, err
+	return err
+}
@@ -0,0 +1,86 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/clock"
+)
+
+// stubCheck fails while err is set and counts the calls
+type stubCheck struct {
+	calls int
+	err   error
+}
+
+func (check *stubCheck) check(context.Context) error {
+	check.calls++
+	return check.err
+}
+
+func probe(readiness *ReadinessCheck) int {
+	recorder := httptest.NewRecorder()
+	readiness.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	return recorder.Code
+}
+
+func TestReadinessCheck_CheckFailed_ReturnServiceUnavailable(t *testing.T) {
+	check := &stubCheck{err: fmt.Errorf("instance metadata is unavailable")}
+	readiness := NewReadinessCheck(check.check, time.Minute)
+
+	if code := probe(readiness); code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status: %v", code)
+	}
+}
+
+func TestReadinessCheck_NoCheck_ReturnOK(t *testing.T) {
+	if code := probe(NewReadinessCheck(nil, time.Minute)); code != http.StatusOK {
+		t.Errorf("Unexpected status: %v", code)
+	}
+}
+
+func TestReadinessCheck_ProbesWithinCacheTTL_CheckOnce(t *testing.T) {
+	check := &stubCheck{}
+	readiness := NewReadinessCheck(check.check, time.Minute)
+	fakeClock := clock.NewFake(time.Now())
+	readiness.clock = fakeClock
+
+	for i := 0; i < 3; i++ {
+		if code := probe(readiness); code != http.StatusOK {
+			t.Errorf("Unexpected status: %v", code)
+		}
+		fakeClock.Advance(10 * time.Second)
+	}
+	if check.calls != 1 {
+		t.Errorf("Cached result should be used: %v calls", check.calls)
+	}
+}
+
+func TestReadinessCheck_CacheTTLExpired_CheckAgain(t *testing.T) {
+	check := &stubCheck{err: fmt.Errorf("authentication failed")}
+	readiness := NewReadinessCheck(check.check, time.Minute)
+	fakeClock := clock.NewFake(time.Now())
+	readiness.clock = fakeClock
+
+	if code := probe(readiness); code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status: %v", code)
+	}
+	check.err = nil
+	fakeClock.Advance(time.Minute)
+	if code := probe(readiness); code != http.StatusOK {
+		t.Errorf("Unexpected status: %v", code)
+	}
+	if check.calls != 2 {
+		t.Errorf("Expired result should be checked again: %v calls", check.calls)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReadinessMarker is a file that exists only while the provider serves gRPC requests.
+// Node bootstrap scripts could wait for the marker instead of dialing the socket.
+// Marker with the empty path is disabled.
+type ReadinessMarker struct {
+	path string
+}
+
+func NewReadinessMarker(path string) *ReadinessMarker {
+	return &ReadinessMarker{path: path}
+}
+
+// MarkReady creates the marker file or updates its modification time if it already exists.
+func (marker *ReadinessMarker) MarkReady() error {
+	if marker.path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(marker.path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create readiness marker: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to create readiness marker: %w", err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(marker.path, now, now); err != nil {
+		return fmt.Errorf("failed to touch readiness marker: %w", err)
+	}
+	log.Info().Str("path", marker.path).Msg("Created readiness marker")
+	return nil
+}
+
+// Clear removes the marker file. Missing marker is not an error.
+func (marker *ReadinessMarker) Clear() error {
+	if marker.path == "" {
+		return nil
+	}
+	err := os.Remove(marker.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove readiness marker: %w", err)
+	}
+	log.Info().Str("path", marker.path).Msg("Removed readiness marker")
+	return nil
+}
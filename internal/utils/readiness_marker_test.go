@@ -0,0 +1,81 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oracle-samples/oci-secrets-store-csi-driver-provider/internal/testutils"
+	"google.golang.org/grpc"
+)
+
+func TestMain(m *testing.M) {
+	testutils.RunTestCase(m)
+}
+
+func TestReadinessMarker_ServingLifecycle_MarkerExistsOnlyWhileServing(t *testing.T) {
+	dir := t.TempDir()
+	markerPath := filepath.Join(dir, "ready")
+	listener, err := net.Listen("unix", filepath.Join(dir, "provider.sock"))
+	if err != nil {
+		t.Fatalf("Precondition failed: unable to listen on socket: %v", err)
+	}
+
+	grpcServer := grpc.NewServer()
+	done := make(chan struct{})
+	go func() {
+		_ = grpcServer.Serve(listener)
+		close(done)
+	}()
+
+	marker := NewReadinessMarker(markerPath)
+	if err := marker.MarkReady(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(markerPath); err != nil {
+		t.Errorf("Readiness marker is missed while serving: %v", err)
+	}
+
+	grpcServer.GracefulStop()
+	<-done
+	if err := marker.Clear(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Errorf("Readiness marker still exists after shutdown: %v", err)
+	}
+}
+
+func TestReadinessMarker_MarkReadyTwice_ReturnNoError(t *testing.T) {
+	marker := NewReadinessMarker(filepath.Join(t.TempDir(), "ready"))
+	if err := marker.MarkReady(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := marker.MarkReady(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestReadinessMarker_ClearMissingMarker_ReturnNoError(t *testing.T) {
+	marker := NewReadinessMarker(filepath.Join(t.TempDir(), "ready"))
+	if err := marker.Clear(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestReadinessMarker_EmptyPath_Disabled(t *testing.T) {
+	marker := NewReadinessMarker("")
+	if err := marker.MarkReady(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := marker.Clear(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
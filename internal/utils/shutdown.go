@@ -0,0 +1,58 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GRPCServer is the part of gRPC server used to stop it, it's satisfied by *grpc.Server.
+type GRPCServer interface {
+	GracefulStop()
+	Stop()
+}
+
+// HTTPServer is the part of HTTP server used to stop it, it's satisfied by *http.Server.
+type HTTPServer interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// Shutdown stops the servers within the timeout. The gRPC server stops accepting new connections
+// and finishes in-flight requests, e.g. mounts, it's stopped forcibly once the timeout elapses.
+// HTTP servers are shut down afterwards, so health probes are answered while the mounts are drained.
+// They are closed forcibly if the timeout has already elapsed.
+func Shutdown(grpcServer GRPCServer, timeout time.Duration, httpServers ...HTTPServer) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		grpcServer.GracefulStop()
+	}()
+	select {
+	case <-stopped:
+		log.Info().Msg("Finished in-flight gRPC requests")
+	case <-ctx.Done():
+		log.Warn().Dur("timeout", timeout).Msg("Shutdown timeout elapsed, stopping gRPC server forcibly")
+		grpcServer.Stop()
+		<-stopped
+	}
+
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("Failed to shut down HTTP server gracefully, closing it")
+			if err := httpServer.Close(); err != nil {
+				log.Error().Err(err).Msg("Failed to close HTTP server")
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+** OCI Secrets Store CSI Driver Provider
+**
+** Copyright (c) 2022 Oracle America, Inc. and its affiliates.
+** Licensed under the Universal Permissive License v 1.0 as shown at https://oss.oracle.com/licenses/upl/
+ */
+package utils
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// shutdownEvents records the calls of the fake servers in their order
+type shutdownEvents struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (events *shutdownEvents) add(event string) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	events.events = append(events.events, event)
+}
+
+func (events *shutdownEvents) String() string {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	return strings.Join(events.events, ",")
+}
+
+// fakeGRPCServer finishes in-flight requests after the drain duration, unless it's stopped forcibly before
+type fakeGRPCServer struct {
+	events *shutdownEvents
+	drain  time.Duration
+	stop   chan struct{}
+}
+
+func newFakeGRPCServer(events *shutdownEvents, drain time.Duration) *fakeGRPCServer {
+	return &fakeGRPCServer{events: events, drain: drain, stop: make(chan struct{})}
+}
+
+func (server *fakeGRPCServer) GracefulStop() {
+	select {
+	case <-time.After(server.drain):
+		server.events.add("drained")
+	case <-server.stop:
+	}
+}
+
+func (server *fakeGRPCServer) Stop() {
+	server.events.add("stopped")
+	close(server.stop)
+}
+
+// fakeHTTPServer fails the graceful shutdown if the context is already done
+type fakeHTTPServer struct {
+	name   string
+	events *shutdownEvents
+}
+
+func (server *fakeHTTPServer) Shutdown(ctx context.Context) error {
+	server.events.add(server.name + " shutdown")
+	return ctx.Err()
+}
+
+func (server *fakeHTTPServer) Close() error {
+	server.events.add(server.name + " closed")
+	return nil
+}
+
+func TestShutdown_InFlightRequestsDrained_ShutDownHTTPServersAfterGRPC(t *testing.T) {
+	events := &shutdownEvents{}
+	grpcServer := newFakeGRPCServer(events, 10*time.Millisecond)
+
+	Shutdown(grpcServer, 5*time.Second,
+		&fakeHTTPServer{name: "health", events: events}, &fakeHTTPServer{name: "metrics", events: events})
+
+	if events.String() != "drained,health shutdown,metrics shutdown" {
+		t.Errorf("Unexpected shutdown sequence: %v", events)
+	}
+}
+
+func TestShutdown_TimeoutElapsed_StopGRPCAndCloseHTTPServers(t *testing.T) {
+	events := &shutdownEvents{}
+	grpcServer := newFakeGRPCServer(events, time.Hour)
+
+	started := time.Now()
+	Shutdown(grpcServer, 10*time.Millisecond, &fakeHTTPServer{name: "health", events: events})
+
+	if events.String() != "stopped,health shutdown,health closed" {
+		t.Errorf("Unexpected shutdown sequence: %v", events)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Shutdown took too long: %v", elapsed)
+	}
+}